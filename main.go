@@ -11,18 +11,175 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 
 	kac "github.com/nodis-com-br/kac-ca-injector/pkg"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
 func main() {
-	var tlsKey, tlsCert string
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		loadTest(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "provision" {
+		provision(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		audit(os.Args[2:])
+		return
+	}
+	var tlsKey, tlsCert, featureGates string
 	flag.StringVar(&tlsKey, "tlsKey", "/certs/tls.key", "Path to the TLS key")
 	flag.StringVar(&tlsCert, "tlsCert", "/certs/tls.crt", "Path to the TLS certificate")
+	flag.StringVar(&featureGates, "feature-gates", "", "Comma separated Name=true|false pairs, overriding CA_BUNDLE_FEATURE_GATES")
 	flag.Parse()
-	log.Printf("Server started")
-	router := kac.NewRouter()
-	log.Fatal(router.RunTLS(":8443", tlsCert, tlsKey))
+	if err := kac.LoadConfigFile(); err != nil {
+		log.Fatal(err)
+	}
+	if err := kac.SetFeatureGates(featureGates); err != nil {
+		log.Fatal(err)
+	}
+	if err := kac.ConfigureLogging(); err != nil {
+		log.Fatal(err)
+	}
+	if err := kac.LoadConfig(); err != nil {
+		log.Fatal(err)
+	}
+	if err := kac.CheckAuxAuth(); err != nil {
+		log.Fatal(err)
+	}
+	servingCert, err := kac.NewServingCertificate(tlsCert, tlsKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := ctrl.SetupSignalHandler()
+	mode := kac.Mode()
+	if mode != kac.ModeController {
+		if err := kac.CheckWebhookConfiguration(ctx); err != nil {
+			log.Printf("Webhook configuration check failed: %v", err)
+		}
+	}
+	var leaderTasks []func(context.Context)
+	if mode != kac.ModeWebhook {
+		// Legacy configmaps are adopted before the refresh looks for them
+		migrateAndRefresh := func(ctx context.Context) {
+			if err := kac.MigrateLegacyConfigMaps(ctx); err != nil {
+				log.Printf("Legacy configmaps migration failed: %v", err)
+			}
+			kac.RunBundleRefresh(ctx)
+		}
+		leaderTasks = append(leaderTasks, migrateAndRefresh, kac.RunBundleMirror, kac.RunCanaryProbe)
+	}
+	go kac.RunMutationSummaries(ctx)
+	go kac.RunConfigReload(ctx)
+	go servingCert.Run(ctx)
+	log.Printf("Server started in %s mode", mode)
+	if err := kac.RunManager(ctx, servingCert, tlsCert, tlsKey, leaderTasks...); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func loadTest(args []string) {
+	var options kac.LoadTestOptions
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	flags.StringVar(&options.Target, "target", "", "Mutate URL to load, the router is loaded in-process when empty")
+	flags.BoolVar(&options.Insecure, "insecure", false, "Skip verification of the target certificate")
+	flags.IntVar(&options.Concurrency, "concurrency", 10, "Number of concurrent clients")
+	flags.IntVar(&options.Requests, "requests", 1000, "Total number of admission reviews")
+	flags.IntVar(&options.Containers, "containers", 1, "Number of containers of every pod")
+	flags.BoolVar(&options.Annotated, "annotated", false, "Set the injection annotation on the pods")
+	_ = flags.Parse(args)
+	report, err := kac.RunLoadTest(context.Background(), options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(report)
+}
+
+func provision(args []string) {
+	var options kac.ProvisionOptions
+	flags := flag.NewFlagSet("provision", flag.ExitOnError)
+	flags.StringVar(&options.Selector, "selector", "", "Label selector of the namespaces to provision, all namespaces when empty")
+	flags.BoolVar(&options.DryRun, "dry-run", false, "Report the changes without applying them")
+	ctx := clusterContext(flags, args)
+	report, err := kac.RunProvision(ctx, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, ref := range report.Created {
+		fmt.Println("created", ref)
+	}
+	for _, ref := range report.Refreshed {
+		fmt.Println("refreshed", ref)
+	}
+	for _, ref := range report.Failed {
+		fmt.Println("failed", ref)
+	}
+	fmt.Println(report)
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+func audit(args []string) {
+	var options kac.AuditOptions
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	flags.StringVar(&options.Selector, "selector", "", "Label selector of the namespaces to audit, all namespaces when empty")
+	ctx := clusterContext(flags, args)
+	report, err := kac.RunAudit(ctx, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, ref := range report.Stale {
+		fmt.Println("stale", ref)
+	}
+	for _, ref := range report.StalePods {
+		fmt.Println("stale pod", ref)
+	}
+	fmt.Println(report)
+	if report.Drifted() {
+		os.Exit(1)
+	}
+}
+
+// clusterContext parses the kubeconfig flags shared by the subcommands
+// working on the cluster, along with their own, and loads the configuration
+func clusterContext(flags *flag.FlagSet, args []string) context.Context {
+	var kubeconfig, kubeContext, featureGates string
+	defaultKubeconfig := os.Getenv("KUBECONFIG")
+	if home, err := os.UserHomeDir(); err == nil && defaultKubeconfig == "" {
+		// Scheduled in the cluster there's no kubeconfig to default to
+		if _, err := os.Stat(filepath.Join(home, ".kube", "config")); err == nil {
+			defaultKubeconfig = filepath.Join(home, ".kube", "config")
+		}
+	}
+	flags.StringVar(&kubeconfig, "kubeconfig", defaultKubeconfig, "Path to the kubeconfig file, the in-cluster configuration is used when empty")
+	flags.StringVar(&kubeContext, "context", "", "Kubeconfig context to use instead of the current context")
+	flags.StringVar(&featureGates, "feature-gates", "", "Comma separated Name=true|false pairs, overriding CA_BUNDLE_FEATURE_GATES")
+	_ = flags.Parse(args)
+	if err := kac.LoadConfigFile(); err != nil {
+		log.Fatal(err)
+	}
+	if err := kac.SetFeatureGates(featureGates); err != nil {
+		log.Fatal(err)
+	}
+	if err := kac.ConfigureLogging(); err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	if kubeconfig != "" {
+		clientSet, err := kac.NewKubeconfigClientSet(kubeconfig, kubeContext)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ctx = kac.WithClientSet(ctx, clientSet)
+	}
+	return ctx
 }
@@ -11,20 +11,140 @@
 package kac
 
 import (
+	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
+// PreviewResponse -
+type PreviewResponse struct {
+	Patch    json.RawMessage `json:"patch"`
+	Pod      json.RawMessage `json:"pod"`
+	Warnings []string        `json:"warnings,omitempty"`
+}
+
+// RollbackRequest -
+type RollbackRequest struct {
+	Namespace string `json:"namespace" binding:"required"`
+	Revision  string `json:"revision" binding:"required"`
+}
+
+// Bundle -
+func Bundle(c *gin.Context) {
+	ctx := c.Request.Context()
+	if c.Query("refresh") == "true" {
+		ctx = withFreshBundle(ctx)
+	}
+	config, _ := currentConfig()
+	bundle, err := loadCABundle(ctx, config.BundleURL)
+	if err != nil {
+		errorResponse(c, http.StatusBadGateway, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/x-pem-file", bundle)
+}
+
+// Provenance -
+func Provenance(c *gin.Context) {
+	if currentProvenance() == nil {
+		config, _ := currentConfig()
+		if _, err := loadCABundle(c.Request.Context(), config.BundleURL); err != nil {
+			errorResponse(c, http.StatusBadGateway, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, currentProvenance())
+}
+
 func Health(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+// Metrics -
+func Metrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4")
+	c.Status(http.StatusOK)
+	writeMetrics(c.Writer)
+}
+
 // Mutate -
 func Mutate(c *gin.Context) {
 	serve(c, mutationReviewer)
 }
 
+// MutateWorkload -
+func MutateWorkload(c *gin.Context) {
+	serve(c, workloadMutationReviewer)
+}
+
+// Preview -
+func Preview(c *gin.Context) {
+	// The pod is kept raw, so that the preview shows the fields unknown to
+	// the injector as the apiserver would keep them
+	var pod map[string]interface{}
+	if err := c.ShouldBindJSON(&pod); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+	preview, err := previewRawMutation(c.Request.Context(), pod)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, preview)
+}
+
+// Ready -
+func Ready(c *gin.Context) {
+	response := gin.H{"status": "ok"}
+	if sources := currentSourceHealth(); len(sources) > 0 {
+		response["sources"] = sources
+	}
+	if stalled := stalledReconcilers(time.Now()); len(stalled) > 0 {
+		response["status"] = "stalled"
+		response["controllers"] = stalled
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Rollback -
+func Rollback(c *gin.Context) {
+	var req RollbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+	ctx := c.Request.Context()
+	clientSet, err := getKubernetesClientSet(ctx)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+	config, _ := currentConfig()
+	if err := rollbackBundle(ctx, clientSet, req.Namespace, config.ConfigMapName, req.Revision); apierrors.IsNotFound(err) {
+		errorResponse(c, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Status -
+func Status(c *gin.Context) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := writeStatus(c.Request.Context(), c.Writer); err != nil {
+		_ = c.Error(err)
+	}
+}
+
 // Validate -
 func Validate(c *gin.Context) {
 	serve(c, validationReviewer)
@@ -0,0 +1,389 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	provenanceSourceS3  = "s3"
+	provenanceSourceGCS = "gs"
+	provenanceSourceOCI = "oci"
+
+	keyAWSRegion               = "AWS_REGION"
+	keyAWSAccessKeyID          = "AWS_ACCESS_KEY_ID"
+	keyAWSSecretAccessKey      = "AWS_SECRET_ACCESS_KEY"
+	keyAWSSessionToken         = "AWS_SESSION_TOKEN"
+	keyAWSRoleARN              = "AWS_ROLE_ARN"
+	keyAWSWebIdentityTokenFile = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	keyAWSEndpointS3           = "AWS_ENDPOINT_URL_S3"
+	keyAWSEndpointSTS          = "AWS_ENDPOINT_URL_STS"
+	keyGCEMetadataHost         = "GCE_METADATA_HOST"
+	keyGCSEndpoint             = "STORAGE_EMULATOR_HOST"
+
+	defaultGCEMetadataHost = "metadata.google.internal"
+	defaultGCSEndpoint     = "https://storage.googleapis.com"
+	awsUnsignedPayload     = "UNSIGNED-PAYLOAD"
+
+	ociManifestMediaTypes = "application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// artifactSource downloads the bundle published as an artifact on an
+// object store or an oci registry, authenticating with the workload
+// identity of the injector: the projected web identity token on aws, the
+// metadata server on gcp, and anonymous registry tokens otherwise
+type artifactSource struct {
+	scheme string
+	uri    string
+	// bucket or registry host, and object key or repository
+	host string
+	path string
+}
+
+func newArtifactSource(scheme string, ref string, uri string) (BundleSource, error) {
+	host, path, _ := strings.Cut(ref, "/")
+	if host == "" || path == "" {
+		return nil, fmt.Errorf("invalid ca bundle source %q, expected %s://host/path", uri, scheme)
+	}
+	return artifactSource{scheme: scheme, uri: uri, host: host, path: path}, nil
+}
+
+func (s artifactSource) Load(ctx context.Context) ([]byte, *BundleProvenance, error) {
+	var content []byte
+	var contentType string
+	var err error
+	client := bundleHTTPClient()
+	switch s.scheme {
+	case provenanceSourceS3:
+		content, contentType, err = s.loadS3(ctx, client)
+	case provenanceSourceGCS:
+		content, contentType, err = s.loadGCS(ctx, client)
+	default:
+		content, err = s.loadOCI(ctx, client)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle, err := parseBundle(contentType, content)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bundle, &BundleProvenance{
+		Source:        s.scheme,
+		URI:           s.uri,
+		Format:        detectFormat(contentType, content),
+		ContentSHA256: bundleHash(content),
+	}, nil
+}
+
+// getArtifact sends an authenticated GET request, returning the body and
+// its content type
+func getArtifact(ctx context.Context, client *http.Client, rawURL string, header http.Header) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", upstreamStatusError{url: rawURL, code: resp.StatusCode, challenge: resp.Header.Get("WWW-Authenticate")}
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// loadGCS downloads the object with an access token of the metadata
+// server, which is bound to the kubernetes service account with gke
+// workload identity
+func (s artifactSource) loadGCS(ctx context.Context, client *http.Client) ([]byte, string, error) {
+	endpoint := defaultGCSEndpoint
+	header := http.Header{}
+	if emulator := os.Getenv(keyGCSEndpoint); emulator != "" {
+		endpoint = "http://" + emulator
+	} else {
+		token, err := gcpAccessToken(ctx, client)
+		if err != nil {
+			return nil, "", err
+		}
+		header.Set("Authorization", "Bearer "+token)
+	}
+	objectURL := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", endpoint, url.PathEscape(s.host), url.PathEscape(s.path))
+	return getArtifact(ctx, client, objectURL, header)
+}
+
+func gcpAccessToken(ctx context.Context, client *http.Client) (string, error) {
+	host := os.Getenv(keyGCEMetadataHost)
+	if host == "" {
+		host = defaultGCEMetadataHost
+	}
+	body, _, err := getArtifact(ctx, client, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token",
+		http.Header{"Metadata-Flavor": []string{"Google"}})
+	if err != nil {
+		return "", fmt.Errorf("unable to get gcp access token: %w", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("unable to get gcp access token: %w", err)
+	}
+	return token.AccessToken, nil
+}
+
+// awsCredentials are either static or exchanged for the projected web
+// identity token of the pod, as set up by eks iam roles for service
+// accounts
+type awsCredentials struct {
+	AccessKeyID     string `xml:"AccessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	SessionToken    string `xml:"SessionToken"`
+}
+
+func loadAWSCredentials(ctx context.Context, client *http.Client, region string) (*awsCredentials, error) {
+	if os.Getenv(keyAWSAccessKeyID) != "" {
+		return &awsCredentials{
+			AccessKeyID:     os.Getenv(keyAWSAccessKeyID),
+			SecretAccessKey: os.Getenv(keyAWSSecretAccessKey),
+			SessionToken:    os.Getenv(keyAWSSessionToken),
+		}, nil
+	}
+	tokenFile, roleARN := os.Getenv(keyAWSWebIdentityTokenFile), os.Getenv(keyAWSRoleARN)
+	if tokenFile == "" || roleARN == "" {
+		return nil, fmt.Errorf("no aws credentials, set %s and %s or %s", keyAWSRoleARN, keyAWSWebIdentityTokenFile, keyAWSAccessKeyID)
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := os.Getenv(keyAWSEndpointSTS)
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+	}
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {labelManagedByValue},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	body, _, err := getArtifact(ctx, client, endpoint+"/?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to assume role %s: %w", roleARN, err)
+	}
+	var response struct {
+		Credentials awsCredentials `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("unable to assume role %s: %w", roleARN, err)
+	}
+	return &response.Credentials, nil
+}
+
+// loadS3 downloads the object with a request signed with aws signature
+// version 4
+func (s artifactSource) loadS3(ctx context.Context, client *http.Client) ([]byte, string, error) {
+	region := os.Getenv(keyAWSRegion)
+	if region == "" {
+		return nil, "", fmt.Errorf("%s is required for s3 ca bundle sources", keyAWSRegion)
+	}
+	credentials, err := loadAWSCredentials(ctx, client, region)
+	if err != nil {
+		return nil, "", err
+	}
+	objectURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.host, region, awsEscapePath(s.path))
+	if endpoint := os.Getenv(keyAWSEndpointS3); endpoint != "" {
+		objectURL = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(endpoint, "/"), s.host, awsEscapePath(s.path))
+	}
+	parsed, err := url.Parse(objectURL)
+	if err != nil {
+		return nil, "", err
+	}
+	return getArtifact(ctx, client, objectURL, signAWSRequest(parsed, credentials, region, "s3", time.Now().UTC()))
+}
+
+// signAWSRequest returns the headers authenticating a GET request to u
+func signAWSRequest(u *url.URL, credentials *awsCredentials, region string, service string, now time.Time) http.Header {
+	amzDate := now.Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", now.Format("20060102"), region, service)
+	headers := map[string]string{
+		"host":                 u.Host,
+		"x-amz-content-sha256": awsUnsignedPayload,
+		"x-amz-date":           amzDate,
+	}
+	if credentials.SessionToken != "" {
+		headers["x-amz-security-token"] = credentials.SessionToken
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet, u.EscapedPath(), u.Query().Encode(), canonicalHeaders.String(), signedHeaders, awsUnsignedPayload,
+	}, "\n")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, bundleHash([]byte(canonicalRequest))}, "\n")
+
+	key := []byte("AWS4" + credentials.SecretAccessKey)
+	for _, part := range []string{now.Format("20060102"), region, service, "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+
+	header := http.Header{}
+	for _, name := range names {
+		if name != "host" {
+			header.Set(name, headers[name])
+		}
+	}
+	header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.AccessKeyID, scope, signedHeaders, hex.EncodeToString(key)))
+	return header
+}
+
+// awsEscapePath encodes every byte of an object key but the unreserved
+// characters and the slashes, as required by signature version 4
+func awsEscapePath(path string) string {
+	var b strings.Builder
+	for _, c := range []byte(path) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+var ociReference = regexp.MustCompile(`^(.+?)(?::([\w][\w.-]{0,127}))?(?:@(sha256:[a-f0-9]{64}))?$`)
+
+// loadOCI downloads the first layer of the artifact manifest, checking
+// it against its digest
+func (s artifactSource) loadOCI(ctx context.Context, client *http.Client) ([]byte, error) {
+	match := ociReference.FindStringSubmatch(s.path)
+	if match == nil {
+		return nil, fmt.Errorf("invalid oci reference %q", s.uri)
+	}
+	repository, reference := match[1], match[2]
+	if match[3] != "" {
+		reference = match[3]
+	} else if reference == "" {
+		reference = "latest"
+	}
+	registry := "https://" + s.host + "/v2/" + repository
+
+	header := http.Header{"Accept": []string{ociManifestMediaTypes}}
+	content, err := getRegistry(ctx, client, registry+"/manifests/"+reference, header)
+	if err != nil {
+		return nil, err
+	}
+	var manifest struct {
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("unable to parse oci manifest of %s: %w", s.uri, err)
+	} else if len(manifest.Layers) == 0 {
+		return nil, fmt.Errorf("oci artifact %s has no layers", s.uri)
+	}
+
+	// Blobs are usually redirected to a storage host, which is safe
+	// since their digest is verified
+	digest := manifest.Layers[0].Digest
+	blobClient := *client
+	blobClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > defaultMaxRedirects {
+			return fmt.Errorf("stopped after %d redirects", defaultMaxRedirects)
+		} else if via[len(via)-1].URL.Scheme == "https" && req.URL.Scheme != "https" {
+			return fmt.Errorf("refusing redirect from https to %s", req.URL.Scheme)
+		}
+		return nil
+	}
+	blob, err := getRegistry(ctx, &blobClient, registry+"/blobs/"+digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	if "sha256:"+bundleHash(blob) != digest {
+		return nil, invalidBundleError{fmt.Errorf("oci layer of %s doesn't match its digest %s", s.uri, digest)}
+	}
+	return blob, nil
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// getRegistry sends a registry request, answering the bearer token
+// challenge of registries that require one, even for anonymous pulls
+func getRegistry(ctx context.Context, client *http.Client, rawURL string, header http.Header) ([]byte, error) {
+	if header == nil {
+		header = http.Header{}
+	}
+	content, _, err := getArtifact(ctx, client, rawURL, header)
+	var statusErr upstreamStatusError
+	if !errors.As(err, &statusErr) || statusErr.code != http.StatusUnauthorized || !strings.HasPrefix(statusErr.challenge, "Bearer ") {
+		return content, err
+	}
+
+	params := map[string]string{}
+	for _, match := range challengeParam.FindAllStringSubmatch(statusErr.challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	query := url.Values{}
+	for _, name := range []string{"service", "scope"} {
+		if params[name] != "" {
+			query.Set(name, params[name])
+		}
+	}
+	body, _, err := getArtifact(ctx, client, params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get registry token: %w", err)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("unable to get registry token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	header.Set("Authorization", "Bearer "+token.Token)
+	content, _, err = getArtifact(ctx, client, rawURL, header)
+	return content, err
+}
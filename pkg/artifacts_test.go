@@ -0,0 +1,107 @@
+package kac
+
+import (
+	"context"
+	"encoding/pem"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ArtifactSources(t *testing.T) {
+
+	ctx := context.Background()
+	bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	digest := "sha256:" + bundleHash(bundle)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Query().Get("Action") == "AssumeRoleWithWebIdentity":
+			_, _ = w.Write([]byte(`<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+<AccessKeyId>ASIAEXAMPLE</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>session</SessionToken>
+</Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`))
+		case r.URL.EscapedPath() == "/trust/certs/ca%20bundle.pem":
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=ASIAEXAMPLE/") || r.Header.Get("X-Amz-Security-Token") != "session" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write(bundle)
+		case r.URL.Path == "/storage/v1/b/trust/o/certs/ca.pem":
+			_, _ = w.Write(bundle)
+		case r.URL.Path == "/token":
+			_, _ = w.Write([]byte(`{"token":"anonymous"}`))
+		case r.Header.Get("Authorization") != "Bearer anonymous":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="https://`+r.Host+`/token",service="registry",scope="repository:platform/trust-bundle:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/platform/trust-bundle/manifests/v1":
+			_, _ = w.Write([]byte(`{"schemaVersion":2,"layers":[{"mediaType":"application/x-pem-file","digest":"` + digest + `"}]}`))
+		case r.URL.Path == "/v2/platform/trust-bundle/blobs/"+digest:
+			_, _ = w.Write(bundle)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile, tokenFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "token")
+	_ = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	_ = os.WriteFile(tokenFile, []byte("projected-token"), 0600)
+	host := strings.TrimPrefix(server.URL, "https://")
+	for key, value := range map[string]string{
+		keyCABundleFetchCAFile:     caFile,
+		keyAWSRegion:               "sa-east-1",
+		keyAWSRoleARN:              "arn:aws:iam::123456789012:role/ca-injector",
+		keyAWSWebIdentityTokenFile: tokenFile,
+		keyAWSEndpointS3:           server.URL,
+		keyAWSEndpointSTS:          server.URL,
+	} {
+		_ = os.Setenv(key, value)
+		defer func(key string) {
+			_ = os.Unsetenv(key)
+		}(key)
+	}
+	defer func() {
+		lastProvenance = nil
+	}()
+
+	for _, tc := range []struct {
+		url    string
+		source string
+		err    string
+	}{
+		{"s3://trust/certs/ca bundle.pem", provenanceSourceS3, ""},
+		{"oci://" + host + "/platform/trust-bundle:v1", provenanceSourceOCI, ""},
+		{"oci://" + host + "/platform/missing:v1", "", "unexpected status 404 Not Found from " + server.URL + "/v2/platform/missing/manifests/v1"},
+		{"gs://trust", "", `invalid ca bundle source "gs://trust", expected gs://host/path`},
+	} {
+		t.Run("test source "+tc.url, func(t *testing.T) {
+			loaded, err := loadCABundle(ctx, tc.url)
+			if tc.err == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, bundle, loaded)
+				assert.Equal(t, tc.source, currentProvenance().Source)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+
+	t.Run("test source gs://trust/certs/ca.pem", func(t *testing.T) {
+		plain := httptest.NewServer(server.Config.Handler)
+		defer plain.Close()
+		_ = os.Setenv(keyGCSEndpoint, strings.TrimPrefix(plain.URL, "http://"))
+		defer func() {
+			_ = os.Unsetenv(keyGCSEndpoint)
+		}()
+		loaded, err := loadCABundle(ctx, "gs://trust/certs/ca.pem")
+		assert.NoError(t, err)
+		assert.Equal(t, bundle, loaded)
+	})
+
+}
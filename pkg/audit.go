@@ -0,0 +1,150 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// AuditOptions selects the namespaces audited against the bundle sources
+type AuditOptions struct {
+	// Selector is the label selector of the namespaces, every namespace
+	// is audited when empty
+	Selector string
+}
+
+// AuditReport lists the bundle objects holding the current bundle or an
+// outdated one, and the pods mounting an outdated revision, as
+// namespace/name
+type AuditReport struct {
+	Current   []string
+	Stale     []string
+	StalePods []string
+}
+
+func (r AuditReport) String() string {
+	return fmt.Sprintf("current=%d stale=%d stale-pods=%d", len(r.Current), len(r.Stale), len(r.StalePods))
+}
+
+// Drifted tells whether the cluster differs from the bundle sources
+func (r AuditReport) Drifted() bool {
+	return len(r.Stale) > 0 || len(r.StalePods) > 0
+}
+
+// RunAudit compares the managed bundle objects and the pods mounting them
+// with the current bundle sources, without changing anything. Pods are
+// compared by the bundle hash recorded when they were injected, those
+// injected before hashes were recorded are left out
+func RunAudit(ctx context.Context, options AuditOptions) (*AuditReport, error) {
+
+	clientSet, err := getKubernetesClientSet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config, err := currentConfig()
+	if err != nil {
+		return nil, err
+	}
+	sources, err := bundleConfigMaps(config)
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := clientSet.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: options.Selector})
+	if err != nil {
+		return nil, err
+	}
+
+	// Load every source once, and hash it by namespace as team bundles
+	// make bundle objects differ
+	bundles := map[string][]byte{}
+	for name, url := range sources {
+		if _, ok := bundles[url]; !ok {
+			if bundles[url], err = loadCABundle(withFreshBundle(ctx), url); err != nil {
+				return nil, fmt.Errorf("unable to load ca bundle for %s %s: %w", bundleTarget(), name, err)
+			}
+		}
+	}
+	hashes := map[string]string{}
+	desiredHash := func(namespace string, name string) (string, error) {
+		if hash, ok := hashes[namespace+"/"+name]; ok {
+			return hash, nil
+		}
+		bundle, err := withTeamBundles(ctx, clientSet, namespace, bundles[sources[name]])
+		if err != nil {
+			return "", err
+		}
+		hashes[namespace+"/"+name] = bundleHash(bundle)
+		return hashes[namespace+"/"+name], nil
+	}
+
+	selected := map[string]bool{}
+	for _, namespace := range namespaces.Items {
+		selected[namespace.Name] = true
+	}
+	configMaps, err := listBundles(ctx, clientSet, metav1.NamespaceAll, labels.SelectorFromSet(labels.Set{labelManagedBy: labelManagedByValue}).String())
+	if err != nil {
+		return nil, err
+	}
+	pods, err := listPods(ctx, clientSet, metav1.NamespaceAll)
+	if err != nil {
+		return nil, err
+	}
+
+	caBundleFilename := config.BundleFilename
+	report := &AuditReport{}
+	for _, configMap := range configMaps {
+		if _, ok := sources[configMap.Name]; !ok || !selected[configMap.Namespace] || configMap.Labels[labelRevisionOf] != "" {
+			continue
+		}
+		hash, err := desiredHash(configMap.Namespace, configMap.Name)
+		if err != nil {
+			return nil, err
+		}
+		ref := configMap.Namespace + "/" + configMap.Name
+		if bundleHash([]byte(configMap.Data[caBundleFilename])) == hash {
+			report.Current = append(report.Current, ref)
+		} else {
+			report.Stale = append(report.Stale, ref)
+		}
+	}
+
+	hashAnnotation := config.Annotation + hashAnnotationSuffix
+	for _, pod := range pods {
+		injected, ok := pod.Annotations[hashAnnotation]
+		if !ok || !selected[pod.Namespace] || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for name := range sources {
+			if !mountsBundle(pod.Spec, name) {
+				continue
+			}
+			hash, err := desiredHash(pod.Namespace, name)
+			if err != nil {
+				return nil, err
+			}
+			if injected != hash {
+				report.StalePods = append(report.StalePods, pod.Namespace+"/"+pod.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(report.Current)
+	sort.Strings(report.Stale)
+	sort.Strings(report.StalePods)
+	return report, nil
+
+}
@@ -0,0 +1,76 @@
+package kac
+
+import (
+	"context"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_RunAudit(t *testing.T) {
+
+	ctx := context.Background()
+	bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bundle)
+	}))
+	defer server.Close()
+	_ = os.Setenv(keyCABundleURL, server.URL)
+	defer func() {
+		_ = os.Setenv(keyCABundleURL, caBundleURL)
+	}()
+
+	name, filename := os.Getenv(keyConfigMapName), os.Getenv(keyCABundleFilename)
+	hashAnnotation := os.Getenv(keyCABundleAnnotation) + hashAnnotationSuffix
+	managed := map[string]string{labelManagedBy: labelManagedByValue}
+	mounting := func(namespace string, podName string, hash string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace, Annotations: map[string]string{hashAnnotation: hash}},
+			Spec:       corev1.PodSpec{Volumes: []corev1.Volume{{Name: name, VolumeSource: bundleVolumeSource(name)}}},
+		}
+	}
+	payments := map[string]string{"team": "payments"}
+	objects := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments-a", Labels: payments}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "search"}},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "payments-a", Labels: managed},
+			Data:       map[string]string{filename: string(bundle)},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "search", Labels: managed},
+			Data:       map[string]string{filename: "outdated"},
+		},
+		mounting("payments-a", "api", bundleHash(bundle)),
+		mounting("payments-a", "worker", bundleHash([]byte("outdated"))),
+		mounting("search", "indexer", bundleHash([]byte("outdated"))),
+	}
+
+	t.Run("test drifted cluster", func(t *testing.T) {
+		report, err := RunAudit(WithClientSet(ctx, fake.NewSimpleClientset(objects...)), AuditOptions{})
+		assert.NoError(t, err)
+		assert.True(t, report.Drifted())
+		assert.Equal(t, []string{"payments-a/" + name}, report.Current)
+		assert.Equal(t, []string{"search/" + name}, report.Stale)
+		assert.Equal(t, []string{"payments-a/worker", "search/indexer"}, report.StalePods)
+		assert.Equal(t, "current=1 stale=1 stale-pods=2", report.String())
+	})
+
+	t.Run("test selected namespaces", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset(objects[:5]...)
+		report, err := RunAudit(WithClientSet(ctx, clientSet), AuditOptions{Selector: "team=payments"})
+		assert.NoError(t, err)
+		assert.False(t, report.Drifted())
+		_, err = clientSet.CoreV1().ConfigMaps("search").Get(ctx, name, metav1.GetOptions{})
+		assert.NoError(t, err)
+	})
+
+}
@@ -0,0 +1,240 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	keyAuxAuth      = "CA_BUNDLE_AUX_AUTH"
+	keyAuxTokenFile = "CA_BUNDLE_AUX_TOKEN_FILE"
+	keyAuxAllowed   = "CA_BUNDLE_AUX_ALLOWED"
+
+	auxAuthToken       = "token"
+	auxAuthTokenReview = "tokenreview"
+
+	tokenReviewTTL = time.Minute
+)
+
+var (
+	// serviceAccountTokenFile is the token of the injector service account,
+	// which followers authenticate to the leader with on tokenreview mode
+	serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// protectedRoutes expose the bundle and the injector internals, unlike
+	// the admission, health and metrics routes
+	protectedRoutes = map[string]bool{
+		"Bundle":     true,
+		"Preview":    true,
+		"Provenance": true,
+		"Rollback":   true,
+		"Status":     true,
+	}
+
+	tokenReviewsMutex sync.Mutex
+	// tokenReviews caches the identities of recently reviewed tokens, by
+	// token hash, so that polling clients don't review on every request
+	tokenReviews = map[[sha256.Size]byte]reviewedToken{}
+
+	auxAuthFailures = newMetric(metricTypeCounter, "kac_aux_auth_failures_total", "Number of requests to auxiliary endpoints refused, by status code", "code")
+)
+
+type reviewedToken struct {
+	user       authenticationv1.UserInfo
+	reviewedAt time.Time
+}
+
+// CheckAuxAuth validates CA_BUNDLE_AUX_AUTH at startup. TokenReview mode
+// requires CA_BUNDLE_AUX_ALLOWED, since any service account of the cluster
+// would be authenticated otherwise
+func CheckAuxAuth() error {
+	switch mode := os.Getenv(keyAuxAuth); mode {
+	case "":
+	case auxAuthToken:
+		if os.Getenv(keyAuxTokenFile) == "" {
+			return fmt.Errorf("%s is required by %s %s", keyAuxTokenFile, keyAuxAuth, mode)
+		}
+	case auxAuthTokenReview:
+		if len(splitList(os.Getenv(keyAuxAllowed))) == 0 {
+			return fmt.Errorf("%s is required by %s %s", keyAuxAllowed, keyAuxAuth, mode)
+		}
+	default:
+		return fmt.Errorf("unknown %s %q", keyAuxAuth, mode)
+	}
+	return nil
+}
+
+// requireAuth protects the auxiliary endpoints according to
+// CA_BUNDLE_AUX_AUTH: with a static bearer token read from
+// CA_BUNDLE_AUX_TOKEN_FILE, or with any token the apiserver authenticates
+// through a TokenReview. The reviewed identities are further restricted to
+// the users and groups of CA_BUNDLE_AUX_ALLOWED, and the injector service
+// account the followers authenticate as
+func requireAuth(c *gin.Context) {
+	mode := os.Getenv(keyAuxAuth)
+	if mode == "" {
+		return
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" || token == c.GetHeader("Authorization") {
+		refuse(c, http.StatusUnauthorized, fmt.Errorf("bearer token required"))
+		return
+	}
+	switch mode {
+	case auxAuthToken:
+		expected, err := os.ReadFile(os.Getenv(keyAuxTokenFile))
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, fmt.Errorf("unable to read %s: %w", keyAuxTokenFile, err))
+			c.Abort()
+			return
+		}
+		expected = bytes.TrimSpace(expected)
+		if len(expected) == 0 || subtle.ConstantTimeCompare(expected, []byte(token)) != 1 {
+			refuse(c, http.StatusUnauthorized, fmt.Errorf("invalid bearer token"))
+		}
+	case auxAuthTokenReview:
+		user, err := reviewToken(c.Request.Context(), token, time.Now())
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, err)
+			c.Abort()
+			return
+		} else if user == nil {
+			refuse(c, http.StatusUnauthorized, fmt.Errorf("invalid bearer token"))
+			return
+		}
+		allowed := splitList(os.Getenv(keyAuxAllowed))
+		if !containsString(allowed, user.Username) && !containsAny(allowed, user.Groups) && user.Username != ownServiceAccount() {
+			refuse(c, http.StatusForbidden, fmt.Errorf("%s is not allowed by %s", user.Username, keyAuxAllowed))
+		}
+	default:
+		errorResponse(c, http.StatusInternalServerError, fmt.Errorf("unknown %s %q", keyAuxAuth, mode))
+		c.Abort()
+	}
+}
+
+func refuse(c *gin.Context, statusCode int, err error) {
+	auxAuthFailures.inc(fmt.Sprint(statusCode))
+	if statusCode == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", "Bearer")
+	}
+	c.AbortWithStatusJSON(statusCode, gin.H{"error": err.Error()})
+}
+
+// reviewToken returns the user the apiserver authenticates the token as,
+// or nil when it doesn't
+func reviewToken(ctx context.Context, token string, now time.Time) (*authenticationv1.UserInfo, error) {
+	key := sha256.Sum256([]byte(token))
+	tokenReviewsMutex.Lock()
+	reviewed, ok := tokenReviews[key]
+	tokenReviewsMutex.Unlock()
+	if ok && now.Sub(reviewed.reviewedAt) < tokenReviewTTL {
+		return &reviewed.user, nil
+	}
+
+	clientSet, err := getKubernetesClientSet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	review, err := clientSet.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	} else if !review.Status.Authenticated {
+		return nil, nil
+	}
+
+	tokenReviewsMutex.Lock()
+	defer tokenReviewsMutex.Unlock()
+	for k, r := range tokenReviews {
+		if now.Sub(r.reviewedAt) >= tokenReviewTTL {
+			delete(tokenReviews, k)
+		}
+	}
+	tokenReviews[key] = reviewedToken{review.Status.User, now}
+	return &review.Status.User, nil
+}
+
+// auxToken returns the bearer token a replica presents to the auxiliary
+// endpoints of another one, empty when they are not protected
+func auxToken() (string, error) {
+	path := serviceAccountTokenFile
+	switch os.Getenv(keyAuxAuth) {
+	case "":
+		return "", nil
+	case auxAuthToken:
+		path = os.Getenv(keyAuxTokenFile)
+	}
+	token, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(token)), nil
+}
+
+// ownServiceAccount returns the username of the injector service account,
+// read from the subject of its token, or an empty string when unknown.
+// The token is the one mounted on the injector, so its signature isn't
+// checked
+func ownServiceAccount() string {
+	token, err := os.ReadFile(serviceAccountTokenFile)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(string(bytes.TrimSpace(token)), ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || !strings.HasPrefix(claims.Subject, "system:serviceaccount:") {
+		return ""
+	}
+	return claims.Subject
+}
+
+// auxAuthTransport authenticates the requests of a replica to the
+// auxiliary endpoints of another one
+type auxAuthTransport struct {
+	next http.RoundTripper
+}
+
+func (t auxAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := auxToken()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the auxiliary endpoints token: %w", err)
+	} else if token != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return t.next.RoundTrip(req)
+}
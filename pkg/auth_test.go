@@ -0,0 +1,162 @@
+package kac
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func authRequest(ctx context.Context, route string, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, route, strings.NewReader(""))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	NewRouter().ServeHTTP(w, req.WithContext(ctx))
+	return w
+}
+
+func Test_RequireAuth(t *testing.T) {
+
+	defer os.Unsetenv(keyAuxAuth)
+	defer os.Unsetenv(keyAuxTokenFile)
+	defer os.Unsetenv(keyAuxAllowed)
+
+	t.Run("test auth disabled by default", func(t *testing.T) {
+		w := authRequest(context.Background(), "/status", "")
+		assert.NotEqual(t, http.StatusUnauthorized, w.Code)
+	})
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	_ = os.WriteFile(tokenFile, []byte("s3cr3t\n"), 0600)
+	_ = os.Setenv(keyAuxAuth, auxAuthToken)
+	_ = os.Setenv(keyAuxTokenFile, tokenFile)
+
+	t.Run("test static token", func(t *testing.T) {
+		w := authRequest(context.Background(), "/bundle", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+		w = authRequest(context.Background(), "/bundle", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		w = authRequest(context.Background(), "/bundle", "s3cr3t")
+		assert.NotEqual(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("test unprotected routes", func(t *testing.T) {
+		w := authRequest(context.Background(), "/health", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	_ = os.Setenv(keyAuxAuth, auxAuthTokenReview)
+	_ = os.Setenv(keyAuxAllowed, "system:serviceaccounts:monitoring")
+	injectorToken := "header." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"system:serviceaccount:kac:ca-injector"}`)) + ".signature"
+	defaultTokenFile := serviceAccountTokenFile
+	serviceAccountTokenFile = filepath.Join(t.TempDir(), "sa-token")
+	_ = os.WriteFile(serviceAccountTokenFile, []byte(injectorToken), 0600)
+	defer func() {
+		serviceAccountTokenFile = defaultTokenFile
+	}()
+	reviews := 0
+	clientSet := fake.NewSimpleClientset()
+	clientSet.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch review.Spec.Token {
+		case "monitoring":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{
+				Username: "system:serviceaccount:monitoring:prometheus",
+				Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:monitoring"},
+			}}
+		case injectorToken:
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{
+				Username: "system:serviceaccount:kac:ca-injector",
+				Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:kac"},
+			}}
+		case "other":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{
+				Username: "system:serviceaccount:default:default",
+				Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:default"},
+			}}
+		}
+		return true, review, nil
+	})
+	ctx := WithClientSet(context.Background(), clientSet)
+
+	t.Run("test token review", func(t *testing.T) {
+		w := authRequest(ctx, "/status", "unknown")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		w = authRequest(ctx, "/status", "other")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w = authRequest(ctx, "/status", "monitoring")
+		assert.NotEqual(t, http.StatusUnauthorized, w.Code)
+		assert.NotEqual(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("test follower authenticates to the leader", func(t *testing.T) {
+		bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(bundle)
+		}))
+		defer upstream.Close()
+		_ = os.Setenv(keyCABundleURL, upstream.URL)
+		defer func() {
+			_ = os.Setenv(keyCABundleURL, caBundleURL)
+		}()
+		router := NewRouter()
+		leader := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			router.ServeHTTP(w, r.WithContext(ctx))
+		}))
+		defer leader.Close()
+
+		body, err := fetchCABundle(ctx, pinnedClientFor(func() []byte { return leader.Certificate().Raw }), leader.URL+"/bundle")
+		assert.NoError(t, err)
+		assert.Equal(t, bundle, body)
+
+		_ = os.Setenv(keyAuxAuth, auxAuthToken)
+		defer func() {
+			_ = os.Setenv(keyAuxAuth, auxAuthTokenReview)
+		}()
+		body, err = fetchCABundle(ctx, pinnedClientFor(func() []byte { return leader.Certificate().Raw }), leader.URL+"/bundle")
+		assert.NoError(t, err)
+		assert.Equal(t, bundle, body)
+	})
+
+	t.Run("test token review cache", func(t *testing.T) {
+		before := reviews
+		_, _ = reviewToken(ctx, "monitoring", time.Now())
+		assert.Equal(t, before, reviews)
+		_, _ = reviewToken(ctx, "monitoring", time.Now().Add(tokenReviewTTL))
+		assert.Equal(t, before+1, reviews)
+	})
+
+}
+
+func Test_CheckAuxAuth(t *testing.T) {
+
+	defer os.Unsetenv(keyAuxAuth)
+	defer os.Unsetenv(keyAuxTokenFile)
+	defer os.Unsetenv(keyAuxAllowed)
+
+	assert.NoError(t, CheckAuxAuth())
+	_ = os.Setenv(keyAuxAuth, auxAuthTokenReview)
+	assert.EqualError(t, CheckAuxAuth(), "CA_BUNDLE_AUX_ALLOWED is required by CA_BUNDLE_AUX_AUTH tokenreview")
+	_ = os.Setenv(keyAuxAllowed, "system:serviceaccounts:monitoring")
+	assert.NoError(t, CheckAuxAuth())
+	_ = os.Setenv(keyAuxAuth, auxAuthToken)
+	assert.EqualError(t, CheckAuxAuth(), "CA_BUNDLE_AUX_TOKEN_FILE is required by CA_BUNDLE_AUX_AUTH token")
+	_ = os.Setenv(keyAuxAuth, "mtls")
+	assert.EqualError(t, CheckAuxAuth(), `unknown CA_BUNDLE_AUX_AUTH "mtls"`)
+
+}
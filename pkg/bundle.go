@@ -0,0 +1,235 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	_ "embed"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	keyCABundleMaxRedirects  = "CA_BUNDLE_MAX_REDIRECTS"
+	keyCABundleRedirectHosts = "CA_BUNDLE_REDIRECT_HOSTS"
+	keyCABundleQuorum        = "CA_BUNDLE_QUORUM"
+	keyCABundleMerge         = "CA_BUNDLE_MERGE"
+	keyCABundleFallback      = "CA_BUNDLE_FALLBACK"
+	keyCABundleSecret        = "CA_BUNDLE_SECRET"
+	keyOffline               = "OFFLINE"
+
+	defaultMaxRedirects = 3
+)
+
+// embeddedBundle is the default ca bundle built into the binary, which is
+// replaced at build time for air-gapped clusters
+//
+//go:embed embedded/ca_bundle.pem
+var embeddedBundle []byte
+
+type loadedBundlesKey struct{}
+
+// withLoadedBundles returns a context in which the bundles already loaded
+// from the given urls are reused instead of loaded again
+func withLoadedBundles(ctx context.Context, bundles map[string][]byte) context.Context {
+	return context.WithValue(ctx, loadedBundlesKey{}, bundles)
+}
+
+// loadCABundle loads the ca bundle from the source selected by url and
+// the configuration, recording its provenance. Bundles are reused from
+// the cache when enabled
+func loadCABundle(ctx context.Context, url string) ([]byte, error) {
+	loaded, _ := ctx.Value(loadedBundlesKey{}).(map[string][]byte)
+	if bundle, ok := loaded[url]; ok {
+		return bundle, nil
+	} else if bundle, ok := cachedCABundle(ctx, url, time.Now()); ok {
+		return bundle, nil
+	}
+	source, err := newBundleSource(url)
+	if err != nil {
+		return nil, err
+	}
+	bundle, provenance, err := source.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	recordProvenance(provenance, bundle)
+	cacheCABundle(url, bundle, time.Now())
+	return bundle, nil
+}
+
+// bundleHTTPClient returns the client used to fetch the ca bundle from
+// its upstream url
+func bundleHTTPClient() *http.Client {
+	maxRedirects := defaultMaxRedirects
+	if value, err := strconv.Atoi(os.Getenv(keyCABundleMaxRedirects)); err == nil {
+		maxRedirects = value
+	}
+	return &http.Client{
+		Transport:     rateLimitedTransport{next: bundleTransport()},
+		CheckRedirect: redirectPolicy(maxRedirects, splitList(os.Getenv(keyCABundleRedirectHosts))),
+	}
+}
+
+// redirectPolicy only follows up to maxRedirects redirects to the original
+// host or to allowedHosts, and never from https to plain http, so that the
+// bundle endpoint can't send the injector to an untrusted location
+func redirectPolicy(maxRedirects int, allowedHosts []string) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		if previous := via[len(via)-1].URL; previous.Scheme == "https" && req.URL.Scheme != "https" {
+			return fmt.Errorf("refusing redirect from https to %s", req.URL.Scheme)
+		}
+		if host := req.URL.Hostname(); host != via[0].URL.Hostname() && !containsString(allowedHosts, host) {
+			return fmt.Errorf("refusing redirect to untrusted host %s", host)
+		}
+		return nil
+	}
+}
+
+// fetchCABundle downloads the ca bundle from url
+func fetchCABundle(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	bundle, _, err := fetchBundle(ctx, client, url)
+	return bundle, err
+}
+
+// fetchBundleOnce downloads the ca bundle from url, describing where it
+// was actually served from
+func fetchBundleOnce(ctx context.Context, client *http.Client, url string) ([]byte, *BundleProvenance, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, upstreamStatusError{url: url, code: resp.StatusCode}
+	}
+	bundle, err := parseBundle(resp.Header.Get("Content-Type"), body)
+	if err != nil {
+		return nil, nil, err
+	}
+	provenance := &BundleProvenance{
+		URI:           url,
+		ResolvedURI:   resp.Request.URL.String(),
+		Format:        detectFormat(resp.Header.Get("Content-Type"), body),
+		ContentSHA256: bundleHash(body),
+	}
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		provenance.Identity = resp.TLS.PeerCertificates[0].Subject.String()
+	}
+	return bundle, provenance, nil
+}
+
+// fetchQuorum downloads the ca bundle from every url in parallel and only
+// accepts it when at least quorum sources serve the same bundle, so that a
+// single compromised mirror can't replace the trusted certificates. The
+// quorum defaults to a majority of the sources
+func fetchQuorum(ctx context.Context, client *http.Client, urls []string, quorum int) ([]byte, *BundleProvenance, error) {
+
+	if quorum <= 0 {
+		quorum = len(urls)/2 + 1
+	}
+
+	type result struct {
+		bundle     []byte
+		provenance *BundleProvenance
+		err        error
+	}
+	results := make([]result, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			started := time.Now()
+			bundle, provenance, err := fetchBundle(ctx, client, url)
+			recordSourceLoad(sourceName(url), started, time.Now(), err)
+			results[i] = result{bundle, provenance, err}
+		}(i, url)
+	}
+	wg.Wait()
+
+	// Group the sources by the hash of the bundle they served
+	agreeing := map[string][]int{}
+	var best string
+	for i, r := range results {
+		if r.err != nil {
+			log.Printf("Unable to fetch ca bundle from %s: %v", urls[i], r.err)
+			continue
+		}
+		hash := bundleHash(r.bundle)
+		agreeing[hash] = append(agreeing[hash], i)
+		if len(agreeing[hash]) > len(agreeing[best]) {
+			best = hash
+		}
+	}
+	if len(agreeing[best]) < quorum {
+		return nil, nil, fmt.Errorf("ca bundle quorum not reached: %d of %d sources agree, %d required", len(agreeing[best]), len(urls), quorum)
+	}
+
+	first := results[agreeing[best][0]]
+	provenance := first.provenance
+	for _, i := range agreeing[best] {
+		provenance.Agreeing = append(provenance.Agreeing, urls[i])
+	}
+	return first.bundle, provenance, nil
+
+}
+
+// bundleHash identifies a bundle revision by its sha256 digest
+func bundleHash(bundle []byte) string {
+	sum := sha256.Sum256(bundle)
+	return hex.EncodeToString(sum[:])
+}
+
+// parseCertificates decodes every CERTIFICATE block of a PEM bundle
+func parseCertificates(bundle []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	for block, rest := pem.Decode(bundle); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	return certificates, nil
+}
+
+// expiryWarnings lists the bundle certificates expiring within window
+func expiryWarnings(certificates []*x509.Certificate, window time.Duration, now time.Time) []string {
+	var warnings []string
+	for _, certificate := range certificates {
+		if certificate.NotAfter.Before(now.Add(window)) {
+			warnings = append(warnings, fmt.Sprintf("ca bundle certificate %q expires on %s", certificate.Subject.CommonName, formatTime(certificate.NotAfter)))
+		}
+	}
+	return warnings
+}
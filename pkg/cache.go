@@ -0,0 +1,67 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+const keyCABundleCacheTTL = "CA_BUNDLE_CACHE_TTL"
+
+var (
+	bundleCache = newLRUCache()
+
+	bundleCacheHits = newMetric(metricTypeCounter, "kac_bundle_cache_hits_total", "Number of ca bundle loads answered from the in-memory cache")
+)
+
+// cachedBundle is a loaded bundle kept for CA_BUNDLE_CACHE_TTL, so that
+// bursts of pod creations on namespaces without bundle don't load it
+// once per namespace
+type cachedBundle struct {
+	bundle   []byte
+	loadedAt time.Time
+}
+
+type freshBundleKey struct{}
+
+// withFreshBundle returns a context in which bundles are loaded from their
+// source regardless of the cache, refreshing it
+func withFreshBundle(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshBundleKey{}, true)
+}
+
+// cachedCABundle returns the bundle loaded from url within the cache ttl
+func cachedCABundle(ctx context.Context, url string, now time.Time) ([]byte, bool) {
+	ttl, _ := time.ParseDuration(os.Getenv(keyCABundleCacheTTL))
+	if ttl <= 0 || ctx.Value(freshBundleKey{}) != nil {
+		return nil, false
+	}
+	value, ok := bundleCache.get(url)
+	if !ok {
+		return nil, false
+	}
+	cached := value.(cachedBundle)
+	if now.Sub(cached.loadedAt) >= ttl {
+		return nil, false
+	}
+	bundleCacheHits.inc()
+	return cached.bundle, true
+}
+
+// cacheCABundle keeps the bundle loaded from url when caching is enabled
+func cacheCABundle(url string, bundle []byte, now time.Time) {
+	if ttl, _ := time.ParseDuration(os.Getenv(keyCABundleCacheTTL)); ttl <= 0 {
+		return
+	}
+	bundleCache.add(url, cachedBundle{bundle: bundle, loadedAt: now})
+}
@@ -0,0 +1,61 @@
+package kac
+
+import (
+	"context"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_BundleCache(t *testing.T) {
+
+	ctx := context.Background()
+	bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write(bundle)
+	}))
+	defer server.Close()
+
+	_ = os.Setenv(keyCABundleCacheTTL, "1m")
+	_ = os.Setenv(keyCABundleURL, server.URL)
+	defer func() {
+		_ = os.Unsetenv(keyCABundleCacheTTL)
+		_ = os.Setenv(keyCABundleURL, caBundleURL)
+		bundleCache = newLRUCache()
+	}()
+
+	t.Run("test cached bundle", func(t *testing.T) {
+		hits := bundleCacheHits.get()
+		for i := 0; i < 3; i++ {
+			loaded, err := loadCABundle(ctx, server.URL)
+			assert.NoError(t, err)
+			assert.Equal(t, bundle, loaded)
+		}
+		assert.Equal(t, 1, requests)
+		assert.Equal(t, hits+2, bundleCacheHits.get())
+	})
+
+	t.Run("test expired bundle", func(t *testing.T) {
+		_, ok := cachedCABundle(ctx, server.URL, time.Now().Add(time.Minute))
+		assert.False(t, ok)
+	})
+
+	t.Run("test forced refresh", func(t *testing.T) {
+		_, err := loadCABundle(withFreshBundle(ctx), server.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, requests)
+		w := fakeRequest(ctx, NewRouter(), http.MethodGet, "/bundle?refresh=true", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 3, requests)
+		w = fakeRequest(ctx, NewRouter(), http.MethodGet, "/bundle", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 3, requests)
+	})
+
+}
@@ -0,0 +1,229 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	keyCanaryNamespace = "CA_BUNDLE_CANARY_NAMESPACE"
+	keyCanaryImage     = "CA_BUNDLE_CANARY_IMAGE"
+	keyCanaryInterval  = "CA_BUNDLE_CANARY_INTERVAL"
+	keyCanaryTimeout   = "CA_BUNDLE_CANARY_TIMEOUT"
+
+	canaryPodName        = "kac-ca-injector-canary"
+	defaultCanaryImage   = "busybox"
+	defaultCanaryPeriod  = 15 * time.Minute
+	defaultCanaryTimeout = 2 * time.Minute
+	canaryPollPeriod     = 2 * time.Second
+
+	eventReasonCanarySucceeded = "CanarySucceeded"
+	eventReasonCanaryFailed    = "CanaryFailed"
+)
+
+var (
+	canarySuccess     = newMetric(metricTypeGauge, "kac_canary_success", "Whether the last canary pod found the ca bundle mounted with the expected content")
+	canaryLastSuccess = newMetric(metricTypeGauge, "kac_canary_last_success_timestamp_seconds", "Unix time of the last successful canary pod")
+)
+
+// RunCanaryProbe periodically verifies the whole injection chain: an
+// annotated canary pod is created on CA_BUNDLE_CANARY_NAMESPACE, and the
+// digest of the bundle it reads from its mount is compared with the
+// bundle stored on the namespace. The outcome is reported as metrics and
+// events. It returns when ctx is done
+func RunCanaryProbe(ctx context.Context) {
+
+	namespace := os.Getenv(keyCanaryNamespace)
+	if namespace == "" || !featureEnabled(featureCanaryProbe) {
+		return
+	}
+	interval, _ := time.ParseDuration(os.Getenv(keyCanaryInterval))
+	if interval <= 0 {
+		interval = defaultCanaryPeriod
+	}
+
+	clientSet, err := getKubernetesClientSet(ctx)
+	if err != nil {
+		log.Printf("Unable to start canary probe: %v", err)
+		return
+	}
+
+	registerReconciler("canary", interval)
+	defer unregisterReconciler("canary")
+	for {
+		err := runCanary(ctx, clientSet, namespace)
+		if err != nil {
+			log.Printf("Canary pod %s/%s failed: %v", namespace, canaryPodName, err)
+			canarySuccess.set(0)
+			recordCanaryEvent(ctx, clientSet, namespace, eventReasonCanaryFailed, err.Error())
+		} else {
+			canarySuccess.set(1)
+			canaryLastSuccess.set(float64(time.Now().Unix()))
+			recordCanaryEvent(ctx, clientSet, namespace, eventReasonCanarySucceeded, "ca bundle mounted with the expected content")
+		}
+		recordReconcile("canary", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+
+}
+
+// canaryPod returns an annotated pod printing the digest of the bundle
+// at the default mount path
+func canaryPod(namespace string) *corev1.Pod {
+	config, _ := currentConfig()
+	image := os.Getenv(keyCanaryImage)
+	if image == "" {
+		image = defaultCanaryImage
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        canaryPodName,
+			Namespace:   namespace,
+			Labels:      map[string]string{labelManagedBy: labelManagedByValue},
+			Annotations: map[string]string{config.Annotation: defaultBundleValue},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{{
+				Name:    "canary",
+				Image:   image,
+				Command: []string{"sha256sum", canaryMountPath()},
+			}},
+		},
+	}
+}
+
+func canaryMountPath() string {
+	config, _ := currentConfig()
+	return "/etc/ssl/certs/" + config.BundleFilename
+}
+
+func runCanary(ctx context.Context, clientSet kubernetes.Interface, namespace string) error {
+
+	timeout, _ := time.ParseDuration(os.Getenv(keyCanaryTimeout))
+	if timeout <= 0 {
+		timeout = defaultCanaryTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pods := clientSet.CoreV1().Pods(namespace)
+	if err := pods.Delete(ctx, canaryPodName, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	defer func() {
+		if err := pods.Delete(context.Background(), canaryPodName, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("Unable to delete canary pod %s/%s: %v", namespace, canaryPodName, err)
+		}
+	}()
+
+	// The previous canary may still be terminating
+	var pod *corev1.Pod
+	var err error
+	for {
+		if pod, err = pods.Create(ctx, canaryPod(namespace), metav1.CreateOptions{}); !apierrors.IsAlreadyExists(err) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(canaryPollPeriod):
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !hasMountPath(pod.Spec.Containers[0], canaryMountPath()) {
+		return fmt.Errorf("ca bundle not mounted at %s by the admission webhook", canaryMountPath())
+	}
+
+	for pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("canary pod not completed within %s, last phase %s", timeout, pod.Status.Phase)
+		case <-time.After(canaryPollPeriod):
+		}
+		if pod, err = pods.Get(ctx, canaryPodName, metav1.GetOptions{}); err != nil {
+			return err
+		}
+	}
+	output, err := pods.GetLogs(canaryPodName, &corev1.PodLogOptions{}).DoRaw(ctx)
+	if err != nil {
+		return err
+	}
+
+	config, _ := currentConfig()
+	configMap, err := getBundle(ctx, clientSet, namespace, config.ConfigMapName)
+	if err != nil {
+		return err
+	}
+	return verifyCanary(pod, string(output), bundleHash([]byte(configMap.Data[config.BundleFilename])))
+
+}
+
+// verifyCanary checks the sha256sum output of a completed canary pod
+// against the digest of the stored bundle
+func verifyCanary(pod *corev1.Pod, output string, expected string) error {
+	if pod.Status.Phase != corev1.PodSucceeded {
+		return fmt.Errorf("canary pod %s: %s", strings.ToLower(string(pod.Status.Phase)), strings.TrimSpace(output))
+	}
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return fmt.Errorf("canary pod printed no digest")
+	} else if fields[0] != expected {
+		return fmt.Errorf("mounted ca bundle digest %s differs from the stored bundle %s", fields[0], expected)
+	}
+	return nil
+}
+
+func recordCanaryEvent(ctx context.Context, clientSet kubernetes.Interface, namespace string, reason string, message string) {
+	eventType := corev1.EventTypeNormal
+	if reason == eventReasonCanaryFailed {
+		eventType = corev1.EventTypeWarning
+	}
+	now := metav1.Now()
+	if _, err := clientSet.CoreV1().Events(namespace).Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", canaryPodName, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       canaryPodName,
+			Namespace:  namespace,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: eventSource},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{}); err != nil {
+		log.Printf("Unable to record event for canary pod %s/%s: %v", namespace, canaryPodName, err)
+	}
+}
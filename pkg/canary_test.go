@@ -0,0 +1,41 @@
+package kac
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+	"testing"
+)
+
+func Test_CanaryProbe(t *testing.T) {
+
+	expected := bundleHash([]byte("bundle"))
+	succeeded := &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodSucceeded}}
+
+	t.Run("test canary pod", func(t *testing.T) {
+		pod := canaryPod("canary")
+		assert.Equal(t, "true", pod.Annotations[os.Getenv(keyCABundleAnnotation)])
+		assert.Equal(t, []string{"sha256sum", "/etc/ssl/certs/" + os.Getenv(keyCABundleFilename)}, pod.Spec.Containers[0].Command)
+		assert.Equal(t, corev1.RestartPolicyNever, pod.Spec.RestartPolicy)
+	})
+
+	t.Run("test verify canary output", func(t *testing.T) {
+		assert.NoError(t, verifyCanary(succeeded, expected+"  /etc/ssl/certs/ca_bundle.pem\n", expected))
+		assert.EqualError(t, verifyCanary(succeeded, bundleHash([]byte("other"))+"  /etc/ssl/certs/ca_bundle.pem\n", expected),
+			"mounted ca bundle digest "+bundleHash([]byte("other"))+" differs from the stored bundle "+expected)
+		assert.EqualError(t, verifyCanary(&corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodFailed}}, "sha256sum: /etc/ssl/certs/ca_bundle.pem: No such file or directory\n", expected),
+			"canary pod failed: sha256sum: /etc/ssl/certs/ca_bundle.pem: No such file or directory")
+	})
+
+	t.Run("test canary pod not injected", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset()
+		err := runCanary(context.Background(), clientSet, "canary")
+		assert.EqualError(t, err, "ca bundle not mounted at /etc/ssl/certs/"+os.Getenv(keyCABundleFilename)+" by the admission webhook")
+		_, err = clientSet.CoreV1().Pods("canary").Get(context.Background(), canaryPodName, metav1.GetOptions{})
+		assert.Error(t, err)
+	})
+
+}
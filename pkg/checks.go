@@ -0,0 +1,63 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"log"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	keyWebhookConfiguration = "WEBHOOK_CONFIGURATION"
+)
+
+var (
+	webhookSelectorsMissing = newMetric(metricTypeGauge, "kac_webhook_selectors_missing", "Whether a webhook sends pods to the injector without object or namespace selector", "webhook")
+)
+
+// CheckWebhookConfiguration warns about webhooks of the injector's
+// MutatingWebhookConfiguration that have neither an object nor a
+// namespace selector
+func CheckWebhookConfiguration(ctx context.Context) error {
+	name := os.Getenv(keyWebhookConfiguration)
+	if name == "" {
+		return nil
+	}
+	clientSet, err := getKubernetesClientSet(ctx)
+	if err != nil {
+		return err
+	}
+	return checkWebhookSelectors(ctx, clientSet, name)
+}
+
+func checkWebhookSelectors(ctx context.Context, clientSet kubernetes.Interface, name string) error {
+	configuration, err := clientSet.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	for _, webhook := range configuration.Webhooks {
+		if isEmptySelector(webhook.ObjectSelector) && isEmptySelector(webhook.NamespaceSelector) {
+			log.Printf("Webhook %s has no object or namespace selector, every pod in the cluster will be sent to the injector", webhook.Name)
+			webhookSelectorsMissing.set(1, webhook.Name)
+		} else {
+			webhookSelectorsMissing.set(0, webhook.Name)
+		}
+	}
+	return nil
+}
+
+func isEmptySelector(selector *metav1.LabelSelector) bool {
+	return selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0)
+}
@@ -0,0 +1,40 @@
+package kac
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func Test_CheckWebhookSelectors(t *testing.T) {
+
+	ctx := context.Background()
+	clientSet := fake.NewSimpleClientset(&admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "ca-injector"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{
+				Name: "unscoped.example.com",
+			},
+			{
+				Name: "scoped.example.com",
+				ObjectSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "example"},
+				},
+			},
+		},
+	})
+
+	t.Run("test missing webhook configuration", func(t *testing.T) {
+		assert.Error(t, checkWebhookSelectors(ctx, clientSet, "missing"))
+	})
+
+	t.Run("test webhook selectors", func(t *testing.T) {
+		assert.NoError(t, checkWebhookSelectors(ctx, clientSet, "ca-injector"))
+		assert.Equal(t, float64(1), webhookSelectorsMissing.get("unscoped.example.com"))
+		assert.Equal(t, float64(0), webhookSelectorsMissing.get("scoped.example.com"))
+	})
+
+}
@@ -0,0 +1,67 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const keyCABundleSHA256 = "CA_BUNDLE_SHA256"
+
+// expectedChecksum returns the sha256 digest the downloaded bundle must
+// match, or an empty string when none is configured. CA_BUNDLE_SHA256 is
+// either the hex digest itself or the http(s) url of a checksum file in
+// the sha256sum format, such as the .sha256 file published next to the
+// bundle
+func expectedChecksum(ctx context.Context, client *http.Client) (string, error) {
+	value := strings.TrimSpace(os.Getenv(keyCABundleSHA256))
+	if value == "" {
+		return "", nil
+	} else if strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "http://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, value, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		defer func() { _ = resp.Body.Close() }()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("unable to fetch ca bundle checksum from %s: %s", value, resp.Status)
+		}
+		value = ""
+		if fields := strings.Fields(string(body)); len(fields) > 0 {
+			value = fields[0]
+		}
+	}
+	value = strings.ToLower(value)
+	if decoded, err := hex.DecodeString(value); err != nil || len(decoded) != 32 {
+		return "", fmt.Errorf("invalid ca bundle checksum %q, expected a sha256 hex digest", value)
+	}
+	return value, nil
+}
+
+// verifyChecksum refuses a bundle whose content, as served, doesn't match
+// the expected digest
+func verifyChecksum(expected string, provenance *BundleProvenance) error {
+	if expected != "" && provenance.ContentSHA256 != expected {
+		return invalidBundleError{fmt.Errorf("ca bundle checksum mismatch: expected %s, got %s from %s", expected, provenance.ContentSHA256, provenance.URI)}
+	}
+	return nil
+}
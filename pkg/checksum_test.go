@@ -0,0 +1,66 @@
+package kac
+
+import (
+	"context"
+	"errors"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_BundleChecksum(t *testing.T) {
+
+	ctx := context.Background()
+	bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	checksum := bundleHash(bundle)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ca_bundle.pem":
+			_, _ = w.Write(bundle)
+		case "/ca_bundle.pem.sha256":
+			_, _ = w.Write([]byte(checksum + "  ca_bundle.pem\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer func() {
+		_ = os.Unsetenv(keyCABundleSHA256)
+		lastProvenance = nil
+	}()
+
+	t.Run("test matching checksum", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleSHA256, checksum)
+		loaded, err := loadCABundle(ctx, server.URL+"/ca_bundle.pem")
+		assert.NoError(t, err)
+		assert.Equal(t, bundle, loaded)
+		assert.Equal(t, checksum, currentProvenance().ContentSHA256)
+	})
+
+	t.Run("test companion checksum url", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleSHA256, server.URL+"/ca_bundle.pem.sha256")
+		_, err := loadCABundle(ctx, server.URL+"/ca_bundle.pem")
+		assert.NoError(t, err)
+		_ = os.Setenv(keyCABundleSHA256, server.URL+"/missing.sha256")
+		_, err = loadCABundle(ctx, server.URL+"/ca_bundle.pem")
+		assert.Error(t, err)
+	})
+
+	t.Run("test checksum mismatch", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleSHA256, bundleHash([]byte("other")))
+		_, err := loadCABundle(ctx, server.URL+"/ca_bundle.pem")
+		assert.True(t, errors.As(err, &invalidBundleError{}))
+		assert.Contains(t, err.Error(), "ca bundle checksum mismatch")
+	})
+
+	t.Run("test invalid checksum", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleSHA256, "not-a-digest")
+		_, err := loadCABundle(ctx, server.URL+"/ca_bundle.pem")
+		assert.EqualError(t, err, `invalid ca bundle checksum "not-a-digest", expected a sha256 hex digest`)
+	})
+
+}
@@ -0,0 +1,493 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	keyConfigFile  = "CONFIG_FILE"
+	keyClusterName = "CLUSTER_NAME"
+
+	// configReloadInterval is how often the configuration file is checked
+	// for changes
+	configReloadInterval = 10 * time.Second
+)
+
+var (
+	configFileMutex sync.Mutex
+	// configFileValues are the settings of the configuration file not
+	// overridden by the process environment, as of startup or the last
+	// reload
+	configFileValues = map[string]string{}
+
+	configKeysOnce sync.Once
+	// configKeys are the settings read into Config, the only ones a reload
+	// changes
+	configKeys map[string]bool
+
+	configMutex sync.RWMutex
+	// publishedConfig is the configuration every review reads, replaced
+	// as a whole on reload
+	publishedConfig *Config
+
+	configReloads = newMetric(metricTypeCounter, "kac_config_reloads_total", "Number of configuration file reloads, by result", "result")
+)
+
+// Config is the configuration of the injector, as set on the process
+// environment or the configuration file
+type Config struct {
+	ConfigMapName       string
+	BundleFilename      string
+	Annotation          string
+	EnvVars             []string
+	InjectSidecars      bool
+	InjectInit          bool
+	InjectEphemeral     bool
+	PodNamespace        string
+	InjectorSelector    labels.Selector
+	PodSelector         labels.Selector
+	ExpiryWarning       time.Duration
+	HashLabel           string
+	Templates           string
+	PrivilegedPolicy    string
+	ForeignPolicy       string
+	MaxPatchSize        int
+	MountConflictPolicy string
+	PodSecurityCheck    bool
+	Location            *time.Location
+	Target              string
+	JavaTruststore      bool
+	Mode                string
+	BundleURL           string
+	Bundles             string
+	Profiles            string
+	NamespaceLabel      string
+	NamespaceTTL        time.Duration
+	VolumeName          string
+	InjectionStrategy   string
+	TrustStoreImage     string
+	TrustStoreCommand   string
+}
+
+// loadConfig reads the configuration with lookup and validates it, so
+// that a missing or mistyped setting fails on startup instead of
+// producing a bundle under an empty key. The configuration is returned
+// along with the error, for callers not depending on the reviewer
+// settings
+func loadConfig(lookup func(string) string) (Config, error) {
+
+	config := Config{
+		ConfigMapName:       lookup(keyConfigMapName),
+		BundleFilename:      lookup(keyCABundleFilename),
+		Annotation:          lookup(keyCABundleAnnotation),
+		EnvVars:             bundleEnvVars(lookup),
+		InjectSidecars:      lookup(keyInjectSidecars) == "true",
+		InjectInit:          lookup(keyInjectInit) == "true",
+		InjectEphemeral:     lookup(keyInjectEphemeral) == "true",
+		PodNamespace:        lookup(keyPodNamespace),
+		HashLabel:           lookup(keyCABundleHashLabel),
+		Templates:           lookup(keyCABundleTemplates),
+		PrivilegedPolicy:    lookup(keyPrivilegedPolicy),
+		ForeignPolicy:       lookup(keyForeignPolicy),
+		MountConflictPolicy: lookup(keyMountConflict),
+		PodSecurityCheck:    lookup(keyPodSecurityCheck) == "true",
+		Location:            time.UTC,
+		Target:              targetConfigMap,
+		JavaTruststore:      lookup(keyJavaTruststore) == "true",
+		Mode:                ModeAll,
+		BundleURL:           lookup(keyCABundleURL),
+		Bundles:             lookup(keyCABundles),
+		Profiles:            lookup(keyCABundleProfiles),
+		NamespaceLabel:      lookup(keyNamespaceLabel),
+		NamespaceTTL:        defaultNamespaceTTL,
+		VolumeName:          lookup(keyVolumeName),
+		InjectionStrategy:   lookup(keyInjectionStrategy),
+		TrustStoreImage:     lookup(keyTrustStoreImage),
+		TrustStoreCommand:   lookup(keyTrustStoreCommand),
+	}
+
+	var errs []string
+	invalid := func(key string, value string, reasons ...string) {
+		errs = append(errs, fmt.Sprintf("invalid %s %q: %s", key, value, strings.Join(reasons, ", ")))
+	}
+	for key, value := range map[string]string{keyConfigMapName: config.ConfigMapName, keyCABundleFilename: config.BundleFilename, keyCABundleAnnotation: config.Annotation} {
+		if value == "" {
+			errs = append(errs, key+" is required")
+		}
+	}
+	if msgs := validation.IsDNS1123Subdomain(config.ConfigMapName); config.ConfigMapName != "" && len(msgs) > 0 {
+		invalid(keyConfigMapName, config.ConfigMapName, msgs...)
+	}
+	if msgs := validation.IsConfigMapKey(config.BundleFilename); config.BundleFilename != "" && len(msgs) > 0 {
+		invalid(keyCABundleFilename, config.BundleFilename, msgs...)
+	}
+	if msgs := validation.IsQualifiedName(config.Annotation); config.Annotation != "" && len(msgs) > 0 {
+		invalid(keyCABundleAnnotation, config.Annotation, msgs...)
+	}
+	if msgs := validation.IsQualifiedName(config.HashLabel); config.HashLabel != "" && len(msgs) > 0 {
+		invalid(keyCABundleHashLabel, config.HashLabel, msgs...)
+	}
+	if value := lookup(keyInjectorSelector); value != "" {
+		var err error
+		if config.InjectorSelector, err = labels.Parse(value); err != nil {
+			invalid(keyInjectorSelector, value, err.Error())
+		}
+	}
+	if value := lookup(keyPodSelector); value != "" {
+		var err error
+		if config.PodSelector, err = labels.Parse(value); err != nil {
+			invalid(keyPodSelector, value, err.Error())
+		}
+	}
+	if value := lookup(keyCABundleExpiryWarn); value != "" {
+		var err error
+		if config.ExpiryWarning, err = time.ParseDuration(value); err != nil {
+			invalid(keyCABundleExpiryWarn, value, "expected a duration")
+		}
+	}
+	if value := lookup(keyNamespaceTTL); value != "" {
+		var err error
+		if config.NamespaceTTL, err = time.ParseDuration(value); err != nil {
+			invalid(keyNamespaceTTL, value, "expected a duration")
+		}
+	}
+	if value := lookup(keyMaxPatchSize); value != "" {
+		var err error
+		if config.MaxPatchSize, err = strconv.Atoi(value); err != nil || config.MaxPatchSize < 0 {
+			invalid(keyMaxPatchSize, value, "expected a number of bytes")
+		}
+	}
+	if value := config.PrivilegedPolicy; value != "" && value != privilegedPolicySkip && value != privilegedPolicyWarn {
+		invalid(keyPrivilegedPolicy, value, "expected "+privilegedPolicySkip+" or "+privilegedPolicyWarn)
+	}
+	if value := config.ForeignPolicy; value != "" && value != foreignPolicySkip && value != foreignPolicyWarn {
+		invalid(keyForeignPolicy, value, "expected "+foreignPolicySkip+" or "+foreignPolicyWarn)
+	}
+	if value := config.MountConflictPolicy; value != "" && value != mountConflictPolicyWarn && value != mountConflictPolicyDeny {
+		invalid(keyMountConflict, value, "expected "+mountConflictPolicyWarn+" or "+mountConflictPolicyDeny)
+	}
+	if value := lookup(keyTimezone); value != "" {
+		location, err := time.LoadLocation(value)
+		if err != nil {
+			invalid(keyTimezone, value, "expected an IANA time zone")
+		} else {
+			config.Location = location
+		}
+	}
+	switch value := lookup(keyCABundleTarget); value {
+	case "", targetConfigMap:
+	case targetSecret:
+		config.Target = targetSecret
+	default:
+		invalid(keyCABundleTarget, value, "expected "+targetConfigMap+" or "+targetSecret)
+	}
+	if config.JavaTruststore && config.Target != targetSecret {
+		invalid(keyJavaTruststore, "true", "java truststores are binary and require "+keyCABundleTarget+"="+targetSecret)
+	}
+	switch value := lookup(keyInjectorMode); value {
+	case "", ModeAll:
+	case ModeWebhook, ModeController:
+		config.Mode = value
+	default:
+		invalid(keyInjectorMode, value, "expected "+ModeAll+", "+ModeWebhook+" or "+ModeController)
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return config, fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+	return config, nil
+
+}
+
+// currentConfig returns the published configuration. Before one is
+// published, as in the cli commands, it is read from the process
+// environment and the configuration file
+func currentConfig() (Config, error) {
+	configMutex.RLock()
+	published := publishedConfig
+	configMutex.RUnlock()
+	if published != nil {
+		return *published, nil
+	}
+	return loadConfig(lookupSetting)
+}
+
+// lookupSetting returns the value of a setting, the variables set on the
+// process environment taking precedence over the configuration file
+func lookupSetting(key string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	configFileMutex.Lock()
+	defer configFileMutex.Unlock()
+	return configFileValues[key]
+}
+
+// isConfigKey tells whether the setting is read into Config
+func isConfigKey(key string) bool {
+	configKeysOnce.Do(func() {
+		configKeys = map[string]bool{}
+		_, _ = loadConfig(func(key string) string {
+			configKeys[key] = true
+			return ""
+		})
+	})
+	return configKeys[key]
+}
+
+// publishConfig replaces the configuration read by every review
+func publishConfig(config Config) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	publishedConfig = &config
+	setReportingLocation(config.Location)
+}
+
+// configFile holds default settings and per-environment overlays, keyed
+// by the same names as the environment variables
+type configFile struct {
+	Defaults     map[string]string            `json:"defaults"`
+	Environments map[string]configEnvironment `json:"environments"`
+}
+
+// configEnvironment overrides the settings of the environment it extends,
+// or the defaults
+type configEnvironment struct {
+	Extends string            `json:"extends"`
+	Values  map[string]string `json:"values"`
+}
+
+// LoadConfigFile reads the settings of the configuration file for the
+// environment named by CLUSTER_NAME, the variables set on the process
+// environment taking precedence. The settings outside of Config are
+// applied to the process environment, where they are read from. It is
+// called once on startup
+func LoadConfigFile() error {
+
+	values, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+	configFileMutex.Lock()
+	defer configFileMutex.Unlock()
+	applied := map[string]string{}
+	for key, value := range values {
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+		applied[key] = value
+		if !isConfigKey(key) {
+			_ = os.Setenv(key, value)
+		}
+	}
+	configFileValues = applied
+	return nil
+
+}
+
+// LoadConfig validates the configuration of the server and publishes it
+func LoadConfig() error {
+	config, err := loadConfig(lookupSetting)
+	if err != nil {
+		return err
+	}
+	publishConfig(config)
+	return nil
+}
+
+// readConfigFile returns the settings of the configuration file for the
+// environment named by CLUSTER_NAME, none when CONFIG_FILE is unset
+func readConfigFile() (map[string]string, error) {
+	path := os.Getenv(keyConfigFile)
+	if path == "" {
+		return map[string]string{}, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config configFile
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse config file %s: %w", path, err)
+	}
+	return config.resolve(os.Getenv(keyClusterName))
+}
+
+// reloadConfig reads the configuration file again and publishes the
+// resulting configuration when valid. The settings the file set are
+// replaced by its current ones, while the variables set on the process
+// environment still take precedence. Changes to the settings outside of
+// Config, read from the process environment, and to the ones naming the
+// bundle objects and the mode are refused since they only apply on
+// restart
+func reloadConfig() error {
+
+	values, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+	configFileMutex.Lock()
+	fromFile := configFileValues
+	configFileMutex.Unlock()
+	lookup := func(key string) string {
+		if _, ok := fromFile[key]; !ok {
+			if value, ok := os.LookupEnv(key); ok {
+				return value
+			}
+		}
+		return values[key]
+	}
+	config, err := loadConfig(lookup)
+	if err != nil {
+		return err
+	}
+
+	var fixed []string
+	current, _ := currentConfig()
+	for key, changed := range map[string]bool{
+		keyConfigMapName:      config.ConfigMapName != current.ConfigMapName,
+		keyCABundleFilename:   config.BundleFilename != current.BundleFilename,
+		keyCABundleAnnotation: config.Annotation != current.Annotation,
+		keyCABundleTarget:     config.Target != current.Target,
+		keyInjectorMode:       config.Mode != current.Mode,
+	} {
+		if changed {
+			fixed = append(fixed, key)
+		}
+	}
+	for _, settings := range []map[string]string{fromFile, values} {
+		for key := range settings {
+			if !isConfigKey(key) && !containsString(fixed, key) && lookup(key) != os.Getenv(key) {
+				fixed = append(fixed, key)
+			}
+		}
+	}
+	if len(fixed) > 0 {
+		sort.Strings(fixed)
+		return fmt.Errorf("%s can't change on reload", strings.Join(fixed, ", "))
+	}
+
+	applied := map[string]string{}
+	for key, value := range values {
+		if _, ok := fromFile[key]; ok {
+			applied[key] = value
+		} else if _, ok := os.LookupEnv(key); !ok {
+			applied[key] = value
+		}
+	}
+	configFileMutex.Lock()
+	configFileValues = applied
+	configFileMutex.Unlock()
+	publishConfig(config)
+	return nil
+
+}
+
+// RunConfigReload reloads the configuration file on SIGHUP and whenever
+// it changes, e.g. when the configmap it is mounted from is updated.
+// Invalid configurations are logged and leave the current one in place
+func RunConfigReload(ctx context.Context) {
+	path := os.Getenv(keyConfigFile)
+	if path == "" {
+		return
+	}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	ticker := time.NewTicker(configReloadInterval)
+	defer ticker.Stop()
+	watchConfigFile(ctx, path, hangup, ticker.C)
+}
+
+// watchConfigFile reloads the configuration file at path on every hangup,
+// and on the ticks finding it modified, until ctx is done
+func watchConfigFile(ctx context.Context, path string, hangup <-chan os.Signal, ticks <-chan time.Time) {
+	modTime := configModTime(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+		case <-ticks:
+			if current := configModTime(path); current.Equal(modTime) {
+				continue
+			}
+		}
+		modTime = configModTime(path)
+		if err := reloadConfig(); err != nil {
+			log.Printf("Unable to reload config file %s, keeping the current configuration: %v", path, err)
+			configReloads.inc("error")
+			continue
+		}
+		log.Printf("Reloaded config file %s", path)
+		configReloads.inc("ok")
+	}
+}
+
+// configModTime returns the modification time of the file at path, the
+// zero time when it can't be read
+func configModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// resolve merges the defaults with the chain of overlays leading to the
+// named environment, the closest overlay winning
+func (c configFile) resolve(environment string) (map[string]string, error) {
+
+	var chain []configEnvironment
+	visited := map[string]bool{}
+	for name := environment; name != ""; {
+		if visited[name] {
+			return nil, fmt.Errorf("config environment %s extends itself", name)
+		}
+		visited[name] = true
+		overlay, ok := c.Environments[name]
+		if !ok {
+			return nil, fmt.Errorf("config environment %s not found", name)
+		}
+		chain = append(chain, overlay)
+		name = overlay.Extends
+	}
+
+	values := map[string]string{}
+	for key, value := range c.Defaults {
+		values[key] = value
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for key, value := range chain[i].Values {
+			values[key] = value
+		}
+	}
+	return values, nil
+
+}
@@ -0,0 +1,234 @@
+package kac
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func resetConfig() {
+	configMutex.Lock()
+	publishedConfig = nil
+	configMutex.Unlock()
+	configFileMutex.Lock()
+	configFileValues = map[string]string{}
+	configFileMutex.Unlock()
+	setReportingLocation(time.UTC)
+}
+
+// testConfig returns the configuration as the reviews of the test read it
+func testConfig() Config {
+	config, _ := currentConfig()
+	return config
+}
+
+func Test_LoadConfigFile(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	_ = os.WriteFile(path, []byte(`
+defaults:
+  CA_BUNDLE_URL: https://pki.example.com/bundle.pem
+  CA_BUNDLE_EXPIRY_WARNING: 720h
+environments:
+  stage:
+    values:
+      CA_BUNDLE_URL: https://pki.stage.example.com/bundle.pem
+  prod:
+    extends: stage
+    values:
+      CA_BUNDLE_EXPIRY_WARNING: 2160h
+  loop:
+    extends: loop
+`), 0644)
+
+	_ = os.Setenv(keyConfigFile, path)
+	defer func() {
+		_ = os.Unsetenv(keyConfigFile)
+		_ = os.Unsetenv(keyClusterName)
+		_ = os.Unsetenv(keyCABundleExpiryWarn)
+		_ = os.Setenv(keyCABundleURL, caBundleURL)
+		resetConfig()
+	}()
+
+	t.Run("test environment overlays", func(t *testing.T) {
+		_ = os.Setenv(keyClusterName, "prod")
+		_ = os.Unsetenv(keyCABundleURL)
+		_ = os.Unsetenv(keyCABundleExpiryWarn)
+		assert.NoError(t, LoadConfigFile())
+		assert.Equal(t, "https://pki.stage.example.com/bundle.pem", testConfig().BundleURL)
+		assert.Equal(t, 2160*time.Hour, testConfig().ExpiryWarning)
+		assert.Empty(t, os.Getenv(keyCABundleURL))
+	})
+
+	t.Run("test environment variables take precedence", func(t *testing.T) {
+		_ = os.Setenv(keyClusterName, "prod")
+		_ = os.Setenv(keyCABundleURL, "https://override.example.com")
+		assert.NoError(t, LoadConfigFile())
+		assert.Equal(t, "https://override.example.com", testConfig().BundleURL)
+	})
+
+	t.Run("test unknown and cyclic environments", func(t *testing.T) {
+		_ = os.Setenv(keyClusterName, "dev")
+		assert.EqualError(t, LoadConfigFile(), "config environment dev not found")
+		_ = os.Setenv(keyClusterName, "loop")
+		assert.EqualError(t, LoadConfigFile(), "config environment loop extends itself")
+	})
+
+	t.Run("test missing config file", func(t *testing.T) {
+		_ = os.Unsetenv(keyConfigFile)
+		defer func() { _ = os.Setenv(keyConfigFile, path) }()
+		assert.NoError(t, LoadConfigFile())
+	})
+
+	t.Run("test settings outside of the configuration", func(t *testing.T) {
+		_ = os.WriteFile(path, []byte("defaults:\n  CA_BUNDLE_REFRESH_INTERVAL: 1m\n"), 0644)
+		_ = os.Unsetenv(keyClusterName)
+		defer func() { _ = os.Unsetenv(keyRefreshInterval) }()
+		assert.NoError(t, LoadConfigFile())
+		assert.Equal(t, "1m", os.Getenv(keyRefreshInterval))
+	})
+
+}
+
+func Test_LoadConfig(t *testing.T) {
+
+	defer resetConfig()
+
+	t.Run("test missing settings", func(t *testing.T) {
+		_ = os.Unsetenv(keyCABundleFilename)
+		defer func() { _ = os.Setenv(keyCABundleFilename, "ca_bundle.pem") }()
+		assert.EqualError(t, LoadConfig(), "invalid configuration: CA_BUNDLE_FILENAME is required")
+		_, err := currentConfig()
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid settings", func(t *testing.T) {
+		for key, value := range map[string]string{
+			keyPodSelector:    "team in (",
+			keyTimezone:       "Mars/Olympus_Mons",
+			keyCABundleTarget: "vault",
+			keyInjectorMode:   "sidecar",
+			keyForeignPolicy:  "deny",
+		} {
+			_ = os.Setenv(key, value)
+			defer func(key string) { _ = os.Unsetenv(key) }(key)
+		}
+		err := LoadConfig()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), `invalid CA_BUNDLE_TARGET "vault": expected configmap or secret`)
+		assert.Contains(t, err.Error(), `invalid CA_BUNDLE_TIMEZONE "Mars/Olympus_Mons": expected an IANA time zone`)
+		assert.Contains(t, err.Error(), `invalid INJECTOR_MODE "sidecar": expected all, webhook or controller`)
+		assert.Contains(t, err.Error(), `invalid CA_BUNDLE_POD_SELECTOR "team in ("`)
+		assert.Contains(t, err.Error(), `invalid CA_BUNDLE_FOREIGN_POLICY "deny": expected skip or warn`)
+	})
+
+	t.Run("test java truststore requires the secret target", func(t *testing.T) {
+		_ = os.Setenv(keyJavaTruststore, "true")
+		defer func() { _ = os.Unsetenv(keyJavaTruststore) }()
+		assert.EqualError(t, LoadConfig(), `invalid configuration: invalid CA_BUNDLE_JAVA_TRUSTSTORE "true": java truststores are binary and require CA_BUNDLE_TARGET=secret`)
+		_ = os.Setenv(keyCABundleTarget, targetSecret)
+		defer func() { _ = os.Unsetenv(keyCABundleTarget) }()
+		assert.NoError(t, LoadConfig())
+	})
+
+	t.Run("test published configuration", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleTarget, targetSecret)
+		defer func() { _ = os.Unsetenv(keyCABundleTarget) }()
+		assert.NoError(t, LoadConfig())
+		_ = os.Unsetenv(keyCABundleTarget)
+		assert.Equal(t, targetSecret, bundleTarget())
+		assert.Equal(t, ModeAll, Mode())
+	})
+
+}
+
+func Test_ReloadConfig(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		_ = os.WriteFile(path, []byte(content), 0644)
+	}
+	expiryWarning := func() time.Duration {
+		config, err := currentConfig()
+		assert.NoError(t, err)
+		return config.ExpiryWarning
+	}
+
+	write("defaults:\n  CA_BUNDLE_EXPIRY_WARNING: 1h\n  CA_BUNDLE_MAX_PATCH_SIZE: \"50\"\n")
+	_ = os.Setenv(keyConfigFile, path)
+	_ = os.Setenv(keyMaxPatchSize, "100")
+	_ = os.Unsetenv(keyCABundleExpiryWarn)
+	defer func() {
+		_ = os.Unsetenv(keyConfigFile)
+		_ = os.Unsetenv(keyMaxPatchSize)
+		_ = os.Unsetenv(keyCABundleExpiryWarn)
+		resetConfig()
+	}()
+	assert.NoError(t, LoadConfigFile())
+	assert.NoError(t, LoadConfig())
+	assert.Equal(t, time.Hour, expiryWarning())
+
+	t.Run("test reload", func(t *testing.T) {
+		write("defaults:\n  CA_BUNDLE_EXPIRY_WARNING: 2h\n")
+		assert.NoError(t, reloadConfig())
+		assert.Equal(t, 2*time.Hour, expiryWarning())
+		assert.Empty(t, os.Getenv(keyCABundleExpiryWarn))
+
+		write("defaults:\n  CA_BUNDLE_EXPIRY_WARNING: soon\n")
+		assert.EqualError(t, reloadConfig(), `invalid configuration: invalid CA_BUNDLE_EXPIRY_WARNING "soon": expected a duration`)
+		assert.Equal(t, 2*time.Hour, expiryWarning())
+
+		write("defaults: {}\n")
+		assert.NoError(t, reloadConfig())
+		assert.Equal(t, time.Duration(0), expiryWarning())
+	})
+
+	t.Run("test environment variables take precedence", func(t *testing.T) {
+		write("defaults:\n  CA_BUNDLE_MAX_PATCH_SIZE: \"10\"\n")
+		assert.NoError(t, reloadConfig())
+		config, _ := currentConfig()
+		assert.Equal(t, 100, config.MaxPatchSize)
+	})
+
+	t.Run("test mode can't change", func(t *testing.T) {
+		write("defaults:\n  INJECTOR_MODE: webhook\n")
+		assert.EqualError(t, reloadConfig(), "INJECTOR_MODE can't change on reload")
+		assert.Equal(t, ModeAll, Mode())
+	})
+
+	t.Run("test bundle objects and settings outside of the configuration can't change", func(t *testing.T) {
+		write("defaults:\n  CA_BUNDLE_TARGET: secret\n  CA_BUNDLE_REFRESH_INTERVAL: 1m\n")
+		assert.EqualError(t, reloadConfig(), "CA_BUNDLE_REFRESH_INTERVAL, CA_BUNDLE_TARGET can't change on reload")
+		assert.Equal(t, targetConfigMap, bundleTarget())
+	})
+
+	t.Run("test watch reloads on hangup and changes", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		hangup, ticks := make(chan os.Signal), make(chan time.Time)
+		done := make(chan struct{})
+		go func() {
+			watchConfigFile(ctx, path, hangup, ticks)
+			close(done)
+		}()
+		reloads := configReloads.get("ok")
+
+		write("defaults:\n  CA_BUNDLE_EXPIRY_WARNING: 3h\n")
+		hangup <- nil
+		ticks <- time.Now()
+		assert.Equal(t, 3*time.Hour, expiryWarning())
+
+		modified := time.Now().Add(time.Minute)
+		write("defaults:\n  CA_BUNDLE_EXPIRY_WARNING: 4h\n")
+		_ = os.Chtimes(path, modified, modified)
+		ticks <- time.Now()
+		ticks <- time.Now()
+		cancel()
+		<-done
+		assert.Equal(t, 4*time.Hour, expiryWarning())
+		assert.Equal(t, reloads+2, configReloads.get("ok"))
+	})
+
+}
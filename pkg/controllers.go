@@ -0,0 +1,259 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Injector modes, allowing the webhook and the controllers to run as
+// separate deployments with distinct permissions
+const (
+	// ModeAll runs the webhook and the background controllers
+	ModeAll = "all"
+	// ModeWebhook runs only the webhook, which never writes configmaps
+	ModeWebhook = "webhook"
+	// ModeController runs only the background controllers
+	ModeController = "controller"
+)
+
+const (
+	keyInjectorMode             = "INJECTOR_MODE"
+	keyControllerInterval       = "CA_BUNDLE_CONTROLLER_INTERVAL"
+	keyControllerStallThreshold = "CONTROLLER_STALL_THRESHOLD"
+
+	defaultControllerPeriod = time.Minute
+
+	// defaultStallIntervals is the number of reconcile intervals without a
+	// successful reconcile after which a controller is considered stalled
+	defaultStallIntervals = 3
+)
+
+var (
+	reconcilersMutex sync.Mutex
+	reconcilers      = map[string]*reconcilerStatus{}
+
+	controllerLastSuccess     = newMetric(metricTypeGauge, "kac_controller_last_success_timestamp_seconds", "Unix time of the last successful reconcile, by controller", "controller")
+	controllerReconcileErrors = newMetric(metricTypeCounter, "kac_controller_reconcile_errors_total", "Number of failed reconciles, by controller", "controller")
+	controllerStalled         = newMetric(metricTypeGauge, "kac_controller_stalled", "Whether the controller has not reconciled successfully within the stall threshold", "controller")
+)
+
+// reconcilerStatus tracks the health of a background controller
+type reconcilerStatus struct {
+	interval    time.Duration
+	started     time.Time
+	lastSuccess time.Time
+}
+
+// registerReconciler starts tracking a background controller reconciling
+// every interval
+func registerReconciler(name string, interval time.Duration) {
+	reconcilersMutex.Lock()
+	defer reconcilersMutex.Unlock()
+	reconcilers[name] = &reconcilerStatus{interval: interval, started: time.Now()}
+	controllerStalled.set(0, name)
+}
+
+// unregisterReconciler stops tracking a background controller, e.g. once
+// its replica is no longer the leader
+func unregisterReconciler(name string) {
+	reconcilersMutex.Lock()
+	defer reconcilersMutex.Unlock()
+	delete(reconcilers, name)
+	controllerStalled.set(0, name)
+}
+
+// recordReconcile records the outcome of a controller reconcile
+func recordReconcile(name string, err error) {
+	reconcilersMutex.Lock()
+	defer reconcilersMutex.Unlock()
+	status, ok := reconcilers[name]
+	if !ok {
+		return
+	}
+	if err != nil {
+		controllerReconcileErrors.inc(name)
+		return
+	}
+	status.lastSuccess = time.Now()
+	controllerLastSuccess.set(float64(status.lastSuccess.Unix()), name)
+}
+
+// stalledReconcilers returns the controllers that have not reconciled
+// successfully within the stall threshold
+func stalledReconcilers(now time.Time) []string {
+	threshold, _ := time.ParseDuration(os.Getenv(keyControllerStallThreshold))
+	reconcilersMutex.Lock()
+	defer reconcilersMutex.Unlock()
+	var stalled []string
+	for name, status := range reconcilers {
+		limit := threshold
+		if limit <= 0 {
+			limit = defaultStallIntervals * status.interval
+		}
+		last := status.lastSuccess
+		if last.IsZero() {
+			last = status.started
+		}
+		if now.Sub(last) > limit {
+			stalled = append(stalled, name)
+			controllerStalled.set(1, name)
+		} else {
+			controllerStalled.set(0, name)
+		}
+	}
+	sort.Strings(stalled)
+	return stalled
+}
+
+// Mode returns the configured injector mode
+func Mode() string {
+	config, _ := currentConfig()
+	return config.Mode
+}
+
+// namespaceError reports a namespace the ca bundle can't be created on
+type namespaceError struct {
+	namespace string
+	reason    string
+	code      int32
+}
+
+func (e namespaceError) Error() string {
+	return fmt.Sprintf("ca bundle can't be created, namespace %s %s", e.namespace, e.reason)
+}
+
+// checkNamespace fails when the namespace is terminating, so that
+// admission tells so instead of failing on the create. Missing namespaces
+// are reported by createError, and lacking the permission to read
+// namespaces skips the check
+func checkNamespace(ctx context.Context, clientSet kubernetes.Interface, namespace string) error {
+	ns, err := clientSet.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if ns.Status.Phase == corev1.NamespaceTerminating || ns.DeletionTimestamp != nil {
+		return namespaceError{namespace, "is terminating", http.StatusForbidden}
+	}
+	return nil
+}
+
+// createError tells apart the creation failures caused by a missing
+// namespace
+func createError(namespace string, err error) error {
+	if apierrors.IsNotFound(err) {
+		return namespaceError{namespace, "does not exist", http.StatusNotFound}
+	}
+	return err
+}
+
+// reconcileBundles creates the bundles requested by pods, along with their
+// companion files
+func reconcileBundles(ctx context.Context, clientSet kubernetes.Interface, pods []corev1.Pod) error {
+
+	config, err := currentConfig()
+	if err != nil {
+		return err
+	}
+	caBundleFilename := config.BundleFilename
+	caBundleAnnotation := config.Annotation
+	caBundleTemplates := config.Templates
+
+	// Collect the bundle configmaps needed on every namespace, with the
+	// companion files requested and the bundle path they are rendered for
+	type target struct {
+		namespace     string
+		configMapName string
+		url           string
+	}
+	requested := map[target]map[string]string{}
+	for _, pod := range pods {
+		injection, annotated := pod.Annotations[caBundleAnnotation]
+		if !annotated {
+			injection = selectorInjection(config.PodSelector, pod.Labels)
+		}
+		if !annotated && injection == "" {
+			if injection, err = namespaceInjection(ctx, clientSet, config, pod.Namespace, time.Now()); err != nil {
+				return err
+			}
+		}
+		configMapName, url, inject, err := selectBundle(config, injection)
+		if err != nil {
+			log.Printf("Unable to select ca bundle of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			continue
+		} else if !inject {
+			continue
+		}
+		key := target{pod.Namespace, configMapName, url}
+		if _, ok := requested[key]; !ok {
+			requested[key] = map[string]string{}
+		}
+		profile, err := resolveProfile(ctx, clientSet, config, pod.Namespace, pod.Annotations)
+		if err != nil {
+			log.Printf("Unable to resolve ca bundle profile of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			continue
+		}
+		mountPath, _, extraFiles := profile.apply(config, "/etc/ssl/certs/"+caBundleFilename, nil, pod.Annotations)
+		bundlePaths, err := mountPaths(config, mountPath, pod.Annotations)
+		if err != nil {
+			log.Printf("Unable to resolve ca bundle mount paths of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			continue
+		}
+		mountPath = bundlePaths[0]
+		for _, name := range extraFiles {
+			if _, ok := requested[key][name]; !ok {
+				requested[key][name] = mountPath
+			}
+		}
+	}
+
+	for key, extraFiles := range requested {
+		configMap, err := ensureBundle(ctx, clientSet, key.namespace, key.configMapName, caBundleFilename, key.url)
+		if err != nil {
+			log.Printf("Unable to create %s %s/%s: %v", bundleTarget(), key.namespace, key.configMapName, err)
+			continue
+		}
+		if len(extraFiles) > 0 && bundleTarget() == targetSecret {
+			log.Printf("Unable to add extra files to secret %s/%s: extra files are only stored on configmaps", key.namespace, key.configMapName)
+			continue
+		}
+		files := map[string]string{}
+		for name, mountPath := range extraFiles {
+			rendered, err := renderExtraFiles(caBundleTemplates, []string{name}, extraFileData{BundlePath: mountPath, Namespace: key.namespace})
+			if err != nil {
+				log.Printf("Unable to render extra file %s for configmap %s/%s: %v", name, key.namespace, key.configMapName, err)
+				continue
+			}
+			files[name] = rendered[name]
+		}
+		if len(files) > 0 {
+			if _, err := addExtraFiles(ctx, clientSet, configMap, files); err != nil {
+				log.Printf("Unable to add extra files to configmap %s/%s: %v", key.namespace, key.configMapName, err)
+			}
+		}
+	}
+	return nil
+
+}
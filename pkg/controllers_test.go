@@ -0,0 +1,232 @@
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"testing"
+	"time"
+)
+
+func Test_ReadyRoute(t *testing.T) {
+
+	defer func() {
+		reconcilersMutex.Lock()
+		delete(reconcilers, "test")
+		reconcilersMutex.Unlock()
+	}()
+	router := NewRouter()
+
+	t.Run("test route /ready with healthy controller", func(t *testing.T) {
+		registerReconciler("test", time.Minute)
+		recordReconcile("test", nil)
+		w := fakeRequest(context.Background(), router, http.MethodGet, "/ready", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, float64(0), controllerStalled.get("test"))
+	})
+
+	t.Run("test failed reconcile", func(t *testing.T) {
+		recordReconcile("test", errors.New("unavailable"))
+		assert.Equal(t, float64(1), controllerReconcileErrors.get("test"))
+		assert.Empty(t, stalledReconcilers(time.Now()))
+	})
+
+	t.Run("test route /ready with stalled controller", func(t *testing.T) {
+		_ = os.Setenv(keyControllerStallThreshold, "1ns")
+		defer func() {
+			_ = os.Unsetenv(keyControllerStallThreshold)
+		}()
+		w := fakeRequest(context.Background(), router, http.MethodGet, "/ready", "")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		var ready map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &ready))
+		assert.Equal(t, "stalled", ready["status"])
+		assert.Equal(t, []interface{}{"test"}, ready["controllers"])
+		assert.Equal(t, float64(1), controllerStalled.get("test"))
+	})
+
+	t.Run("test default stall threshold", func(t *testing.T) {
+		assert.Empty(t, stalledReconcilers(time.Now().Add(2*time.Minute)))
+		assert.Equal(t, []string{"test"}, stalledReconcilers(time.Now().Add(4*time.Minute)))
+	})
+
+}
+
+func Test_LeaderTasks(t *testing.T) {
+
+	t.Run("test tasks run without leader election", func(t *testing.T) {
+		ran := make(chan string, 2)
+		runTasks(context.Background(), []func(context.Context){func(ctx context.Context) { ran <- "mirror" }, func(ctx context.Context) { ran <- "refresh" }})
+		assert.Len(t, ran, 2)
+	})
+
+	t.Run("test followers track the leader address", func(t *testing.T) {
+		holder := "ca-injector-1_1c5e6f"
+		clientSet := fake.NewSimpleClientset(&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: "ca-injector", Namespace: "kac"},
+			Spec:       coordinationv1.LeaseSpec{HolderIdentity: &holder},
+		}, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "ca-injector-1", Namespace: "kac"},
+			Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+		})
+		defer func() {
+			leaderIdentity = ""
+		}()
+		tracker := leaderTracker{clientSet, "kac", "ca-injector", "ca-injector-2"}
+		assert.Equal(t, holder, tracker.track(context.Background(), ""))
+		assert.Equal(t, "10.0.0.1", leaderIdentity)
+		tracker.hostname = "ca-injector-1"
+		assert.Equal(t, holder, tracker.track(context.Background(), "ca-injector-2_7d3a9b"))
+		assert.Empty(t, leaderIdentity)
+	})
+
+	t.Run("test stopped tasks are not reported stalled", func(t *testing.T) {
+		registerReconciler("leader-task", time.Minute)
+		unregisterReconciler("leader-task")
+		assert.NotContains(t, stalledReconcilers(time.Now().Add(time.Hour)), "leader-task")
+	})
+
+}
+
+func Test_ReconcileConfigMaps(t *testing.T) {
+
+	ctx := context.Background()
+	bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bundle)
+	}))
+	defer server.Close()
+
+	_ = os.Setenv(keyCABundleURL, server.URL)
+	defer func() {
+		_ = os.Setenv(keyCABundleURL, caBundleURL)
+	}()
+
+	annotated := func(namespace string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-pod",
+			Namespace:   namespace,
+			Annotations: map[string]string{os.Getenv(keyCABundleAnnotation): "true"},
+		}}
+	}
+	unannotated := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "team-c"}}
+	clientSet := fake.NewSimpleClientset()
+
+	reconciler := namespaceReconciler{reader: ctrlfake.NewClientBuilder().WithObjects(annotated("team-a"), annotated("team-b"), unannotated).Build(), clientSet: clientSet}
+	defer func() {
+		reconcilersMutex.Lock()
+		delete(reconcilers, "configmap")
+		reconcilersMutex.Unlock()
+	}()
+	for _, namespace := range []string{"team-a", "team-b", "team-c"} {
+		_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: namespace}})
+		assert.NoError(t, err)
+	}
+	for _, namespace := range []string{"team-a", "team-b"} {
+		configMap, err := clientSet.CoreV1().ConfigMaps(namespace).Get(ctx, os.Getenv(keyConfigMapName), metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, string(bundle), configMap.Data[os.Getenv(keyCABundleFilename)])
+	}
+	_, err := clientSet.CoreV1().ConfigMaps("team-c").Get(ctx, os.Getenv(keyConfigMapName), metav1.GetOptions{})
+	assert.Error(t, err)
+
+	t.Run("test pods are queued by namespace", func(t *testing.T) {
+		assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "team-a"}}}, podNamespace(annotated("team-a")))
+	})
+
+	t.Run("test failed namespaces are requeued", func(t *testing.T) {
+		reconciler := namespaceReconciler{reader: ctrlfake.NewClientBuilder().WithScheme(runtime.NewScheme()).Build(), clientSet: clientSet}
+		_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: "team-d"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("test webhook mode does not create configmaps", func(t *testing.T) {
+		_ = os.Setenv(keyInjectorMode, ModeWebhook)
+		defer func() {
+			_ = os.Unsetenv(keyInjectorMode)
+		}()
+		encodedPod, _ := json.Marshal(corev1.Pod{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: annotated("team-c").ObjectMeta,
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		})
+		ar, _ := admissionReviewFactory(podsGVR, encodedPod)
+		w := fakeRequest(WithClientSet(ctx, clientSet), NewRouter(), http.MethodPost, "/mutate", string(ar))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEmpty(t, admissionResponse(t, w).Patch)
+		assert.Len(t, admissionResponse(t, w).Warnings, 1)
+		_, err := clientSet.CoreV1().ConfigMaps("team-c").Get(ctx, os.Getenv(keyConfigMapName), metav1.GetOptions{})
+		assert.Error(t, err)
+	})
+
+}
+
+func Test_NamespaceChecks(t *testing.T) {
+
+	clientSet := fake.NewSimpleClientset(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "terminating"},
+		Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating},
+	})
+	clientSet.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "deleted" {
+			return true, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, "deleted")
+		}
+		return false, nil, nil
+	})
+	mutate := func(namespace string) *admissionv1.AdmissionResponse {
+		encodedPod, _ := json.Marshal(corev1.Pod{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-pod",
+				Namespace:   namespace,
+				Annotations: map[string]string{os.Getenv(keyCABundleAnnotation): "true"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		})
+		ar, _ := admissionReviewFactory(podsGVR, encodedPod)
+		w := fakeRequest(WithClientSet(context.Background(), clientSet), NewRouter(), http.MethodPost, "/mutate", string(ar))
+		assert.Equal(t, http.StatusOK, w.Code)
+		return admissionResponse(t, w)
+	}
+
+	t.Run("test terminating namespace", func(t *testing.T) {
+		response := mutate("terminating")
+		assert.False(t, response.Allowed)
+		assert.Equal(t, int32(http.StatusForbidden), response.Result.Code)
+		assert.Equal(t, "ca bundle can't be created, namespace terminating is terminating", response.Result.Message)
+	})
+
+	t.Run("test missing namespace", func(t *testing.T) {
+		bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(bundle)
+		}))
+		defer server.Close()
+		_ = os.Setenv(keyCABundleURL, server.URL)
+		defer func() {
+			_ = os.Setenv(keyCABundleURL, caBundleURL)
+		}()
+		response := mutate("deleted")
+		assert.False(t, response.Allowed)
+		assert.Equal(t, int32(http.StatusNotFound), response.Result.Code)
+		assert.Equal(t, "ca bundle can't be created, namespace deleted does not exist", response.Result.Message)
+	})
+
+}
@@ -0,0 +1,52 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+const (
+	decisionInjected      = "injected"
+	decisionUnchanged     = "unchanged"
+	decisionInjectorPod   = "injector-pod"
+	decisionPrivileged    = "privileged"
+	decisionForeignBundle = "foreign-bundle"
+	decisionDegraded      = "degraded"
+	decisionBundleError   = "bundle-error"
+	decisionPatchTooLarge = "patch-too-large"
+	decisionPodSecurity   = "pod-security"
+)
+
+// admissionDecision is the outcome of the review of a pod targeted by the
+// injection. It is set on the audit annotations of the response, which
+// the apiserver records on its audit log prefixed by the webhook name
+type admissionDecision struct {
+	reason string
+	bundle string
+	hash   string
+}
+
+// annotations returns the audit annotations of the decision: whether the
+// bundle was injected, skipped or the pod denied, why, and the bundle
+// object and revision involved
+func (d admissionDecision) annotations(allowed bool) map[string]string {
+	decision := "skipped"
+	if !allowed {
+		decision = "denied"
+	} else if d.reason == decisionInjected {
+		decision = decisionInjected
+	}
+	annotations := map[string]string{"decision": decision, "reason": d.reason}
+	if d.bundle != "" {
+		annotations["bundle"] = d.bundle
+	}
+	if d.hash != "" {
+		annotations["bundle-hash"] = d.hash
+	}
+	return annotations
+}
@@ -0,0 +1,20 @@
+package kac
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_AdmissionDecisions(t *testing.T) {
+
+	t.Run("test injected decision", func(t *testing.T) {
+		decision := admissionDecision{reason: decisionInjected, bundle: "ca-bundle", hash: "abc"}
+		assert.Equal(t, map[string]string{"decision": "injected", "reason": "injected", "bundle": "ca-bundle", "bundle-hash": "abc"}, decision.annotations(true))
+	})
+
+	t.Run("test skipped and denied decisions", func(t *testing.T) {
+		assert.Equal(t, map[string]string{"decision": "skipped", "reason": "privileged", "bundle": "ca-bundle"}, admissionDecision{reason: decisionPrivileged, bundle: "ca-bundle"}.annotations(true))
+		assert.Equal(t, map[string]string{"decision": "denied", "reason": "patch-too-large"}, admissionDecision{reason: decisionPatchTooLarge}.annotations(false))
+	})
+
+}
@@ -0,0 +1,98 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// createRetryInterval is how long bundles are only mounted, not created,
+// after the apiserver refused to create one
+const createRetryInterval = 5 * time.Minute
+
+var (
+	createForbiddenMutex sync.Mutex
+	createForbiddenSince time.Time
+
+	bundleCreateForbidden   = newMetric(metricTypeGauge, "kac_bundle_create_forbidden", "Whether the injector lacks the permission to create ca bundle objects and only mounts existing ones")
+	degradedAdmissionsTotal = newMetric(metricTypeCounter, "kac_degraded_admissions_total", "Number of annotated pods admitted without ca bundle because it could not be created")
+)
+
+// degradedError reports a missing ca bundle object the injector is not
+// allowed to create
+type degradedError struct {
+	namespace string
+	name      string
+}
+
+func (e degradedError) Error() string {
+	return fmt.Sprintf("%s %s/%s does not exist and the injector is not allowed to create it", bundleTarget(), e.namespace, e.name)
+}
+
+// createForbidden reports whether the apiserver refused to create a ca
+// bundle object within the last createRetryInterval
+func createForbidden(now time.Time) bool {
+	createForbiddenMutex.Lock()
+	defer createForbiddenMutex.Unlock()
+	return !createForbiddenSince.IsZero() && now.Sub(createForbiddenSince) < createRetryInterval
+}
+
+// setCreateForbidden records whether creating ca bundle objects is
+// allowed, logging the transitions
+func setCreateForbidden(forbidden bool, now time.Time) {
+	createForbiddenMutex.Lock()
+	defer createForbiddenMutex.Unlock()
+	if forbidden {
+		if createForbiddenSince.IsZero() {
+			log.Printf("Warning: not allowed to create %ss, only existing ca bundles are mounted until the injector is granted the permission", bundleTarget())
+		}
+		createForbiddenSince = now
+		bundleCreateForbidden.set(1)
+	} else if !createForbiddenSince.IsZero() {
+		log.Printf("Allowed to create %ss again", bundleTarget())
+		createForbiddenSince = time.Time{}
+		bundleCreateForbidden.set(0)
+	}
+}
+
+// mountableBundle returns the ca bundle object of the namespace, creating
+// it when missing. Without the permission to create it, e.g. when the
+// injector's role was trimmed, existing objects are still mounted and a
+// missing one is reported as a degradedError rather than an api error
+func mountableBundle(ctx context.Context, clientSet kubernetes.Interface, namespace string, name string, caBundleFilename string, caBundleURL string) (*corev1.ConfigMap, error) {
+	now := time.Now()
+	if createForbidden(now) {
+		configMap, err := getBundle(ctx, clientSet, namespace, name)
+		if apierrors.IsNotFound(err) {
+			return nil, degradedError{namespace, name}
+		}
+		return configMap, err
+	}
+	configMap, err := ensureBundle(ctx, clientSet, namespace, name, caBundleFilename, caBundleURL)
+	if apierrors.IsForbidden(err) {
+		// Tell a refused creation apart from a refused read
+		if _, getErr := getBundle(ctx, clientSet, namespace, name); apierrors.IsNotFound(getErr) {
+			setCreateForbidden(true, now)
+			return nil, degradedError{namespace, name}
+		}
+	} else if err == nil {
+		setCreateForbidden(false, now)
+	}
+	return configMap, err
+}
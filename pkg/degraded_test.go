@@ -0,0 +1,90 @@
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_CreateForbidden(t *testing.T) {
+
+	bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bundle)
+	}))
+	defer server.Close()
+	_ = os.Setenv(keyCABundleURL, server.URL)
+	defer func() {
+		_ = os.Setenv(keyCABundleURL, caBundleURL)
+		setCreateForbidden(false, time.Now())
+	}()
+
+	configMapName := os.Getenv(keyConfigMapName)
+	clientSet := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: configMapName, Namespace: "existing"},
+		Data:       map[string]string{os.Getenv(keyCABundleFilename): string(bundle)},
+	})
+	creates := 0
+	clientSet.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		creates++
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, configMapName, nil)
+	})
+	mutate := func(namespace string) *admissionv1.AdmissionResponse {
+		encodedPod, _ := json.Marshal(corev1.Pod{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-pod",
+				Namespace:   namespace,
+				Annotations: map[string]string{os.Getenv(keyCABundleAnnotation): "true"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		})
+		ar, _ := admissionReviewFactory(podsGVR, encodedPod)
+		w := fakeRequest(WithClientSet(context.Background(), clientSet), NewRouter(), http.MethodPost, "/mutate", string(ar))
+		assert.Equal(t, http.StatusOK, w.Code)
+		return admissionResponse(t, w)
+	}
+
+	t.Run("test missing configmap is not injected", func(t *testing.T) {
+		admissions := degradedAdmissionsTotal.get()
+		response := mutate("missing")
+		assert.True(t, response.Allowed)
+		assert.Nil(t, response.Patch)
+		assert.Equal(t, []string{"ca bundle is not injected, configmap missing/" + configMapName + " does not exist and the injector is not allowed to create it"}, response.Warnings)
+		assert.Equal(t, admissions+1, degradedAdmissionsTotal.get())
+		assert.Equal(t, float64(1), bundleCreateForbidden.get())
+	})
+
+	t.Run("test creation is not retried right away", func(t *testing.T) {
+		response := mutate("other")
+		assert.True(t, response.Allowed)
+		assert.Nil(t, response.Patch)
+		assert.Equal(t, 1, creates)
+	})
+
+	t.Run("test existing configmap is still mounted", func(t *testing.T) {
+		response := mutate("existing")
+		assert.True(t, response.Allowed)
+		assert.NotNil(t, response.Patch)
+	})
+
+	t.Run("test creation is retried after interval", func(t *testing.T) {
+		assert.True(t, createForbidden(time.Now()))
+		assert.False(t, createForbidden(time.Now().Add(createRetryInterval)))
+	})
+
+}
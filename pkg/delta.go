@@ -0,0 +1,113 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	eventReasonBundleUpdated = "BundleUpdated"
+	eventSource              = "kac-ca-injector"
+)
+
+// bundleDelta describes the certificates added and removed between two
+// bundle revisions
+func bundleDelta(previous []byte, current []byte) (added []string, removed []string) {
+	previousCertificates, _ := parseCertificates(previous)
+	currentCertificates, _ := parseCertificates(current)
+	previousSet, currentSet := fingerprints(previousCertificates), fingerprints(currentCertificates)
+	for fingerprint, certificate := range currentSet {
+		if _, ok := previousSet[fingerprint]; !ok {
+			added = append(added, describeCertificate(certificate))
+		}
+	}
+	for fingerprint, certificate := range previousSet {
+		if _, ok := currentSet[fingerprint]; !ok {
+			removed = append(removed, describeCertificate(certificate))
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func fingerprints(certificates []*x509.Certificate) map[[sha256.Size]byte]*x509.Certificate {
+	set := map[[sha256.Size]byte]*x509.Certificate{}
+	for _, certificate := range certificates {
+		set[sha256.Sum256(certificate.Raw)] = certificate
+	}
+	return set
+}
+
+func describeCertificate(certificate *x509.Certificate) string {
+	return fmt.Sprintf("%q (expires %s)", certificate.Subject.CommonName, formatTime(certificate.NotAfter))
+}
+
+// describeDelta renders a bundle delta in a single human readable line
+func describeDelta(added []string, removed []string) string {
+	if len(added) == 0 && len(removed) == 0 {
+		return "no certificate changes"
+	}
+	var parts []string
+	if len(added) > 0 {
+		parts = append(parts, fmt.Sprintf("added %d: %s", len(added), strings.Join(added, ", ")))
+	}
+	if len(removed) > 0 {
+		parts = append(parts, fmt.Sprintf("removed %d: %s", len(removed), strings.Join(removed, ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// recordBundleUpdate logs the certificates changed on a configmap update
+// and records them as an event of the configmap, or of the secret it is a
+// view of
+func recordBundleUpdate(ctx context.Context, clientSet kubernetes.Interface, configMap *corev1.ConfigMap, previous []byte, current []byte) {
+	kind := "ConfigMap"
+	if configMap.Kind == "Secret" {
+		kind = configMap.Kind
+	}
+	message := fmt.Sprintf("ca bundle of %s %s/%s updated, %s", strings.ToLower(kind), configMap.Namespace, configMap.Name, describeDelta(bundleDelta(previous, current)))
+	log.Print(message)
+	now := metav1.Now()
+	if _, err := clientSet.CoreV1().Events(configMap.Namespace).Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", configMap.Name, now.UnixNano()),
+			Namespace: configMap.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       kind,
+			Name:       configMap.Name,
+			Namespace:  configMap.Namespace,
+			UID:        configMap.UID,
+		},
+		Reason:         eventReasonBundleUpdated,
+		Message:        message,
+		Type:           corev1.EventTypeNormal,
+		Source:         corev1.EventSource{Component: eventSource},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{}); err != nil {
+		log.Printf("Unable to record event for configmap %s/%s: %v", configMap.Namespace, configMap.Name, err)
+	}
+}
@@ -0,0 +1,39 @@
+package kac
+
+import (
+	"context"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+	"time"
+)
+
+func Test_BundleDelta(t *testing.T) {
+
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	kept, retired, rotated := fixtures.Certificate("kept", expiry), fixtures.Certificate("retired", expiry), fixtures.Certificate("rotated", expiry)
+	previous := append(append([]byte{}, kept...), retired...)
+	current := append(append([]byte{}, kept...), rotated...)
+
+	t.Run("test delta between revisions", func(t *testing.T) {
+		added, removed := bundleDelta(previous, current)
+		assert.Equal(t, []string{`"rotated" (expires 2030-01-01T00:00:00Z)`}, added)
+		assert.Equal(t, []string{`"retired" (expires 2030-01-01T00:00:00Z)`}, removed)
+		assert.Equal(t, `added 1: "rotated" (expires 2030-01-01T00:00:00Z); removed 1: "retired" (expires 2030-01-01T00:00:00Z)`, describeDelta(added, removed))
+		assert.Equal(t, "no certificate changes", describeDelta(bundleDelta(previous, previous)))
+	})
+
+	t.Run("test bundle update event", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset()
+		configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "ca-bundle", Namespace: "example"}}
+		recordBundleUpdate(context.Background(), clientSet, configMap, previous, current)
+		events, _ := clientSet.CoreV1().Events("example").List(context.Background(), metav1.ListOptions{})
+		assert.Len(t, events.Items, 1)
+		assert.Equal(t, eventReasonBundleUpdated, events.Items[0].Reason)
+		assert.Contains(t, events.Items[0].Message, `added 1: "rotated"`)
+	})
+
+}
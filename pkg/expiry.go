@@ -0,0 +1,56 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const keyCABundleMinValidity = "CA_BUNDLE_MIN_VALIDITY"
+
+var bundleExpiry = newMetric(metricTypeGauge, "kac_bundle_expiry_timestamp_seconds", "Unix time the first certificate of the loaded ca bundle expires")
+
+// expiringBundleError reports a bundle refused because even its newest
+// certificate expires within the minimum validity
+type expiringBundleError struct {
+	notAfter time.Time
+	window   time.Duration
+}
+
+func (e expiringBundleError) Error() string {
+	return fmt.Sprintf("ca bundle expires on %s, within the %s minimum validity set by %s", formatTime(e.notAfter), e.window, keyCABundleMinValidity)
+}
+
+// checkBundleValidity refuses bundles whose newest certificate expires
+// within CA_BUNDLE_MIN_VALIDITY, which would break every pod mounting them
+// soon after. Older certificates about to expire are only warned about
+func checkBundleValidity(bundle []byte, now time.Time) error {
+	window, _ := time.ParseDuration(os.Getenv(keyCABundleMinValidity))
+	if window <= 0 {
+		return nil
+	}
+	certificates, err := parseCertificates(bundle)
+	if err != nil {
+		return err
+	}
+	var newest time.Time
+	for _, certificate := range certificates {
+		if certificate.NotAfter.After(newest) {
+			newest = certificate.NotAfter
+		}
+	}
+	if newest.Before(now.Add(window)) {
+		return expiringBundleError{newest, window}
+	}
+	return nil
+}
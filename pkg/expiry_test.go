@@ -0,0 +1,58 @@
+package kac
+
+import (
+	"context"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_BundleMinValidity(t *testing.T) {
+
+	now := time.Now()
+	expiring := fixtures.Certificate("expiring", now.AddDate(0, 0, 10))
+	valid := fixtures.Certificate("valid", now.AddDate(1, 0, 0))
+	_ = os.Setenv(keyCABundleMinValidity, "720h")
+	defer func() {
+		_ = os.Unsetenv(keyCABundleMinValidity)
+		lastProvenance = nil
+	}()
+
+	t.Run("test newest certificate decides", func(t *testing.T) {
+		assert.NoError(t, checkBundleValidity(append(expiring, valid...), now))
+		err := checkBundleValidity(expiring, now)
+		assert.ErrorAs(t, err, &expiringBundleError{})
+	})
+
+	t.Run("test disabled by default", func(t *testing.T) {
+		_ = os.Unsetenv(keyCABundleMinValidity)
+		defer func() {
+			_ = os.Setenv(keyCABundleMinValidity, "720h")
+		}()
+		assert.NoError(t, checkBundleValidity(expiring, now))
+	})
+
+	t.Run("test expiring bundle is not created", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(expiring)
+		}))
+		defer server.Close()
+		clientSet := fake.NewSimpleClientset()
+		_, err := ensureBundle(context.Background(), clientSet, "team-a", "ca-bundle", "ca_bundle.pem", server.URL)
+		assert.ErrorAs(t, err, &expiringBundleError{})
+		for _, action := range clientSet.Actions() {
+			assert.NotEqual(t, "create", action.GetVerb())
+		}
+	})
+
+	t.Run("test expiry metric", func(t *testing.T) {
+		recordProvenance(&BundleProvenance{}, append(expiring, valid...))
+		assert.Equal(t, float64(currentProvenance().NotAfter.Unix()), bundleExpiry.get())
+	})
+
+}
@@ -0,0 +1,81 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	keyCABundleTemplates = "CA_BUNDLE_TEMPLATES"
+
+	extraFilesAnnotationSuffix = "-extra-files"
+	extraFilesMountDir         = "/etc/ssl/"
+)
+
+// extraFileData is available to extra file templates
+type extraFileData struct {
+	BundlePath string
+	Namespace  string
+}
+
+// renderExtraFiles renders the requested templates found on the templates
+// directory, keyed by their file names
+func renderExtraFiles(templatesDir string, names []string, data extraFileData) (map[string]string, error) {
+	if templatesDir == "" {
+		return nil, fmt.Errorf("extra files requested but no templates directory is configured")
+	}
+	files := map[string]string{}
+	for _, name := range names {
+		if name != filepath.Base(name) || name == "." || name == ".." {
+			return nil, fmt.Errorf("invalid extra file name %q", name)
+		}
+		tmpl, err := template.ParseFiles(filepath.Join(templatesDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load extra file template %s: %w", name, err)
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("unable to render extra file template %s: %w", name, err)
+		}
+		files[name] = b.String()
+	}
+	return files, nil
+}
+
+// addExtraFiles stores the files on the bundle object, updating it only
+// when a key is missing or outdated
+func addExtraFiles(ctx context.Context, clientSet kubernetes.Interface, configMap *corev1.ConfigMap, files map[string]string) (*corev1.ConfigMap, error) {
+	changed := false
+	for name, content := range files {
+		if current, ok := configMap.Data[name]; !ok || current != content {
+			changed = true
+		}
+	}
+	if !changed {
+		return configMap, nil
+	}
+	updated := configMap.DeepCopy()
+	if updated.Data == nil {
+		updated.Data = map[string]string{}
+	}
+	for name, content := range files {
+		updated.Data[name] = content
+	}
+	return updateBundle(ctx, clientSet, updated)
+}
@@ -0,0 +1,51 @@
+package kac
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ExtraFiles(t *testing.T) {
+
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "openssl.cnf"), []byte("CAfile = {{ .BundlePath }}\n"), 0644)
+	data := extraFileData{BundlePath: "/etc/ssl/certs/ca_bundle.pem", Namespace: "example"}
+
+	t.Run("test render extra files", func(t *testing.T) {
+		files, err := renderExtraFiles(dir, []string{"openssl.cnf"}, data)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"openssl.cnf": "CAfile = /etc/ssl/certs/ca_bundle.pem\n"}, files)
+	})
+
+	t.Run("test render missing or invalid extra files", func(t *testing.T) {
+		_, err := renderExtraFiles("", []string{"openssl.cnf"}, data)
+		assert.Error(t, err)
+		_, err = renderExtraFiles(dir, []string{"java.security"}, data)
+		assert.Error(t, err)
+		_, err = renderExtraFiles(dir, []string{"../openssl.cnf"}, data)
+		assert.EqualError(t, err, `invalid extra file name "../openssl.cnf"`)
+	})
+
+	t.Run("test add extra files to configmap", func(t *testing.T) {
+		ctx := context.Background()
+		clientSet := fake.NewSimpleClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "ca-bundle", Namespace: "example"},
+			Data:       map[string]string{"ca_bundle.pem": "bundle"},
+		})
+		configMap, _ := clientSet.CoreV1().ConfigMaps("example").Get(ctx, "ca-bundle", metav1.GetOptions{})
+		updated, err := addExtraFiles(ctx, clientSet, configMap, map[string]string{"openssl.cnf": "CAfile = bundle\n"})
+		assert.NoError(t, err)
+		assert.Equal(t, "bundle", updated.Data["ca_bundle.pem"])
+		assert.Equal(t, "CAfile = bundle\n", updated.Data["openssl.cnf"])
+		unchanged, err := addExtraFiles(ctx, clientSet, updated, map[string]string{"openssl.cnf": "CAfile = bundle\n"})
+		assert.NoError(t, err)
+		assert.Same(t, updated, unchanged)
+	})
+
+}
@@ -0,0 +1,126 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	keyFeatureGates = "CA_BUNDLE_FEATURE_GATES"
+
+	maturityAlpha = "ALPHA"
+	maturityBeta  = "BETA"
+	maturityGA    = "GA"
+
+	featureEnvInjection      = "EnvInjection"
+	featureRefreshController = "RefreshController"
+	featureBundleMirror      = "BundleMirror"
+	featureCanaryProbe       = "CanaryProbe"
+	featureTeamCABundles     = "TeamCABundles"
+	featureSkewChecks        = "SkewChecks"
+)
+
+// featureSpec is the maturity of a feature gate and whether it is enabled
+// by default. Alpha features are off by default, beta features on, and GA
+// features can't be turned off anymore
+type featureSpec struct {
+	maturity       string
+	defaultEnabled bool
+}
+
+// featureState is a feature gate and its current state, as listed on the
+// status page
+type featureState struct {
+	Name     string
+	Maturity string
+	Enabled  bool
+}
+
+var featureGates = map[string]featureSpec{
+	featureEnvInjection:      {maturityBeta, true},
+	featureRefreshController: {maturityBeta, true},
+	featureBundleMirror:      {maturityBeta, true},
+	featureCanaryProbe:       {maturityBeta, true},
+	featureTeamCABundles:     {maturityAlpha, false},
+	featureSkewChecks:        {maturityAlpha, false},
+}
+
+// parseFeatureGates parses a comma separated list of Name=bool pairs, the
+// last pair of a gate winning
+func parseFeatureGates(value string) (map[string]bool, error) {
+	gates := map[string]bool{}
+	for _, pair := range splitList(value) {
+		name, raw, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid feature gate %q, expected Name=true|false", pair)
+		}
+		name = strings.TrimSpace(name)
+		spec, ok := featureGates[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown feature gate %s", name)
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q of feature gate %s", raw, name)
+		}
+		if spec.maturity == maturityGA && !enabled {
+			return nil, fmt.Errorf("feature gate %s is GA and can't be disabled", name)
+		}
+		gates[name] = enabled
+	}
+	return gates, nil
+}
+
+// SetFeatureGates validates the feature gates of CA_BUNDLE_FEATURE_GATES
+// overridden by value, usually the --feature-gates flag, and sets the
+// result on CA_BUNDLE_FEATURE_GATES
+func SetFeatureGates(value string) error {
+	merged := strings.Trim(os.Getenv(keyFeatureGates)+","+value, ",")
+	gates, err := parseFeatureGates(merged)
+	if err != nil {
+		return err
+	}
+	for name, enabled := range gates {
+		if spec := featureGates[name]; enabled && spec.maturity == maturityAlpha {
+			log.Printf("Enabling alpha feature %s, which may change or be removed in later releases", name)
+		}
+	}
+	return os.Setenv(keyFeatureGates, merged)
+}
+
+// featureEnabled reports whether the named feature gate is enabled.
+// Invalid gates are refused on startup, so parsing errors only fall back
+// to the defaults
+func featureEnabled(name string) bool {
+	gates, _ := parseFeatureGates(os.Getenv(keyFeatureGates))
+	if enabled, ok := gates[name]; ok {
+		return enabled
+	}
+	return featureGates[name].defaultEnabled
+}
+
+// currentFeatureGates lists the feature gates by name
+func currentFeatureGates() []featureState {
+	gates := make([]featureState, 0, len(featureGates))
+	for name, spec := range featureGates {
+		gates = append(gates, featureState{name, spec.maturity, featureEnabled(name)})
+	}
+	sort.Slice(gates, func(i, j int) bool {
+		return gates[i].Name < gates[j].Name
+	})
+	return gates
+}
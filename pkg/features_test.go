@@ -0,0 +1,49 @@
+package kac
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func Test_FeatureGates(t *testing.T) {
+
+	defer func() {
+		_ = os.Unsetenv(keyFeatureGates)
+	}()
+
+	t.Run("test feature gate defaults", func(t *testing.T) {
+		assert.True(t, featureEnabled(featureEnvInjection))
+		assert.False(t, featureEnabled(featureTeamCABundles))
+	})
+
+	t.Run("test parse feature gates", func(t *testing.T) {
+		gates, err := parseFeatureGates("EnvInjection=false, TeamCABundles=true,EnvInjection=true")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]bool{featureEnvInjection: true, featureTeamCABundles: true}, gates)
+		for value, message := range map[string]string{
+			"EnvInjection":         `invalid feature gate "EnvInjection", expected Name=true|false`,
+			"Unknown=true":         "unknown feature gate Unknown",
+			"RefreshController=no": `invalid value "no" of feature gate RefreshController`,
+		} {
+			_, err := parseFeatureGates(value)
+			assert.EqualError(t, err, message)
+		}
+	})
+
+	t.Run("test GA feature gates can't be disabled", func(t *testing.T) {
+		featureGates["StableFeature"] = featureSpec{maturityGA, true}
+		defer delete(featureGates, "StableFeature")
+		_, err := parseFeatureGates("StableFeature=false")
+		assert.EqualError(t, err, "feature gate StableFeature is GA and can't be disabled")
+	})
+
+	t.Run("test flag overrides environment", func(t *testing.T) {
+		_ = os.Setenv(keyFeatureGates, "RefreshController=false,CanaryProbe=false")
+		assert.NoError(t, SetFeatureGates("RefreshController=true"))
+		assert.True(t, featureEnabled(featureRefreshController))
+		assert.False(t, featureEnabled(featureCanaryProbe))
+		assert.Error(t, SetFeatureGates("Unknown=true"))
+	})
+
+}
@@ -0,0 +1,72 @@
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	// bundleFixture is the bundle served by the stub bundle server, the
+	// upstream of every test not setting its own
+	bundleFixture = fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	caBundleURL   string
+)
+
+// init configures the injector environment as pkg/testing does, the
+// package tests can't use the harness since it imports the package
+func init() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		_, _ = w.Write(bundleFixture)
+	}))
+	_ = os.Setenv(keyConfigMapName, "ca-bundle")
+	_ = os.Setenv(keyCABundleFilename, "ca_bundle.pem")
+	_ = os.Setenv(keyCABundleAnnotation, "example.com/ca-injector")
+	_ = os.Setenv(keyCABundleURL, server.URL)
+	_ = os.Setenv(keyPodNamespace, "example")
+	caBundleURL = os.Getenv(keyCABundleURL)
+}
+
+func admissionReviewFactory(gvr metav1.GroupVersionResource, rawObject []byte) ([]byte, error) {
+	return json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "AdmissionReview",
+			APIVersion: "admission.k8s.io/v1",
+		},
+		Request: &admissionv1.AdmissionRequest{
+			Resource: gvr,
+			Object: runtime.RawExtension{
+				Raw: rawObject,
+			},
+		},
+	})
+}
+
+func fakeRequest(ctx context.Context, r *gin.Engine, method string, route string, rawBody string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, route, strings.NewReader(rawBody))
+	req = req.WithContext(ctx)
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// admissionResponse decodes the response of an admission review, failing
+// the test when there's none
+func admissionResponse(t *testing.T, w *httptest.ResponseRecorder) *admissionv1.AdmissionResponse {
+	ar := admissionv1.AdmissionReview{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ar))
+	require.NotNil(t, ar.Response)
+	return ar.Response
+}
@@ -0,0 +1,93 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	keyForeignPolicy = "CA_BUNDLE_FOREIGN_POLICY"
+
+	foreignPolicySkip = "skip"
+	foreignPolicyWarn = "warn"
+)
+
+// foreignBundleLabels identify the configmaps and secrets filled with a
+// ca bundle by other injection mechanisms, by the mechanism name
+var foreignBundleLabels = map[string]string{
+	"config.openshift.io/inject-trusted-cabundle": "openshift trusted ca",
+	"trust.cert-manager.io/bundle":                "trust-manager",
+}
+
+// foreignInjections lists the pod volumes holding a ca bundle injected by
+// another mechanism, which would conflict with the injected bundle
+func foreignInjections(ctx context.Context, clientSet kubernetes.Interface, namespace string, spec corev1.PodSpec) ([]string, error) {
+
+	var configMaps, secrets []string
+	for _, volume := range spec.Volumes {
+		switch {
+		case volume.ConfigMap != nil:
+			configMaps = append(configMaps, volume.ConfigMap.Name)
+		case volume.Secret != nil:
+			secrets = append(secrets, volume.Secret.SecretName)
+		case volume.Projected != nil:
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					configMaps = append(configMaps, source.ConfigMap.Name)
+				} else if source.Secret != nil {
+					secrets = append(secrets, source.Secret.Name)
+				}
+			}
+		}
+	}
+
+	var found []string
+	for _, name := range configMaps {
+		configMap, err := clientSet.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if mechanism, ok := foreignMechanism(configMap.ObjectMeta); ok {
+			found = append(found, fmt.Sprintf("configmap %s from %s", name, mechanism))
+		}
+	}
+	for _, name := range secrets {
+		secret, err := clientSet.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if mechanism, ok := foreignMechanism(secret.ObjectMeta); ok {
+			found = append(found, fmt.Sprintf("secret %s from %s", name, mechanism))
+		}
+	}
+	return found, nil
+
+}
+
+func foreignMechanism(meta metav1.ObjectMeta) (string, bool) {
+	for label, mechanism := range foreignBundleLabels {
+		if _, ok := meta.Labels[label]; ok {
+			return mechanism, true
+		}
+	}
+	return "", false
+}
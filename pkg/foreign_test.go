@@ -0,0 +1,68 @@
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func Test_ForeignInjections(t *testing.T) {
+
+	ctx := context.Background()
+	clientSet := fake.NewSimpleClientset(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "trusted-ca", Namespace: "team-a", Labels: map[string]string{"config.openshift.io/inject-trusted-cabundle": "true"}}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "corporate-roots", Namespace: "team-a", Labels: map[string]string{"trust.cert-manager.io/bundle": "corporate"}}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-a"}},
+	)
+	spec := corev1.PodSpec{
+		Containers: []corev1.Container{{Name: "app"}},
+		Volumes: []corev1.Volume{
+			{Name: "trusted-ca", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "trusted-ca"}}}},
+			{Name: "settings", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}}},
+			{Name: "missing", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}}}},
+			{Name: "tls", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+				{Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "corporate-roots"}}},
+			}}}},
+		},
+	}
+
+	t.Run("test detect foreign injections", func(t *testing.T) {
+		found, err := foreignInjections(ctx, clientSet, "team-a", spec)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"configmap trusted-ca from openshift trusted ca", "secret corporate-roots from trust-manager"}, found)
+		found, err = foreignInjections(ctx, clientSet, "team-b", spec)
+		assert.NoError(t, err)
+		assert.Empty(t, found)
+	})
+
+	t.Run("test route /mutate skips pods with foreign injections", func(t *testing.T) {
+		_ = os.Setenv(keyForeignPolicy, foreignPolicySkip)
+		_ = os.Setenv(keyInjectorMode, ModeWebhook)
+		defer func() {
+			_ = os.Unsetenv(keyForeignPolicy)
+			_ = os.Unsetenv(keyInjectorMode)
+		}()
+		encodedPod, _ := json.Marshal(corev1.Pod{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-pod",
+				Namespace:   "team-a",
+				Annotations: map[string]string{os.Getenv(keyCABundleAnnotation): "true"},
+			},
+			Spec: spec,
+		})
+		ar, _ := admissionReviewFactory(podsGVR, encodedPod)
+		w := fakeRequest(WithClientSet(ctx, clientSet), NewRouter(), http.MethodPost, "/mutate", string(ar))
+		assert.Equal(t, http.StatusOK, w.Code)
+		response := admissionResponse(t, w)
+		assert.Empty(t, response.Patch)
+		assert.Equal(t, []string{"ca bundle is not injected into pods mounting another injected bundle, configmap trusted-ca from openshift trusted ca, secret corporate-roots from trust-manager"}, response.Warnings)
+	})
+
+}
@@ -0,0 +1,356 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	formatPEM     = "pem"
+	formatDER     = "der"
+	formatPKCS7   = "pkcs7"
+	formatJSON    = "json"
+	formatZip     = "zip"
+	formatTar     = "tar"
+	formatGzip    = "gzip"
+	formatUnknown = "unknown"
+)
+
+var (
+	oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+	// contentTypeFormats maps media types to the format of their content;
+	// generic types such as text/plain are detected by their magic bytes
+	contentTypeFormats = map[string]string{
+		"application/x-pem-file":            formatPEM,
+		"application/pem-certificate-chain": formatPEM,
+		"application/pkix-cert":             formatDER,
+		"application/x-x509-ca-cert":        formatDER,
+		"application/pkcs7-mime":            formatPKCS7,
+		"application/x-pkcs7-certificates":  formatPKCS7,
+		"application/json":                  formatJSON,
+		"application/jwk-set+json":          formatJSON,
+		"application/zip":                   formatZip,
+		"application/x-tar":                 formatTar,
+		"application/gzip":                  formatGzip,
+		"application/x-gzip":                formatGzip,
+	}
+
+	// archiveExtensions are the archive members read as certificates
+	archiveExtensions = map[string]bool{".pem": true, ".crt": true, ".cer": true, ".der": true}
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     pkcs7Certificates `asn1:"optional,tag:0"`
+}
+
+type pkcs7Certificates struct {
+	Raw asn1.RawContent
+}
+
+// spiffeBundle is a SPIFFE trust bundle, a JWK set whose X.509 authorities
+// carry their certificate in the x5c parameter
+type spiffeBundle struct {
+	Keys []struct {
+		Use string   `json:"use"`
+		X5c []string `json:"x5c"`
+	} `json:"keys"`
+}
+
+// detectFormat identifies the content format from its media type, falling
+// back to its magic bytes when the media type is missing or generic
+func detectFormat(contentType string, content []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if format, ok := contentTypeFormats[mediaType]; ok {
+			return format
+		}
+	}
+	trimmed := bytes.TrimSpace(content)
+	switch {
+	case bytes.Contains(content, []byte("-----BEGIN ")):
+		return formatPEM
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return formatJSON
+	case bytes.HasPrefix(content, []byte("PK\x03\x04")):
+		return formatZip
+	case bytes.HasPrefix(content, []byte{0x1f, 0x8b}):
+		return formatGzip
+	case len(content) > 262 && string(content[257:262]) == "ustar":
+		return formatTar
+	case len(content) > 0 && content[0] == 0x30:
+		if isPKCS7(content) {
+			return formatPKCS7
+		}
+		return formatDER
+	}
+	return formatUnknown
+}
+
+// invalidBundleError reports content rejected as a ca bundle, so that the
+// reason reaches the admission response
+type invalidBundleError struct {
+	err error
+}
+
+func (e invalidBundleError) Error() string {
+	return e.err.Error()
+}
+
+func (e invalidBundleError) Unwrap() error {
+	return e.err
+}
+
+// parseBundle converts content of any supported format into a PEM bundle.
+// PEM content is kept as is, preserving comments between certificates
+func parseBundle(contentType string, content []byte) ([]byte, error) {
+	format := detectFormat(contentType, content)
+	certificates, err := parseFormat(format, content)
+	if err != nil {
+		return nil, invalidBundleError{fmt.Errorf("unable to parse ca bundle as %s: %w", format, err)}
+	} else if len(certificates) == 0 {
+		return nil, invalidBundleError{fmt.Errorf("invalid ca bundle: no certificates found in %s content", format)}
+	} else if err := validateCertificates(certificates, time.Now()); err != nil {
+		return nil, invalidBundleError{fmt.Errorf("invalid ca bundle: %w", err)}
+	}
+	if format == formatPEM {
+		return content, nil
+	}
+	return encodeCertificates(certificates), nil
+}
+
+// validateCertificates fully parses every certificate of the bundle,
+// rejecting expired ones
+func validateCertificates(certificates [][]byte, now time.Time) error {
+	for i, der := range certificates {
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("certificate %d: %w", i+1, err)
+		}
+		if now.After(certificate.NotAfter) {
+			return fmt.Errorf("certificate %d %q expired on %s", i+1, certificate.Subject.CommonName, formatTime(certificate.NotAfter))
+		}
+	}
+	return nil
+}
+
+func parseFormat(format string, content []byte) ([][]byte, error) {
+	switch format {
+	case formatPEM:
+		return parsePEM(content)
+	case formatDER:
+		return parseDER(content)
+	case formatPKCS7:
+		return parsePKCS7(content)
+	case formatJSON:
+		return parseSPIFFE(content)
+	case formatZip:
+		return parseZip(content)
+	case formatTar:
+		return parseTar(bytes.NewReader(content))
+	case formatGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		return parseTar(reader)
+	}
+	return nil, fmt.Errorf("unsupported content")
+}
+
+// parsePEM decodes the certificates of PEM content. Text is allowed before
+// and between blocks, but not after the last one, and private keys are
+// refused since publishing them with the bundle would leak them
+func parsePEM(content []byte) ([][]byte, error) {
+	var certificates [][]byte
+	block, rest := pem.Decode(content)
+	for ; block != nil; block, rest = pem.Decode(rest) {
+		switch {
+		case block.Type == "CERTIFICATE":
+			certificates = append(certificates, block.Bytes)
+		case block.Type == "PKCS7":
+			fromPKCS7, err := parsePKCS7(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certificates = append(certificates, fromPKCS7...)
+		case strings.Contains(block.Type, "PRIVATE KEY"):
+			return nil, fmt.Errorf("%s block found", strings.ToLower(block.Type))
+		}
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return nil, fmt.Errorf("trailing data after the last pem block")
+	}
+	return certificates, nil
+}
+
+func parseDER(content []byte) ([][]byte, error) {
+	parsed, err := x509.ParseCertificates(content)
+	if err != nil {
+		return nil, err
+	}
+	var certificates [][]byte
+	for _, certificate := range parsed {
+		certificates = append(certificates, certificate.Raw)
+	}
+	return certificates, nil
+}
+
+func isPKCS7(content []byte) bool {
+	var contentInfo pkcs7ContentInfo
+	_, err := asn1.Unmarshal(content, &contentInfo)
+	return err == nil && contentInfo.ContentType.Equal(oidPKCS7SignedData)
+}
+
+func parsePKCS7(content []byte) ([][]byte, error) {
+	var contentInfo pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(content, &contentInfo); err != nil {
+		return nil, err
+	} else if !contentInfo.ContentType.Equal(oidPKCS7SignedData) {
+		return nil, fmt.Errorf("unsupported pkcs7 content type %s", contentInfo.ContentType)
+	}
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, err
+	} else if len(signedData.Certificates.Raw) == 0 {
+		return nil, nil
+	}
+	var set asn1.RawValue
+	if _, err := asn1.Unmarshal(signedData.Certificates.Raw, &set); err != nil {
+		return nil, err
+	}
+	return parseDER(set.Bytes)
+}
+
+func parseSPIFFE(content []byte) ([][]byte, error) {
+	var bundle spiffeBundle
+	if err := json.Unmarshal(content, &bundle); err != nil {
+		return nil, err
+	}
+	var certificates [][]byte
+	for _, key := range bundle.Keys {
+		if key.Use != "" && key.Use != "x509-svid" {
+			continue
+		}
+		for _, encoded := range key.X5c {
+			der, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := x509.ParseCertificate(der); err != nil {
+				return nil, err
+			}
+			certificates = append(certificates, der)
+		}
+	}
+	return certificates, nil
+}
+
+// parseArchiveMember reads the certificates of a single archive member,
+// which may be either PEM or DER encoded
+func parseArchiveMember(name string, content []byte) ([][]byte, error) {
+	if !archiveExtensions[strings.ToLower(path.Ext(name))] {
+		return nil, nil
+	}
+	format := formatDER
+	if bytes.Contains(content, []byte("-----BEGIN ")) {
+		format = formatPEM
+	}
+	certificates, err := parseFormat(format, content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return certificates, nil
+}
+
+func parseZip(content []byte) ([][]byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+	var certificates [][]byte
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		member, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(member)
+		_ = member.Close()
+		if err != nil {
+			return nil, err
+		}
+		fromMember, err := parseArchiveMember(file.Name, data)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, fromMember...)
+	}
+	return certificates, nil
+}
+
+func parseTar(r io.Reader) ([][]byte, error) {
+	reader := tar.NewReader(r)
+	var certificates [][]byte
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return certificates, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		fromMember, err := parseArchiveMember(header.Name, data)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, fromMember...)
+	}
+}
+
+func encodeCertificates(certificates [][]byte) []byte {
+	var b bytes.Buffer
+	for _, certificate := range certificates {
+		_ = pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: certificate})
+	}
+	return b.Bytes()
+}
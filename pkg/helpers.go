@@ -12,9 +12,16 @@ package kac
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"mime"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -30,13 +37,20 @@ import (
 )
 
 const (
-	keyFake = "fake"
+	keyFake              = "fake"
+	keyAdmissionDeadline = "ADMISSION_DEADLINE"
+	keyAPIQPS            = "CA_BUNDLE_API_QPS"
+	keyAPIBurst          = "CA_BUNDLE_API_BURST"
 )
 
 var (
 	runtimeScheme = runtime.NewScheme()
 	codecFactory  = serializer.NewCodecFactory(runtimeScheme)
 	deserializer  = codecFactory.UniversalDeserializer()
+
+	// strictDeserializer reports unknown and duplicate fields, which hint at
+	// version skew between the apiserver and the webhook
+	strictDeserializer = serializer.NewCodecFactory(runtimeScheme, serializer.EnableStrict).UniversalDeserializer()
 )
 
 type AdmissionReviewer func(context.Context, admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error)
@@ -48,6 +62,7 @@ func init() {
 
 func errorResponse(c *gin.Context, statusCode int, err error) {
 	_ = c.Error(err)
+	recordError(err)
 	c.JSON(statusCode, gin.H{"error": err.Error()})
 }
 
@@ -56,19 +71,32 @@ func validateAndDeserialize(ar admissionv1.AdmissionReview, expectedGVR metav1.G
 	if ar.Request.Resource != expectedGVR {
 		return nil, fmt.Errorf("expect resource to be %s, got %s", expectedGVR, ar.Request.Resource)
 	}
-	// Deserialize object from AdmissionRequest
+	// Deserialize object from AdmissionRequest, ignoring the fields of
+	// newer api versions
 	obj, gvk, err := deserializer.Decode(ar.Request.Object.Raw, nil, nil)
-	if *gvk != expectedGVK {
-		return nil, fmt.Errorf("deserialized object is invalid: %v", obj)
-	} else if err != nil {
+	if err != nil {
 		return nil, err
-	} else {
-		return obj, nil
+	} else if *gvk != expectedGVK {
+		return nil, fmt.Errorf("deserialized object is invalid: %v", obj)
+	}
+	if featureEnabled(featureSkewChecks) {
+		reportUnknownFields(expectedGVK, ar.Request.Object.Raw)
 	}
+	return obj, nil
+}
+
+type clientSetKey struct{}
+
+// WithClientSet returns a context in which the injector uses clientSet
+// instead of the in-cluster clientset
+func WithClientSet(ctx context.Context, clientSet kubernetes.Interface) context.Context {
+	return context.WithValue(ctx, clientSetKey{}, clientSet)
 }
 
 func getKubernetesClientSet(ctx context.Context) (kubernetes.Interface, error) {
-	if ctx.Value(keyFake) != nil && ctx.Value(keyFake).(bool) {
+	if clientSet, ok := ctx.Value(clientSetKey{}).(kubernetes.Interface); ok {
+		return clientSet, nil
+	} else if ctx.Value(keyFake) != nil && ctx.Value(keyFake).(bool) {
 		c := fake.NewSimpleClientset()
 		return c, nil
 	} else {
@@ -76,8 +104,47 @@ func getKubernetesClientSet(ctx context.Context) (kubernetes.Interface, error) {
 		if err != nil {
 			return nil, err
 		}
-		return kubernetes.NewForConfig(config)
+		return kubernetes.NewForConfig(withAPIRateLimits(config))
+	}
+
+}
+
+// withAPIRateLimits sets the client side rate limit of the apiserver
+// requests from CA_BUNDLE_API_QPS and CA_BUNDLE_API_BURST, keeping the
+// client-go defaults when unset
+func withAPIRateLimits(config *rest.Config) *rest.Config {
+	if qps, _ := strconv.ParseFloat(os.Getenv(keyAPIQPS), 32); qps > 0 {
+		config.QPS = float32(qps)
 	}
+	if burst, _ := strconv.Atoi(os.Getenv(keyAPIBurst)); burst > 0 {
+		config.Burst = burst
+	}
+	return config
+}
+
+// reviewWithDeadline runs the reviewer bounded by the configured admission
+// deadline and records whether it was answered in time
+func reviewWithDeadline(ctx context.Context, admissionReviewer AdmissionReviewer, ar admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+
+	deadline, _ := time.ParseDuration(os.Getenv(keyAdmissionDeadline))
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+	admissionDeadlineSeconds.set(deadline.Seconds())
+
+	start := time.Now()
+	response, err := admissionReviewer(ctx, ar)
+	elapsed := time.Since(start)
+
+	admissionsTotal.inc()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || (deadline > 0 && elapsed > deadline) {
+		admissionsDeadlineExceededTotal.inc()
+	} else if err == nil {
+		admissionsWithinDeadlineTotal.inc()
+	}
+	return response, err
 
 }
 
@@ -94,7 +161,14 @@ func serve(c *gin.Context, admissionReviewer AdmissionReviewer) {
 		return
 	}
 
-	if obj, gvk, err := deserializer.Decode(body, nil, nil); err != nil {
+	// The payload encoding is recognized whatever the content type, and
+	// the review is answered with the serializer the apiserver asked
+	// for, or else the one it used
+	encoder := responseSerializer(c.GetHeader("Accept"), c.ContentType())
+
+	obj, gvk, err := strictDeserializer.Decode(body, nil, nil)
+	strictErr, isStrictErr := runtime.AsStrictDecodingError(err)
+	if err != nil && !isStrictErr {
 		errorResponse(c, http.StatusBadRequest, err)
 		return
 	} else {
@@ -105,15 +179,219 @@ func serve(c *gin.Context, admissionReviewer AdmissionReviewer) {
 		}
 		resp = &admissionv1.AdmissionReview{}
 		resp.SetGroupVersionKind(*gvk)
-		resp.Response, err = admissionReviewer(ctx, *req)
+
+		// Reviews without request have nothing to answer, the rejection
+		// is still a review so that the caller can read its result
+		if req.Request == nil {
+			err := fmt.Errorf("admission review has no request")
+			_ = c.Error(err)
+			recordError(err)
+			resp.Response = &admissionv1.AdmissionResponse{Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusBadRequest,
+				Reason:  metav1.StatusReasonBadRequest,
+				Message: err.Error(),
+			}}
+			writeReview(c, encoder, resp, http.StatusBadRequest)
+			return
+		}
+
+		resp.Response, err = reviewWithDeadline(ctx, admissionReviewer, *req)
 		if err != nil {
 			errorResponse(c, http.StatusInternalServerError, err)
 			return
 		}
 		resp.Response.UID = req.Request.UID
+		if isStrictErr {
+			resp.Response.Warnings = append(resp.Response.Warnings, strictWarnings(strictErr.Errors())...)
+		}
+
+	}
+
+	writeReview(c, encoder, resp, http.StatusOK)
+
+}
+
+// writeReview encodes the review with the negotiated serializer
+func writeReview(c *gin.Context, encoder runtime.SerializerInfo, review *admissionv1.AdmissionReview, statusCode int) {
+	encoded, err := encodeReview(encoder, review)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+	defer releaseBuffer(encoded)
+	c.Data(statusCode, encoder.MediaType, encoded.Bytes())
+}
+
+// responseSerializer picks the first supported media type accepted by the
+// client, falling back to the media type of the request and then to json
+func responseSerializer(accept string, contentType string) runtime.SerializerInfo {
+	mediaTypes := codecFactory.SupportedMediaTypes()
+	for _, accepted := range strings.Split(accept, ",") {
+		mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if info, ok := runtime.SerializerInfoForMediaType(mediaTypes, mediaType); ok {
+			return info
+		}
+	}
+	if info, ok := runtime.SerializerInfoForMediaType(mediaTypes, contentType); ok {
+		return info
+	}
+	info, _ := runtime.SerializerInfoForMediaType(mediaTypes, runtime.ContentTypeJSON)
+	return info
+}
+
+// strictWarnings turns strict decoding errors into admission warnings
+func strictWarnings(errs []error) []string {
+	var warnings []string
+	for _, err := range errs {
+		log.Printf("admission review: %s", err)
+		warnings = append(warnings, "admission review: "+err.Error())
+	}
+	return warnings
+}
 
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
 
-	c.JSON(http.StatusOK, resp)
+func containsString(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
 
+func containsAny(items []string, values []string) bool {
+	for _, value := range values {
+		if containsString(items, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasEnvVar(container corev1.Container, name string) bool {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMountPath(container corev1.Container, mountPath string) bool {
+	for _, mount := range container.VolumeMounts {
+		if mount.MountPath == mountPath {
+			return true
+		}
+	}
+	return false
+}
+
+// conflictingMountPaths returns the paths of mounts already taken on the
+// container by something else
+func conflictingMountPaths(container corev1.Container, mounts []corev1.VolumeMount) []string {
+	var paths []string
+	for _, mount := range mounts {
+		if hasMountPath(container, mount.MountPath) {
+			paths = append(paths, mount.MountPath)
+		}
+	}
+	return paths
+}
+
+func hasVolumeMount(container corev1.Container, name string, mountPath string) bool {
+	for _, mount := range container.VolumeMounts {
+		if mount.Name == name && mount.MountPath == mountPath {
+			return true
+		}
+	}
+	return false
+}
+
+func hasContainer(containers []corev1.Container, name string) bool {
+	for _, container := range containers {
+		if container.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasVolume(spec corev1.PodSpec, name string) bool {
+	for _, volume := range spec.Volumes {
+		if volume.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func mountsConfigMap(spec corev1.PodSpec, name string) bool {
+	for _, volume := range spec.Volumes {
+		if volume.ConfigMap != nil && volume.ConfigMap.Name == name {
+			return true
+		}
+		if volume.Projected == nil {
+			continue
+		}
+		for _, source := range volume.Projected.Sources {
+			if source.ConfigMap != nil && source.ConfigMap.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mountsBundle tells whether the pod mounts the bundle object named name,
+// whatever the name of its volume
+func mountsBundle(spec corev1.PodSpec, name string) bool {
+	if bundleTarget() != targetSecret {
+		return mountsConfigMap(spec, name)
+	}
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == name {
+			return true
+		}
+		if volume.Projected != nil && projectsBundle(spec, volume.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// privilegedReasons lists the host access and privileges a pod requests
+func privilegedReasons(spec corev1.PodSpec) []string {
+	var reasons []string
+	if spec.HostNetwork {
+		reasons = append(reasons, "hostNetwork")
+	}
+	if spec.HostPID {
+		reasons = append(reasons, "hostPID")
+	}
+	if spec.HostIPC {
+		reasons = append(reasons, "hostIPC")
+	}
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			reasons = append(reasons, "hostPath volume "+volume.Name)
+		}
+	}
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, container := range containers {
+			if container.SecurityContext != nil && container.SecurityContext.Privileged != nil && *container.SecurityContext.Privileged {
+				reasons = append(reasons, "privileged container "+container.Name)
+			}
+		}
+	}
+	return reasons
 }
@@ -0,0 +1,102 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	keyCABundleHistory = "CA_BUNDLE_HISTORY"
+
+	labelRevisionOf = "kac-ca-injector/revision-of"
+	revisionLength  = 10
+)
+
+// revisionName names the bundle object holding a bundle revision
+func revisionName(name string, hash string) string {
+	return name + "-" + hash[:revisionLength]
+}
+
+// recordRevision keeps a copy of the managed bundle object as a revision,
+// on the bundle target, up to the configured number of revisions per
+// namespace
+func recordRevision(ctx context.Context, clientSet kubernetes.Interface, configMap *corev1.ConfigMap, filename string) error {
+
+	history, _ := strconv.Atoi(os.Getenv(keyCABundleHistory))
+	if history <= 0 {
+		return nil
+	}
+
+	hash := bundleHash([]byte(configMap.Data[filename]))
+	_, err := createBundle(ctx, clientSet, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      revisionName(configMap.Name, hash),
+			Namespace: configMap.Namespace,
+			Labels: map[string]string{
+				labelManagedBy:  labelManagedByValue,
+				labelRevisionOf: configMap.Name,
+			},
+			Annotations: timestampAnnotations([]byte(configMap.Data[filename])),
+		},
+		Data: configMap.Data,
+	})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	// Drop the oldest revisions
+	items, err := listBundles(ctx, clientSet, configMap.Namespace, labels.SelectorFromSet(labels.Set{labelRevisionOf: configMap.Name}).String())
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].CreationTimestamp.Before(&items[j].CreationTimestamp)
+	})
+	for i := 0; i < len(items)-history; i++ {
+		if err := deleteBundle(ctx, clientSet, configMap.Namespace, items[i].Name); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+
+}
+
+// rollbackBundle points the managed bundle object back at a recorded
+// revision
+func rollbackBundle(ctx context.Context, clientSet kubernetes.Interface, namespace string, name string, revision string) error {
+	revisionBundle, err := getBundle(ctx, clientSet, namespace, name+"-"+revision)
+	if err != nil {
+		return err
+	}
+	configMap, err := getBundle(ctx, clientSet, namespace, name)
+	if err != nil {
+		return err
+	}
+	config, _ := currentConfig()
+	filename := config.BundleFilename
+	previous := configMap.Data[filename]
+	configMap.Data = revisionBundle.Data
+	if configMap, err = updateBundle(ctx, clientSet, configMap); err != nil {
+		return err
+	}
+	recordBundleUpdate(ctx, clientSet, configMap, []byte(previous), []byte(configMap.Data[filename]))
+	return nil
+}
@@ -0,0 +1,36 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// NewKubeconfigClientSet returns a clientset for the cluster of the named
+// context of the kubeconfig file, or of its current context
+func NewKubeconfigClientSet(path string, contextName string) (kubernetes.Interface, error) {
+	config, err := loadKubeconfig(path, contextName)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(withAPIRateLimits(config))
+}
+
+// loadKubeconfig loads the kubeconfig file the way kubectl does, relative
+// paths, exec and auth provider credentials included
+func loadKubeconfig(path string, contextName string) (*rest.Config, error) {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: path},
+		&clientcmd.ConfigOverrides{CurrentContext: contextName},
+	).ClientConfig()
+}
@@ -0,0 +1,64 @@
+package kac
+
+import (
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_LoadKubeconfig(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config")
+	assert.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(path), "ca.crt"), fixtures.Certificate("staging", time.Now().AddDate(1, 0, 0)), 0600))
+	assert.NoError(t, os.WriteFile(path, []byte(`
+current-context: staging
+clusters:
+- name: staging
+  cluster:
+    server: https://staging.example.com:6443
+    certificate-authority: ca.crt
+- name: production
+  cluster:
+    server: https://production.example.com:6443
+    certificate-authority-data: Y2EtZGF0YQ==
+contexts:
+- name: staging
+  context: {cluster: staging, user: admin}
+- name: production
+  context: {cluster: production, user: oidc}
+users:
+- name: admin
+  user:
+    token: secret-token
+- name: oidc
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: kubelogin
+`), 0600))
+
+	t.Run("test current context", func(t *testing.T) {
+		config, err := loadKubeconfig(path, "")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://staging.example.com:6443", config.Host)
+		assert.Equal(t, filepath.Join(filepath.Dir(path), "ca.crt"), config.TLSClientConfig.CAFile)
+		assert.Equal(t, "secret-token", config.BearerToken)
+	})
+
+	t.Run("test exec credentials", func(t *testing.T) {
+		config, err := loadKubeconfig(path, "production")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://production.example.com:6443", config.Host)
+		assert.Equal(t, []byte("ca-data"), config.TLSClientConfig.CAData)
+		assert.Equal(t, "kubelogin", config.ExecProvider.Command)
+	})
+
+	t.Run("test missing context", func(t *testing.T) {
+		_, err := loadKubeconfig(path, "development")
+		assert.EqualError(t, err, `context "development" does not exist`)
+	})
+
+}
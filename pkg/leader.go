@@ -0,0 +1,90 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	keyLeaderElectionLease = "LEADER_ELECTION_LEASE"
+	keyPodIP               = "POD_IP"
+
+	serverPort = "8443"
+)
+
+var (
+	leaderMutex    sync.RWMutex
+	leaderIdentity string
+	ownIdentity    string
+	leaderClient   *http.Client
+)
+
+// runTasks runs every task concurrently until they all return
+func runTasks(ctx context.Context, tasks []func(context.Context)) {
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task func(context.Context)) {
+			defer wg.Done()
+			task(ctx)
+		}(task)
+	}
+	wg.Wait()
+}
+
+// delegatedLeader returns the leader address and the client to reach it
+// when running as a follower
+func delegatedLeader() (string, *http.Client, bool) {
+	leaderMutex.RLock()
+	defer leaderMutex.RUnlock()
+	if leaderIdentity != "" && leaderIdentity != ownIdentity && leaderClient != nil {
+		return leaderIdentity, leaderClient, true
+	}
+	return "", nil, false
+}
+
+// pinnedClient returns a client that only accepts servers presenting the
+// current certificate of servingCert, and authenticates to their auxiliary
+// endpoints. The certificate is read on every connection, so that the pin
+// follows the rotations of the certificate shared by all replicas
+func pinnedClient(servingCert *ServingCertificate) *http.Client {
+	return pinnedClientFor(func() []byte {
+		certificate, _ := servingCert.GetCertificate(nil)
+		return certificate.Certificate[0]
+	})
+}
+
+func pinnedClientFor(certificate func() []byte) *http.Client {
+	return &http.Client{
+		Transport: auxAuthTransport{next: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				// Pod IPs are not in the certificate names, so the chain
+				// is not verified and the leaf certificate is pinned instead
+				InsecureSkipVerify: true,
+				VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+					if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], certificate()) {
+						return fmt.Errorf("leader certificate does not match the serving certificate")
+					}
+					return nil
+				},
+			},
+		}},
+		Timeout: 30 * time.Second,
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// LoadTestOptions describes the synthetic admission traffic of a load test
+type LoadTestOptions struct {
+	// Target is the mutate url, the router is exercised in-process when empty
+	Target string
+	// Insecure skips verification of the target certificate
+	Insecure bool
+	// Concurrency is the number of concurrent clients
+	Concurrency int
+	// Requests is the total number of admission reviews sent
+	Requests int
+	// Containers is the number of containers of every pod
+	Containers int
+	// Annotated sets the injection annotation on the pods
+	Annotated bool
+}
+
+// LoadTestReport summarizes the latencies observed during a load test
+type LoadTestReport struct {
+	Requests int
+	Errors   int
+	Duration time.Duration
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+func (r LoadTestReport) String() string {
+	return fmt.Sprintf("requests=%d errors=%d duration=%s rps=%.1f p50=%s p90=%s p99=%s max=%s",
+		r.Requests, r.Errors, r.Duration, float64(r.Requests)/r.Duration.Seconds(), r.P50, r.P90, r.P99, r.Max)
+}
+
+// RunLoadTest fires synthetic AdmissionReview traffic at the mutate
+// endpoint and reports the latency percentiles
+func RunLoadTest(ctx context.Context, options LoadTestOptions) (*LoadTestReport, error) {
+
+	if options.Concurrency <= 0 || options.Requests <= 0 {
+		return nil, fmt.Errorf("concurrency and requests must be positive")
+	}
+
+	body, err := loadTestReview(options)
+	if err != nil {
+		return nil, err
+	}
+	send := loadTestSender(ctx, options)
+
+	var mutex sync.Mutex
+	var latencies []time.Duration
+	report := &LoadTestReport{Requests: options.Requests}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < options.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				requestStart := time.Now()
+				err := send(body)
+				latency := time.Since(requestStart)
+				mutex.Lock()
+				latencies = append(latencies, latency)
+				if err != nil {
+					report.Errors++
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < options.Requests; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	report.Duration = time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P50 = percentile(latencies, 0.50)
+	report.P90 = percentile(latencies, 0.90)
+	report.P99 = percentile(latencies, 0.99)
+	report.Max = latencies[len(latencies)-1]
+	return report, nil
+
+}
+
+func loadTestReview(options LoadTestOptions) ([]byte, error) {
+	config, _ := currentConfig()
+	pod := corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "loadtest", Namespace: config.PodNamespace},
+	}
+	if options.Annotated {
+		pod.Annotations = map[string]string{config.Annotation: "true"}
+	}
+	for i := 0; i < options.Containers; i++ {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "container-" + strconv.Itoa(i), Image: "loadtest"})
+	}
+	encodedPod, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("loadtest"),
+			Resource:  podsGVR,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: encodedPod},
+		},
+	})
+}
+
+// loadTestSender returns a function posting a review either to the target
+// or to an in-process router backed by a fake clientset
+func loadTestSender(ctx context.Context, options LoadTestOptions) func([]byte) error {
+
+	if options.Target == "" {
+		gin.DefaultWriter = ioutil.Discard
+		router := NewRouter()
+		fakeCtx := context.WithValue(ctx, keyFake, true)
+		return func(body []byte) error {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)).WithContext(fakeCtx)
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				return fmt.Errorf("unexpected status %d", w.Code)
+			}
+			return nil
+		}
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: options.Insecure},
+			MaxIdleConnsPerHost: options.Concurrency,
+		},
+		Timeout: 30 * time.Second,
+	}
+	return func(body []byte) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, options.Target, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil
+	}
+
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
@@ -0,0 +1,131 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	keyLogFormat = "LOG_FORMAT"
+
+	logFormatText        = "text"
+	logFormatJSON        = "json"
+	logFormatECS         = "ecs"
+	logFormatStackdriver = "stackdriver"
+
+	logLevelInfo  = "info"
+	logLevelError = "error"
+)
+
+// logSchema names the fields of a json log entry
+type logSchema struct {
+	timestamp string
+	level     string
+	message   string
+	// levels maps the severities to the values expected by the schema
+	levels map[string]string
+	// static fields added to every entry
+	static map[string]string
+}
+
+var logSchemas = map[string]logSchema{
+	logFormatJSON: {
+		timestamp: "time", level: "level", message: "msg",
+		levels: map[string]string{logLevelInfo: "info", logLevelError: "error"},
+	},
+	logFormatECS: {
+		timestamp: "@timestamp", level: "log.level", message: "message",
+		levels: map[string]string{logLevelInfo: "info", logLevelError: "error"},
+		static: map[string]string{"ecs.version": "1.6.0"},
+	},
+	logFormatStackdriver: {
+		timestamp: "timestamp", level: "severity", message: "message",
+		levels: map[string]string{logLevelInfo: "INFO", logLevelError: "ERROR"},
+	},
+}
+
+// ConfigureLogging sets the format of the injector and gin logs from
+// LOG_FORMAT, either text, json, ecs for the Elastic Common Schema or
+// stackdriver for Google Cloud Logging. Log times are in the zone of
+// CA_BUNDLE_TIMEZONE, which is resolved once here. It must be called
+// before the router is created
+func ConfigureLogging() error {
+	format := os.Getenv(keyLogFormat)
+	schema, ok := logSchemas[format]
+	if !ok && format != "" && format != logFormatText {
+		return fmt.Errorf("unsupported %s %q", keyLogFormat, format)
+	}
+	loaded, err := loadReportingLocation()
+	if err != nil {
+		return err
+	}
+	setReportingLocation(loaded)
+	if !ok {
+		if lookupSetting(keyTimezone) != "" {
+			log.SetFlags(0)
+			log.SetOutput(textLogWriter{out: os.Stderr, location: loaded})
+		}
+		return nil
+	}
+	log.SetFlags(0)
+	log.SetOutput(&logWriter{schema: schema, level: logLevelInfo, out: os.Stderr, location: loaded})
+	gin.DefaultWriter = &logWriter{schema: schema, level: logLevelInfo, out: os.Stdout, location: loaded}
+	gin.DefaultErrorWriter = &logWriter{schema: schema, level: logLevelError, out: os.Stderr, location: loaded}
+	return nil
+}
+
+// logWriter wraps every line written into a json entry of its schema
+type logWriter struct {
+	schema   logSchema
+	level    string
+	out      io.Writer
+	location *time.Location
+	now      func() time.Time
+
+	mu sync.Mutex
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	now := time.Now
+	if w.now != nil {
+		now = w.now
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		entry := map[string]string{
+			w.schema.timestamp: now().In(w.location).Format(time.RFC3339Nano),
+			w.schema.level:     w.schema.levels[w.level],
+			w.schema.message:   string(line),
+		}
+		for key, value := range w.schema.static {
+			entry[key] = value
+		}
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := w.out.Write(append(encoded, '\n')); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
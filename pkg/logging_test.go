@@ -0,0 +1,50 @@
+package kac
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_LogWriter(t *testing.T) {
+
+	now := func() time.Time { return time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC) }
+	decode := func(format string, level string, line string) map[string]string {
+		var out bytes.Buffer
+		w := &logWriter{schema: logSchemas[format], level: level, out: &out, location: time.UTC, now: now}
+		_, err := w.Write([]byte(line))
+		assert.NoError(t, err)
+		entry := map[string]string{}
+		assert.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+		return entry
+	}
+
+	t.Run("test elastic common schema", func(t *testing.T) {
+		assert.Equal(t, map[string]string{
+			"@timestamp":  "2022-07-01T12:00:00Z",
+			"log.level":   "info",
+			"message":     "Server started in all mode",
+			"ecs.version": "1.6.0",
+		}, decode(logFormatECS, logLevelInfo, "Server started in all mode\n"))
+	})
+
+	t.Run("test stackdriver schema", func(t *testing.T) {
+		assert.Equal(t, map[string]string{
+			"timestamp": "2022-07-01T12:00:00Z",
+			"severity":  "ERROR",
+			"message":   "panic recovered",
+		}, decode(logFormatStackdriver, logLevelError, "panic recovered\n"))
+	})
+
+	t.Run("test unsupported format", func(t *testing.T) {
+		_ = os.Setenv(keyLogFormat, "logfmt")
+		defer func() {
+			_ = os.Unsetenv(keyLogFormat)
+		}()
+		assert.EqualError(t, ConfigureLogging(), `unsupported LOG_FORMAT "logfmt"`)
+	})
+
+}
@@ -0,0 +1,159 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"container/list"
+	"context"
+	"os"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	keyLowMemory = "CA_BUNDLE_LOW_MEMORY"
+
+	// lowMemoryCacheEntries bounds every in-memory cache on low memory mode
+	lowMemoryCacheEntries = 16
+	// lowMemoryPageSize is the number of pods listed per request on low
+	// memory mode
+	lowMemoryPageSize = 100
+)
+
+// lowMemory tells whether CA_BUNDLE_LOW_MEMORY is enabled, trading api
+// server requests for a smaller footprint on tiny clusters
+func lowMemory() bool {
+	return os.Getenv(keyLowMemory) == "true"
+}
+
+// cacheEntries is the maximum number of entries kept by each cache, zero
+// meaning unbounded
+func cacheEntries() int {
+	if lowMemory() {
+		return lowMemoryCacheEntries
+	}
+	return 0
+}
+
+// lruCache is a string keyed cache evicting its least recently used
+// entries beyond cacheEntries, or beyond its own limit when lower
+type lruCache struct {
+	mutex sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+	limit int
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func newLRUCache() *lruCache {
+	return newBoundedLRUCache(0)
+}
+
+// newBoundedLRUCache returns a cache keeping at most limit entries even
+// outside of low memory mode
+func newBoundedLRUCache(limit int) *lruCache {
+	return &lruCache{order: list.New(), items: map[string]*list.Element{}, limit: limit}
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+func (c *lruCache) add(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.items[key]; ok {
+		element.Value.(*lruEntry).value = value
+		c.order.MoveToFront(element)
+	} else {
+		c.items[key] = c.order.PushFront(&lruEntry{key, value})
+	}
+	limit := cacheEntries()
+	if c.limit > 0 && (limit == 0 || c.limit < limit) {
+		limit = c.limit
+	}
+	for limit > 0 && c.order.Len() > limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lruCache) remove(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.items[key]; ok {
+		c.order.Remove(element)
+		delete(c.items, key)
+	}
+}
+
+// retain removes the entries whose key is not kept
+func (c *lruCache) retain(keep func(key string) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, element := range c.items {
+		if !keep(key) {
+			c.order.Remove(element)
+			delete(c.items, key)
+		}
+	}
+}
+
+func (c *lruCache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
+
+// listPods lists the pods of namespace. On low memory mode pods are listed
+// in pages, and the fields no caller looks at are dropped from each page
+// before the next one is requested
+func listPods(ctx context.Context, clientSet kubernetes.Interface, namespace string) ([]corev1.Pod, error) {
+	if !lowMemory() {
+		pods, err := clientSet.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return pods.Items, nil
+	}
+
+	var items []corev1.Pod
+	options := metav1.ListOptions{Limit: lowMemoryPageSize}
+	for {
+		pods, err := clientSet.CoreV1().Pods(namespace).List(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods.Items {
+			pod.ManagedFields = nil
+			pod.Status = corev1.PodStatus{Phase: pod.Status.Phase}
+			items = append(items, pod)
+		}
+		if options.Continue = pods.Continue; options.Continue == "" {
+			return items, nil
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package kac
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+	"testing"
+)
+
+func Test_LowMemory(t *testing.T) {
+
+	t.Run("test unbounded cache", func(t *testing.T) {
+		cache := newLRUCache()
+		for i := 0; i < 2*lowMemoryCacheEntries; i++ {
+			cache.add(fmt.Sprint(i), i)
+		}
+		assert.Equal(t, 2*lowMemoryCacheEntries, cache.len())
+	})
+
+	t.Run("test bounded cache", func(t *testing.T) {
+		_ = os.Setenv(keyLowMemory, "true")
+		defer func() { _ = os.Unsetenv(keyLowMemory) }()
+		cache := newLRUCache()
+		for i := 0; i < lowMemoryCacheEntries; i++ {
+			cache.add(fmt.Sprint(i), i)
+		}
+		_, _ = cache.get("0")
+		cache.add("new", -1)
+		assert.Equal(t, lowMemoryCacheEntries, cache.len())
+		_, ok := cache.get("0")
+		assert.True(t, ok)
+		_, ok = cache.get("1")
+		assert.False(t, ok)
+	})
+
+	t.Run("test cache with its own limit", func(t *testing.T) {
+		cache := newBoundedLRUCache(2)
+		for i := 0; i < 3; i++ {
+			cache.add(fmt.Sprint(i), i)
+		}
+		assert.Equal(t, 2, cache.len())
+		cache.remove("1")
+		cache.retain(func(key string) bool { return key != "2" })
+		assert.Equal(t, 0, cache.len())
+	})
+
+	t.Run("test trimmed pods", func(t *testing.T) {
+		_ = os.Setenv(keyLowMemory, "true")
+		defer func() { _ = os.Unsetenv(keyLowMemory) }()
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubectl"}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.1"},
+		}
+		pods, err := listPods(context.Background(), fake.NewSimpleClientset(pod), metav1.NamespaceAll)
+		assert.NoError(t, err)
+		assert.Len(t, pods, 1)
+		assert.Nil(t, pods[0].ManagedFields)
+		assert.Equal(t, corev1.PodStatus{Phase: corev1.PodRunning}, pods[0].Status)
+	})
+
+}
@@ -0,0 +1,280 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+const (
+	keyHealthProbeAddress = "CA_BUNDLE_HEALTH_PROBE_ADDRESS"
+
+	defaultHealthProbeAddress = ":8081"
+	leaderTrackerPeriod       = 2 * time.Second
+)
+
+// RunManager serves the webhook and runs the background controllers on a
+// controller-runtime manager, which provides the informer caches, the
+// leader election and the health probes. Outside of webhook mode and with
+// LEADER_ELECTION_LEASE set, a single replica fetches the ca bundle from
+// the upstream url and runs tasks, which are cancelled once it loses the
+// lease; the other replicas fetch the bundle from the leader's /bundle
+// endpoint, authenticating it by the serving certificate shared by all
+// replicas. Without leader election every replica runs the tasks. It
+// returns when ctx is done or the manager fails
+func RunManager(ctx context.Context, servingCert *ServingCertificate, certFile, keyFile string, tasks ...func(context.Context)) error {
+
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	restConfig = withAPIRateLimits(restConfig)
+	clientSet, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	config, _ := currentConfig()
+	interval, _ := time.ParseDuration(os.Getenv(keyControllerInterval))
+	if interval <= 0 {
+		interval = defaultControllerPeriod
+	}
+	probeAddress := os.Getenv(keyHealthProbeAddress)
+	if probeAddress == "" {
+		probeAddress = defaultHealthProbeAddress
+	}
+	port, _ := strconv.Atoi(serverPort)
+	leaseName := os.Getenv(keyLeaderElectionLease)
+	leaderElection := config.Mode != ModeWebhook && leaseName != ""
+
+	logger := managerLogger()
+	ctrllog.SetLogger(logger)
+	mgr, err := manager.New(restConfig, manager.Options{
+		Scheme:                        scheme.Scheme,
+		Logger:                        logger,
+		SyncPeriod:                    &interval,
+		MetricsBindAddress:            "0",
+		HealthProbeBindAddress:        probeAddress,
+		LeaderElection:                leaderElection,
+		LeaderElectionResourceLock:    resourcelock.LeasesResourceLock,
+		LeaderElectionID:              leaseName,
+		LeaderElectionNamespace:       config.PodNamespace,
+		LeaderElectionReleaseOnCancel: true,
+		WebhookServer: &webhook.Server{
+			Port:     port,
+			CertDir:  filepath.Dir(certFile),
+			CertName: filepath.Base(certFile),
+			KeyName:  filepath.Base(keyFile),
+			TLSOpts: []func(*tls.Config){func(tlsConfig *tls.Config) {
+				tlsConfig.GetCertificate = servingCert.GetCertificate
+			}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The router keeps serving every route, the webhook server only
+	// provides the listener and the certificate
+	mgr.GetWebhookServer().Register("/", NewRouter())
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		return err
+	}
+	if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+		return err
+	}
+	if err := mgr.AddReadyzCheck("controllers", reconcilersChecker); err != nil {
+		return err
+	}
+
+	if config.Mode == ModeController {
+		if err := addConfigMapController(mgr, clientSet, interval); err != nil {
+			return err
+		}
+	}
+	if config.Mode != ModeWebhook {
+		if leaderElection {
+			leaderMutex.Lock()
+			ownIdentity = os.Getenv(keyPodIP)
+			leaderClient = pinnedClient(servingCert)
+			leaderMutex.Unlock()
+			hostname, _ := os.Hostname()
+			if err := mgr.Add(leaderTracker{clientSet, config.PodNamespace, leaseName, hostname}); err != nil {
+				return err
+			}
+		}
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			log.Printf("Started fetching the ca bundle for all replicas")
+			runTasks(ctx, tasks)
+			log.Printf("Stopped fetching the ca bundle for all replicas")
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+
+	return mgr.Start(ctx)
+
+}
+
+// managerLogger routes the logs of the manager to the standard logger,
+// along with the injector's own
+func managerLogger() logr.Logger {
+	return funcr.New(func(prefix, args string) {
+		if prefix != "" {
+			log.Printf("%s: %s", prefix, args)
+		} else {
+			log.Print(args)
+		}
+	}, funcr.Options{})
+}
+
+// reconcilersChecker fails the readiness probe while any controller is
+// stalled
+func reconcilersChecker(_ *http.Request) error {
+	if stalled := stalledReconcilers(time.Now()); len(stalled) > 0 {
+		return fmt.Errorf("stalled controllers: %s", strings.Join(stalled, ", "))
+	}
+	return nil
+}
+
+// addConfigMapController creates the ca bundle configmap, along with the
+// requested companion files, on every namespace with annotated pods. Pod
+// changes are watched through the manager cache and their namespaces
+// queued for reconcile, failed namespaces being retried with backoff. The
+// cache resyncs every interval, which queues every namespace with pods
+// again
+func addConfigMapController(mgr manager.Manager, clientSet kubernetes.Interface, interval time.Duration) error {
+	c, err := controller.New("configmap", mgr, controller.Options{
+		Reconciler: namespaceReconciler{reader: mgr.GetClient(), clientSet: clientSet},
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(podNamespace)); err != nil {
+		return err
+	}
+	// The controller only runs on the leader, and so is its stall tracking
+	return mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		registerReconciler("configmap", interval)
+		defer unregisterReconciler("configmap")
+		<-ctx.Done()
+		return nil
+	}))
+}
+
+// podNamespace queues the namespace of a pod, the unit reconciled by the
+// configmap controller
+func podNamespace(object client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: object.GetNamespace()}}}
+}
+
+// namespaceReconciler reconciles the bundles of the pods of a namespace,
+// read from the manager cache
+type namespaceReconciler struct {
+	reader    client.Reader
+	clientSet kubernetes.Interface
+}
+
+func (r namespaceReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	pods := corev1.PodList{}
+	err := r.reader.List(ctx, &pods, client.InNamespace(request.Name))
+	if err == nil {
+		err = reconcileBundles(ctx, r.clientSet, pods.Items)
+	}
+	recordReconcile("configmap", err)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("unable to reconcile ca bundle configmaps of namespace %s: %v", request.Name, err)
+	}
+	return reconcile.Result{}, nil
+}
+
+// leaderTracker follows the holder of the leader election lease on every
+// replica, so that the followers fetch the ca bundle from the leader. The
+// manager identifies the holder by its hostname, which is the pod name
+type leaderTracker struct {
+	clientSet kubernetes.Interface
+	namespace string
+	lease     string
+	hostname  string
+}
+
+func (t leaderTracker) Start(ctx context.Context) error {
+	ticker := time.NewTicker(leaderTrackerPeriod)
+	defer ticker.Stop()
+	holder := ""
+	for {
+		holder = t.track(ctx, holder)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection runs the tracker on the followers too
+func (t leaderTracker) NeedLeaderElection() bool {
+	return false
+}
+
+// track updates the leader address when the lease holder is no longer
+// holder, returning the current one
+func (t leaderTracker) track(ctx context.Context, holder string) string {
+	lease, err := t.clientSet.CoordinationV1().Leases(t.namespace).Get(ctx, t.lease, metav1.GetOptions{})
+	if err != nil || lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == holder {
+		return holder
+	}
+	current := *lease.Spec.HolderIdentity
+	podName := strings.SplitN(current, "_", 2)[0]
+
+	address := ""
+	if podName != t.hostname {
+		pod, err := t.clientSet.CoreV1().Pods(t.namespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("Unable to resolve the leader address of %s: %v", podName, err)
+			return holder
+		}
+		address = pod.Status.PodIP
+	}
+	leaderMutex.Lock()
+	defer leaderMutex.Unlock()
+	leaderIdentity = address
+	return current
+}
@@ -0,0 +1,109 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	metricTypeCounter = "counter"
+	metricTypeGauge   = "gauge"
+
+	triggerAnnotation     = "annotation"
+	triggerPodSelector    = "pod_selector"
+	triggerNamespaceLabel = "namespace_label"
+)
+
+var (
+	metricsRegistry []*metric
+
+	mutationsTotal                  = newMetric(metricTypeCounter, "kac_mutations_total", "Number of pods mutated, by injection trigger", "trigger")
+	admissionsTotal                 = newMetric(metricTypeCounter, "kac_admissions_total", "Number of admission reviews handled")
+	admissionsWithinDeadlineTotal   = newMetric(metricTypeCounter, "kac_admissions_within_deadline_total", "Number of admission reviews resolved successfully within the admission deadline")
+	admissionsDeadlineExceededTotal = newMetric(metricTypeCounter, "kac_admissions_deadline_exceeded_total", "Number of admission reviews that exceeded the admission deadline")
+	admissionDeadlineSeconds        = newMetric(metricTypeGauge, "kac_admission_deadline_seconds", "Configured admission deadline, zero when unbounded")
+)
+
+// metric is a minimal Prometheus counter or gauge with optional labels
+type metric struct {
+	kind   string
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newMetric(kind string, name string, help string, labels ...string) *metric {
+	m := &metric{kind: kind, name: name, help: help, labels: labels, values: map[string]float64{}}
+	metricsRegistry = append(metricsRegistry, m)
+	return m
+}
+
+func (m *metric) add(value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[strings.Join(labelValues, "\xff")] += value
+}
+
+func (m *metric) inc(labelValues ...string) {
+	m.add(1, labelValues...)
+}
+
+func (m *metric) set(value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[strings.Join(labelValues, "\xff")] = value
+}
+
+func (m *metric) get(labelValues ...string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[strings.Join(labelValues, "\xff")]
+}
+
+// write renders the metric in the Prometheus text exposition format
+func (m *metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var pairs []string
+		if len(m.labels) > 0 {
+			for i, value := range strings.Split(key, "\xff") {
+				if i < len(m.labels) {
+					pairs = append(pairs, fmt.Sprintf("%s=%q", m.labels[i], value))
+				}
+			}
+		}
+		if len(pairs) > 0 {
+			_, _ = fmt.Fprintf(w, "%s{%s} %g\n", m.name, strings.Join(pairs, ","), m.values[key])
+		} else {
+			_, _ = fmt.Fprintf(w, "%s %g\n", m.name, m.values[key])
+		}
+	}
+}
+
+func writeMetrics(w io.Writer) {
+	for _, m := range metricsRegistry {
+		m.write(w)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"log"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var configMapsAdopted = newMetric(metricTypeCounter, "kac_configmaps_adopted_total", "Number of configmaps created by older injector versions adopted at startup")
+
+// MigrateLegacyConfigMaps adopts the ca bundle configmaps created by older
+// versions of the injector, which carry no managed-by label, and logs the
+// adopted configmaps
+func MigrateLegacyConfigMaps(ctx context.Context) error {
+	clientSet, err := getKubernetesClientSet(ctx)
+	if err != nil {
+		return err
+	}
+	adopted, err := adoptLegacyConfigMaps(ctx, clientSet)
+	for _, name := range adopted {
+		log.Printf("Adopted legacy configmap %s", name)
+	}
+	log.Printf("Adopted %d legacy configmaps", len(adopted))
+	return err
+}
+
+func adoptLegacyConfigMaps(ctx context.Context, clientSet kubernetes.Interface) ([]string, error) {
+
+	config, _ := currentConfig()
+	configMapName := config.ConfigMapName
+	caBundleFilename := config.BundleFilename
+	hashAnnotation := config.Annotation + hashAnnotationSuffix
+
+	configMaps, err := clientSet.CoreV1().ConfigMaps(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var adopted []string
+	for _, configMap := range configMaps.Items {
+		bundle, ok := configMap.Data[caBundleFilename]
+		if configMap.Name != configMapName || !ok || configMap.Labels[labelManagedBy] != "" {
+			continue
+		}
+		if configMap.Labels == nil {
+			configMap.Labels = map[string]string{}
+		}
+		configMap.Labels[labelManagedBy] = labelManagedByValue
+		if configMap.Annotations == nil {
+			configMap.Annotations = map[string]string{}
+		}
+		configMap.Annotations[hashAnnotation] = bundleHash([]byte(bundle))
+		if _, err := clientSet.CoreV1().ConfigMaps(configMap.Namespace).Update(ctx, &configMap, metav1.UpdateOptions{}); err != nil {
+			log.Printf("Unable to adopt legacy configmap %s/%s: %v", configMap.Namespace, configMap.Name, err)
+			continue
+		}
+		configMapsAdopted.inc()
+		adopted = append(adopted, configMap.Namespace+"/"+configMap.Name)
+	}
+	return adopted, nil
+
+}
@@ -0,0 +1,36 @@
+package kac
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+	"testing"
+)
+
+func Test_AdoptLegacyConfigMaps(t *testing.T) {
+
+	ctx := context.Background()
+	name, filename := os.Getenv(keyConfigMapName), os.Getenv(keyCABundleFilename)
+	clientSet := fake.NewSimpleClientset(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "legacy"}, Data: map[string]string{filename: "bundle"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "managed", Labels: map[string]string{labelManagedBy: labelManagedByValue}}, Data: map[string]string{filename: "bundle"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "foreign"}, Data: map[string]string{"other": "data"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "legacy"}, Data: map[string]string{filename: "bundle"}},
+	)
+
+	adopted, err := adoptLegacyConfigMaps(ctx, clientSet)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"legacy/" + name}, adopted)
+
+	configMap, _ := clientSet.CoreV1().ConfigMaps("legacy").Get(ctx, name, metav1.GetOptions{})
+	assert.Equal(t, labelManagedByValue, configMap.Labels[labelManagedBy])
+	assert.Equal(t, bundleHash([]byte("bundle")), configMap.Annotations[os.Getenv(keyCABundleAnnotation)+hashAnnotationSuffix])
+
+	adopted, err = adoptLegacyConfigMaps(ctx, clientSet)
+	assert.NoError(t, err)
+	assert.Empty(t, adopted)
+
+}
@@ -0,0 +1,149 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	keyMirrorNamespaces = "CA_BUNDLE_MIRROR_NAMESPACES"
+	keyMirrorSecret     = "CA_BUNDLE_MIRROR_SECRET"
+	keyMirrorInterval   = "CA_BUNDLE_MIRROR_INTERVAL"
+	keyMirrorSecretType = "CA_BUNDLE_MIRROR_SECRET_TYPE"
+
+	mirrorSecretKey     = "ca.crt"
+	defaultMirrorPeriod = time.Hour
+	labelManagedBy      = "app.kubernetes.io/managed-by"
+	labelManagedByValue = "kac-ca-injector"
+)
+
+// RunBundleMirror keeps a Secret copy of the ca bundle on every designated
+// namespace, so that gateway components (ingress controllers, service
+// meshes) can reference it. Secrets are created with the type of
+// CA_BUNDLE_MIRROR_SECRET_TYPE, Opaque or kubernetes.io/tls, Opaque by
+// default. Existing secrets are only updated when labeled as managed by
+// the injector, keeping their type. It returns when ctx is done
+func RunBundleMirror(ctx context.Context) {
+
+	namespaces := splitList(os.Getenv(keyMirrorNamespaces))
+	secretName := os.Getenv(keyMirrorSecret)
+	if len(namespaces) == 0 || secretName == "" || !featureEnabled(featureBundleMirror) {
+		return
+	}
+	secretType := corev1.SecretType(os.Getenv(keyMirrorSecretType))
+	if secretType == "" {
+		secretType = corev1.SecretTypeOpaque
+	} else if secretType != corev1.SecretTypeOpaque && secretType != corev1.SecretTypeTLS {
+		log.Printf("Unable to start ca bundle mirror: invalid %s %q, expected %s or %s", keyMirrorSecretType, secretType, corev1.SecretTypeOpaque, corev1.SecretTypeTLS)
+		return
+	}
+	interval, _ := time.ParseDuration(os.Getenv(keyMirrorInterval))
+	if interval <= 0 {
+		interval = defaultMirrorPeriod
+	}
+
+	clientSet, err := getKubernetesClientSet(ctx)
+	if err != nil {
+		log.Printf("Unable to start ca bundle mirror: %v", err)
+		return
+	}
+
+	registerReconciler("mirror", interval)
+	defer unregisterReconciler("mirror")
+	for {
+		err := mirrorBundle(ctx, clientSet, namespaces, secretName, secretType)
+		if err != nil {
+			log.Printf("Unable to mirror ca bundle: %v", err)
+		}
+		recordReconcile("mirror", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+
+}
+
+// mirrorBundle copies the bundle into the secret of every namespace,
+// returning the failures of all of them so that the mirror is reported
+// unhealthy even when a single namespace is out of date
+func mirrorBundle(ctx context.Context, clientSet kubernetes.Interface, namespaces []string, secretName string, secretType corev1.SecretType) error {
+
+	// Bundle objects are secrets too on the secret target, which the
+	// mirror must not overwrite
+	config, _ := currentConfig()
+	if config.Target == targetSecret {
+		bundles, err := bundleConfigMaps(config)
+		if err != nil {
+			return err
+		} else if _, ok := bundles[secretName]; ok {
+			return fmt.Errorf("%s %s is the name of a ca bundle secret", keyMirrorSecret, secretName)
+		}
+	}
+
+	bundle, err := loadCABundle(withFreshBundle(ctx), config.BundleURL)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, namespace := range namespaces {
+		secret, err := clientSet.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			data := map[string][]byte{mirrorSecretKey: bundle}
+			// TLS secrets require the certificate and key entries, left
+			// empty since only the ca is mirrored
+			if secretType == corev1.SecretTypeTLS {
+				data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey] = []byte{}, []byte{}
+			}
+			_, err = clientSet.CoreV1().Secrets(namespace).Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      secretName,
+					Namespace: namespace,
+					Labels:    map[string]string{labelManagedBy: labelManagedByValue},
+				},
+				Type: secretType,
+				Data: data,
+			}, metav1.CreateOptions{})
+		} else if err == nil && secret.Labels[labelManagedBy] != labelManagedByValue {
+			// Leave alone secrets the injector didn't create
+			log.Printf("Skipping ca bundle mirror into secret %s/%s, not managed by %s", namespace, secretName, labelManagedByValue)
+			continue
+		} else if err == nil && !bytes.Equal(secret.Data[mirrorSecretKey], bundle) {
+			if secret.Data == nil {
+				secret.Data = map[string][]byte{}
+			}
+			secret.Data[mirrorSecretKey] = bundle
+			_, err = clientSet.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s/%s: %v", namespace, secretName, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to mirror ca bundle into %d of %d namespaces: %s", len(errs), len(namespaces), strings.Join(errs, "; "))
+	}
+	return nil
+
+}
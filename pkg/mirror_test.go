@@ -0,0 +1,82 @@
+package kac
+
+import (
+	"context"
+	"fmt"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_MirrorBundle(t *testing.T) {
+
+	ctx := context.Background()
+	bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bundle)
+	}))
+	defer server.Close()
+
+	_ = os.Setenv(keyCABundleURL, server.URL)
+	defer func() {
+		_ = os.Setenv(keyCABundleURL, caBundleURL)
+	}()
+
+	clientSet := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ca-bundle", Namespace: "istio-system", Labels: map[string]string{labelManagedBy: labelManagedByValue}},
+		Data:       map[string][]byte{mirrorSecretKey: []byte("outdated")},
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ca-bundle", Namespace: "gateway"},
+		Data:       map[string][]byte{mirrorSecretKey: []byte("unmanaged")},
+	})
+
+	assert.NoError(t, mirrorBundle(ctx, clientSet, []string{"ingress-nginx", "istio-system", "gateway"}, "ca-bundle", corev1.SecretTypeOpaque))
+	for _, namespace := range []string{"ingress-nginx", "istio-system"} {
+		secret, err := clientSet.CoreV1().Secrets(namespace).Get(ctx, "ca-bundle", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, bundle, secret.Data[mirrorSecretKey])
+	}
+	secret, _ := clientSet.CoreV1().Secrets("gateway").Get(ctx, "ca-bundle", metav1.GetOptions{})
+	assert.Equal(t, []byte("unmanaged"), secret.Data[mirrorSecretKey])
+
+	t.Run("test tls secrets", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "ca-bundle", Namespace: "istio-system", Labels: map[string]string{labelManagedBy: labelManagedByValue}},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{mirrorSecretKey: []byte("outdated"), corev1.TLSCertKey: []byte("cert"), corev1.TLSPrivateKeyKey: []byte("key")},
+		})
+		assert.NoError(t, mirrorBundle(ctx, clientSet, []string{"ingress-nginx", "istio-system"}, "ca-bundle", corev1.SecretTypeTLS))
+		created, _ := clientSet.CoreV1().Secrets("ingress-nginx").Get(ctx, "ca-bundle", metav1.GetOptions{})
+		assert.Equal(t, corev1.SecretTypeTLS, created.Type)
+		assert.Equal(t, bundle, created.Data[mirrorSecretKey])
+		assert.Contains(t, created.Data, corev1.TLSCertKey)
+		assert.Contains(t, created.Data, corev1.TLSPrivateKeyKey)
+		updated, _ := clientSet.CoreV1().Secrets("istio-system").Get(ctx, "ca-bundle", metav1.GetOptions{})
+		assert.Equal(t, bundle, updated.Data[mirrorSecretKey])
+		assert.Equal(t, []byte("cert"), updated.Data[corev1.TLSCertKey])
+	})
+
+	t.Run("test failed namespaces are returned", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset()
+		clientSet.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetNamespace() == "gateway" {
+				return true, nil, fmt.Errorf("forbidden")
+			}
+			return false, nil, nil
+		})
+		assert.EqualError(t, mirrorBundle(ctx, clientSet, []string{"ingress-nginx", "gateway"}, "ca-bundle", corev1.SecretTypeOpaque),
+			"unable to mirror ca bundle into 1 of 2 namespaces: gateway/ca-bundle: forbidden")
+		_, err := clientSet.CoreV1().Secrets("ingress-nginx").Get(ctx, "ca-bundle", metav1.GetOptions{})
+		assert.NoError(t, err)
+	})
+
+}
@@ -0,0 +1,100 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	keyCABundles   = "CA_BUNDLES"
+	keyPodSelector = "CA_BUNDLE_POD_SELECTOR"
+
+	// defaultBundleValue is the annotation value selecting the default
+	// bundle, loaded from CA_BUNDLE_URL
+	defaultBundleValue = "true"
+)
+
+// namedBundles parses the bundles configured on CA_BUNDLES, a YAML or
+// JSON object mapping a bundle name to its source, in any form accepted by
+// CA_BUNDLE_URL
+func namedBundles(config Config) (map[string]string, error) {
+	bundles := map[string]string{}
+	if err := yaml.UnmarshalStrict([]byte(config.Bundles), &bundles); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", keyCABundles, err)
+	}
+	for name := range bundles {
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 || name == defaultBundleValue {
+			return nil, fmt.Errorf("invalid ca bundle name %q in %s", name, keyCABundles)
+		}
+	}
+	return bundles, nil
+}
+
+// selectBundle returns the configmap and the source of the bundle selected
+// by the value of the injection annotation. Named bundles are kept on
+// their own configmap, suffixed by the bundle name
+func selectBundle(config Config, value string) (configMapName string, url string, ok bool, err error) {
+	if value == defaultBundleValue {
+		return config.ConfigMapName, config.BundleURL, true, nil
+	}
+	if value == "" || value == "false" {
+		return "", "", false, nil
+	}
+	bundles, err := namedBundles(config)
+	if err != nil {
+		return "", "", false, err
+	}
+	if url, ok = bundles[value]; !ok {
+		return "", "", false, fmt.Errorf("ca bundle %s is not configured", value)
+	}
+	return config.ConfigMapName + "-" + value, url, true, nil
+}
+
+// selectorInjection returns the injection annotation value of the default
+// bundle for pods matching the CA_BUNDLE_POD_SELECTOR label selector, so
+// that platform teams can target pods without annotating them
+func selectorInjection(selector labels.Selector, podLabels map[string]string) string {
+	if selector != nil && selector.Matches(labels.Set(podLabels)) {
+		return defaultBundleValue
+	}
+	return ""
+}
+
+// bundleConfigMaps maps the name of every managed bundle configmap to the
+// source of its bundle
+func bundleConfigMaps(config Config) (map[string]string, error) {
+	bundles, err := namedBundles(config)
+	if err != nil {
+		return nil, err
+	}
+	configMaps := map[string]string{config.ConfigMapName: config.BundleURL}
+	for name, url := range bundles {
+		configMaps[config.ConfigMapName+"-"+name] = url
+	}
+	return configMaps, nil
+}
+
+// isBundleConfigMap tells whether name is one of the managed bundle
+// configmaps
+func isBundleConfigMap(name string) bool {
+	config, _ := currentConfig()
+	configMaps, err := bundleConfigMaps(config)
+	if err != nil {
+		return name == config.ConfigMapName
+	}
+	_, ok := configMaps[name]
+	return ok
+}
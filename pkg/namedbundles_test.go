@@ -0,0 +1,145 @@
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func Test_NamedBundles(t *testing.T) {
+
+	_ = os.Setenv(keyCABundles, `{"internal-ca": "https://pki.example.com/internal.pem", "partner-ca": "configmap://pki/partner-ca"}`)
+	defer func() {
+		_ = os.Unsetenv(keyCABundles)
+	}()
+
+	for _, tc := range []struct {
+		value         string
+		configMapName string
+		url           string
+		inject        bool
+		err           string
+	}{
+		{"true", "ca-bundle", caBundleURL, true, ""},
+		{"internal-ca", "ca-bundle-internal-ca", "https://pki.example.com/internal.pem", true, ""},
+		{"partner-ca", "ca-bundle-partner-ca", "configmap://pki/partner-ca", true, ""},
+		{"false", "", "", false, ""},
+		{"", "", "", false, ""},
+		{"other-ca", "", "", false, "ca bundle other-ca is not configured"},
+	} {
+		t.Run("test select bundle "+tc.value, func(t *testing.T) {
+			configMapName, url, inject, err := selectBundle(testConfig(), tc.value)
+			assert.Equal(t, tc.configMapName, configMapName)
+			assert.Equal(t, tc.url, url)
+			assert.Equal(t, tc.inject, inject)
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+
+	t.Run("test invalid bundle names", func(t *testing.T) {
+		_ = os.Setenv(keyCABundles, `{"Partner_CA": "https://pki.example.com/partner.pem"}`)
+		_, _, _, err := selectBundle(testConfig(), "Partner_CA")
+		assert.EqualError(t, err, `invalid ca bundle name "Partner_CA" in CA_BUNDLES`)
+		assert.True(t, isBundleConfigMap("ca-bundle"))
+		assert.False(t, isBundleConfigMap("ca-bundle-Partner_CA"))
+	})
+
+	t.Run("test route /mutate with named bundle", func(t *testing.T) {
+		_ = os.Setenv(keyCABundles, `{"partner-ca": "configmap://pki/partner-ca"}`)
+		_ = os.Setenv(keyInjectorMode, ModeWebhook)
+		defer func() {
+			_ = os.Unsetenv(keyInjectorMode)
+		}()
+		encodedPod, _ := json.Marshal(corev1.Pod{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-pod",
+				Namespace:   "team-a",
+				Annotations: map[string]string{os.Getenv(keyCABundleAnnotation): "partner-ca"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		})
+		ar, _ := admissionReviewFactory(podsGVR, encodedPod)
+		w := fakeRequest(WithClientSet(context.Background(), fake.NewSimpleClientset()), NewRouter(), http.MethodPost, "/mutate", string(ar))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, string(admissionResponse(t, w).Patch), `"configMap":{"name":"ca-bundle-partner-ca"}`)
+	})
+
+	t.Run("test route /mutate with namespace injection label", func(t *testing.T) {
+		_ = os.Setenv(keyNamespaceLabel, "ca-injector/inject")
+		_ = os.Setenv(keyInjectorMode, ModeWebhook)
+		defer func() {
+			_ = os.Unsetenv(keyNamespaceLabel)
+			_ = os.Unsetenv(keyInjectorMode)
+		}()
+		clientSet := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "team-a",
+			Labels: map[string]string{"ca-injector/inject": "true"},
+		}})
+		mutate := func(annotations map[string]string) string {
+			encodedPod, _ := json.Marshal(corev1.Pod{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "team-a", Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			})
+			ar, _ := admissionReviewFactory(podsGVR, encodedPod)
+			w := fakeRequest(WithClientSet(context.Background(), clientSet), NewRouter(), http.MethodPost, "/mutate", string(ar))
+			assert.Equal(t, http.StatusOK, w.Code)
+			return string(admissionResponse(t, w).Patch)
+		}
+		mutations := mutationsTotal.get(triggerNamespaceLabel)
+		assert.Contains(t, mutate(nil), `"configMap":{"name":"ca-bundle"}`)
+		assert.Equal(t, mutations+1, mutationsTotal.get(triggerNamespaceLabel))
+		assert.Contains(t, mutate(map[string]string{os.Getenv(keyCABundleAnnotation): "partner-ca"}), `"configMap":{"name":"ca-bundle-partner-ca"}`)
+		assert.Equal(t, mutations+1, mutationsTotal.get(triggerNamespaceLabel))
+		assert.Empty(t, mutate(map[string]string{os.Getenv(keyCABundleAnnotation): "false"}))
+	})
+
+	t.Run("test pod selector injection", func(t *testing.T) {
+		selector, _ := labels.Parse("team=payments,tls=required")
+		assert.Equal(t, defaultBundleValue, selectorInjection(selector, map[string]string{"team": "payments", "tls": "required", "app": "api"}))
+		assert.Equal(t, "", selectorInjection(selector, map[string]string{"team": "payments"}))
+		assert.Equal(t, "", selectorInjection(nil, map[string]string{"team": "payments"}))
+	})
+
+	t.Run("test route /mutate with pod selector", func(t *testing.T) {
+		_ = os.Setenv(keyPodSelector, "team=payments")
+		_ = os.Setenv(keyInjectorMode, ModeWebhook)
+		defer func() {
+			_ = os.Unsetenv(keyPodSelector)
+			_ = os.Unsetenv(keyInjectorMode)
+		}()
+		mutate := func(annotations map[string]string) string {
+			encodedPod, _ := json.Marshal(corev1.Pod{
+				TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-pod",
+					Namespace:   "team-c",
+					Labels:      map[string]string{"team": "payments"},
+					Annotations: annotations,
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			})
+			ar, _ := admissionReviewFactory(podsGVR, encodedPod)
+			w := fakeRequest(WithClientSet(context.Background(), fake.NewSimpleClientset()), NewRouter(), http.MethodPost, "/mutate", string(ar))
+			assert.Equal(t, http.StatusOK, w.Code)
+			return string(admissionResponse(t, w).Patch)
+		}
+		mutations := mutationsTotal.get(triggerPodSelector)
+		assert.Contains(t, mutate(nil), `"configMap":{"name":"ca-bundle"}`)
+		assert.Equal(t, mutations+1, mutationsTotal.get(triggerPodSelector))
+		assert.Empty(t, mutate(map[string]string{os.Getenv(keyCABundleAnnotation): "false"}))
+	})
+
+}
@@ -0,0 +1,68 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	keyNamespaceLabel = "CA_BUNDLE_NAMESPACE_LABEL"
+	keyNamespaceTTL   = "CA_BUNDLE_NAMESPACE_CACHE_TTL"
+
+	defaultNamespaceTTL = 30 * time.Second
+)
+
+var (
+	// namespaceInjections caches the injection label of namespaces, by
+	// label and namespace, so that a rollout doesn't get the namespace
+	// once per pod
+	namespaceInjections = newLRUCache()
+)
+
+type cachedInjection struct {
+	value     string
+	fetchedAt time.Time
+}
+
+// namespaceInjection returns the value of the CA_BUNDLE_NAMESPACE_LABEL
+// label of the namespace, which pods not setting the injection annotation
+// inherit as if they were annotated with it. Pods opt out of a namespace
+// wide injection with the "false" annotation value. Values are cached for
+// CA_BUNDLE_NAMESPACE_CACHE_TTL, 30s by default and disabled with 0
+func namespaceInjection(ctx context.Context, clientSet kubernetes.Interface, config Config, namespace string, now time.Time) (string, error) {
+	label, ttl := config.NamespaceLabel, config.NamespaceTTL
+	if label == "" {
+		return "", nil
+	}
+
+	key := label + "/" + namespace
+	if cached, ok := namespaceInjections.get(key); ok && now.Sub(cached.(cachedInjection).fetchedAt) < ttl {
+		return cached.(cachedInjection).value, nil
+	}
+
+	var value string
+	ns, err := clientSet.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	} else if err == nil {
+		value = ns.Labels[label]
+	}
+	if ttl > 0 {
+		namespaceInjections.add(key, cachedInjection{value, now})
+	}
+	return value, nil
+}
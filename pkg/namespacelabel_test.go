@@ -0,0 +1,45 @@
+package kac
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_NamespaceInjection(t *testing.T) {
+
+	t.Run("test namespace injection cache", func(t *testing.T) {
+		_ = os.Setenv(keyNamespaceLabel, "ca-injector/enabled")
+		defer func() {
+			_ = os.Unsetenv(keyNamespaceLabel)
+			_ = os.Unsetenv(keyNamespaceTTL)
+			namespaceInjections = newLRUCache()
+		}()
+		ctx := context.Background()
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"ca-injector/enabled": "true"}}}
+		clientSet := fake.NewSimpleClientset(namespace)
+		now := time.Now()
+
+		value, err := namespaceInjection(ctx, clientSet, testConfig(), "team-b", now)
+		assert.NoError(t, err)
+		assert.Equal(t, "true", value)
+		namespace.Labels = nil
+		_, _ = clientSet.CoreV1().Namespaces().Update(ctx, namespace, metav1.UpdateOptions{})
+		value, _ = namespaceInjection(ctx, clientSet, testConfig(), "team-b", now.Add(time.Second))
+		assert.Equal(t, "true", value)
+		value, _ = namespaceInjection(ctx, clientSet, testConfig(), "team-b", now.Add(defaultNamespaceTTL))
+		assert.Equal(t, "", value)
+
+		_ = os.Setenv(keyNamespaceTTL, "0")
+		namespace.Labels = map[string]string{"ca-injector/enabled": "true"}
+		_, _ = clientSet.CoreV1().Namespaces().Update(ctx, namespace, metav1.UpdateOptions{})
+		value, _ = namespaceInjection(ctx, clientSet, testConfig(), "team-b", now.Add(defaultNamespaceTTL))
+		assert.Equal(t, "true", value)
+	})
+
+}
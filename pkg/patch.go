@@ -0,0 +1,158 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	patchOpAdd = "add"
+)
+
+// schedulingPaths are the pod fields deciding where a pod runs, which the
+// injector must never change
+var schedulingPaths = []string{
+	"/spec/affinity",
+	"/spec/nodeName",
+	"/spec/nodeSelector",
+	"/spec/priority",
+	"/spec/priorityClassName",
+	"/spec/runtimeClassName",
+	"/spec/schedulerName",
+	"/spec/tolerations",
+	"/spec/topologySpreadConstraints",
+}
+
+// patchOperation is a single RFC 6902 JSON Patch operation
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// patchBuilder accumulates JSON Patch operations against the admitted
+// object, keeping track of the arrays and maps it has already created so
+// that consecutive additions to a missing field produce a valid patch
+type patchBuilder struct {
+	operations []patchOperation
+	created    map[string]bool
+	// neutral are the operations adding containers sized to keep the pod
+	// qos class, the only containers the injector may add
+	neutral map[string]bool
+}
+
+func newPatchBuilder() *patchBuilder {
+	return &patchBuilder{created: map[string]bool{}, neutral: map[string]bool{}}
+}
+
+// escapeJSONPointer escapes a single JSON Pointer reference token (RFC 6901)
+func escapeJSONPointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// jsonPointer builds a JSON Pointer from unescaped reference tokens
+func jsonPointer(tokens ...string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteString("/")
+		b.WriteString(escapeJSONPointer(token))
+	}
+	return b.String()
+}
+
+func (b *patchBuilder) add(path string, value interface{}) {
+	b.operations = append(b.operations, patchOperation{Op: patchOpAdd, Path: path, Value: value})
+}
+
+// appendItem appends value to the array at path, which currently holds
+// length items. Empty arrays are omitted from serialized objects, so the
+// array is created with the first item when it doesn't exist yet
+func (b *patchBuilder) appendItem(path string, length int, value interface{}) {
+	if length == 0 && !b.created[path] {
+		b.created[path] = true
+		b.add(path, []interface{}{value})
+	} else {
+		b.add(path+"/-", value)
+	}
+}
+
+// appendNeutralContainer appends a container whose resources keep the pod
+// qos class, see qosNeutralResources
+func (b *patchBuilder) appendNeutralContainer(path string, length int, container interface{}) {
+	b.appendItem(path, length, container)
+	b.neutral[b.operations[len(b.operations)-1].Path] = true
+}
+
+// setMapEntry sets key to value on the map at path, creating the map when
+// it doesn't exist yet
+func (b *patchBuilder) setMapEntry(path string, exists bool, key string, value interface{}) {
+	if !exists && !b.created[path] {
+		b.created[path] = true
+		b.add(path, map[string]interface{}{key: value})
+	} else {
+		b.add(path+"/"+escapeJSONPointer(key), value)
+	}
+}
+
+// qosPaths are the pod fields deciding its QoS class. The injector only
+// adds mounts and variables to existing containers, so adding containers
+// not sized for the class or changing resources would silently change it
+var qosPaths = []string{
+	"/spec/containers",
+	"/spec/initContainers",
+	"/spec/ephemeralContainers",
+	"/spec/overhead",
+}
+
+// touchingQOS returns the operation paths that change containers resources
+// or the containers lists themselves, other than neutral containers
+func (b *patchBuilder) touchingQOS() []string {
+	var paths []string
+	for _, path := range b.touching(qosPaths) {
+		if b.neutral[path] {
+			continue
+		}
+		tokens := strings.Split(path, "/")
+		// Only /spec/<list>/<index>/<field>[/...] is allowed, for fields
+		// other than resources
+		if len(tokens) < 5 || tokens[4] == "resources" || tokens[2] == "overhead" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// touching returns the operation paths at or below any of the prefixes
+func (b *patchBuilder) touching(prefixes []string) []string {
+	var paths []string
+	for _, operation := range b.operations {
+		for _, prefix := range prefixes {
+			if operation.Path == prefix || strings.HasPrefix(operation.Path, prefix+"/") {
+				paths = append(paths, operation.Path)
+				break
+			}
+		}
+	}
+	return paths
+}
+
+func (b *patchBuilder) empty() bool {
+	return len(b.operations) == 0
+}
+
+func (b *patchBuilder) encode() ([]byte, error) {
+	if b.empty() {
+		return nil, nil
+	}
+	return json.Marshal(b.operations)
+}
@@ -0,0 +1,76 @@
+package kac
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_JSONPointer(t *testing.T) {
+	assert.Equal(t, "a~0b", escapeJSONPointer("a~b"))
+	assert.Equal(t, "example.com~1ca-injector", escapeJSONPointer("example.com/ca-injector"))
+	assert.Equal(t, "~01", escapeJSONPointer("~1"))
+	assert.Equal(t, "/metadata/annotations/example.com~1ca-injector", jsonPointer("metadata", "annotations", "example.com/ca-injector"))
+	assert.Equal(t, "", jsonPointer())
+}
+
+func Test_PatchBuilder(t *testing.T) {
+
+	t.Run("test empty patch", func(t *testing.T) {
+		encoded, err := newPatchBuilder().encode()
+		assert.NoError(t, err)
+		assert.Nil(t, encoded)
+	})
+
+	t.Run("test append to missing array", func(t *testing.T) {
+		b := newPatchBuilder()
+		b.appendItem("/spec/volumes", 0, "a")
+		b.appendItem("/spec/volumes", 0, "b")
+		encoded, _ := b.encode()
+		assert.JSONEq(t, `[{"op":"add","path":"/spec/volumes","value":["a"]},{"op":"add","path":"/spec/volumes/-","value":"b"}]`, string(encoded))
+	})
+
+	t.Run("test append to existing array", func(t *testing.T) {
+		b := newPatchBuilder()
+		b.appendItem("/spec/volumes", 2, "a")
+		encoded, _ := b.encode()
+		assert.JSONEq(t, `[{"op":"add","path":"/spec/volumes/-","value":"a"}]`, string(encoded))
+	})
+
+	t.Run("test set entries on missing map", func(t *testing.T) {
+		b := newPatchBuilder()
+		b.setMapEntry("/metadata/annotations", false, "example.com/a", "1")
+		b.setMapEntry("/metadata/annotations", false, "example.com/b", "2")
+		encoded, _ := b.encode()
+		assert.JSONEq(t, `[{"op":"add","path":"/metadata/annotations","value":{"example.com/a":"1"}},{"op":"add","path":"/metadata/annotations/example.com~1b","value":"2"}]`, string(encoded))
+	})
+
+	t.Run("test set entry on existing map", func(t *testing.T) {
+		b := newPatchBuilder()
+		b.setMapEntry("/metadata/annotations", true, "example.com/a", "1")
+		encoded, _ := b.encode()
+		assert.JSONEq(t, `[{"op":"add","path":"/metadata/annotations/example.com~1a","value":"1"}]`, string(encoded))
+	})
+
+	t.Run("test paths touching scheduling fields", func(t *testing.T) {
+		b := newPatchBuilder()
+		b.appendItem("/spec/volumes", 0, "a")
+		b.setMapEntry("/spec/nodeSelectorTerms", true, "a", "1")
+		assert.Empty(t, b.touching(schedulingPaths))
+		b.appendItem("/spec/tolerations", 1, "a")
+		b.setMapEntry("/spec/nodeSelector", false, "pool", "gpu")
+		assert.Equal(t, []string{"/spec/tolerations/-", "/spec/nodeSelector"}, b.touching(schedulingPaths))
+	})
+
+	t.Run("test paths touching qos fields", func(t *testing.T) {
+		b := newPatchBuilder()
+		b.appendItem("/spec/containers/0/volumeMounts", 0, "a")
+		b.appendItem("/spec/initContainers/1/env", 2, "a")
+		b.appendNeutralContainer("/spec/initContainers", 2, "trust-store")
+		assert.Empty(t, b.touchingQOS())
+		b.appendItem("/spec/containers", 1, "sidecar")
+		b.setMapEntry("/spec/containers/0/resources/limits", true, "cpu", "1")
+		b.setMapEntry("/spec/overhead", false, "cpu", "1")
+		assert.Equal(t, []string{"/spec/containers/-", "/spec/containers/0/resources/limits/cpu", "/spec/overhead"}, b.touchingQOS())
+	})
+
+}
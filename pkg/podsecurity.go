@@ -0,0 +1,135 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"encoding/json"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	keyPodSecurityCheck = "CA_BUNDLE_POD_SECURITY_CHECK"
+
+	labelPodSecurityEnforce = "pod-security.kubernetes.io/enforce"
+
+	podSecurityBaseline   = "baseline"
+	podSecurityRestricted = "restricted"
+)
+
+// namespacePodSecurityLevel returns the pod security level enforced on the
+// namespace, if any
+func namespacePodSecurityLevel(ctx context.Context, clientSet kubernetes.Interface, namespace string) (string, error) {
+	ns, err := clientSet.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return ns.Labels[labelPodSecurityEnforce], nil
+}
+
+// podSecurityViolations lists the pod fields violating the pod security
+// level. Only the checks relevant to the fields an injection may add are
+// covered, so an empty result does not mean the pod is admitted
+func podSecurityViolations(level string, spec corev1.PodSpec) []string {
+	if level != podSecurityBaseline && level != podSecurityRestricted {
+		return nil
+	}
+	violations := privilegedReasons(spec)
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, container := range containers {
+			for _, port := range container.Ports {
+				if port.HostPort != 0 {
+					violations = append(violations, "hostPort on container "+container.Name)
+				}
+			}
+		}
+	}
+	if level == podSecurityBaseline {
+		return violations
+	}
+
+	for _, volume := range spec.Volumes {
+		if !restrictedVolumeType(volume.VolumeSource) {
+			violations = append(violations, "restricted volume type of volume "+volume.Name)
+		}
+	}
+	podRunAsNonRoot := spec.SecurityContext != nil && spec.SecurityContext.RunAsNonRoot != nil && *spec.SecurityContext.RunAsNonRoot
+	podSeccomp := spec.SecurityContext != nil && spec.SecurityContext.SeccompProfile != nil
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, container := range containers {
+			sc := container.SecurityContext
+			if sc == nil || sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+				violations = append(violations, "privilege escalation allowed on container "+container.Name)
+			}
+			if sc == nil || sc.Capabilities == nil || !containsCapability(sc.Capabilities.Drop, "ALL") {
+				violations = append(violations, "capabilities not dropped on container "+container.Name)
+			}
+			if !podRunAsNonRoot && (sc == nil || sc.RunAsNonRoot == nil || !*sc.RunAsNonRoot) {
+				violations = append(violations, "runAsNonRoot not set on container "+container.Name)
+			}
+			if !podSeccomp && (sc == nil || sc.SeccompProfile == nil) {
+				violations = append(violations, "seccomp profile not set on container "+container.Name)
+			}
+		}
+	}
+	return violations
+}
+
+// restrictedVolumeType tells whether the volume type is allowed by the
+// restricted pod security standard
+func restrictedVolumeType(v corev1.VolumeSource) bool {
+	return v.ConfigMap != nil || v.CSI != nil || v.DownwardAPI != nil || v.EmptyDir != nil ||
+		v.Ephemeral != nil || v.PersistentVolumeClaim != nil || v.Projected != nil || v.Secret != nil
+}
+
+func containsCapability(capabilities []corev1.Capability, value corev1.Capability) bool {
+	for _, capability := range capabilities {
+		if capability == value {
+			return true
+		}
+	}
+	return false
+}
+
+// introducedViolations applies the patch to the pod and returns the pod
+// security violations the patch would introduce
+func introducedViolations(level string, encodedPod []byte, encodedPatch []byte) ([]string, error) {
+	patch, err := jsonpatch.DecodePatch(encodedPatch)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := patch.Apply(encodedPod)
+	if err != nil {
+		return nil, err
+	}
+	var before, after corev1.Pod
+	if err := json.Unmarshal(encodedPod, &before); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patched, &after); err != nil {
+		return nil, err
+	}
+	existing := podSecurityViolations(level, before.Spec)
+	var introduced []string
+	for _, violation := range podSecurityViolations(level, after.Spec) {
+		if !containsString(existing, violation) {
+			introduced = append(introduced, violation)
+		}
+	}
+	return introduced, nil
+}
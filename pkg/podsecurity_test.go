@@ -0,0 +1,129 @@
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func restrictedPodFactory(annotations map[string]string) *corev1.Pod {
+	runAsNonRoot, allowPrivilegeEscalation := true, false
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "restricted", Annotations: annotations},
+		Spec: corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsNonRoot:   &runAsNonRoot,
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			},
+			Containers: []corev1.Container{{
+				Name: "app",
+				SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				},
+			}},
+		},
+	}
+}
+
+func Test_PodSecurity(t *testing.T) {
+
+	name, filename, annotation := os.Getenv(keyConfigMapName), os.Getenv(keyCABundleFilename), os.Getenv(keyCABundleAnnotation)
+	clientSet := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "restricted",
+		Labels: map[string]string{labelPodSecurityEnforce: podSecurityRestricted},
+	}})
+	ctx := WithClientSet(context.Background(), clientSet)
+
+	t.Run("test restricted pod factory", func(t *testing.T) {
+		assert.Empty(t, podSecurityViolations(podSecurityRestricted, restrictedPodFactory(nil).Spec))
+		assert.Len(t, podSecurityViolations(podSecurityRestricted, corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}), 4)
+		assert.Empty(t, podSecurityViolations(podSecurityBaseline, corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}))
+	})
+
+	_ = os.Setenv(keyCABundleProfiles, `{"java": {"mountPath": "/etc/pki/ca.pem", "envVars": ["JAVA_CA_FILE"], "extraFiles": ["java.security"]}}`)
+	_ = os.Setenv(keyCABundleEnvVars, "SSL_CERT_FILE")
+	defer func() {
+		_ = os.Unsetenv(keyCABundleProfiles)
+		_ = os.Unsetenv(keyCABundleEnvVars)
+	}()
+	for name, annotations := range map[string]map[string]string{
+		"volume mount and env vars": {annotation: "true"},
+		"extra files":               {annotation: "true", annotation + extraFilesAnnotationSuffix: "openssl.cnf"},
+		"profile":                   {annotation: "true", annotation + profileAnnotationSuffix: "java"},
+	} {
+		t.Run("test restricted pod stays restricted with "+name, func(t *testing.T) {
+			preview, err := previewMutation(ctx, restrictedPodFactory(annotations))
+			assert.NoError(t, err)
+			assert.NotEqual(t, "[]", string(preview.Patch))
+			var mutated corev1.Pod
+			assert.NoError(t, json.Unmarshal(preview.Pod, &mutated))
+			assert.Empty(t, podSecurityViolations(podSecurityRestricted, mutated.Spec))
+		})
+	}
+
+	t.Run("test injection checked against the namespace level", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(fixtures.Certificate("root", time.Now().AddDate(1, 0, 0)))
+		}))
+		defer server.Close()
+		_ = os.Setenv(keyCABundleURL, server.URL)
+		_ = os.Setenv(keyPodSecurityCheck, "true")
+		defer func() {
+			_ = os.Setenv(keyCABundleURL, caBundleURL)
+			_ = os.Unsetenv(keyPodSecurityCheck)
+		}()
+		review := func(dryRun bool) *admissionv1.AdmissionResponse {
+			pod := restrictedPodFactory(map[string]string{annotation: "true"})
+			pod.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+			encodedPod, _ := json.Marshal(pod)
+			response, err := mutationReviewer(ctx, admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+				Resource:  podsGVR,
+				Namespace: "restricted",
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: encodedPod},
+				DryRun:    &dryRun,
+			}})
+			assert.NoError(t, err)
+			assert.True(t, response.Allowed)
+			return response
+		}
+
+		review(true)
+		_, err := getBundle(ctx, clientSet, "restricted", name)
+		assert.True(t, apierrors.IsNotFound(err))
+
+		patch := review(false).Patch
+		configMap, err := getBundle(ctx, clientSet, "restricted", name)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `[
+			{"op":"add","path":"/spec/volumes","value":[{"name":"`+name+`","configMap":{"name":"`+name+`"}}]},
+			{"op":"add","path":"/spec/containers/0/volumeMounts","value":[{"name":"`+name+`","mountPath":"/etc/ssl/certs/`+filename+`","subPath":"`+filename+`"}]},
+			{"op":"add","path":"/spec/containers/0/env","value":[{"name":"SSL_CERT_FILE","value":"/etc/ssl/certs/`+filename+`"}]},
+			{"op":"add","path":"/metadata/annotations/`+escapeJSONPointer(annotation+hashAnnotationSuffix)+`","value":"`+bundleHash([]byte(configMap.Data[filename]))+`"}
+		]`, string(patch))
+	})
+
+	t.Run("test introduced violations", func(t *testing.T) {
+		encodedPod, _ := json.Marshal(restrictedPodFactory(nil))
+		violations, err := introducedViolations(podSecurityRestricted, encodedPod, []byte(`[{"op":"add","path":"/spec/volumes","value":[{"name":"certs","hostPath":{"path":"/etc/ssl/certs"}}]}]`))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"hostPath volume certs", "restricted volume type of volume certs"}, violations)
+		violations, err = introducedViolations(podSecurityRestricted, encodedPod, []byte(`[{"op":"add","path":"/spec/volumes","value":[{"name":"certs","configMap":{"name":"ca-bundle"}}]}]`))
+		assert.NoError(t, err)
+		assert.Empty(t, violations)
+	})
+
+}
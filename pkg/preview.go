@@ -0,0 +1,80 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"encoding/json"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// previewMutation runs the mutation reviewer as a dry run and applies the
+// resulting patch to the pod, without creating or updating anything
+func previewMutation(ctx context.Context, pod *corev1.Pod) (*PreviewResponse, error) {
+	encoded, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(encoded, &object); err != nil {
+		return nil, err
+	}
+	return previewRawMutation(ctx, object)
+}
+
+// previewRawMutation previews the mutation of a pod given as a generic
+// object, which keeps the fields unknown to the injector
+func previewRawMutation(ctx context.Context, pod map[string]interface{}) (*PreviewResponse, error) {
+
+	pod["apiVersion"], pod["kind"] = podGVK.GroupVersion().String(), podGVK.Kind
+	encodedPod, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	encodedMetadata, _ := json.Marshal(pod["metadata"])
+	var metadata metav1.ObjectMeta
+	_ = json.Unmarshal(encodedMetadata, &metadata)
+
+	dryRun := true
+	response, err := mutationReviewer(ctx, admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("preview"),
+			Resource:  podsGVR,
+			Namespace: metadata.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: encodedPod},
+			DryRun:    &dryRun,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &PreviewResponse{Patch: json.RawMessage("[]"), Pod: encodedPod, Warnings: response.Warnings}
+	if len(response.Patch) > 0 {
+		patch, err := jsonpatch.DecodePatch(response.Patch)
+		if err != nil {
+			return nil, err
+		}
+		if preview.Pod, err = patch.Apply(encodedPod); err != nil {
+			return nil, err
+		}
+		preview.Patch = response.Patch
+	}
+	return preview, nil
+
+}
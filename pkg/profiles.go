@@ -0,0 +1,177 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	keyCABundleProfiles = "CA_BUNDLE_PROFILES"
+
+	profileAnnotationSuffix    = "-profile"
+	mountPathsAnnotationSuffix = "-mount-paths"
+
+	formatJKS   = "jks"
+	derFilename = "ca-bundle.der"
+)
+
+// injectionProfile is a named set of injection settings, letting a
+// namespace declare the defaults of all its annotated pods. The format is
+// the encoding of the mounted bundle, PEM unless set to der or jks
+type injectionProfile struct {
+	Format     string   `json:"format"`
+	MountPath  string   `json:"mountPath"`
+	EnvVars    []string `json:"envVars"`
+	ExtraFiles []string `json:"extraFiles"`
+}
+
+// loadProfiles parses the profiles configured on CA_BUNDLE_PROFILES, a
+// YAML or JSON object keyed by profile name
+func loadProfiles(config Config) (map[string]injectionProfile, error) {
+	profiles := map[string]injectionProfile{}
+	if err := yaml.UnmarshalStrict([]byte(config.Profiles), &profiles); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", keyCABundleProfiles, err)
+	}
+	for name, profile := range profiles {
+		if profile.MountPath != "" && (!path.IsAbs(profile.MountPath) || path.Clean(profile.MountPath) != profile.MountPath) {
+			return nil, fmt.Errorf("ca bundle profile %s has invalid mount path %q", name, profile.MountPath)
+		}
+		switch profile.Format {
+		case "", formatPEM:
+		case formatDER, formatJKS:
+			// Binary files are only held as they are by secrets
+			if config.Target != targetSecret {
+				return nil, fmt.Errorf("ca bundle profile %s format %s requires %s=%s", name, profile.Format, keyCABundleTarget, targetSecret)
+			}
+		default:
+			return nil, fmt.Errorf("ca bundle profile %s has invalid format %q, expected pem, der or jks", name, profile.Format)
+		}
+	}
+	return profiles, nil
+}
+
+// resolveProfile returns the profile named on the pod annotations or,
+// failing that, on the annotations of its namespace. The zero profile is
+// returned when neither names one
+func resolveProfile(ctx context.Context, clientSet kubernetes.Interface, config Config, namespace string, annotations map[string]string) (injectionProfile, error) {
+
+	profiles, err := loadProfiles(config)
+	if err != nil {
+		return injectionProfile{}, err
+	}
+
+	// Namespaces are only looked up when profiles are configured, so
+	// that deployments without them need no access to namespaces
+	profileAnnotation := config.Annotation + profileAnnotationSuffix
+	name, ok := annotations[profileAnnotation]
+	if !ok && len(profiles) > 0 {
+		ns, err := clientSet.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return injectionProfile{}, err
+		} else if err == nil {
+			name = ns.Annotations[profileAnnotation]
+		}
+	}
+	if name == "" {
+		return injectionProfile{}, nil
+	}
+
+	profile, ok := profiles[name]
+	if !ok {
+		return injectionProfile{}, fmt.Errorf("ca bundle profile %s is not configured", name)
+	}
+	return profile, nil
+
+}
+
+// apply returns the settings resolved for a pod, its own annotations
+// taking precedence over the profile
+func (p injectionProfile) apply(config Config, mountPath string, envVars []string, annotations map[string]string) (string, []string, []string) {
+	if p.MountPath != "" {
+		mountPath = p.MountPath
+	}
+	if p.EnvVars != nil {
+		envVars = p.EnvVars
+	}
+	extraFiles := p.ExtraFiles
+	if value, ok := annotations[config.Annotation+extraFilesAnnotationSuffix]; ok {
+		extraFiles = splitList(value)
+	}
+	return mountPath, envVars, extraFiles
+}
+
+// bundleFile returns the key of the bundle object holding the bundle in
+// the profile format, pemFilename holding it as PEM
+func (p injectionProfile) bundleFile(pemFilename string) string {
+	switch p.Format {
+	case formatDER:
+		return derFilename
+	case formatJKS:
+		return truststoreFilename
+	}
+	return pemFilename
+}
+
+// formatBundle encodes the PEM bundle in the profile format
+func (p injectionProfile) formatBundle(bundle []byte) ([]byte, error) {
+	switch p.Format {
+	case formatDER:
+		return derBundle(bundle)
+	case formatJKS:
+		return javaTruststore(bundle)
+	}
+	return bundle, nil
+}
+
+// derBundle encodes the certificates of the bundle as DER, one after the
+// other, as read by tools loading binary certificate bundles
+func derBundle(bundle []byte) ([]byte, error) {
+	certificates, err := parseCertificates(bundle)
+	if err != nil {
+		return nil, err
+	}
+	var der []byte
+	for _, certificate := range certificates {
+		der = append(der, certificate.Raw...)
+	}
+	return der, nil
+}
+
+// mountPaths returns the paths the bundle file is mounted at, the ones
+// listed on the pod mount paths annotation or else the resolved mount path
+// alone. The first path is the one other settings point at
+func mountPaths(config Config, mountPath string, annotations map[string]string) ([]string, error) {
+	value, ok := annotations[config.Annotation+mountPathsAnnotationSuffix]
+	if !ok {
+		return []string{mountPath}, nil
+	}
+	paths := splitList(value)
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no ca bundle mount paths listed")
+	}
+	for i, p := range paths {
+		if !path.IsAbs(p) || path.Clean(p) != p {
+			return nil, fmt.Errorf("invalid ca bundle mount path %q", p)
+		}
+		if containsString(paths[:i], p) {
+			return nil, fmt.Errorf("duplicate ca bundle mount path %q", p)
+		}
+	}
+	return paths, nil
+}
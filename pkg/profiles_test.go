@@ -0,0 +1,125 @@
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_ResolveProfile(t *testing.T) {
+
+	ctx := context.Background()
+	profileAnnotation := os.Getenv(keyCABundleAnnotation) + profileAnnotationSuffix
+	clientSet := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "team-a",
+		Annotations: map[string]string{profileAnnotation: "java"},
+	}})
+
+	_ = os.Setenv(keyCABundleProfiles, `{"java": {"mountPath": "/etc/pki/ca.pem", "envVars": ["JAVA_CA_FILE"]}, "node": {"envVars": ["NODE_EXTRA_CA_CERTS"]}}`)
+	defer func() {
+		_ = os.Unsetenv(keyCABundleProfiles)
+	}()
+
+	t.Run("test namespace default profile", func(t *testing.T) {
+		profile, err := resolveProfile(ctx, clientSet, testConfig(), "team-a", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, injectionProfile{MountPath: "/etc/pki/ca.pem", EnvVars: []string{"JAVA_CA_FILE"}}, profile)
+		profile, err = resolveProfile(ctx, clientSet, testConfig(), "team-b", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, injectionProfile{}, profile)
+	})
+
+	t.Run("test pod annotations override the namespace profile", func(t *testing.T) {
+		profile, err := resolveProfile(ctx, clientSet, testConfig(), "team-a", map[string]string{profileAnnotation: "node"})
+		assert.NoError(t, err)
+		mountPath, envVars, extraFiles := profile.apply(testConfig(), "/etc/ssl/certs/ca.pem", []string{"SSL_CERT_FILE"}, map[string]string{
+			os.Getenv(keyCABundleAnnotation) + extraFilesAnnotationSuffix: "openssl.cnf",
+		})
+		assert.Equal(t, "/etc/ssl/certs/ca.pem", mountPath)
+		assert.Equal(t, []string{"NODE_EXTRA_CA_CERTS"}, envVars)
+		assert.Equal(t, []string{"openssl.cnf"}, extraFiles)
+		profile, err = resolveProfile(ctx, clientSet, testConfig(), "team-a", map[string]string{profileAnnotation: ""})
+		assert.NoError(t, err)
+		assert.Equal(t, injectionProfile{}, profile)
+	})
+
+	t.Run("test unknown or invalid profiles", func(t *testing.T) {
+		_, err := resolveProfile(ctx, clientSet, testConfig(), "team-a", map[string]string{profileAnnotation: "python"})
+		assert.EqualError(t, err, "ca bundle profile python is not configured")
+		_ = os.Setenv(keyCABundleProfiles, `{"java": {"mountPath": "etc/pki/ca.pem"}}`)
+		_, err = resolveProfile(ctx, clientSet, testConfig(), "team-a", nil)
+		assert.EqualError(t, err, `ca bundle profile java has invalid mount path "etc/pki/ca.pem"`)
+		_ = os.Setenv(keyCABundleProfiles, `{"java": {"format": "p12"}}`)
+		_, err = resolveProfile(ctx, clientSet, testConfig(), "team-a", nil)
+		assert.EqualError(t, err, `ca bundle profile java has invalid format "p12", expected pem, der or jks`)
+		_ = os.Setenv(keyCABundleProfiles, `{"java": {"format": "jks"}}`)
+		_, err = resolveProfile(ctx, clientSet, testConfig(), "team-a", nil)
+		assert.EqualError(t, err, "ca bundle profile java format jks requires CA_BUNDLE_TARGET=secret")
+	})
+
+	t.Run("test bundle formats", func(t *testing.T) {
+		bundle := append(fixtures.Certificate("root", time.Now().AddDate(1, 0, 0)), fixtures.Certificate("intermediate", time.Now().AddDate(1, 0, 0))...)
+		certificates, _ := parseCertificates(bundle)
+		der, err := injectionProfile{Format: formatDER}.formatBundle(bundle)
+		assert.NoError(t, err)
+		assert.Equal(t, append(certificates[0].Raw, certificates[1].Raw...), der)
+		jks, err := injectionProfile{Format: formatJKS}.formatBundle(bundle)
+		assert.NoError(t, err)
+		truststore, _ := javaTruststore(bundle)
+		assert.Equal(t, truststore, jks)
+		pem, err := injectionProfile{}.formatBundle(bundle)
+		assert.NoError(t, err)
+		assert.Equal(t, bundle, pem)
+		assert.Equal(t, "ca.pem", injectionProfile{Format: formatPEM}.bundleFile("ca.pem"))
+		assert.Equal(t, derFilename, injectionProfile{Format: formatDER}.bundleFile("ca.pem"))
+		assert.Equal(t, truststoreFilename, injectionProfile{Format: formatJKS}.bundleFile("ca.pem"))
+	})
+
+	t.Run("test mount paths", func(t *testing.T) {
+		annotation := os.Getenv(keyCABundleAnnotation) + mountPathsAnnotationSuffix
+		paths, err := mountPaths(testConfig(), "/etc/ssl/certs/ca.pem", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"/etc/ssl/certs/ca.pem"}, paths)
+		paths, err = mountPaths(testConfig(), "/etc/ssl/certs/ca.pem", map[string]string{annotation: "/etc/pki/tls/certs/ca.pem, /etc/ssl/cert.pem"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"/etc/pki/tls/certs/ca.pem", "/etc/ssl/cert.pem"}, paths)
+		_, err = mountPaths(testConfig(), "/etc/ssl/certs/ca.pem", map[string]string{annotation: "/etc/ssl/cert.pem,etc/pki/ca.pem"})
+		assert.EqualError(t, err, `invalid ca bundle mount path "etc/pki/ca.pem"`)
+		_, err = mountPaths(testConfig(), "/etc/ssl/certs/ca.pem", map[string]string{annotation: "/etc/ssl/cert.pem,/etc/ssl/cert.pem"})
+		assert.EqualError(t, err, `duplicate ca bundle mount path "/etc/ssl/cert.pem"`)
+		_, err = mountPaths(testConfig(), "/etc/ssl/certs/ca.pem", map[string]string{annotation: ""})
+		assert.EqualError(t, err, "no ca bundle mount paths listed")
+	})
+
+	t.Run("test route /mutate with namespace profile", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleProfiles, `{"java": {"mountPath": "/etc/pki/ca.pem", "envVars": ["JAVA_CA_FILE"]}}`)
+		_ = os.Setenv(keyInjectorMode, ModeWebhook)
+		defer func() {
+			_ = os.Unsetenv(keyInjectorMode)
+		}()
+		encodedPod, _ := json.Marshal(corev1.Pod{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-pod",
+				Namespace:   "team-a",
+				Annotations: map[string]string{os.Getenv(keyCABundleAnnotation): "true"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		})
+		ar, _ := admissionReviewFactory(podsGVR, encodedPod)
+		w := fakeRequest(WithClientSet(ctx, clientSet), NewRouter(), http.MethodPost, "/mutate", string(ar))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.Contains(t, patch, `"mountPath":"/etc/pki/ca.pem"`)
+		assert.Contains(t, patch, `{"name":"JAVA_CA_FILE","value":"/etc/pki/ca.pem"}`)
+	})
+
+}
@@ -0,0 +1,187 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	secretItemsAnnotationSuffix     = "-secret-items"
+	projectedVolumeAnnotationSuffix = "-projected-volume"
+
+	// projectedServiceAccount names the service account token volume the
+	// apiserver adds to pods, whose generated name templates can't know
+	projectedServiceAccount    = "serviceaccount"
+	serviceAccountVolumePrefix = "kube-api-access-"
+)
+
+// secretItem is a key of a secret of the pod namespace, projected next
+// to the bundle under the extra files directory
+type secretItem struct {
+	secret string
+	key    string
+}
+
+// parseSecretItems parses the secret/key entries of the secret items
+// annotation. Keys name the projected files, so they must not collide
+// with each other, the bundle or the extra files
+func parseSecretItems(value string, reserved []string) ([]secretItem, error) {
+	var items []secretItem
+	files := map[string]bool{}
+	for _, name := range reserved {
+		files[name] = true
+	}
+	for _, entry := range splitList(value) {
+		secret, key, ok := strings.Cut(entry, "/")
+		if !ok || secret == "" || key != filepath.Base(key) || key == "." || key == ".." {
+			return nil, fmt.Errorf("invalid secret item %q, expected secret/key", entry)
+		}
+		if files[key] {
+			return nil, fmt.Errorf("secret item %q collides with another injected file", entry)
+		}
+		files[key] = true
+		items = append(items, secretItem{secret, key})
+	}
+	return items, nil
+}
+
+// projectedBundleVolumeSource mounts the ca bundle object named name along
+// with the secret items, in a single volume
+func projectedBundleVolumeSource(name string, items []secretItem) corev1.VolumeSource {
+	bundle := corev1.VolumeProjection{ConfigMap: &corev1.ConfigMapProjection{
+		LocalObjectReference: corev1.LocalObjectReference{Name: name},
+	}}
+	if bundleTarget() == targetSecret {
+		bundle = corev1.VolumeProjection{Secret: &corev1.SecretProjection{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+		}}
+	}
+	sources := []corev1.VolumeProjection{bundle}
+	bySecret := map[string]*corev1.SecretProjection{}
+	for _, item := range items {
+		projection, ok := bySecret[item.secret]
+		if !ok {
+			projection = &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: item.secret}}
+			bySecret[item.secret] = projection
+			sources = append(sources, corev1.VolumeProjection{Secret: projection})
+		}
+		projection.Items = append(projection.Items, corev1.KeyToPath{Key: item.key, Path: item.key})
+	}
+	return corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: sources}}
+}
+
+// projectsBundle reports whether the pod's projected volume named
+// volumeName already holds the ca bundle object named name
+func projectsBundle(spec corev1.PodSpec, volumeName string, name string) bool {
+	for _, volume := range spec.Volumes {
+		if volume.Name != volumeName || volume.Projected == nil {
+			continue
+		}
+		for _, source := range volume.Projected.Sources {
+			if source.ConfigMap != nil && source.ConfigMap.Name == name || source.Secret != nil && source.Secret.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// projectedVolumeName resolves the volume named by the projected volume
+// annotation. The serviceaccount value names the service account token
+// volume, found by the kube-api-access- prefix the apiserver gives it, or
+// else by its token source
+func projectedVolumeName(spec corev1.PodSpec, value string) (string, error) {
+	if value != projectedServiceAccount {
+		return value, nil
+	}
+	for _, volume := range spec.Volumes {
+		if volume.Projected != nil && strings.HasPrefix(volume.Name, serviceAccountVolumePrefix) {
+			return volume.Name, nil
+		}
+	}
+	for _, volume := range spec.Volumes {
+		if volume.Projected == nil {
+			continue
+		}
+		for _, source := range volume.Projected.Sources {
+			if source.ServiceAccountToken != nil {
+				return volume.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("pod has no service account token volume")
+}
+
+// projectionTarget returns the index of the pod's projected volume named
+// volumeName, e.g. its service account token volume, and the sources to
+// add to it so that it also holds the bundle files and secret items. The
+// bundle files are listed explicitly so that keys added to the bundle
+// object later can't shadow the files already projected
+func projectionTarget(spec corev1.PodSpec, volumeName string, name string, bundleFiles []string, items []secretItem) (int, []corev1.VolumeProjection, error) {
+	index := -1
+	for i, volume := range spec.Volumes {
+		if volume.Name == volumeName && volume.Projected != nil {
+			index = i
+		}
+	}
+	if index < 0 {
+		return 0, nil, fmt.Errorf("pod has no projected volume %q", volumeName)
+	} else if projectsBundle(spec, volumeName, name) {
+		return index, nil, nil
+	}
+
+	paths := map[string]bool{}
+	for _, source := range spec.Volumes[index].Projected.Sources {
+		switch {
+		case source.ConfigMap != nil:
+			for _, item := range source.ConfigMap.Items {
+				paths[item.Path] = true
+			}
+		case source.Secret != nil:
+			for _, item := range source.Secret.Items {
+				paths[item.Path] = true
+			}
+		case source.DownwardAPI != nil:
+			for _, item := range source.DownwardAPI.Items {
+				paths[item.Path] = true
+			}
+		case source.ServiceAccountToken != nil:
+			paths[source.ServiceAccountToken.Path] = true
+		}
+	}
+
+	files := make([]corev1.KeyToPath, 0, len(bundleFiles))
+	for _, file := range bundleFiles {
+		files = append(files, corev1.KeyToPath{Key: file, Path: file})
+	}
+	sources := projectedBundleVolumeSource(name, items).Projected.Sources
+	if sources[0].ConfigMap != nil {
+		sources[0].ConfigMap.Items = files
+	} else {
+		sources[0].Secret.Items = files
+	}
+	for _, file := range files {
+		if paths[file.Path] {
+			return 0, nil, fmt.Errorf("bundle file %q collides with a file of projected volume %q", file.Path, volumeName)
+		}
+	}
+	for _, item := range items {
+		if paths[item.key] {
+			return 0, nil, fmt.Errorf("secret item %s/%s collides with a file of projected volume %q", item.secret, item.key, volumeName)
+		}
+	}
+	return index, sources, nil
+}
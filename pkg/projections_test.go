@@ -0,0 +1,104 @@
+package kac
+
+import (
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"os"
+	"testing"
+)
+
+func Test_SecretItems(t *testing.T) {
+
+	t.Run("test parse secret items", func(t *testing.T) {
+		items, err := parseSecretItems("client-tls/tls.crt, client-tls/tls.key,mtls/ca.crt", []string{"ca_bundle.pem"})
+		assert.NoError(t, err)
+		assert.Equal(t, []secretItem{{"client-tls", "tls.crt"}, {"client-tls", "tls.key"}, {"mtls", "ca.crt"}}, items)
+	})
+
+	t.Run("test invalid secret items", func(t *testing.T) {
+		for _, value := range []string{"tls.crt", "/tls.crt", "client-tls/../tls.crt", "client-tls/..", "a/tls.crt,b/tls.crt", "a/ca_bundle.pem"} {
+			_, err := parseSecretItems(value, []string{"ca_bundle.pem"})
+			assert.Error(t, err, value)
+		}
+	})
+
+	t.Run("test projected volume", func(t *testing.T) {
+		source := projectedBundleVolumeSource("ca-bundle", []secretItem{{"client-tls", "tls.crt"}, {"mtls", "ca.crt"}, {"client-tls", "tls.key"}})
+		assert.Len(t, source.Projected.Sources, 3)
+		assert.Equal(t, "ca-bundle", source.Projected.Sources[0].ConfigMap.Name)
+		assert.Equal(t, "client-tls", source.Projected.Sources[1].Secret.Name)
+		assert.Len(t, source.Projected.Sources[1].Secret.Items, 2)
+		assert.Equal(t, "ca.crt", source.Projected.Sources[2].Secret.Items[0].Path)
+	})
+
+	t.Run("test projected volume on secret target", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleTarget, targetSecret)
+		defer func() {
+			_ = os.Unsetenv(keyCABundleTarget)
+		}()
+		source := projectedBundleVolumeSource("ca-bundle", []secretItem{{"client-tls", "tls.crt"}})
+		assert.Nil(t, source.Projected.Sources[0].ConfigMap)
+		assert.Equal(t, "ca-bundle", source.Projected.Sources[0].Secret.Name)
+	})
+
+	t.Run("test projection target", func(t *testing.T) {
+		spec := corev1.PodSpec{Volumes: []corev1.Volume{
+			{Name: "data"},
+			{Name: "kube-api-access", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+				{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{Path: "token"}},
+				{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "kube-root-ca.crt"}, Items: []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}}}},
+			}}}},
+		}}
+		index, sources, err := projectionTarget(spec, "kube-api-access", "ca-bundle", []string{"ca_bundle.pem"}, []secretItem{{"client-tls", "tls.crt"}})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, index)
+		assert.Len(t, sources, 2)
+		assert.Equal(t, []corev1.KeyToPath{{Key: "ca_bundle.pem", Path: "ca_bundle.pem"}}, sources[0].ConfigMap.Items)
+
+		_, _, err = projectionTarget(spec, "data", "ca-bundle", []string{"ca_bundle.pem"}, nil)
+		assert.EqualError(t, err, `pod has no projected volume "data"`)
+		_, _, err = projectionTarget(spec, "kube-api-access", "ca-bundle", []string{"ca.crt"}, nil)
+		assert.EqualError(t, err, `bundle file "ca.crt" collides with a file of projected volume "kube-api-access"`)
+		_, _, err = projectionTarget(spec, "kube-api-access", "ca-bundle", []string{"ca_bundle.pem"}, []secretItem{{"client-tls", "token"}})
+		assert.Error(t, err)
+
+		spec.Volumes[1].Projected.Sources = append(spec.Volumes[1].Projected.Sources, sources...)
+		assert.True(t, projectsBundle(spec, "kube-api-access", "ca-bundle"))
+		_, sources, err = projectionTarget(spec, "kube-api-access", "ca-bundle", []string{"ca_bundle.pem"}, nil)
+		assert.NoError(t, err)
+		assert.Empty(t, sources)
+	})
+
+}
+
+func Test_ProjectedVolumeName(t *testing.T) {
+
+	token := corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+		{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{Path: "token"}},
+	}}}
+
+	t.Run("test named volume", func(t *testing.T) {
+		name, err := projectedVolumeName(corev1.PodSpec{}, "certs")
+		assert.NoError(t, err)
+		assert.Equal(t, "certs", name)
+	})
+
+	t.Run("test generated service account volume", func(t *testing.T) {
+		spec := corev1.PodSpec{Volumes: []corev1.Volume{
+			{Name: "tokens", VolumeSource: token},
+			{Name: "kube-api-access-x7k2p", VolumeSource: token},
+		}}
+		name, err := projectedVolumeName(spec, projectedServiceAccount)
+		assert.NoError(t, err)
+		assert.Equal(t, "kube-api-access-x7k2p", name)
+		name, err = projectedVolumeName(corev1.PodSpec{Volumes: spec.Volumes[:1]}, projectedServiceAccount)
+		assert.NoError(t, err)
+		assert.Equal(t, "tokens", name)
+	})
+
+	t.Run("test pod without service account volume", func(t *testing.T) {
+		_, err := projectedVolumeName(corev1.PodSpec{}, projectedServiceAccount)
+		assert.EqualError(t, err, "pod has no service account token volume")
+	})
+
+}
@@ -0,0 +1,96 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	provenanceSourceURL       = "url"
+	provenanceSourceLeader    = "leader"
+	provenanceSourceEmbedded  = "embedded"
+	provenanceSourceSecret    = "secret"
+	provenanceSourceFile      = "file"
+	provenanceSourceConfigMap = "configmap"
+	provenanceSourceMerge     = "merge"
+
+	// signatureNotVerified is reported until bundle signatures are
+	// supported, so auditors don't mistake silence for a verified bundle
+	signatureNotVerified = "not-verified"
+)
+
+var (
+	provenanceMutex sync.Mutex
+	lastProvenance  *BundleProvenance
+)
+
+// BundleProvenance describes where and when the current ca bundle was
+// loaded from
+type BundleProvenance struct {
+	// Source is either url, leader, secret, configmap, file, s3, gs, oci,
+	// vault, clustertrustbundle, trustbundle, merge or embedded
+	Source string `json:"source"`
+	// URI is the requested bundle location
+	URI string `json:"uri,omitempty"`
+	// ResolvedURI is the location that served the bundle after redirects
+	ResolvedURI string `json:"resolvedUri,omitempty"`
+	// Identity is the subject of the certificate presented by the source
+	Identity string `json:"identity,omitempty"`
+	// Agreeing are the sources that served the same bundle, when fetched
+	// from multiple sources
+	Agreeing []string `json:"agreeing,omitempty"`
+	// Merged are the sources whose certificates were merged into the
+	// bundle
+	Merged []string `json:"merged,omitempty"`
+	// FailedOver are the higher priority sources that failed to load
+	// before the one serving the bundle, with CA_BUNDLE_FALLBACK
+	FailedOver []string `json:"failedOver,omitempty"`
+	Format     string   `json:"format"`
+	// ContentSHA256 is the digest of the content as served, before any
+	// format conversion
+	ContentSHA256 string    `json:"contentSha256,omitempty"`
+	SHA256        string    `json:"sha256"`
+	Certificates  int       `json:"certificates"`
+	NotAfter      time.Time `json:"notAfter"`
+	Signature     string    `json:"signature"`
+	LoadedAt      time.Time `json:"loadedAt"`
+}
+
+// recordProvenance keeps the provenance of the last loaded bundle
+func recordProvenance(provenance *BundleProvenance, bundle []byte) {
+	provenance.SHA256 = bundleHash(bundle)
+	provenance.Signature = signatureNotVerified
+	provenance.LoadedAt = time.Now().UTC()
+	if certificates, err := parseCertificates(bundle); err == nil {
+		provenance.Certificates = len(certificates)
+		for _, certificate := range certificates {
+			if provenance.NotAfter.IsZero() || certificate.NotAfter.Before(provenance.NotAfter) {
+				provenance.NotAfter = certificate.NotAfter
+			}
+		}
+	}
+	if !provenance.NotAfter.IsZero() {
+		bundleExpiry.set(float64(provenance.NotAfter.Unix()))
+	}
+	provenanceMutex.Lock()
+	defer provenanceMutex.Unlock()
+	lastProvenance = provenance
+}
+
+// currentProvenance returns the provenance of the last loaded bundle, or
+// nil when no bundle was loaded yet
+func currentProvenance() *BundleProvenance {
+	provenanceMutex.Lock()
+	defer provenanceMutex.Unlock()
+	return lastProvenance
+}
@@ -0,0 +1,124 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProvisionOptions selects the namespaces provisioned with ca bundles
+type ProvisionOptions struct {
+	// Selector is the label selector of the namespaces, every namespace
+	// is provisioned when empty
+	Selector string
+	// DryRun reports the changes without applying them
+	DryRun bool
+}
+
+// ProvisionReport lists the bundle objects provisioned, as namespace/name
+type ProvisionReport struct {
+	Created   []string
+	Refreshed []string
+	Unchanged []string
+	Failed    []string
+}
+
+func (r ProvisionReport) String() string {
+	return fmt.Sprintf("created=%d refreshed=%d unchanged=%d failed=%d",
+		len(r.Created), len(r.Refreshed), len(r.Unchanged), len(r.Failed))
+}
+
+// RunProvision creates the missing bundle objects and refreshes the
+// outdated ones on every namespace matching the selector, ahead of the
+// pods requesting them. Every bundle source is loaded once, up front
+func RunProvision(ctx context.Context, options ProvisionOptions) (*ProvisionReport, error) {
+
+	clientSet, err := getKubernetesClientSet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config, err := currentConfig()
+	if err != nil {
+		return nil, err
+	}
+	sources, err := bundleConfigMaps(config)
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := clientSet.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: options.Selector})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(sources))
+	bundles := map[string][]byte{}
+	for name, url := range sources {
+		if _, ok := bundles[url]; !ok {
+			if bundles[url], err = loadCABundle(withFreshBundle(ctx), url); err != nil {
+				return nil, fmt.Errorf("unable to load ca bundle for %s %s: %w", bundleTarget(), name, err)
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ctx = withLoadedBundles(ctx, bundles)
+
+	caBundleFilename := config.BundleFilename
+	report := &ProvisionReport{}
+	for _, namespace := range namespaces.Items {
+		if namespace.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+		for _, name := range names {
+			ref := namespace.Name + "/" + name
+			bundle, err := withTeamBundles(ctx, clientSet, namespace.Name, bundles[sources[name]])
+			if err != nil {
+				log.Printf("Unable to provision %s %s: %v", bundleTarget(), ref, err)
+				report.Failed = append(report.Failed, ref)
+				continue
+			}
+			configMap, err := getBundle(ctx, clientSet, namespace.Name, name)
+			switch {
+			case apierrors.IsNotFound(err):
+				err = nil
+				if !options.DryRun {
+					_, err = ensureBundle(ctx, clientSet, namespace.Name, name, caBundleFilename, sources[name])
+				}
+				if err == nil {
+					report.Created = append(report.Created, ref)
+				}
+			case err != nil:
+			case configMap.Data[caBundleFilename] == string(bundle) && !missingJavaTruststore(configMap):
+				report.Unchanged = append(report.Unchanged, ref)
+			default:
+				if !options.DryRun {
+					_, err = storeBundle(ctx, clientSet, configMap, bundle)
+				}
+				if err == nil {
+					report.Refreshed = append(report.Refreshed, ref)
+				}
+			}
+			if err != nil {
+				log.Printf("Unable to provision %s %s: %v", bundleTarget(), ref, err)
+				report.Failed = append(report.Failed, ref)
+			}
+		}
+	}
+	return report, nil
+
+}
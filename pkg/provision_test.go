@@ -0,0 +1,74 @@
+package kac
+
+import (
+	"context"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_RunProvision(t *testing.T) {
+
+	ctx := context.Background()
+	bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write(bundle)
+	}))
+	defer server.Close()
+	_ = os.Setenv(keyCABundleURL, server.URL)
+	defer func() {
+		_ = os.Setenv(keyCABundleURL, caBundleURL)
+	}()
+
+	name, filename := os.Getenv(keyConfigMapName), os.Getenv(keyCABundleFilename)
+	payments := map[string]string{"team": "payments"}
+	clientSet := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments-a", Labels: payments}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments-b", Labels: payments}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments-c", Labels: payments}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "search"}},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "payments-b", Labels: map[string]string{labelManagedBy: labelManagedByValue}},
+			Data:       map[string]string{filename: "outdated"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "payments-c", Labels: map[string]string{labelManagedBy: labelManagedByValue}},
+			Data:       map[string]string{filename: string(bundle)},
+		},
+	)
+	ctx = WithClientSet(ctx, clientSet)
+
+	t.Run("test dry run", func(t *testing.T) {
+		report, err := RunProvision(ctx, ProvisionOptions{Selector: "team=payments", DryRun: true})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"payments-a/" + name}, report.Created)
+		assert.Equal(t, []string{"payments-b/" + name}, report.Refreshed)
+		_, err = clientSet.CoreV1().ConfigMaps("payments-a").Get(ctx, name, metav1.GetOptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("test provision selected namespaces", func(t *testing.T) {
+		requests = 0
+		report, err := RunProvision(ctx, ProvisionOptions{Selector: "team=payments"})
+		assert.NoError(t, err)
+		assert.Equal(t, "created=1 refreshed=1 unchanged=1 failed=0", report.String())
+		assert.Equal(t, 1, requests)
+		for _, namespace := range []string{"payments-a", "payments-b"} {
+			configMap, err := clientSet.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, string(bundle), configMap.Data[filename])
+		}
+		_, err = clientSet.CoreV1().ConfigMaps("search").Get(ctx, name, metav1.GetOptions{})
+		assert.Error(t, err)
+	})
+
+}
@@ -0,0 +1,74 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	keyCABundleRateLimit = "CA_BUNDLE_RATE_LIMIT"
+	keyCABundleRateBurst = "CA_BUNDLE_RATE_BURST"
+)
+
+var (
+	upstreamLimiterMutex  sync.Mutex
+	upstreamLimiter       *rate.Limiter
+	upstreamLimiterConfig [2]int
+
+	bundleFetchesThrottled = newMetric(metricTypeCounter, "kac_bundle_fetches_throttled_total", "Number of upstream bundle requests delayed by the rate limit")
+)
+
+// sharedUpstreamLimiter returns the token bucket shared by every request to
+// the upstream bundle source, allowing CA_BUNDLE_RATE_LIMIT requests per
+// minute, or nil when unlimited
+func sharedUpstreamLimiter() *rate.Limiter {
+	perMinute, _ := strconv.Atoi(os.Getenv(keyCABundleRateLimit))
+	if perMinute <= 0 {
+		return nil
+	}
+	burst, _ := strconv.Atoi(os.Getenv(keyCABundleRateBurst))
+	if burst <= 0 {
+		burst = 1
+	}
+	upstreamLimiterMutex.Lock()
+	defer upstreamLimiterMutex.Unlock()
+	if upstreamLimiter == nil || upstreamLimiterConfig != [2]int{perMinute, burst} {
+		upstreamLimiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), burst)
+		upstreamLimiterConfig = [2]int{perMinute, burst}
+	}
+	return upstreamLimiter
+}
+
+// rateLimitedTransport waits for the shared upstream limiter before every
+// request, redirects included. Waiting is bounded by the request context,
+// so admissions give up at their deadline
+type rateLimitedTransport struct {
+	next http.RoundTripper
+}
+
+func (t rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if limiter := sharedUpstreamLimiter(); limiter != nil {
+		if !limiter.Allow() {
+			bundleFetchesThrottled.inc()
+			if err := limiter.Wait(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,242 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	keyRefreshInterval = "CA_BUNDLE_REFRESH_INTERVAL"
+	keyRefreshWorkers  = "CA_BUNDLE_REFRESH_WORKERS"
+
+	defaultRefreshPeriod  = time.Hour
+	defaultRefreshWorkers = 4
+)
+
+var configMapsRefreshed = newMetric(metricTypeCounter, "kac_configmaps_refreshed_total", "Number of managed configmaps updated with a new ca bundle")
+
+// RunBundleRefresh periodically reloads the ca bundle and updates every
+// managed configmap still holding an older bundle, so that a rotated ca
+// reaches the namespaces injected before. With CA_BUNDLE_REFRESH_WINDOW,
+// configmaps are only updated within that daily window. It returns when
+// ctx is done
+func RunBundleRefresh(ctx context.Context) {
+
+	if !featureEnabled(featureRefreshController) {
+		return
+	}
+	interval, _ := time.ParseDuration(os.Getenv(keyRefreshInterval))
+	if interval <= 0 {
+		interval = defaultRefreshPeriod
+	}
+	window, err := parseTimeWindow(os.Getenv(keyRefreshWindow))
+	if err != nil {
+		log.Printf("Unable to start ca bundle refresh: %v", err)
+		return
+	}
+
+	clientSet, err := getKubernetesClientSet(ctx)
+	if err != nil {
+		log.Printf("Unable to start ca bundle refresh: %v", err)
+		return
+	}
+
+	registerReconciler("refresh", interval)
+	defer unregisterReconciler("refresh")
+	for {
+		var refreshed []string
+		var err error
+		if window.contains(time.Now()) {
+			refreshed, err = refreshConfigMaps(ctx, clientSet)
+		}
+		if err != nil {
+			log.Printf("Unable to refresh ca bundle configmaps: %v", err)
+		} else if len(refreshed) > 0 {
+			log.Printf("Refreshed %d ca bundle configmaps", len(refreshed))
+		}
+		recordReconcile("refresh", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+
+}
+
+// refreshConfigMaps updates the managed configmaps holding an outdated
+// bundle, returning the updated ones. Every bundle source is loaded at most
+// once, and a failing source does not hold back the others. Configmaps are
+// updated by CA_BUNDLE_REFRESH_WORKERS concurrent workers, bounded by the
+// apiserver rate limits of the clientset
+func refreshConfigMaps(ctx context.Context, clientSet kubernetes.Interface) ([]string, error) {
+
+	config, err := currentConfig()
+	if err != nil {
+		return nil, err
+	}
+	caBundleFilename := config.BundleFilename
+
+	sources, err := bundleConfigMaps(config)
+	if err != nil {
+		return nil, err
+	}
+
+	configMaps, err := listBundles(ctx, clientSet, metav1.NamespaceAll, labels.SelectorFromSet(labels.Set{labelManagedBy: labelManagedByValue}).String())
+	if err != nil {
+		return nil, err
+	}
+
+	type refreshJob struct {
+		configMap corev1.ConfigMap
+		bundle    []byte
+	}
+	var jobs []refreshJob
+	var failed error
+	bundles := map[string][]byte{}
+	// The hashes of the bundles held or about to be stored, whose stamps
+	// are kept once the others are rotated out
+	current := map[string]bool{}
+	for _, configMap := range configMaps {
+		url, managed := sources[configMap.Name]
+		previous, ok := configMap.Data[caBundleFilename]
+		if !managed || configMap.Labels[labelRevisionOf] != "" || !ok {
+			continue
+		}
+		current[bundleHash([]byte(previous))] = true
+		bundle, loaded := bundles[configMap.Name]
+		if !loaded {
+			if bundle, err = loadCABundle(withFreshBundle(ctx), url); err != nil {
+				log.Printf("Unable to load ca bundle for configmap %s: %v", configMap.Name, err)
+				failed = err
+			}
+			bundles[configMap.Name] = bundle
+		}
+		if bundle == nil {
+			continue
+		}
+		namespaceBundle, err := withTeamBundles(ctx, clientSet, configMap.Namespace, bundle)
+		if err != nil {
+			log.Printf("Unable to load team ca bundles of namespace %s: %v", configMap.Namespace, err)
+			failed = err
+			continue
+		}
+		current[bundleHash(namespaceBundle)] = true
+		if previous == string(namespaceBundle) && !missingJavaTruststore(&configMap) {
+			continue
+		}
+		jobs = append(jobs, refreshJob{configMap, namespaceBundle})
+	}
+	pruneTimestamps(current)
+
+	workers, _ := strconv.Atoi(os.Getenv(keyRefreshWorkers))
+	if workers <= 0 {
+		workers = defaultRefreshWorkers
+	}
+	var refreshedMutex sync.Mutex
+	var refreshed []string
+	forEachParallel(len(jobs), workers, func(i int) {
+		configMap := &jobs[i].configMap
+		stampBundle(ctx, jobs[i].bundle)
+		updated, err := storeBundle(ctx, clientSet, configMap, jobs[i].bundle)
+		if err != nil {
+			log.Printf("Unable to refresh %s %s/%s: %v", bundleTarget(), configMap.Namespace, configMap.Name, err)
+			return
+		}
+		configMapsRefreshed.inc()
+		refreshedMutex.Lock()
+		defer refreshedMutex.Unlock()
+		refreshed = append(refreshed, updated.Namespace+"/"+updated.Name)
+	})
+	sort.Strings(refreshed)
+	return refreshed, failed
+
+}
+
+// storeBundle replaces the bundle held by a bundle object, recording the
+// revision and the update
+func storeBundle(ctx context.Context, clientSet kubernetes.Interface, configMap *corev1.ConfigMap, bundle []byte) (*corev1.ConfigMap, error) {
+
+	if err := checkBundleValidity(bundle, time.Now()); err != nil {
+		return nil, err
+	}
+	config, _ := currentConfig()
+	caBundleFilename := config.BundleFilename
+	previous := configMap.Data[caBundleFilename]
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[caBundleFilename] = string(bundle)
+	// The bundle is also held in the formats requested by profiles
+	if _, ok := configMap.Data[truststoreFilename]; (ok || javaTruststoreEnabled()) && configMap.Kind == "Secret" {
+		truststore, err := javaTruststore(bundle)
+		if err != nil {
+			return nil, err
+		}
+		configMap.Data[truststoreFilename] = string(truststore)
+	}
+	if _, ok := configMap.Data[derFilename]; ok {
+		der, err := derBundle(bundle)
+		if err != nil {
+			return nil, err
+		}
+		configMap.Data[derFilename] = string(der)
+	}
+	configMap.Annotations = setTimestampAnnotations(configMap.Annotations, bundle)
+	if configMap.Annotations == nil {
+		configMap.Annotations = map[string]string{}
+	}
+	configMap.Annotations[config.Annotation+hashAnnotationSuffix] = bundleHash(bundle)
+	updated, err := updateBundle(ctx, clientSet, configMap)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordRevision(ctx, clientSet, updated, caBundleFilename); err != nil {
+		log.Printf("Unable to record ca bundle revision in namespace %s: %v", updated.Namespace, err)
+	}
+	recordBundleUpdate(ctx, clientSet, updated, []byte(previous), bundle)
+	return updated, nil
+
+}
+
+// forEachParallel calls fn with every index below n on at most workers
+// goroutines, returning once every call returned
+func forEachParallel(n int, workers int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
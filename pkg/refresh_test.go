@@ -0,0 +1,112 @@
+package kac
+
+import (
+	"context"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_RefreshConfigMaps(t *testing.T) {
+
+	ctx := context.Background()
+	bundle := fixtures.Certificate("rotated", time.Now().AddDate(1, 0, 0))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bundle)
+	}))
+	defer server.Close()
+
+	_ = os.Setenv(keyCABundleURL, server.URL)
+	defer func() {
+		_ = os.Setenv(keyCABundleURL, caBundleURL)
+	}()
+
+	name, filename := os.Getenv(keyConfigMapName), os.Getenv(keyCABundleFilename)
+	managed := map[string]string{labelManagedBy: labelManagedByValue}
+	clientSet := fake.NewSimpleClientset(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "outdated", Labels: managed}, Data: map[string]string{filename: "old"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "current", Labels: managed}, Data: map[string]string{filename: string(bundle)}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "unmanaged"}, Data: map[string]string{filename: "old"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: revisionName(name, bundleHash([]byte("old"))), Namespace: "outdated", Labels: map[string]string{
+			labelManagedBy:  labelManagedByValue,
+			labelRevisionOf: name,
+		}}, Data: map[string]string{filename: "old"}},
+	)
+
+	refreshed, err := refreshConfigMaps(ctx, clientSet)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"outdated/" + name}, refreshed)
+
+	configMap, _ := clientSet.CoreV1().ConfigMaps("outdated").Get(ctx, name, metav1.GetOptions{})
+	assert.Equal(t, string(bundle), configMap.Data[filename])
+	assert.Equal(t, bundleHash(bundle), configMap.Annotations[os.Getenv(keyCABundleAnnotation)+hashAnnotationSuffix])
+	configMap, _ = clientSet.CoreV1().ConfigMaps("unmanaged").Get(ctx, name, metav1.GetOptions{})
+	assert.Equal(t, "old", configMap.Data[filename])
+	events, _ := clientSet.CoreV1().Events("outdated").List(ctx, metav1.ListOptions{})
+	assert.Len(t, events.Items, 1)
+
+	refreshed, err = refreshConfigMaps(ctx, clientSet)
+	assert.NoError(t, err)
+	assert.Empty(t, refreshed)
+
+}
+
+func Test_RefreshWorkers(t *testing.T) {
+
+	t.Run("test bounded concurrency", func(t *testing.T) {
+		var running, peak, calls int32
+		forEachParallel(40, 4, func(i int) {
+			current := atomic.AddInt32(&running, 1)
+			for {
+				if seen := atomic.LoadInt32(&peak); current <= seen || atomic.CompareAndSwapInt32(&peak, seen, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&calls, 1)
+		})
+		assert.Equal(t, int32(40), calls)
+		assert.LessOrEqual(t, peak, int32(4))
+		assert.Greater(t, peak, int32(1))
+	})
+
+	t.Run("test every namespace is refreshed", func(t *testing.T) {
+		ctx := context.Background()
+		bundle := fixtures.Certificate("rotated", time.Now().AddDate(1, 0, 0))
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(bundle)
+		}))
+		defer server.Close()
+		_ = os.Setenv(keyCABundleURL, server.URL)
+		_ = os.Setenv(keyRefreshWorkers, "8")
+		defer func() {
+			_ = os.Setenv(keyCABundleURL, caBundleURL)
+			_ = os.Unsetenv(keyRefreshWorkers)
+		}()
+
+		name, filename := os.Getenv(keyConfigMapName), os.Getenv(keyCABundleFilename)
+		clientSet := fake.NewSimpleClientset()
+		var expected []string
+		for _, namespace := range []string{"team-a", "team-b", "team-c", "team-d", "team-e", "team-f", "team-g", "team-h", "team-i", "team-j"} {
+			_, _ = clientSet.CoreV1().ConfigMaps(namespace).Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{labelManagedBy: labelManagedByValue}},
+				Data:       map[string]string{filename: "old"},
+			}, metav1.CreateOptions{})
+			expected = append(expected, namespace+"/"+name)
+		}
+
+		refreshed, err := refreshConfigMaps(ctx, clientSet)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, refreshed)
+	})
+
+}
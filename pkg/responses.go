@@ -0,0 +1,101 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// allowedReviewPlaceholder stands for the uid in the pre-serialized
+// reviews, and plainUID matches the uids spliced in its place without
+// escaping
+const allowedReviewPlaceholder = "00000000-0000-0000-0000-000000000000"
+
+var (
+	plainUID = regexp.MustCompile(`^[0-9A-Za-z-]{1,64}$`)
+
+	// encodeBuffers are reused for every review encoding, as most pods
+	// only need a small review answered
+	encodeBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+	// allowedReviews holds the allowed reviews without patch nor warnings,
+	// by version and media type, split around their uid
+	allowedReviews sync.Map
+)
+
+type allowedReviewKey struct {
+	apiVersion string
+	mediaType  string
+}
+
+// allowedOnly tells whether the response only allows the request, which
+// is the answer to most pods during churn storms
+func allowedOnly(response *admissionv1.AdmissionResponse) bool {
+	return response.Allowed && response.Result == nil && response.Patch == nil && response.PatchType == nil &&
+		len(response.Warnings) == 0 && len(response.AuditAnnotations) == 0
+}
+
+// encodeReview encodes the review with the serializer into a pooled buffer,
+// which the caller releases with releaseBuffer. Allowed reviews in JSON
+// are spliced from the pre-serialized ones instead
+func encodeReview(encoder runtime.SerializerInfo, review *admissionv1.AdmissionReview) (*bytes.Buffer, error) {
+	buffer := encodeBuffers.Get().(*bytes.Buffer)
+	buffer.Reset()
+	if review.Response != nil && allowedOnly(review.Response) && plainUID.MatchString(string(review.Response.UID)) && encoder.MediaType == runtime.ContentTypeJSON {
+		parts, err := allowedReview(encoder, review.APIVersion)
+		if err != nil {
+			releaseBuffer(buffer)
+			return nil, err
+		}
+		buffer.Write(parts[0])
+		buffer.WriteString(string(review.Response.UID))
+		buffer.Write(parts[1])
+		return buffer, nil
+	}
+	if err := encoder.Serializer.Encode(review, buffer); err != nil {
+		releaseBuffer(buffer)
+		return nil, err
+	}
+	return buffer, nil
+}
+
+// allowedReview returns the allowed review of the api version serialized
+// by the encoder, split around its uid
+func allowedReview(encoder runtime.SerializerInfo, apiVersion string) ([2][]byte, error) {
+	key := allowedReviewKey{apiVersion, encoder.MediaType}
+	if parts, ok := allowedReviews.Load(key); ok {
+		return parts.([2][]byte), nil
+	}
+	review := &admissionv1.AdmissionReview{Response: &admissionv1.AdmissionResponse{UID: types.UID(allowedReviewPlaceholder), Allowed: true}}
+	review.APIVersion, review.Kind = apiVersion, "AdmissionReview"
+	var encoded bytes.Buffer
+	if err := encoder.Serializer.Encode(review, &encoded); err != nil {
+		return [2][]byte{}, err
+	}
+	prefix, suffix, _ := bytes.Cut(encoded.Bytes(), []byte(allowedReviewPlaceholder))
+	parts := [2][]byte{prefix, suffix}
+	allowedReviews.Store(key, parts)
+	return parts, nil
+}
+
+// releaseBuffer returns an encoding buffer to the pool, unless a large
+// review grew it beyond what is worth keeping
+func releaseBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() <= 64<<10 {
+		encodeBuffers.Put(buffer)
+	}
+}
@@ -0,0 +1,41 @@
+package kac
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"testing"
+)
+
+func Test_EncodeReview(t *testing.T) {
+
+	review := func(uid string, warnings ...string) *admissionv1.AdmissionReview {
+		r := &admissionv1.AdmissionReview{Response: &admissionv1.AdmissionResponse{UID: types.UID(uid), Allowed: true, Warnings: warnings}}
+		r.APIVersion, r.Kind = "admission.k8s.io/v1", "AdmissionReview"
+		return r
+	}
+	serialized := func(encoder runtime.SerializerInfo, r *admissionv1.AdmissionReview) []byte {
+		var b bytes.Buffer
+		_ = encoder.Serializer.Encode(r, &b)
+		return b.Bytes()
+	}
+
+	for _, mediaType := range []string{runtime.ContentTypeJSON, runtime.ContentTypeYAML} {
+		encoder := responseSerializer(mediaType, "")
+		for _, r := range []*admissionv1.AdmissionReview{
+			review("705ab4f5-6393-11e8-b7cc-42010a800002"),
+			review(`quoted"uid`),
+			review("705ab4f5-6393-11e8-b7cc-42010a800002", "ca bundle expires soon"),
+		} {
+			t.Run("test "+mediaType+" review "+string(r.Response.UID), func(t *testing.T) {
+				encoded, err := encodeReview(encoder, r)
+				assert.NoError(t, err)
+				assert.Equal(t, string(serialized(encoder, r)), encoded.String())
+				releaseBuffer(encoded)
+			})
+		}
+	}
+
+}
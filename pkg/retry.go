@@ -0,0 +1,106 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	keyCABundleFetchAttempts = "CA_BUNDLE_FETCH_ATTEMPTS"
+	keyCABundleFetchBackoff  = "CA_BUNDLE_FETCH_BACKOFF"
+	keyCABundleFetchTimeout  = "CA_BUNDLE_FETCH_TIMEOUT"
+
+	defaultFetchAttempts = 3
+	defaultFetchBackoff  = 200 * time.Millisecond
+	defaultFetchTimeout  = 5 * time.Second
+)
+
+var bundleFetchRetries = newMetric(metricTypeCounter, "kac_bundle_fetch_retries_total", "Number of bundle downloads retried after a transient failure")
+
+// upstreamStatusError reports an unexpected http status from the bundle
+// source
+type upstreamStatusError struct {
+	url  string
+	code int
+	// challenge is the WWW-Authenticate header of the response
+	challenge string
+}
+
+func (e upstreamStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s from %s", e.code, http.StatusText(e.code), e.url)
+}
+
+// retryPolicy bounds the attempts to download the bundle. The backoff
+// doubles after every failed attempt
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+	timeout  time.Duration
+}
+
+// fetchRetryPolicy reads the retry policy from the environment
+func fetchRetryPolicy() retryPolicy {
+	policy := retryPolicy{attempts: defaultFetchAttempts, backoff: defaultFetchBackoff, timeout: defaultFetchTimeout}
+	if value, err := strconv.Atoi(os.Getenv(keyCABundleFetchAttempts)); err == nil && value > 0 {
+		policy.attempts = value
+	}
+	if value, err := time.ParseDuration(os.Getenv(keyCABundleFetchBackoff)); err == nil {
+		policy.backoff = value
+	}
+	if value, err := time.ParseDuration(os.Getenv(keyCABundleFetchTimeout)); err == nil {
+		policy.timeout = value
+	}
+	return policy
+}
+
+// retryable tells whether a failed download may succeed when repeated.
+// Invalid bundles and client errors other than throttling are final
+func retryable(err error) bool {
+	var statusErr upstreamStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= http.StatusInternalServerError || statusErr.code == http.StatusTooManyRequests
+	}
+	return !errors.As(err, &invalidBundleError{})
+}
+
+// fetchBundle downloads the ca bundle from url, retrying transient
+// failures within the bounds of the retry policy and of ctx
+func fetchBundle(ctx context.Context, client *http.Client, url string) ([]byte, *BundleProvenance, error) {
+	policy := fetchRetryPolicy()
+	backoff := policy.backoff
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if policy.timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, policy.timeout)
+		}
+		bundle, provenance, err := fetchBundleOnce(attemptCtx, client, url)
+		cancel()
+		if err == nil || attempt >= policy.attempts || !retryable(err) || ctx.Err() != nil {
+			return bundle, provenance, err
+		}
+		log.Printf("Unable to fetch ca bundle from %s, attempt %d of %d: %v", url, attempt, policy.attempts, err)
+		select {
+		case <-ctx.Done():
+			return nil, nil, err
+		case <-time.After(backoff):
+		}
+		bundleFetchRetries.inc()
+		backoff *= 2
+	}
+}
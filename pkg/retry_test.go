@@ -0,0 +1,58 @@
+package kac
+
+import (
+	"context"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_FetchBundleRetry(t *testing.T) {
+
+	bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	var requests, failures int
+	var status int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write(bundle)
+	}))
+	defer server.Close()
+
+	_ = os.Setenv(keyCABundleFetchBackoff, "1ms")
+	defer func() {
+		_ = os.Unsetenv(keyCABundleFetchBackoff)
+	}()
+
+	t.Run("test retry transient failures", func(t *testing.T) {
+		requests, failures, status = 0, 2, http.StatusBadGateway
+		retries := bundleFetchRetries.get()
+		fetched, _, err := fetchBundle(context.Background(), http.DefaultClient, server.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, bundle, fetched)
+		assert.Equal(t, 3, requests)
+		assert.Equal(t, retries+2, bundleFetchRetries.get())
+	})
+
+	t.Run("test give up after the last attempt", func(t *testing.T) {
+		requests, failures, status = 0, 5, http.StatusServiceUnavailable
+		_, _, err := fetchBundle(context.Background(), http.DefaultClient, server.URL)
+		assert.EqualError(t, err, "unexpected status 503 Service Unavailable from "+server.URL)
+		assert.Equal(t, defaultFetchAttempts, requests)
+	})
+
+	t.Run("test final failures are not retried", func(t *testing.T) {
+		requests, failures, status = 0, 5, http.StatusNotFound
+		_, _, err := fetchBundle(context.Background(), http.DefaultClient, server.URL)
+		assert.Error(t, err)
+		assert.Equal(t, 1, requests)
+	})
+
+}
@@ -12,19 +12,22 @@ package kac
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"log"
 	"net/http"
-	"os"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-
-	"github.com/wI2L/jsondiff"
+	"k8s.io/client-go/kubernetes"
 )
 
 const (
@@ -32,108 +35,734 @@ const (
 	keyConfigMapName      = "CA_BUNDLE_CONFIGMAP"
 	keyCABundleFilename   = "CA_BUNDLE_FILENAME"
 	keyCABundleAnnotation = "CA_BUNDLE_ANNOTATION"
+	keyCABundleEnvVars    = "CA_BUNDLE_ENV_VARS"
+	keyInjectSidecars     = "CA_BUNDLE_INJECT_SIDECARS"
+	keyInjectEnvVars      = "CA_BUNDLE_INJECT_ENV_VARS"
+	keyInjectInit         = "CA_BUNDLE_INJECT_INIT_CONTAINERS"
+	keyInjectEphemeral    = "CA_BUNDLE_INJECT_EPHEMERAL_CONTAINERS"
 	keyPodNamespace       = "POD_NAMESPACE"
+	keyInjectorSelector   = "INJECTOR_SELECTOR"
+	keyCABundleExpiryWarn = "CA_BUNDLE_EXPIRY_WARNING"
+	keyCABundleHashLabel  = "CA_BUNDLE_HASH_LABEL"
+	keyPrivilegedPolicy   = "CA_BUNDLE_PRIVILEGED_POLICY"
+	keyMaxPatchSize       = "CA_BUNDLE_MAX_PATCH_SIZE"
+	keyMountConflict      = "CA_BUNDLE_MOUNT_CONFLICT_POLICY"
+
+	skippedAnnotationSuffix     = "-skipped"
+	hashAnnotationSuffix        = "-hash"
+	hashLabelLength             = 16
+	skipReasonMountPathConflict = "mount-path-conflict"
+	skipReasonKnownSidecar      = "known-sidecar"
+	skipReasonNotSelected       = "not-selected"
+	skipReasonExcluded          = "excluded"
+
+	containersAnnotationSuffix        = "-containers"
+	excludeContainersAnnotationSuffix = "-exclude-containers"
+
+	privilegedPolicySkip = "skip"
+	privilegedPolicyWarn = "warn"
+
+	mountConflictPolicyWarn = "warn"
+	mountConflictPolicyDeny = "deny"
+
+	allowDeletionAnnotationSuffix = "-allow-deletion"
+	maxReportedPods               = 5
+
+	subresourceEphemeralContainers = "ephemeralcontainers"
 )
 
 var (
 	podsGVR = metav1.GroupVersionResource{Version: "v1", Resource: "pods"}
 	podGVK  = schema.GroupVersionKind{Version: "v1", Kind: "Pod"}
+
+	configMapsGVR = metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	secretsGVR    = metav1.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+	// knownSidecars are containers added by other mutating webhooks that
+	// manage their own trust and are left alone by default
+	knownSidecars = map[string]bool{
+		"istio-proxy":   true,
+		"linkerd-proxy": true,
+		"vault-agent":   true,
+	}
+
+	// wellKnownEnvVars point the common runtimes at the mounted bundle:
+	// OpenSSL and Go, Node.js and Python requests
+	wellKnownEnvVars = []string{"SSL_CERT_FILE", "NODE_EXTRA_CA_CERTS", "REQUESTS_CA_BUNDLE"}
+
+	// allowedResponse is copied for every request that needs no patch
+	allowedResponse = admissionv1.AdmissionResponse{Allowed: true}
 )
 
 func validationReviewer(ctx context.Context, ar admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
 
+	// Deny deletion of managed bundle objects still mounted by pods
+	if ar.Request.Operation == admissionv1.Delete && (ar.Request.Resource == configMapsGVR || ar.Request.Resource == secretsGVR) {
+		clientSet, err := getKubernetesClientSet(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return bundleDeletionReviewer(ctx, clientSet, ar)
+	}
+
 	pt := admissionv1.PatchTypeJSONPatch
 	return &admissionv1.AdmissionResponse{Allowed: true, PatchType: &pt, Patch: []byte{}}, nil
 
 }
 
+func bundleDeletionReviewer(ctx context.Context, clientSet kubernetes.Interface, ar admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+
+	config, _ := currentConfig()
+	caBundleAnnotation := config.Annotation
+
+	// Deserialize deleted object
+	obj, _, err := deserializer.Decode(ar.Request.OldObject.Raw, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var object metav1.ObjectMeta
+	var kind string
+	switch o := obj.(type) {
+	case *corev1.ConfigMap:
+		object, kind = o.ObjectMeta, targetConfigMap
+	case *corev1.Secret:
+		object, kind = o.ObjectMeta, targetSecret
+	default:
+		return nil, fmt.Errorf("expected v1.ConfigMap or v1.Secret but got: %T", obj)
+	}
+
+	// Only managed objects of the bundle target are protected, and only
+	// until they are explicitly allowed to be deleted
+	if kind != bundleTarget() || object.Labels[labelManagedBy] != labelManagedByValue || object.Labels[labelRevisionOf] != "" ||
+		object.Annotations[caBundleAnnotation+allowDeletionAnnotationSuffix] == "true" {
+		response := allowedResponse
+		return &response, nil
+	}
+
+	pods, err := listPods(ctx, clientSet, ar.Request.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed && mountsBundle(pod.Spec, object.Name) {
+			users = append(users, pod.Name)
+		}
+	}
+	if len(users) == 0 {
+		response := allowedResponse
+		return &response, nil
+	} else if len(users) > maxReportedPods {
+		users = append(users[:maxReportedPods], fmt.Sprintf("and %d more", len(users)-maxReportedPods))
+	}
+
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status: metav1.StatusFailure,
+			Code:   http.StatusForbidden,
+			Reason: metav1.StatusReasonForbidden,
+			Message: fmt.Sprintf("%s %s is mounted by running pods (%s), annotate it with %s=true to allow its deletion",
+				kind, object.Name, strings.Join(users, ", "), caBundleAnnotation+allowDeletionAnnotationSuffix),
+		},
+	}, nil
+
+}
+
+// bundleEnvVars returns the variables set to the bundle path on injected
+// containers: those of CA_BUNDLE_ENV_VARS and, with
+// CA_BUNDLE_INJECT_ENV_VARS, the well-known ones
+func bundleEnvVars(lookup func(string) string) []string {
+	envVars := splitList(lookup(keyCABundleEnvVars))
+	if lookup(keyInjectEnvVars) == "true" {
+		for _, name := range wellKnownEnvVars {
+			if !containsString(envVars, name) {
+				envVars = append(envVars, name)
+			}
+		}
+	}
+	return envVars
+}
+
 func mutationReviewer(ctx context.Context, ar admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+	var decision admissionDecision
+	response, err := reviewPod(ctx, ar, &decision)
+	if response != nil && decision.reason != "" {
+		response.AuditAnnotations = decision.annotations(response.Allowed)
+	}
+	return response, err
+}
 
-	configMapName := os.Getenv(keyConfigMapName)
-	caBundleFilename := os.Getenv(keyCABundleFilename)
-	caBundleAnnotation := os.Getenv(keyCABundleAnnotation)
-	caBundleURL := os.Getenv(keyCABundleURL)
-	currentNamespace := os.Getenv(keyPodNamespace)
+// reviewPod builds the mutation of a pod, recording the decision taken
+// for pods targeted by the injection
+func reviewPod(ctx context.Context, ar admissionv1.AdmissionReview, decision *admissionDecision) (*admissionv1.AdmissionResponse, error) {
 
-	// Deserialize and copy request object
+	config, err := currentConfig()
+	if err != nil {
+		return nil, err
+	}
+	caBundleEnvVars := config.EnvVars
+	dryRun := ar.Request.DryRun != nil && *ar.Request.DryRun
+	readOnly := config.Mode == ModeWebhook || dryRun
+
+	// Deserialize request object
 	obj, err := validateAndDeserialize(ar, podsGVR, podGVK)
 	if err != nil {
 		return nil, err
 	}
 	pod := obj.(*corev1.Pod)
-	newPod := pod.DeepCopy()
 
-	// Inject ca bundle configmap if pods contains annotation
-	if pod.Annotations[caBundleAnnotation] == "true" {
+	// If the pod is in the same namespace as the webhook, the namespace
+	// will be empty and must be manually set
+	namespace := pod.Namespace
+	if namespace == "" {
+		namespace = config.PodNamespace
+	}
+
+	// Answer pods without the injection annotation right away, since
+	// depending on the webhook selectors every pod may be sent here,
+	// unless selected by CA_BUNDLE_POD_SELECTOR or their namespace label
+	trigger := triggerAnnotation
+	injection, annotated := pod.Annotations[config.Annotation]
+	if !annotated {
+		trigger, injection = triggerPodSelector, selectorInjection(config.PodSelector, pod.Labels)
+	}
+	if !annotated && injection == "" && config.NamespaceLabel != "" {
+		clientSet, err := getKubernetesClientSet(ctx)
+		if err != nil {
+			return nil, err
+		}
+		trigger = triggerNamespaceLabel
+		if injection, err = namespaceInjection(ctx, clientSet, config, namespace, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	configMapName, caBundleURL, inject, err := selectBundle(config, injection)
+	if err != nil {
+		response := allowedResponse
+		response.Warnings = []string{"ca bundle is not injected, " + err.Error()}
+		return &response, nil
+	} else if !inject {
+		response := allowedResponse
+		return &response, nil
+	}
+	decision.bundle = configMapName
+	patch := newPatchBuilder()
+
+	// The bundle gets a volume of its own, named after the bundle object,
+	// or is projected into one the pod already has, e.g. its service
+	// account token volume
+	projectedVolume, err := projectedVolumeName(pod.Spec, pod.Annotations[config.Annotation+projectedVolumeAnnotationSuffix])
+	if err != nil {
+		return nil, err
+	}
+	volumeName, err := bundleVolumeName(config, configMapName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if projectedVolume != "" {
+		volumeName = projectedVolume
+	}
+
+	// Updates of workload templates already injected are left alone, so
+	// that applying an unchanged manifest doesn't roll the workload out
+	templateOp, template := templateOperation(ctx)
+	if template && templateOp == admissionv1.Update && hasVolume(pod.Spec, volumeName) {
+		response := allowedResponse
+		return &response, nil
+	}
+
+	// Ephemeral containers are added to running pods through a subresource
+	// that can't change anything else, so they can only mount the volume
+	// injected when the pod was created
+	ephemeralUpdate := ar.Request.SubResource == subresourceEphemeralContainers
+	if ephemeralUpdate && (!config.InjectEphemeral || !hasVolume(pod.Spec, volumeName) || projectedVolume != "" && !projectsBundle(pod.Spec, volumeName, configMapName)) {
+		response := allowedResponse
+		return &response, nil
+	}
 
-		// If the pod is in the same namespace as the webhook, the namespace
-		// will be empty and must be manually set
-		namespace := pod.Namespace
-		if namespace == "" {
-			namespace = currentNamespace
+	// Other updates are re-admissions of created pods, whose volumes and
+	// containers can't change anymore
+	if ar.Request.Operation == admissionv1.Update && !ephemeralUpdate {
+		response := allowedResponse
+		return &response, nil
+	}
+
+	// Never mutate the injector's own pods, which would have to be
+	// admitted by themselves to start
+	if namespace == config.PodNamespace && config.InjectorSelector != nil && config.InjectorSelector.Matches(labels.Set(pod.Labels)) {
+		log.Printf("Refusing to inject ca bundle into injector pod %s/%s", namespace, pod.Name+pod.GenerateName)
+		decision.reason = decisionInjectorPod
+		response := allowedResponse
+		response.Warnings = []string{"ca bundle is not injected into the injector's own pods"}
+		return &response, nil
+	}
+
+	// Privileged system pods may be excluded by policy even if annotated
+	var warnings []string
+	if reasons := privilegedReasons(pod.Spec); len(reasons) > 0 {
+		message := fmt.Sprintf("pod uses %s", strings.Join(reasons, ", "))
+		switch config.PrivilegedPolicy {
+		case privilegedPolicySkip:
+			log.Printf("Refusing to inject ca bundle into privileged pod %s/%s: %s", namespace, pod.Name+pod.GenerateName, message)
+			decision.reason = decisionPrivileged
+			response := allowedResponse
+			response.Warnings = []string{"ca bundle is not injected into privileged pods, " + message}
+			return &response, nil
+		case privilegedPolicyWarn:
+			warnings = append(warnings, "ca bundle injected into privileged pod, "+message)
 		}
+	}
 
-		// Connect to to kubernetes cluster to check if configmap exists
-		clientSet, err := getKubernetesClientSet(ctx)
+	// Connect to to kubernetes cluster to check if configmap exists
+	clientSet, err := getKubernetesClientSet(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bundles injected by other mechanisms conflict with ours, which is
+	// likely during migrations
+	if config.ForeignPolicy == foreignPolicySkip || config.ForeignPolicy == foreignPolicyWarn {
+		found, err := foreignInjections(ctx, clientSet, namespace, pod.Spec)
 		if err != nil {
 			return nil, err
 		}
-		configMap, _ := clientSet.CoreV1().ConfigMaps(fmt.Sprint(namespace)).Get(ctx, configMapName, metav1.GetOptions{})
+		if message := strings.Join(found, ", "); len(found) > 0 && config.ForeignPolicy == foreignPolicySkip {
+			log.Printf("Refusing to inject ca bundle into pod %s/%s, already injected by %s", namespace, pod.Name+pod.GenerateName, message)
+			decision.reason = decisionForeignBundle
+			response := allowedResponse
+			response.Warnings = append(warnings, "ca bundle is not injected into pods mounting another injected bundle, "+message)
+			return &response, nil
+		} else if len(found) > 0 {
+			warnings = append(warnings, "ca bundle injected into pod mounting another injected bundle, "+message)
+		}
+	}
 
-		// Create configmap if not found
-		if configMap == nil || configMap.Name == "" {
-			resp, err := http.Get(caBundleURL)
-			if err != nil {
-				return nil, err
+	// Resolve the profile named by the pod or its namespace
+	profile, err := resolveProfile(ctx, clientSet, config, namespace, pod.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	bundleFile := profile.bundleFile(config.BundleFilename)
+	mountPath, caBundleEnvVars, extraFiles := profile.apply(config, "/etc/ssl/certs/"+bundleFile, caBundleEnvVars, pod.Annotations)
+	bundlePaths, err := mountPaths(config, mountPath, pod.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	mountPath = bundlePaths[0]
+	if !featureEnabled(featureEnvInjection) {
+		caBundleEnvVars = nil
+	}
+
+	// The init-container strategy mounts a trust store built from the
+	// bundle over the whole bundle directory, instead of the bundle file
+	strategy, err := injectionStrategy(config, pod.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	trustStoreDir := ""
+	if strategy == strategyInitContainer && len(bundlePaths) > 1 {
+		return nil, fmt.Errorf("multiple mount paths are not supported with the %s strategy", strategyInitContainer)
+	} else if strategy == strategyInitContainer && bundleFile != config.BundleFilename {
+		return nil, fmt.Errorf("ca bundle format %s is not supported with the %s strategy", profile.Format, strategyInitContainer)
+	}
+	if strategy == strategyInitContainer && (!ephemeralUpdate || hasVolume(pod.Spec, trustStoreVolumeName(volumeName))) {
+		trustStoreDir = path.Dir(mountPath)
+	}
+
+	// Java truststores are binary, which only secrets hold as they are, so
+	// the configuration requires the secret target along with them
+	javaTruststorePath := ""
+	bundleFiles := append([]string{config.BundleFilename}, extraFiles...)
+	if bundleFile != config.BundleFilename && !containsString(bundleFiles, bundleFile) {
+		bundleFiles = append(bundleFiles, bundleFile)
+	}
+	if config.JavaTruststore {
+		javaTruststorePath = path.Join(path.Dir(mountPath), truststoreFilename)
+		if !containsString(bundleFiles, truststoreFilename) {
+			bundleFiles = append(bundleFiles, truststoreFilename)
+		}
+	}
+
+	// Keys of the pod's own secrets are projected along with the bundle
+	secretItems, err := parseSecretItems(pod.Annotations[config.Annotation+secretItemsAnnotationSuffix], bundleFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add Volume to pod, unless it was added on a previous invocation
+	if projectedVolume != "" && !ephemeralUpdate {
+		index, sources, err := projectionTarget(pod.Spec, projectedVolume, configMapName, bundleFiles, secretItems)
+		if err != nil {
+			return nil, err
+		}
+		sourcesPath := jsonPointer("spec", "volumes", strconv.Itoa(index), "projected", "sources")
+		for _, source := range sources {
+			patch.appendItem(sourcesPath, len(pod.Spec.Volumes[index].Projected.Sources), source)
+		}
+	} else if projectedVolume == "" && !hasVolume(pod.Spec, volumeName) {
+		volumeSource := bundleVolumeSource(configMapName)
+		if len(secretItems) > 0 {
+			volumeSource = projectedBundleVolumeSource(configMapName, secretItems)
+		}
+		patch.appendItem("/spec/volumes", len(pod.Spec.Volumes), corev1.Volume{
+			Name:         volumeName,
+			VolumeSource: volumeSource,
+		})
+	}
+	if trustStoreDir != "" && !hasVolume(pod.Spec, trustStoreVolumeName(volumeName)) {
+		patch.appendItem("/spec/volumes", len(pod.Spec.Volumes), corev1.Volume{
+			Name:         trustStoreVolumeName(volumeName),
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+
+	// The bundle file is mounted alone at every path, or the directory of
+	// the trust store
+	var bundleMounts []corev1.VolumeMount
+	for _, p := range bundlePaths {
+		bundleMounts = append(bundleMounts, corev1.VolumeMount{Name: volumeName, MountPath: p, SubPath: bundleFile})
+	}
+	if trustStoreDir != "" {
+		bundleMounts = []corev1.VolumeMount{{Name: trustStoreVolumeName(volumeName), MountPath: trustStoreDir}}
+	}
+
+	// Containers named by the pod annotations are the only ones injected
+	// or the ones left alone, e.g. a service mesh proxy with its own trust
+	selectedContainers := splitList(pod.Annotations[config.Annotation+containersAnnotationSuffix])
+	excludedContainers := splitList(pod.Annotations[config.Annotation+excludeContainersAnnotationSuffix])
+
+	// Add VolumeMounts to pod containers
+	var skipped []string
+	var mounted bool
+	var conflicts []string
+	injectContainers := func(field string, containers []corev1.Container) {
+		for i, container := range containers {
+
+			// The webhook is reinvoked after other mutating webhooks change
+			// the pod, so containers injected before are left as they are
+			if hasVolumeMount(container, bundleMounts[0].Name, bundleMounts[0].MountPath) {
+				continue
 			}
-			body, _ := ioutil.ReadAll(resp.Body)
-			defer func() { _ = resp.Body.Close() }()
-			if !strings.Contains(string(body), "-----BEGIN CERTIFICATE-----") {
-				return nil, fmt.Errorf("invalid ca bundle")
+
+			// Leave alone containers not selected by the pod, sidecars
+			// injected by other webhooks unless selected and containers that
+			// already mount something at the bundle path
+			if containsString(excludedContainers, container.Name) {
+				skipped = append(skipped, container.Name+"="+skipReasonExcluded)
+				continue
 			}
-			if configMap, err = clientSet.CoreV1().ConfigMaps(namespace).Create(ctx, &corev1.ConfigMap{
-				TypeMeta: metav1.TypeMeta{},
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      configMapName,
-					Namespace: namespace,
-				},
-				Data: map[string]string{
-					caBundleFilename: string(body),
+			if len(selectedContainers) > 0 && !containsString(selectedContainers, container.Name) {
+				skipped = append(skipped, container.Name+"="+skipReasonNotSelected)
+				continue
+			}
+			if knownSidecars[container.Name] && !config.InjectSidecars && !containsString(selectedContainers, container.Name) {
+				skipped = append(skipped, container.Name+"="+skipReasonKnownSidecar)
+				continue
+			}
+			if conflicting := conflictingMountPaths(container, bundleMounts); len(conflicting) > 0 {
+				skipped = append(skipped, container.Name+"="+skipReasonMountPathConflict)
+				conflicts = append(conflicts, container.Name+" ("+strings.Join(conflicting, ", ")+")")
+				continue
+			}
+
+			containerPath := jsonPointer("spec", field, strconv.Itoa(i))
+			for _, bundleMount := range bundleMounts {
+				patch.appendItem(containerPath+"/volumeMounts", len(container.VolumeMounts), bundleMount)
+			}
+			mounted = true
+			for _, name := range extraFiles {
+				if !hasMountPath(container, extraFilesMountDir+name) {
+					patch.appendItem(containerPath+"/volumeMounts", len(container.VolumeMounts), corev1.VolumeMount{
+						Name:      volumeName,
+						MountPath: extraFilesMountDir + name,
+						SubPath:   name,
+					})
+				}
+			}
+			if javaTruststorePath != "" && !hasMountPath(container, javaTruststorePath) {
+				patch.appendItem(containerPath+"/volumeMounts", len(container.VolumeMounts), corev1.VolumeMount{
+					Name:      volumeName,
+					MountPath: javaTruststorePath,
+					SubPath:   truststoreFilename,
+				})
+			}
+			for _, item := range secretItems {
+				if !hasMountPath(container, extraFilesMountDir+item.key) {
+					patch.appendItem(containerPath+"/volumeMounts", len(container.VolumeMounts), corev1.VolumeMount{
+						Name:      volumeName,
+						MountPath: extraFilesMountDir + item.key,
+						SubPath:   item.key,
+					})
+				}
+			}
+
+			// Point the JVM at the truststore, unless the container sets its
+			// own options, which are kept
+			if javaTruststorePath != "" && !hasEnvVar(container, javaToolOptions) {
+				patch.appendItem(containerPath+"/env", len(container.Env), corev1.EnvVar{
+					Name:  javaToolOptions,
+					Value: javaToolOptionsValue(javaTruststorePath),
+				})
+			} else if javaTruststorePath != "" {
+				warnings = append(warnings, fmt.Sprintf("container %s sets %s, the java truststore is mounted at %s but not configured", container.Name, javaToolOptions, javaTruststorePath))
+			}
+
+			// Point custom trust file variables at the mounted bundle,
+			// keeping any value already set on the container
+			for _, name := range caBundleEnvVars {
+				if !hasEnvVar(container, name) {
+					patch.appendItem(containerPath+"/env", len(container.Env), corev1.EnvVar{
+						Name:  name,
+						Value: mountPath,
+					})
+				}
+			}
+		}
+	}
+	if !ephemeralUpdate {
+		injectContainers("containers", pod.Spec.Containers)
+		// Init containers running before the trust store is built would
+		// find its directory empty
+		if config.InjectInit && trustStoreDir == "" {
+			injectContainers("initContainers", pod.Spec.InitContainers)
+		}
+		if trustStoreDir != "" && mounted && !hasContainer(pod.Spec.InitContainers, trustStoreContainerName) {
+			patch.appendNeutralContainer("/spec/initContainers", len(pod.Spec.InitContainers),
+				trustStoreContainer(config, pod.Spec, volumeName, mountPath))
+		}
+	}
+	if config.InjectEphemeral {
+		ephemeral := make([]corev1.Container, len(pod.Spec.EphemeralContainers))
+		for i, container := range pod.Spec.EphemeralContainers {
+			ephemeral[i] = corev1.Container(container.EphemeralContainerCommon)
+		}
+		injectContainers("ephemeralContainers", ephemeral)
+	}
+
+	// Containers already mounting something else at the bundle path keep
+	// their mount, and the pod is either admitted without the bundle in
+	// them or denied by policy
+	if len(conflicts) > 0 {
+		message := "containers already mounting something at the bundle path: " + strings.Join(conflicts, ", ")
+		if config.MountConflictPolicy == mountConflictPolicyDeny {
+			log.Printf("Refusing pod %s/%s: %s", namespace, pod.Name+pod.GenerateName, message)
+			decision.reason = skipReasonMountPathConflict
+			return &admissionv1.AdmissionResponse{
+				Allowed: false,
+				Result: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Code:    http.StatusConflict,
+					Reason:  metav1.StatusReasonConflict,
+					Message: fmt.Sprintf("ca bundle can't be injected, %s (%s=%s)", message, keyMountConflict, config.MountConflictPolicy),
 				},
-			}, metav1.CreateOptions{}); err != nil {
+			}, nil
+		}
+		warnings = append(warnings, "ca bundle is not injected into "+message)
+	}
+
+	// Record skipped containers on the pod itself
+	skippedAnnotation := config.Annotation + skippedAnnotationSuffix
+	if len(skipped) > 0 && pod.Annotations[skippedAnnotation] != strings.Join(skipped, ",") && !ephemeralUpdate {
+		patch.setMapEntry("/metadata/annotations", pod.Annotations != nil, skippedAnnotation, strings.Join(skipped, ","))
+	}
+
+	// Leave the pod alone rather than have it rejected when the injection
+	// would break the pod security level enforced on its namespace. It is
+	// decided before the bundle object is written, so that pods left alone
+	// don't leave bundle objects behind; the bundle only adds metadata to
+	// the patch, which pod security doesn't look at
+	if config.PodSecurityCheck && !patch.empty() {
+		level, err := namespacePodSecurityLevel(ctx, clientSet, namespace)
+		if err != nil {
+			return nil, err
+		}
+		encodedPatch, err := patch.encode()
+		if err != nil {
+			return nil, err
+		}
+		violations, err := introducedViolations(level, ar.Request.Object.Raw, encodedPatch)
+		if err != nil {
+			return nil, err
+		}
+		if len(violations) > 0 {
+			log.Printf("Refusing to inject ca bundle into pod %s/%s, %s pod security level violated: %s", namespace, pod.Name+pod.GenerateName, level, strings.Join(violations, ", "))
+			decision.reason = decisionPodSecurity
+			response := allowedResponse
+			response.Warnings = append(warnings, fmt.Sprintf("ca bundle is not injected, it would violate the %s pod security level of the namespace: %s", level, strings.Join(violations, ", ")))
+			return &response, nil
+		}
+	}
+
+	var configMap *corev1.ConfigMap
+	if readOnly {
+		// Dry runs have no side effects, otherwise the controller creates
+		// the configmap and the pod waits for its volume until then
+		configMap, err = getBundle(ctx, clientSet, namespace, configMapName)
+		if apierrors.IsNotFound(err) && dryRun {
+			warnings = append(warnings, fmt.Sprintf("%s %s/%s does not exist yet and would be created", bundleTarget(), namespace, configMapName))
+			configMap = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: configMapName, Namespace: namespace}}
+		} else if apierrors.IsNotFound(err) {
+			warnings = append(warnings, fmt.Sprintf("%s %s/%s is not created yet, the pod starts once the controller creates it", bundleTarget(), namespace, configMapName))
+			configMap = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: configMapName, Namespace: namespace}}
+		} else if err != nil {
+			return nil, err
+		}
+	} else if configMap, err = mountableBundle(ctx, clientSet, namespace, configMapName, config.BundleFilename, caBundleURL); err != nil {
+		if errors.As(err, &degradedError{}) || errors.As(err, &expiringBundleError{}) {
+			log.Printf("Admitting pod %s/%s without ca bundle: %v", namespace, pod.Name+pod.GenerateName, err)
+			degradedAdmissionsTotal.inc()
+			decision.reason = decisionDegraded
+			response := allowedResponse
+			response.Warnings = append(warnings, "ca bundle is not injected, "+err.Error())
+			return &response, nil
+		}
+		if response, ok := bundleErrorResponse(err); ok {
+			log.Printf("Refusing to create %s %s/%s: %v", bundleTarget(), namespace, configMapName, err)
+			decision.reason = decisionBundleError
+			return response, nil
+		}
+		return nil, err
+	}
+
+	// Add the truststore to secrets created before truststores were enabled
+	if missingJavaTruststore(configMap) && !readOnly {
+		if configMap, err = storeBundle(ctx, clientSet, configMap, []byte(configMap.Data[config.BundleFilename])); err != nil {
+			return nil, err
+		}
+	}
+
+	// Add the bundle encoded in the profile format to the bundle object,
+	// kept up to date by refreshes from then on
+	if bundleFile != config.BundleFilename && configMap.Data != nil {
+		formatted, err := profile.formatBundle([]byte(configMap.Data[config.BundleFilename]))
+		if err != nil {
+			return nil, err
+		}
+		if !readOnly {
+			if configMap, err = addExtraFiles(ctx, clientSet, configMap, map[string]string{bundleFile: string(formatted)}); err != nil {
 				return nil, err
 			}
+		} else if configMap.Data[bundleFile] != string(formatted) {
+			warnings = append(warnings, fmt.Sprintf("%s %s/%s doesn't hold the ca bundle as %s yet", bundleTarget(), namespace, configMap.Name, profile.Format))
 		}
+	}
 
-		// Add Volume to new pod
-		newPod.Spec.Volumes = append(newPod.Spec.Volumes, corev1.Volume{
-			Name: configMap.Name,
-			VolumeSource: corev1.VolumeSource{
-				ConfigMap: &corev1.ConfigMapVolumeSource{
-					LocalObjectReference: corev1.LocalObjectReference{
-						Name: configMap.Name,
-					},
-				},
-			},
-		})
+	// Add the companion files requested by the pod to the configmap
+	if len(extraFiles) > 0 && !readOnly {
+		files, err := renderExtraFiles(config.Templates, extraFiles, extraFileData{BundlePath: mountPath, Namespace: namespace})
+		if err != nil {
+			return nil, err
+		}
+		if configMap, err = addExtraFiles(ctx, clientSet, configMap, files); err != nil {
+			return nil, err
+		}
+	}
 
-		// Add VolumeMounts to new pod containers
-		for i := range newPod.Spec.Containers {
-			newPod.Spec.Containers[i].VolumeMounts = append(newPod.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
-				Name:      configMap.Name,
-				MountPath: "/etc/ssl/certs/" + caBundleFilename,
-				SubPath:   caBundleFilename,
-			})
+	if configMap.Data != nil {
+		decision.hash = bundleHash([]byte(configMap.Data[config.BundleFilename]))
+	}
+
+	// Warn about bundle certificates close to expiration, so that teams
+	// see the upcoming rotation in their deploy tooling
+	if config.ExpiryWarning > 0 && configMap.Data != nil {
+		if certificates, err := parseCertificates([]byte(configMap.Data[config.BundleFilename])); err != nil {
+			log.Printf("Unable to parse ca bundle from configmap %s/%s: %v", namespace, configMap.Name, err)
+		} else {
+			warnings = append(warnings, expiryWarnings(certificates, config.ExpiryWarning, time.Now())...)
 		}
+	}
+
+	// Record the injected bundle revision, optionally also as a label
+	// (truncated to fit label values) so pods can be selected by it. Pod
+	// templates are left out, a new revision would roll their workload out
+	if !patch.empty() && configMap.Data != nil && !ephemeralUpdate && !template {
+		hash := bundleHash([]byte(configMap.Data[config.BundleFilename]))
+		hashAnnotation := config.Annotation + hashAnnotationSuffix
+		if pod.Annotations[hashAnnotation] != hash {
+			patch.setMapEntry("/metadata/annotations", pod.Annotations != nil, hashAnnotation, hash)
+		}
+		if config.HashLabel != "" && pod.Labels[config.HashLabel] != hash[:hashLabelLength] {
+			patch.setMapEntry("/metadata/labels", pod.Labels != nil, config.HashLabel, hash[:hashLabelLength])
+		}
+	}
+
+	if patch.empty() {
+		decision.reason = decisionUnchanged
+		response := allowedResponse
+		response.Warnings = warnings
+		return &response, nil
+	}
+	// Injected mounts and variables must not move the pod to other nodes
+	// nor change its qos class, so a patch reaching scheduling or resource
+	// fields is a bug and fails loudly
+	if paths := patch.touching(schedulingPaths); len(paths) > 0 {
+		return nil, fmt.Errorf("refusing to patch pod scheduling fields: %s", strings.Join(paths, ", "))
+	}
+	if paths := patch.touchingQOS(); len(paths) > 0 {
+		return nil, fmt.Errorf("refusing to patch fields deciding the pod qos class: %s", strings.Join(paths, ", "))
+	}
 
+	// The patch is built from the decoded pod, so make sure it keeps the
+	// fields only the apiserver knows about. Decoding the pod twice and
+	// applying the patch costs every admission, so it is opt-in
+	if featureEnabled(featureSkewChecks) {
+		if err := patch.preserves(ar.Request.Object.Raw); err != nil {
+			return nil, err
+		}
 	}
 
 	// Create mutation patch
-	patch, _ := jsondiff.Compare(pod, newPod)
-	encodedPatch, _ := json.Marshal(patch)
+	encodedPatch, err := patch.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse pathological patches, e.g. from misconfigured env var lists,
+	// before they reach the apiserver and etcd
+	if config.MaxPatchSize > 0 && len(encodedPatch) > config.MaxPatchSize {
+		log.Printf("Refusing %d bytes patch for pod %s/%s", len(encodedPatch), namespace, pod.Name+pod.GenerateName)
+		decision.reason = decisionPatchTooLarge
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusRequestEntityTooLarge,
+				Reason:  metav1.StatusReasonRequestEntityTooLarge,
+				Message: fmt.Sprintf("ca bundle injection patch is %d bytes, above the %d bytes limit set by %s", len(encodedPatch), config.MaxPatchSize, keyMaxPatchSize),
+			},
+		}, nil
+	}
+	if !dryRun {
+		mutationsTotal.inc(trigger)
+		recordMutation(namespace, pod)
+	}
 
 	// Return AdmissionReview object with AdmissionResponse
+	decision.reason = decisionInjected
 	pt := admissionv1.PatchTypeJSONPatch
-	return &admissionv1.AdmissionResponse{Allowed: true, PatchType: &pt, Patch: encodedPatch}, nil
+	return &admissionv1.AdmissionResponse{Allowed: true, PatchType: &pt, Patch: encodedPatch, Warnings: warnings}, nil
 
 }
+
+// bundleErrorResponse denies the pod with the reason its ca bundle could
+// not be created, for the errors worth more than an opaque server error
+func bundleErrorResponse(err error) (*admissionv1.AdmissionResponse, bool) {
+	var code int32
+	var nsErr namespaceError
+	if errors.As(err, &invalidBundleError{}) {
+		code = http.StatusBadGateway
+	} else if errors.As(err, &nsErr) {
+		code = nsErr.code
+	} else {
+		return nil, false
+	}
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    code,
+			Message: err.Error(),
+		},
+	}, true
+}
@@ -36,29 +36,81 @@ func NewRouter() *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 	for _, route := range routes {
+		handlers := []gin.HandlerFunc{route.HandlerFunc}
+		if protectedRoutes[route.Name] {
+			handlers = append([]gin.HandlerFunc{requireAuth}, handlers...)
+		}
 		switch route.Method {
 		case http.MethodGet:
-			router.GET(route.Pattern, route.HandlerFunc)
+			router.GET(route.Pattern, handlers...)
 		case http.MethodPost:
-			router.POST(route.Pattern, route.HandlerFunc)
+			router.POST(route.Pattern, handlers...)
 		}
 	}
 	return router
 }
 
 var routes = Routes{
+	{
+		"Bundle",
+		http.MethodGet,
+		"/bundle",
+		Bundle,
+	},
 	{
 		"Health",
 		http.MethodGet,
 		"/health",
 		Health,
 	},
+	{
+		"Metrics",
+		http.MethodGet,
+		"/metrics",
+		Metrics,
+	},
 	{
 		"Mutate",
 		http.MethodPost,
 		"/mutate",
 		Mutate,
 	},
+	{
+		"MutateWorkload",
+		http.MethodPost,
+		"/mutate-workload",
+		MutateWorkload,
+	},
+	{
+		"Preview",
+		http.MethodPost,
+		"/preview",
+		Preview,
+	},
+	{
+		"Provenance",
+		http.MethodGet,
+		"/bundle/provenance",
+		Provenance,
+	},
+	{
+		"Ready",
+		http.MethodGet,
+		"/ready",
+		Ready,
+	},
+	{
+		"Rollback",
+		http.MethodPost,
+		"/rollback",
+		Rollback,
+	},
+	{
+		"Status",
+		http.MethodGet,
+		"/status",
+		Status,
+	},
 	{
 		"Validate",
 		http.MethodPost,
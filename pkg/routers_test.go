@@ -3,17 +3,20 @@ package kac
 import (
 	"context"
 	"encoding/json"
-	"github.com/gin-gonic/gin"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
 	"github.com/stretchr/testify/assert"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 var (
@@ -23,10 +26,6 @@ var (
 			Kind:       "ConfigMap",
 		},
 	}
-	configMapsGVR = metav1.GroupVersionResource{
-		Version:  "v1",
-		Resource: "ConfigMaps",
-	}
 	pod = corev1.Pod{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Pod",
@@ -42,46 +41,289 @@ var (
 			},
 		},
 	}
-	caBundleURL string
 )
 
-func init() {
-	_ = os.Setenv(keyConfigMapName, "ca-bundle")
-	_ = os.Setenv(keyCABundleFilename, "ca_bundle.pem")
-	_ = os.Setenv(keyCABundleAnnotation, "example.com/ca-injector")
-	_ = os.Setenv(keyCABundleURL, "https://curl.se/ca/cacert.pem")
-	_ = os.Setenv(keyPodNamespace, "example")
-	caBundleURL = os.Getenv(keyCABundleURL)
+func Test_HealthcheckRoute(t *testing.T) {
+	router := NewRouter()
+	w := fakeRequest(context.Background(), router, http.MethodGet, "/health", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"status":"ok"}`, w.Body.String())
 }
 
-func admissionReviewFactory(gvr metav1.GroupVersionResource, rawObject []byte) ([]byte, error) {
-	return json.Marshal(admissionv1.AdmissionReview{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "AdmissionReview",
-			APIVersion: "admission.k8s.io/v1",
+func Test_BundleRoute(t *testing.T) {
+
+	bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bundle)
+	}))
+	defer server.Close()
+
+	t.Run("test route /bundle", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleURL, "https://invalid.local")
+		defer func() {
+			_ = os.Setenv(keyCABundleURL, caBundleURL)
+		}()
+		w := fakeRequest(context.Background(), NewRouter(), http.MethodGet, "/bundle", "")
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+	})
+
+	t.Run("test leader certificate pinning", func(t *testing.T) {
+		pinned := []byte("other")
+		client := pinnedClientFor(func() []byte { return pinned })
+		_, err := fetchCABundle(context.Background(), client, server.URL)
+		assert.Error(t, err)
+		pinned = server.Certificate().Raw
+		body, err := fetchCABundle(context.Background(), client, server.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, bundle, body)
+	})
+
+	t.Run("test follower falls back to upstream", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleFetchAttempts, "1")
+		leaderMutex.Lock()
+		leaderIdentity, ownIdentity, leaderClient = "127.0.0.1", "127.0.0.2", pinnedClientFor(func() []byte { return nil })
+		leaderMutex.Unlock()
+		defer func() {
+			_ = os.Unsetenv(keyCABundleFetchAttempts)
+			leaderMutex.Lock()
+			leaderIdentity, ownIdentity, leaderClient = "", "", nil
+			leaderMutex.Unlock()
+		}()
+		body, provenance, err := urlSource{urls: []string{caBundleURL}}.Load(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, bundleFixture, body)
+		assert.Equal(t, provenanceSourceURL, provenance.Source)
+	})
+
+}
+
+func Test_BundleDeletionReviewer(t *testing.T) {
+
+	ctx := context.Background()
+	managedConfigMap := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: "ca-bundle", Namespace: "example", Labels: map[string]string{labelManagedBy: labelManagedByValue}},
+	}
+	mountingPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "example"},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{
+				Name: "ca-bundle",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "ca-bundle"}},
+				},
+			}},
 		},
-		Request: &admissionv1.AdmissionRequest{
-			Resource: gvr,
-			Object: runtime.RawExtension{
-				Raw: rawObject,
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	deletionReview := func(configMap *corev1.ConfigMap) admissionv1.AdmissionReview {
+		encoded, _ := json.Marshal(configMap)
+		return admissionv1.AdmissionReview{TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"}, Request: &admissionv1.AdmissionRequest{
+			Operation: admissionv1.Delete,
+			Resource:  configMapsGVR,
+			Namespace: "example",
+			OldObject: runtime.RawExtension{Raw: encoded},
+		}}
+	}
+
+	t.Run("test deletion of unused configmap", func(t *testing.T) {
+		response, err := bundleDeletionReviewer(ctx, fake.NewSimpleClientset(), deletionReview(managedConfigMap))
+		assert.NoError(t, err)
+		assert.True(t, response.Allowed)
+	})
+
+	t.Run("test deletion of mounted configmap", func(t *testing.T) {
+		response, err := bundleDeletionReviewer(ctx, fake.NewSimpleClientset(mountingPod), deletionReview(managedConfigMap))
+		assert.NoError(t, err)
+		assert.False(t, response.Allowed)
+		assert.Contains(t, response.Result.Message, "(app)")
+	})
+
+	t.Run("test deletion of mounted configmap allowed by annotation", func(t *testing.T) {
+		allowedConfigMap := managedConfigMap.DeepCopy()
+		allowedConfigMap.Annotations = map[string]string{os.Getenv(keyCABundleAnnotation) + allowDeletionAnnotationSuffix: "true"}
+		response, err := bundleDeletionReviewer(ctx, fake.NewSimpleClientset(mountingPod), deletionReview(allowedConfigMap))
+		assert.NoError(t, err)
+		assert.True(t, response.Allowed)
+	})
+
+	t.Run("test route /validate with configmap deletion", func(t *testing.T) {
+		encoded, _ := json.Marshal(deletionReview(managedConfigMap))
+		w := fakeRequest(context.WithValue(ctx, keyFake, true), NewRouter(), http.MethodPost, "/validate", string(encoded))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, admissionResponse(t, w).Allowed)
+	})
+
+}
+
+func Test_PreviewRoute(t *testing.T) {
+
+	clientSet := fake.NewSimpleClientset()
+	ctx := WithClientSet(context.Background(), clientSet)
+	router := NewRouter()
+
+	t.Run("test route /preview with invalid body", func(t *testing.T) {
+		w := fakeRequest(ctx, router, http.MethodPost, "/preview", "{")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("test route /preview with annotated pod", func(t *testing.T) {
+		previewPod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-pod",
+				Namespace:   "team-a",
+				Annotations: map[string]string{os.Getenv(keyCABundleAnnotation): "true"},
 			},
-		},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+		body, _ := json.Marshal(previewPod)
+		mutations := mutationsTotal.get(triggerAnnotation)
+		w := fakeRequest(ctx, router, http.MethodPost, "/preview", string(body))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var preview PreviewResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &preview))
+		assert.Contains(t, string(preview.Patch), "/spec/volumes")
+		var mutated corev1.Pod
+		assert.NoError(t, json.Unmarshal(preview.Pod, &mutated))
+		assert.Len(t, mutated.Spec.Volumes, 1)
+		assert.Equal(t, "/etc/ssl/certs/"+os.Getenv(keyCABundleFilename), mutated.Spec.Containers[0].VolumeMounts[0].MountPath)
+		assert.Len(t, preview.Warnings, 1)
+		configMaps, _ := clientSet.CoreV1().ConfigMaps("team-a").List(context.Background(), metav1.ListOptions{})
+		assert.Empty(t, configMaps.Items)
+		assert.Equal(t, mutations, mutationsTotal.get(triggerAnnotation))
+	})
+
+	t.Run("test route /preview keeps guaranteed qos", func(t *testing.T) {
+		resources := corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+		}
+		guaranteedPod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-pod",
+				Namespace:   "team-a",
+				Annotations: map[string]string{os.Getenv(keyCABundleAnnotation): "true"},
+			},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init", Resources: resources}},
+				Containers:     []corev1.Container{{Name: "app", Resources: resources}, {Name: "istio-proxy", Resources: resources}},
+			},
+		}
+		body, _ := json.Marshal(guaranteedPod)
+		w := fakeRequest(ctx, router, http.MethodPost, "/preview", string(body))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var preview PreviewResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &preview)
+		var mutated corev1.Pod
+		assert.NoError(t, json.Unmarshal(preview.Pod, &mutated))
+		assert.Len(t, mutated.Spec.InitContainers, 1)
+		assert.Len(t, mutated.Spec.Containers, 2)
+		for _, container := range append(mutated.Spec.InitContainers, mutated.Spec.Containers...) {
+			assert.True(t, container.Resources.Requests.Cpu().Equal(*container.Resources.Limits.Cpu()))
+			assert.True(t, container.Resources.Requests.Memory().Equal(*container.Resources.Limits.Memory()))
+		}
+		assert.Nil(t, mutated.Spec.Overhead)
+	})
+
+	t.Run("test route /preview with unannotated pod", func(t *testing.T) {
+		w := fakeRequest(ctx, router, http.MethodPost, "/preview", `{"metadata":{"name":"test-pod"}}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var preview PreviewResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &preview))
+		assert.Equal(t, "[]", string(preview.Patch))
+	})
+
+}
+
+func Test_RollbackRoute(t *testing.T) {
+
+	ctx := context.WithValue(context.Background(), keyFake, true)
+	router := NewRouter()
+
+	t.Run("test route /rollback with invalid body", func(t *testing.T) {
+		w := fakeRequest(ctx, router, http.MethodPost, "/rollback", `{"namespace":"example"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("test route /rollback with missing revision", func(t *testing.T) {
+		w := fakeRequest(ctx, router, http.MethodPost, "/rollback", `{"namespace":"example","revision":"0123456789"}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("test revision history and rollback", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleHistory, "2")
+		defer func() {
+			_ = os.Unsetenv(keyCABundleHistory)
+		}()
+		clientSet := fake.NewSimpleClientset()
+		managedConfigMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "ca-bundle", Namespace: "example"}}
+		for _, bundle := range []string{"first", "second", "third"} {
+			managedConfigMap.Data = map[string]string{"ca_bundle.pem": bundle}
+			assert.NoError(t, recordRevision(ctx, clientSet, managedConfigMap, "ca_bundle.pem"))
+		}
+		revisions, _ := clientSet.CoreV1().ConfigMaps("example").List(ctx, metav1.ListOptions{})
+		assert.Len(t, revisions.Items, 2)
+
+		revision := revisions.Items[0]
+		_, _ = clientSet.CoreV1().ConfigMaps("example").Create(ctx, managedConfigMap, metav1.CreateOptions{})
+		assert.NoError(t, rollbackBundle(ctx, clientSet, "example", "ca-bundle", strings.TrimPrefix(revision.Name, "ca-bundle-")))
+		rolledBack, _ := clientSet.CoreV1().ConfigMaps("example").Get(ctx, "ca-bundle", metav1.GetOptions{})
+		assert.Equal(t, revision.Data["ca_bundle.pem"], rolledBack.Data["ca_bundle.pem"])
 	})
+
 }
 
-func fakeRequest(ctx context.Context, r *gin.Engine, method string, route string, rawBody string) *httptest.ResponseRecorder {
-	w := httptest.NewRecorder()
-	req, _ := http.NewRequest(method, route, strings.NewReader(rawBody))
-	req = req.WithContext(ctx)
-	r.ServeHTTP(w, req)
-	return w
+func Test_LoadTest(t *testing.T) {
+
+	t.Run("test invalid options", func(t *testing.T) {
+		_, err := RunLoadTest(context.Background(), LoadTestOptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("test in-process load test", func(t *testing.T) {
+		report, err := RunLoadTest(context.Background(), LoadTestOptions{Concurrency: 4, Requests: 20, Containers: 3})
+		assert.NoError(t, err)
+		assert.Equal(t, 20, report.Requests)
+		assert.Equal(t, 0, report.Errors)
+		assert.LessOrEqual(t, report.P50, report.Max)
+	})
+
 }
 
-func Test_HealthcheckRoute(t *testing.T) {
+func Test_MetricsRoute(t *testing.T) {
 	router := NewRouter()
-	w := fakeRequest(context.Background(), router, http.MethodGet, "/health", "")
+	mutationsTotal.inc(triggerAnnotation)
+	w := fakeRequest(context.Background(), router, http.MethodGet, "/metrics", "")
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Equal(t, `{"status":"ok"}`, w.Body.String())
+	assert.Contains(t, w.Body.String(), "# TYPE kac_mutations_total counter")
+	assert.Contains(t, w.Body.String(), `kac_mutations_total{trigger="annotation"}`)
+}
+
+func Test_ReviewWithDeadline(t *testing.T) {
+
+	_ = os.Setenv(keyAdmissionDeadline, "10ms")
+	defer func() {
+		_ = os.Unsetenv(keyAdmissionDeadline)
+	}()
+
+	t.Run("test reviewer exceeding deadline", func(t *testing.T) {
+		slowReviewer := func(ctx context.Context, ar admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		exceeded := admissionsDeadlineExceededTotal.get()
+		_, err := reviewWithDeadline(context.Background(), slowReviewer, admissionv1.AdmissionReview{})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, exceeded+1, admissionsDeadlineExceededTotal.get())
+	})
+
+	t.Run("test reviewer within deadline", func(t *testing.T) {
+		within := admissionsWithinDeadlineTotal.get()
+		_, err := reviewWithDeadline(context.Background(), validationReviewer, admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{}})
+		assert.NoError(t, err)
+		assert.Equal(t, within+1, admissionsWithinDeadlineTotal.get())
+	})
+
 }
 
 func Test_ReviewerRoutes(t *testing.T) {
@@ -120,6 +362,19 @@ func Test_ReviewerRoutes(t *testing.T) {
 			w := fakeRequest(ctx, router, http.MethodPost, route, string(encodedConfigMap))
 			assert.Equal(t, http.StatusBadRequest, w.Code)
 		})
+		for name, body := range map[string]string{
+			"missing request": `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`,
+			"null request":    `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":null}`,
+		} {
+			t.Run("test route "+route+" with "+name, func(t *testing.T) {
+				w := fakeRequest(ctx, router, http.MethodPost, route, body)
+				assert.Equal(t, http.StatusBadRequest, w.Code)
+				response := admissionResponse(t, w)
+				assert.False(t, response.Allowed)
+				assert.Equal(t, int32(http.StatusBadRequest), response.Result.Code)
+				assert.Equal(t, "admission review has no request", response.Result.Message)
+			})
+		}
 	}
 
 	t.Run("test route /validate with valid request", func(t *testing.T) {
@@ -167,6 +422,18 @@ func Test_ReviewerRoutes(t *testing.T) {
 	t.Run("test route /mutate with valid request missing annotation", func(t *testing.T) {
 		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arValidRequestNoAnnotationNoNamespace))
 		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, admissionResponse(t, w).Allowed)
+		assert.Empty(t, admissionResponse(t, w).Patch)
+		assert.Empty(t, admissionResponse(t, w).AuditAnnotations)
+	})
+
+	t.Run("test route /mutate with unknown admission review fields", func(t *testing.T) {
+		body := strings.Replace(string(arValidRequestNoAnnotationNoNamespace), `"request":{`, `"request":{"futureField":true,`, 1)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", body)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, admissionResponse(t, w).Allowed)
+		assert.Len(t, admissionResponse(t, w).Warnings, 1)
+		assert.Contains(t, admissionResponse(t, w).Warnings[0], "futureField")
 	})
 
 	t.Run("test route /mutate with valid request missing namespace", func(t *testing.T) {
@@ -181,4 +448,464 @@ func Test_ReviewerRoutes(t *testing.T) {
 		assert.Equal(t, http.StatusOK, w.Code)
 	})
 
+	t.Run("test route /mutate with custom env vars", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleEnvVars, "GRPC_DEFAULT_SSL_ROOTS_FILE_PATH, SSL_CERT_FILE")
+		ctx = context.WithValue(ctx, keyFake, true)
+		defer func() {
+			_ = os.Unsetenv(keyCABundleEnvVars)
+		}()
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arValidRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.Contains(t, patch, "GRPC_DEFAULT_SSL_ROOTS_FILE_PATH")
+		assert.Contains(t, patch, "SSL_CERT_FILE")
+	})
+
+	t.Run("test route /mutate with well-known env vars", func(t *testing.T) {
+		_ = os.Setenv(keyInjectEnvVars, "true")
+		_ = os.Setenv(keyCABundleEnvVars, "SSL_CERT_FILE")
+		ctx = context.WithValue(ctx, keyFake, true)
+		defer func() {
+			_ = os.Unsetenv(keyInjectEnvVars)
+			_ = os.Unsetenv(keyCABundleEnvVars)
+		}()
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arValidRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		response := admissionResponse(t, w)
+		patch := string(response.Patch)
+		assert.Equal(t, decisionInjected, response.AuditAnnotations["decision"])
+		assert.Equal(t, "ca-bundle", response.AuditAnnotations["bundle"])
+		assert.Len(t, response.AuditAnnotations["bundle-hash"], 64)
+		assert.Equal(t, 1, strings.Count(patch, "SSL_CERT_FILE"))
+		assert.Contains(t, patch, "NODE_EXTRA_CA_CERTS")
+		assert.Contains(t, patch, "REQUESTS_CA_BUNDLE")
+	})
+
+	t.Run("test route /mutate with env injection feature gate disabled", func(t *testing.T) {
+		_ = os.Setenv(keyFeatureGates, featureEnvInjection+"=false")
+		_ = os.Setenv(keyCABundleEnvVars, "SSL_CERT_FILE")
+		ctx = context.WithValue(ctx, keyFake, true)
+		defer func() {
+			_ = os.Unsetenv(keyFeatureGates)
+			_ = os.Unsetenv(keyCABundleEnvVars)
+		}()
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arValidRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.Contains(t, patch, "volumeMounts")
+		assert.NotContains(t, patch, "SSL_CERT_FILE")
+	})
+
+	t.Run("test route /mutate with conflicting container mount", func(t *testing.T) {
+		conflictingPod := pod.DeepCopy()
+		conflictingPod.Spec.Containers = append(conflictingPod.Spec.Containers, corev1.Container{
+			Name: "proxy",
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: "certs", MountPath: "/etc/ssl/certs/" + os.Getenv(keyCABundleFilename)},
+			},
+		})
+		encodedConflictingPod, _ := json.Marshal(conflictingPod)
+		arConflictingRequest, _ := admissionReviewFactory(podsGVR, encodedConflictingPod)
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arConflictingRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		response := admissionResponse(t, w)
+		patch := string(response.Patch)
+		assert.Contains(t, patch, "/metadata/annotations/example.com~1ca-injector-skipped")
+		assert.Contains(t, patch, "proxy=mount-path-conflict")
+		assert.NotContains(t, patch, "/spec/containers/1/volumeMounts")
+		assert.Len(t, response.Warnings, 1)
+		assert.Contains(t, response.Warnings[0], "proxy")
+
+		_ = os.Setenv(keyMountConflict, mountConflictPolicyDeny)
+		defer os.Unsetenv(keyMountConflict)
+		w = fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arConflictingRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		response = admissionResponse(t, w)
+		assert.False(t, response.Allowed)
+		assert.Equal(t, int32(http.StatusConflict), response.Result.Code)
+		assert.Equal(t, "denied", response.AuditAnnotations["decision"])
+		assert.Equal(t, skipReasonMountPathConflict, response.AuditAnnotations["reason"])
+		assert.Contains(t, response.Result.Message, "proxy")
+	})
+
+	t.Run("test route /mutate with multiple mount paths", func(t *testing.T) {
+		multiPathPod := pod.DeepCopy()
+		multiPathPod.Annotations[os.Getenv(keyCABundleAnnotation)+mountPathsAnnotationSuffix] = "/etc/ssl/certs/ca.pem, /etc/pki/tls/certs/ca.pem"
+		encodedMultiPathPod, _ := json.Marshal(multiPathPod)
+		arMultiPathRequest, _ := admissionReviewFactory(podsGVR, encodedMultiPathPod)
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arMultiPathRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.Contains(t, patch, `"mountPath":"/etc/ssl/certs/ca.pem"`)
+		assert.Contains(t, patch, `"mountPath":"/etc/pki/tls/certs/ca.pem"`)
+	})
+
+	t.Run("test route /mutate with known sidecar", func(t *testing.T) {
+		sidecarPod := pod.DeepCopy()
+		sidecarPod.Spec.Containers = append(sidecarPod.Spec.Containers, corev1.Container{Name: "linkerd-proxy"})
+		encodedSidecarPod, _ := json.Marshal(sidecarPod)
+		arSidecarRequest, _ := admissionReviewFactory(podsGVR, encodedSidecarPod)
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arSidecarRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.Contains(t, patch, "linkerd-proxy=known-sidecar")
+		assert.NotContains(t, patch, "/spec/containers/1/volumeMounts")
+	})
+
+	t.Run("test route /mutate with selected containers", func(t *testing.T) {
+		selectedPod := pod.DeepCopy()
+		selectedPod.Annotations["example.com/ca-injector-containers"] = "worker, istio-proxy"
+		selectedPod.Spec.Containers = append(selectedPod.Spec.Containers, corev1.Container{Name: "worker"}, corev1.Container{Name: "istio-proxy"})
+		encodedSelectedPod, _ := json.Marshal(selectedPod)
+		arSelectedRequest, _ := admissionReviewFactory(podsGVR, encodedSelectedPod)
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arSelectedRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.Contains(t, patch, "=not-selected")
+		assert.NotContains(t, patch, "/spec/containers/0/volumeMounts")
+		assert.Contains(t, patch, "/spec/containers/1/volumeMounts")
+		assert.Contains(t, patch, "/spec/containers/2/volumeMounts")
+	})
+
+	t.Run("test route /mutate with excluded containers", func(t *testing.T) {
+		excludedPod := pod.DeepCopy()
+		excludedPod.Annotations["example.com/ca-injector-exclude-containers"] = "worker"
+		excludedPod.Spec.Containers = append(excludedPod.Spec.Containers, corev1.Container{Name: "worker"})
+		encodedExcludedPod, _ := json.Marshal(excludedPod)
+		arExcludedRequest, _ := admissionReviewFactory(podsGVR, encodedExcludedPod)
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arExcludedRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.Contains(t, patch, "worker=excluded")
+		assert.Contains(t, patch, "/spec/containers/0/volumeMounts")
+		assert.NotContains(t, patch, "/spec/containers/1/volumeMounts")
+	})
+
+	t.Run("test route /mutate with secret items", func(t *testing.T) {
+		projectedPod := pod.DeepCopy()
+		projectedPod.Annotations["example.com/ca-injector-secret-items"] = "client-tls/tls.crt,client-tls/tls.key"
+		encodedProjectedPod, _ := json.Marshal(projectedPod)
+		arProjectedRequest, _ := admissionReviewFactory(podsGVR, encodedProjectedPod)
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arProjectedRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.Contains(t, patch, `"projected"`)
+		assert.Contains(t, patch, `"mountPath":"/etc/ssl/tls.crt"`)
+		assert.Contains(t, patch, `"mountPath":"/etc/ssl/tls.key"`)
+	})
+
+	t.Run("test route /mutate with volume name template", func(t *testing.T) {
+		_ = os.Setenv(keyVolumeName, "{{ .Name }}.certs")
+		defer func() {
+			_ = os.Unsetenv(keyVolumeName)
+		}()
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arValidRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.Contains(t, patch, `"name":"ca-bundle-certs","configMap":{"name":"ca-bundle"}`)
+		assert.Contains(t, patch, `"name":"ca-bundle-certs","mountPath":"/etc/ssl/certs/ca_bundle.pem"`)
+	})
+
+	t.Run("test route /mutate into existing projected volume", func(t *testing.T) {
+		projectedPod := pod.DeepCopy()
+		projectedPod.Annotations["example.com/ca-injector-projected-volume"] = projectedServiceAccount
+		projectedPod.Spec.Volumes = append(projectedPod.Spec.Volumes, corev1.Volume{
+			Name: "kube-api-access-x7k2p",
+			VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+				{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{Path: "token"}},
+			}}},
+		})
+		encodedProjectedPod, _ := json.Marshal(projectedPod)
+		arProjectedRequest, _ := admissionReviewFactory(podsGVR, encodedProjectedPod)
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arProjectedRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.Contains(t, patch, `"path":"/spec/volumes/0/projected/sources/-"`)
+		assert.NotContains(t, patch, `"path":"/spec/volumes/-"`)
+		assert.Contains(t, patch, `"name":"kube-api-access-x7k2p","mountPath":"/etc/ssl/certs/ca_bundle.pem"`)
+	})
+
+	t.Run("test route /mutate with init container strategy", func(t *testing.T) {
+		_ = os.Setenv(keyTrustStoreImage, "debian:stable-slim")
+		defer func() {
+			_ = os.Unsetenv(keyTrustStoreImage)
+		}()
+		trustStorePod := pod.DeepCopy()
+		trustStorePod.Annotations["example.com/ca-injector-strategy"] = strategyInitContainer
+		encodedTrustStorePod, _ := json.Marshal(trustStorePod)
+		arTrustStoreRequest, _ := admissionReviewFactory(podsGVR, encodedTrustStorePod)
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arTrustStoreRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.Contains(t, patch, `"emptyDir":{}`)
+		assert.Contains(t, patch, `"path":"/spec/initContainers"`)
+		assert.Contains(t, patch, `"name":"`+trustStoreContainerName+`"`)
+		assert.Contains(t, patch, `{"name":"ca-bundle-trust-store","mountPath":"/etc/ssl/certs"}`)
+	})
+
+	t.Run("test route /mutate reinvocation of injected pod", func(t *testing.T) {
+		injectedPod := pod.DeepCopy()
+		injectedPod.Spec.Volumes = []corev1.Volume{{Name: os.Getenv(keyConfigMapName)}}
+		injectedPod.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{
+			{Name: os.Getenv(keyConfigMapName), MountPath: "/etc/ssl/certs/" + os.Getenv(keyCABundleFilename)},
+		}
+		encodedInjectedPod, _ := json.Marshal(injectedPod)
+		arInjectedRequest, _ := admissionReviewFactory(podsGVR, encodedInjectedPod)
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arInjectedRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, admissionResponse(t, w).Patch)
+	})
+
+	t.Run("test route /mutate with manually declared volume", func(t *testing.T) {
+		declaredPod := pod.DeepCopy()
+		declaredPod.Spec.Volumes = []corev1.Volume{{Name: os.Getenv(keyConfigMapName), VolumeSource: bundleVolumeSource(os.Getenv(keyConfigMapName))}}
+		encodedDeclaredPod, _ := json.Marshal(declaredPod)
+		arDeclaredRequest, _ := admissionReviewFactory(podsGVR, encodedDeclaredPod)
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arDeclaredRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.NotContains(t, patch, "/spec/volumes")
+		assert.Contains(t, patch, "/spec/containers/0/volumeMounts")
+	})
+
+	t.Run("test route /mutate with pod update", func(t *testing.T) {
+		var arUpdate admissionv1.AdmissionReview
+		_ = json.Unmarshal(arValidRequest, &arUpdate)
+		arUpdate.Request.Operation = admissionv1.Update
+		arUpdateRequest, _ := json.Marshal(arUpdate)
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arUpdateRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, admissionResponse(t, w).Patch)
+	})
+
+	t.Run("test route /mutate with injector pod", func(t *testing.T) {
+		_ = os.Setenv(keyInjectorSelector, "app=ca-injector")
+		defer func() {
+			_ = os.Unsetenv(keyInjectorSelector)
+		}()
+		injectorPod := pod.DeepCopy()
+		injectorPod.Labels = map[string]string{"app": "ca-injector"}
+		encodedInjectorPod, _ := json.Marshal(injectorPod)
+		arInjectorRequest, _ := admissionReviewFactory(podsGVR, encodedInjectorPod)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arInjectorRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		response := admissionResponse(t, w)
+		assert.Empty(t, response.Patch)
+		assert.NotEmpty(t, response.Warnings)
+	})
+
+	t.Run("test route /mutate with expiring bundle", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleExpiryWarn, "876000h")
+		defer func() {
+			_ = os.Unsetenv(keyCABundleExpiryWarn)
+		}()
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arValidRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEmpty(t, admissionResponse(t, w).Warnings)
+	})
+
+	t.Run("test route /mutate with privileged pod", func(t *testing.T) {
+		privileged := true
+		privilegedPod := pod.DeepCopy()
+		privilegedPod.Spec.HostNetwork = true
+		privilegedPod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{Privileged: &privileged}
+		encodedPrivilegedPod, _ := json.Marshal(privilegedPod)
+		ar, _ := admissionReviewFactory(podsGVR, encodedPrivilegedPod)
+		ctx = context.WithValue(ctx, keyFake, true)
+		defer func() {
+			_ = os.Unsetenv(keyPrivilegedPolicy)
+		}()
+		for policy, patched := range map[string]bool{"": true, privilegedPolicyWarn: true, privilegedPolicySkip: false} {
+			_ = os.Setenv(keyPrivilegedPolicy, policy)
+			w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(ar))
+			assert.Equal(t, http.StatusOK, w.Code)
+			response := admissionResponse(t, w)
+			assert.Equal(t, patched, len(response.Patch) > 0, policy)
+			if policy == "" {
+				assert.Empty(t, response.Warnings)
+			} else {
+				assert.Len(t, response.Warnings, 1)
+				assert.Contains(t, response.Warnings[0], "hostNetwork, privileged container")
+			}
+		}
+	})
+
+	t.Run("test route /mutate with patch above size limit", func(t *testing.T) {
+		_ = os.Setenv(keyMaxPatchSize, "64")
+		defer func() {
+			_ = os.Unsetenv(keyMaxPatchSize)
+		}()
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arValidRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		response := admissionResponse(t, w)
+		assert.False(t, response.Allowed)
+		assert.Empty(t, response.Patch)
+		assert.Equal(t, int32(http.StatusRequestEntityTooLarge), response.Result.Code)
+		assert.Contains(t, response.Result.Message, "above the 64 bytes limit set by "+keyMaxPatchSize)
+	})
+
+	t.Run("test route /mutate keeps scheduling fields", func(t *testing.T) {
+		gpuPod := pod.DeepCopy()
+		gpuPod.Spec.NodeSelector = map[string]string{"pool": "gpu"}
+		gpuPod.Spec.Tolerations = []corev1.Toleration{{Key: "nvidia.com/gpu", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}}
+		encodedGPUPod, _ := json.Marshal(gpuPod)
+		ar, _ := admissionReviewFactory(podsGVR, encodedGPUPod)
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(ar))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.NotEmpty(t, patch)
+		for _, path := range schedulingPaths {
+			assert.NotContains(t, patch, `"path":"`+path)
+		}
+	})
+
+	t.Run("test route /mutate with bundle hash label", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleHashLabel, "kac/bundle-hash")
+		defer func() {
+			_ = os.Unsetenv(keyCABundleHashLabel)
+		}()
+		ctx = context.WithValue(ctx, keyFake, true)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(arValidRequest))
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.Contains(t, patch, "/metadata/annotations/example.com~1ca-injector-hash")
+		assert.Contains(t, patch, `"path":"/metadata/labels","value":{"kac/bundle-hash":`)
+	})
+
+}
+
+func Test_AdmissionSerializers(t *testing.T) {
+
+	encodedPod, _ := json.Marshal(corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "team-a"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	})
+	review := &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:      "705ab4f5-6393-11e8-b7cc-42010a800002",
+			Resource: podsGVR,
+			Object:   runtime.RawExtension{Raw: encodedPod},
+		},
+	}
+
+	for _, mediaType := range []string{runtime.ContentTypeJSON, runtime.ContentTypeYAML, runtime.ContentTypeProtobuf} {
+		t.Run("test route /mutate with "+mediaType, func(t *testing.T) {
+			info, _ := runtime.SerializerInfoForMediaType(codecFactory.SupportedMediaTypes(), mediaType)
+			var body strings.Builder
+			assert.NoError(t, info.Serializer.Encode(review, &body))
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/mutate", strings.NewReader(body.String()))
+			req.Header.Set("Content-Type", mediaType)
+			NewRouter().ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, mediaType, w.Header().Get("Content-Type"))
+			obj, _, err := deserializer.Decode(w.Body.Bytes(), nil, nil)
+			assert.NoError(t, err)
+			response := obj.(*admissionv1.AdmissionReview).Response
+			assert.True(t, response.Allowed)
+			assert.Equal(t, review.Request.UID, response.UID)
+		})
+	}
+
+	t.Run("test accepted media type", func(t *testing.T) {
+		assert.Equal(t, runtime.ContentTypeYAML, responseSerializer("application/foo, application/yaml;q=0.9", runtime.ContentTypeJSON).MediaType)
+		assert.Equal(t, runtime.ContentTypeJSON, responseSerializer("*/*", "").MediaType)
+	})
+
+}
+
+func Test_ContainerKinds(t *testing.T) {
+
+	name, filename := os.Getenv(keyConfigMapName), os.Getenv(keyCABundleFilename)
+	clientSet := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a"},
+		Data:       map[string]string{filename: string(fixtures.Certificate("root", time.Now().AddDate(1, 0, 0)))},
+	})
+	ctx := WithClientSet(context.Background(), clientSet)
+	router := NewRouter()
+	annotatedPod := corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-pod",
+			Namespace:   "team-a",
+			Annotations: map[string]string{os.Getenv(keyCABundleAnnotation): "true"},
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "migrate"}},
+			Containers:     []corev1.Container{{Name: "app"}},
+		},
+	}
+	mutate := func(pod corev1.Pod, subResource string) *admissionv1.AdmissionResponse {
+		encodedPod, _ := json.Marshal(pod)
+		ar, _ := admissionReviewFactory(podsGVR, encodedPod)
+		body := strings.Replace(string(ar), `"request":{`, `"request":{"subResource":"`+subResource+`",`, 1)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", body)
+		assert.Equal(t, http.StatusOK, w.Code)
+		return admissionResponse(t, w)
+	}
+	defer func() {
+		_ = os.Unsetenv(keyInjectInit)
+		_ = os.Unsetenv(keyInjectEphemeral)
+	}()
+
+	t.Run("test init containers are not injected by default", func(t *testing.T) {
+		patch := string(mutate(annotatedPod, "").Patch)
+		assert.Contains(t, patch, "/spec/containers/0/volumeMounts")
+		assert.NotContains(t, patch, "/spec/initContainers")
+	})
+
+	t.Run("test init containers", func(t *testing.T) {
+		_ = os.Setenv(keyInjectInit, "true")
+		patch := string(mutate(annotatedPod, "").Patch)
+		assert.Contains(t, patch, "/spec/containers/0/volumeMounts")
+		assert.Contains(t, patch, "/spec/initContainers/0/volumeMounts")
+	})
+
+	injectedPod := annotatedPod.DeepCopy()
+	injectedPod.Spec.Volumes = []corev1.Volume{{Name: name, VolumeSource: bundleVolumeSource(name)}}
+	injectedPod.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: name, MountPath: "/etc/ssl/certs/" + filename, SubPath: filename}}
+	injectedPod.Spec.EphemeralContainers = []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger"}}}
+
+	t.Run("test ephemeral containers are not injected by default", func(t *testing.T) {
+		response := mutate(*injectedPod, subresourceEphemeralContainers)
+		assert.True(t, response.Allowed)
+		assert.Empty(t, response.Patch)
+	})
+
+	t.Run("test ephemeral containers", func(t *testing.T) {
+		_ = os.Setenv(keyInjectEphemeral, "true")
+		response := mutate(*injectedPod, subresourceEphemeralContainers)
+		assert.True(t, response.Allowed)
+		var operations []map[string]interface{}
+		assert.NoError(t, json.Unmarshal(response.Patch, &operations))
+		assert.Len(t, operations, 1)
+		assert.Equal(t, "/spec/ephemeralContainers/0/volumeMounts", operations[0]["path"])
+	})
+
+	t.Run("test ephemeral containers of pods without bundle", func(t *testing.T) {
+		_ = os.Setenv(keyInjectEphemeral, "true")
+		pod := annotatedPod.DeepCopy()
+		pod.Spec.EphemeralContainers = injectedPod.Spec.EphemeralContainers
+		response := mutate(*pod, subresourceEphemeralContainers)
+		assert.True(t, response.Allowed)
+		assert.Empty(t, response.Patch)
+	})
+
 }
@@ -0,0 +1,123 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	keyServingCertWarning = "WEBHOOK_CERT_EXPIRY_WARNING"
+
+	defaultServingCertWarning = 14 * 24 * time.Hour
+	servingCertCheckPeriod    = time.Minute
+	servingCertWarnPeriod     = time.Hour
+)
+
+var servingCertExpiry = newMetric(metricTypeGauge, "kac_webhook_certificate_expiry_timestamp_seconds", "Unix time the webhook serving certificate expires")
+
+// ServingCertificate serves the webhook certificate, reloading it when
+// its files are rotated, since an expired serving certificate breaks the
+// admission of every pod sent to the webhook
+type ServingCertificate struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	notAfter    time.Time
+	modTime     time.Time
+	warned      time.Time
+}
+
+// NewServingCertificate loads the webhook certificate from its files
+func NewServingCertificate(certFile string, keyFile string) (*ServingCertificate, error) {
+	c := &ServingCertificate{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	c.checkExpiry(time.Now())
+	return c, nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config
+func (c *ServingCertificate) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.certificate, nil
+}
+
+// Run reloads the certificate whenever its file changes and warns as it
+// approaches expiry. It returns when ctx is done
+func (c *ServingCertificate) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(servingCertCheckPeriod):
+		}
+		if info, err := os.Stat(c.certFile); err != nil {
+			log.Printf("Unable to check webhook certificate %s: %v", c.certFile, err)
+		} else if !info.ModTime().Equal(c.modTime) {
+			if err := c.reload(); err != nil {
+				log.Printf("Unable to reload webhook certificate %s: %v", c.certFile, err)
+			} else {
+				log.Printf("Reloaded webhook certificate %s", c.certFile)
+			}
+		}
+		c.checkExpiry(time.Now())
+	}
+}
+
+func (c *ServingCertificate) reload() error {
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.certificate, c.notAfter, c.modTime = &certificate, leaf.NotAfter, info.ModTime()
+	servingCertExpiry.set(float64(leaf.NotAfter.Unix()))
+	return nil
+}
+
+// checkExpiry tells whether the certificate expires within the configured
+// window, logging a warning at most once per warn period
+func (c *ServingCertificate) checkExpiry(now time.Time) bool {
+	window := defaultServingCertWarning
+	if value, err := time.ParseDuration(os.Getenv(keyServingCertWarning)); err == nil {
+		window = value
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.notAfter.Sub(now) > window {
+		return false
+	}
+	if now.Sub(c.warned) >= servingCertWarnPeriod {
+		log.Printf("Webhook certificate %s expires on %s", c.certFile, formatTime(c.notAfter))
+		c.warned = now
+	}
+	return true
+}
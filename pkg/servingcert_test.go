@@ -0,0 +1,70 @@
+package kac
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func servingCertFactory(t *testing.T, dir string, notAfter time.Time) (string, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "ca-injector.ca-injector.svc"},
+		DNSNames:     []string{"ca-injector.ca-injector.svc"},
+		NotBefore:    notAfter.AddDate(-1, 0, 0),
+		NotAfter:     notAfter,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	encodedKey, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: encodedKey}), 0600))
+	return certFile, keyFile
+}
+
+func Test_ServingCertificate(t *testing.T) {
+
+	dir := t.TempDir()
+	notAfter := time.Now().Add(10 * 24 * time.Hour).Truncate(time.Second)
+	certFile, keyFile := servingCertFactory(t, dir, notAfter)
+
+	servingCert, err := NewServingCertificate(certFile, keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(notAfter.Unix()), servingCertExpiry.get())
+
+	t.Run("test expiry warning window", func(t *testing.T) {
+		assert.True(t, servingCert.checkExpiry(time.Now()))
+		_ = os.Setenv(keyServingCertWarning, "168h")
+		defer func() {
+			_ = os.Unsetenv(keyServingCertWarning)
+		}()
+		assert.False(t, servingCert.checkExpiry(time.Now()))
+	})
+
+	t.Run("test reload rotated certificate", func(t *testing.T) {
+		rotated := notAfter.AddDate(0, 3, 0)
+		servingCertFactory(t, dir, rotated)
+		assert.NoError(t, servingCert.reload())
+		certificate, err := servingCert.GetCertificate(nil)
+		assert.NoError(t, err)
+		leaf, _ := x509.ParseCertificate(certificate.Certificate[0])
+		assert.Equal(t, rotated.Unix(), leaf.NotAfter.Unix())
+		assert.Equal(t, float64(rotated.Unix()), servingCertExpiry.get())
+	})
+
+	t.Run("test missing certificate", func(t *testing.T) {
+		_, err := NewServingCertificate(filepath.Join(dir, "missing.crt"), keyFile)
+		assert.Error(t, err)
+	})
+
+}
@@ -0,0 +1,121 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"sync"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	// reportedUnknownFields remembers the unknown fields already logged,
+	// which every pod of a newer apiserver carries
+	reportedUnknownFields sync.Map
+
+	unknownFieldsTotal = newMetric(metricTypeCounter, "kac_unknown_fields_total", "Number of admitted objects carrying fields unknown to the injector, by kind", "kind")
+)
+
+// reportUnknownFields logs the fields of raw the injector doesn't know,
+// once each, as they hint at an injector older than the apiserver. The
+// object is decoded leniently anyway. It decodes the object a second time,
+// so it only runs with the SkewChecks feature gate
+func reportUnknownFields(gvk schema.GroupVersionKind, raw []byte) {
+	_, _, err := strictDeserializer.Decode(raw, nil, nil)
+	strictErr, ok := runtime.AsStrictDecodingError(err)
+	if !ok {
+		return
+	}
+	unknownFieldsTotal.inc(gvk.Kind)
+	for _, fieldErr := range strictErr.Errors() {
+		if _, reported := reportedUnknownFields.LoadOrStore(gvk.Kind+"\xff"+fieldErr.Error(), true); !reported {
+			log.Printf("Admitted %s with fields unknown to the injector, kept as they are: %v", gvk.Kind, fieldErr)
+		}
+	}
+}
+
+// preserves applies the patch to the raw object as admitted and checks
+// that every field it doesn't target is kept, so that a patch built from
+// the decoded object never strips fields the injector doesn't know
+func (b *patchBuilder) preserves(raw []byte) error {
+	encodedPatch, err := b.encode()
+	if err != nil {
+		return err
+	}
+	patch, err := jsonpatch.DecodePatch(encodedPatch)
+	if err != nil {
+		return err
+	}
+	patched, err := patch.Apply(raw)
+	if err != nil {
+		return fmt.Errorf("patch doesn't apply to the admitted object: %w", err)
+	}
+	var before, after interface{}
+	if err := json.Unmarshal(raw, &before); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(patched, &after); err != nil {
+		return err
+	}
+	targets := map[string]bool{}
+	for _, operation := range b.operations {
+		targets[operation.Path] = true
+	}
+	if path, ok := preserved(before, after, "", targets); !ok {
+		return fmt.Errorf("refusing patch changing or removing %s of the admitted object", path)
+	}
+	return nil
+}
+
+// preserved tells whether after holds every field and item of before,
+// returning the path of the first one missing or changed. Only the scalar
+// values the patch targets, such as the injector's own annotations, may
+// change
+func preserved(before interface{}, after interface{}, path string, targets map[string]bool) (string, bool) {
+	if before == nil {
+		return "", true
+	}
+	switch b := before.(type) {
+	case map[string]interface{}:
+		a, ok := after.(map[string]interface{})
+		if !ok {
+			return path, false
+		}
+		for key, value := range b {
+			if p, ok := preserved(value, a[key], path+jsonPointer(key), targets); !ok {
+				return p, false
+			}
+		}
+		return "", true
+	case []interface{}:
+		a, ok := after.([]interface{})
+		if !ok || len(a) < len(b) {
+			return path, false
+		}
+		for i, value := range b {
+			if p, ok := preserved(value, a[i], path+jsonPointer(strconv.Itoa(i)), targets); !ok {
+				return p, false
+			}
+		}
+		return "", true
+	}
+	if !targets[path] && !reflect.DeepEqual(before, after) {
+		return path, false
+	}
+	return "", true
+}
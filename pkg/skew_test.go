@@ -0,0 +1,82 @@
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_VersionSkew(t *testing.T) {
+
+	_ = os.Setenv(keyFeatureGates, featureSkewChecks+"=true")
+	defer func() {
+		_ = os.Unsetenv(keyFeatureGates)
+	}()
+
+	name, filename := os.Getenv(keyConfigMapName), os.Getenv(keyCABundleFilename)
+	clientSet := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a"},
+		Data:       map[string]string{filename: string(fixtures.Certificate("root", time.Now().AddDate(1, 0, 0)))},
+	})
+	ctx := WithClientSet(context.Background(), clientSet)
+	router := NewRouter()
+	newerPod := `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"test-pod","namespace":"team-a","annotations":{"` + os.Getenv(keyCABundleAnnotation) + `":"true"}},` +
+		`"spec":{"futureSpecField":{"enabled":true},"containers":[{"name":"app","futureContainerField":"value"}]}}`
+
+	t.Run("test unknown fields are reported", func(t *testing.T) {
+		reported := unknownFieldsTotal.get("Pod")
+		ar, _ := admissionReviewFactory(podsGVR, []byte(newerPod))
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(ar))
+		assert.Equal(t, http.StatusOK, w.Code)
+		response := admissionResponse(t, w)
+		assert.True(t, response.Allowed)
+		assert.NotEmpty(t, response.Patch)
+		assert.Equal(t, reported+1, unknownFieldsTotal.get("Pod"))
+	})
+
+	t.Run("test unknown fields aren't checked without the feature gate", func(t *testing.T) {
+		_ = os.Unsetenv(keyFeatureGates)
+		defer func() {
+			_ = os.Setenv(keyFeatureGates, featureSkewChecks+"=true")
+		}()
+		reported := unknownFieldsTotal.get("Pod")
+		ar, _ := admissionReviewFactory(podsGVR, []byte(newerPod))
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate", string(ar))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, admissionResponse(t, w).Allowed)
+		assert.Equal(t, reported, unknownFieldsTotal.get("Pod"))
+	})
+
+	t.Run("test preview keeps unknown fields", func(t *testing.T) {
+		w := fakeRequest(ctx, router, http.MethodPost, "/preview", newerPod)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var preview PreviewResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &preview))
+		assert.Contains(t, string(preview.Pod), `"futureSpecField":{"enabled":true}`)
+		assert.Contains(t, string(preview.Pod), `"futureContainerField":"value"`)
+		assert.Contains(t, string(preview.Pod), `"volumeMounts"`)
+	})
+
+	t.Run("test patch replacing unknown content is refused", func(t *testing.T) {
+		raw := []byte(`{"metadata":{},"spec":{"containers":[{"name":"app","env":[{"name":"FUTURE","valueFrom":{"futureSource":{}}}]}]}}`)
+		patch := newPatchBuilder()
+		patch.appendItem("/spec/containers/0/env", 0, corev1.EnvVar{Name: "SSL_CERT_FILE", Value: "/etc/ssl/certs/ca.pem"})
+		err := patch.preserves(raw)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "/spec/containers/0/env")
+
+		patch = newPatchBuilder()
+		patch.appendItem("/spec/containers/0/env", 1, corev1.EnvVar{Name: "SSL_CERT_FILE", Value: "/etc/ssl/certs/ca.pem"})
+		patch.setMapEntry("/metadata/annotations", false, "example.com/ca-injector-hash", "abc")
+		assert.NoError(t, patch.preserves(raw))
+	})
+
+}
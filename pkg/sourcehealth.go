@@ -0,0 +1,124 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	// sourceURLPattern matches the urls within error messages, as wrapped
+	// by net/http and the source loaders
+	sourceURLPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"']+`)
+
+	sourceHealthMutex sync.Mutex
+	// sourceHealth is the outcome of the last load of every bundle source,
+	// by source name
+	sourceHealth = map[string]*SourceHealth{}
+
+	sourceUp          = newMetric(metricTypeGauge, "kac_source_up", "Whether the last load of the ca bundle source succeeded, by source", "source")
+	sourceLastSuccess = newMetric(metricTypeGauge, "kac_source_last_success_timestamp_seconds", "Time of the last successful load of the ca bundle source, by source", "source")
+	sourceLoadSeconds = newMetric(metricTypeGauge, "kac_source_load_duration_seconds", "Duration of the last load of the ca bundle source, by source", "source")
+	sourceLoadErrors  = newMetric(metricTypeCounter, "kac_source_load_errors_total", "Number of failed loads of the ca bundle source, by source", "source")
+)
+
+// SourceHealth is the outcome of the last load of a bundle source, as
+// reported by the readiness endpoint
+type SourceHealth struct {
+	Reachable      bool       `json:"reachable"`
+	LastAttempt    time.Time  `json:"lastAttempt"`
+	LastSuccess    *time.Time `json:"lastSuccess,omitempty"`
+	LatencySeconds float64    `json:"latencySeconds"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// observedSource records the health of every load of the wrapped source
+// under its name
+type observedSource struct {
+	name   string
+	source BundleSource
+}
+
+// observeSource wraps source to record its health under the location it
+// loads from, without credentials
+func observeSource(location string, source BundleSource) BundleSource {
+	return observedSource{name: sourceName(location), source: source}
+}
+
+func (s observedSource) Load(ctx context.Context) ([]byte, *BundleProvenance, error) {
+	started := time.Now()
+	bundle, provenance, err := s.source.Load(ctx)
+	recordSourceLoad(s.name, started, time.Now(), err)
+	return bundle, provenance, err
+}
+
+// sourceName strips the user info and query of a source location, which
+// may hold credentials, so it can be used as a metric label
+func sourceName(location string) string {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme == "" {
+		return location
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// sourceError returns the message of a load error with the urls it
+// mentions stripped like sourceName does, since the error is served by
+// the readiness endpoint
+func sourceError(err error) string {
+	return sourceURLPattern.ReplaceAllStringFunc(err.Error(), sourceName)
+}
+
+// recordSourceLoad records the outcome of a load of the named source,
+// started at started and finished at now
+func recordSourceLoad(name string, started time.Time, now time.Time, err error) {
+	sourceHealthMutex.Lock()
+	defer sourceHealthMutex.Unlock()
+	health, ok := sourceHealth[name]
+	if !ok {
+		health = &SourceHealth{}
+		sourceHealth[name] = health
+	}
+	health.LastAttempt = now.UTC()
+	health.LatencySeconds = now.Sub(started).Seconds()
+	health.Reachable = err == nil
+	sourceLoadSeconds.set(health.LatencySeconds, name)
+	if err != nil {
+		health.Error = sourceError(err)
+		sourceUp.set(0, name)
+		sourceLoadErrors.inc(name)
+		return
+	}
+	lastSuccess := now.UTC()
+	health.LastSuccess = &lastSuccess
+	health.Error = ""
+	sourceUp.set(1, name)
+	sourceLastSuccess.set(float64(now.Unix()), name)
+}
+
+// currentSourceHealth returns a copy of the health of every source loaded
+// so far
+func currentSourceHealth() map[string]SourceHealth {
+	sourceHealthMutex.Lock()
+	defer sourceHealthMutex.Unlock()
+	sources := make(map[string]SourceHealth, len(sourceHealth))
+	for name, health := range sourceHealth {
+		sources[name] = *health
+	}
+	return sources
+}
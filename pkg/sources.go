@@ -0,0 +1,318 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var sourceFallbacks = newMetric(metricTypeCounter, "kac_source_fallbacks_total", "Number of ca bundle loads served by a fallback source, by source", "source")
+
+// BundleSource loads the ca bundle from where it is published
+type BundleSource interface {
+	Load(ctx context.Context) ([]byte, *BundleProvenance, error)
+}
+
+// newBundleSource selects the source of the ca bundle. The embedded bundle
+// is used when running offline or without url, and CA_BUNDLE_SECRET takes
+// precedence over url, which is either a list of http(s) urls, a file://
+// path, a secret:// or configmap:// reference to namespace/name[/key], or
+// an artifact published on s3://, gs:// or oci://, the ca chain of a
+// vault:// PKI mount, or the trust anchors of a clustertrustbundle:// or
+// trust-manager trustbundle:// in the cluster.
+// With CA_BUNDLE_MERGE, the bundles of every location of the list are
+// merged instead of required to agree. With CA_BUNDLE_FALLBACK, they are
+// tried in order of priority, the first listed first, and the first one
+// loading serves the bundle. embedded:// names the bundle built into the
+// binary, as a last resort
+func newBundleSource(url string) (BundleSource, error) {
+
+	offline := os.Getenv(keyOffline) == "true"
+	if secretRef := os.Getenv(keyCABundleSecret); secretRef != "" && !offline {
+		source, err := newObjectSource(provenanceSourceSecret, secretRef, keyCABundleSecret, secretRef)
+		if err != nil {
+			return nil, err
+		}
+		return observeSource(provenanceSourceSecret+"://"+secretRef, source), nil
+	}
+	urls := splitList(url)
+	if offline || len(urls) == 0 {
+		return embeddedSource{}, nil
+	}
+
+	// Every location is observed on its own, the quorum ones as they are
+	// fetched, so that the broken one can be told apart
+	if len(urls) == 1 {
+		source, err := newSingleSource(urls[0], true)
+		if err != nil {
+			return nil, err
+		}
+		return observeSource(urls[0], source), nil
+	} else if os.Getenv(keyCABundleMerge) == "true" && os.Getenv(keyCABundleFallback) == "true" {
+		return nil, fmt.Errorf("%s and %s can't be both enabled", keyCABundleMerge, keyCABundleFallback)
+	} else if os.Getenv(keyCABundleFallback) == "true" {
+		source := fallbackSource{}
+		for _, u := range urls {
+			single, err := newSingleSource(u, true)
+			if err != nil {
+				return nil, err
+			}
+			source.locations = append(source.locations, u)
+			source.sources = append(source.sources, observeSource(u, single))
+		}
+		return source, nil
+	} else if os.Getenv(keyCABundleMerge) == "true" {
+		source := mergeSource{}
+		for _, u := range urls {
+			single, err := newSingleSource(u, false)
+			if err != nil {
+				return nil, err
+			}
+			source.sources = append(source.sources, observeSource(u, single))
+		}
+		return source, nil
+	}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+			return nil, fmt.Errorf("unsupported ca bundle source %q", u)
+		}
+	}
+	quorum, _ := strconv.Atoi(os.Getenv(keyCABundleQuorum))
+	return urlSource{urls: urls, quorum: quorum, checksum: true}, nil
+
+}
+
+// newSingleSource selects the source of a single bundle location. The
+// checksum of downloaded bundles is only verified when it describes the
+// whole bundle, not a merged part
+func newSingleSource(url string, checksum bool) (BundleSource, error) {
+	scheme, ref, _ := strings.Cut(url, "://")
+	switch scheme {
+	case "file":
+		return fileSource{path: ref}, nil
+	case provenanceSourceEmbedded:
+		return embeddedSource{}, nil
+	case provenanceSourceSecret, provenanceSourceConfigMap:
+		return newObjectSource(scheme, ref, keyCABundleURL, url)
+	case "http", "https":
+		return urlSource{urls: []string{url}, checksum: checksum}, nil
+	case provenanceSourceS3, provenanceSourceGCS, provenanceSourceOCI:
+		return newArtifactSource(scheme, ref, url)
+	case provenanceSourceClusterTrustBundle, provenanceSourceTrustBundle:
+		return newTrustBundleSource(scheme, url)
+	case provenanceSourceVault:
+		return newVaultSource(ref, url)
+	}
+	return nil, fmt.Errorf("unsupported ca bundle source %q", url)
+}
+
+// embeddedSource returns the bundle built into the binary
+type embeddedSource struct{}
+
+func (embeddedSource) Load(_ context.Context) ([]byte, *BundleProvenance, error) {
+	if len(embeddedBundle) == 0 {
+		return nil, nil, fmt.Errorf("no ca bundle embedded into the binary")
+	}
+	return embeddedBundle, &BundleProvenance{Source: provenanceSourceEmbedded, Format: formatPEM}, nil
+}
+
+// urlSource downloads the bundle from one or more http(s) urls, or from
+// the elected leader when running as a follower. The leader has already
+// verified the checksum of the bundle it serves. Followers fall back to
+// the urls when the leader can't serve the bundle, e.g. while the replicas
+// don't agree yet on a rotated serving certificate
+type urlSource struct {
+	urls     []string
+	quorum   int
+	checksum bool
+}
+
+func (s urlSource) Load(ctx context.Context) ([]byte, *BundleProvenance, error) {
+	if leader, leaderClient, ok := delegatedLeader(); ok {
+		bundle, provenance, err := fetchBundle(ctx, leaderClient, "https://"+net.JoinHostPort(leader, serverPort)+"/bundle")
+		if err == nil {
+			provenance.Source = provenanceSourceLeader
+			return bundle, provenance, nil
+		}
+		log.Printf("Unable to fetch ca bundle from leader %s, fetching it upstream: %v", leader, err)
+	}
+	client := bundleHTTPClient()
+	var checksum string
+	var err error
+	if s.checksum {
+		if checksum, err = expectedChecksum(ctx, client); err != nil {
+			return nil, nil, err
+		}
+	}
+	var bundle []byte
+	var provenance *BundleProvenance
+	if len(s.urls) > 1 {
+		bundle, provenance, err = fetchQuorum(ctx, client, s.urls, s.quorum)
+	} else {
+		bundle, provenance, err = fetchBundle(ctx, client, s.urls[0])
+	}
+	if err != nil {
+		return nil, nil, err
+	} else if err := verifyChecksum(checksum, provenance); err != nil {
+		return nil, nil, err
+	}
+	provenance.Source = provenanceSourceURL
+	return bundle, provenance, nil
+}
+
+// fileSource reads the bundle from a local file, e.g. mounted from a
+// volume managed by other tooling
+type fileSource struct {
+	path string
+}
+
+func (s fileSource) Load(_ context.Context) ([]byte, *BundleProvenance, error) {
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle, err := parseBundle("", content)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bundle, &BundleProvenance{
+		Source: provenanceSourceFile,
+		URI:    "file://" + s.path,
+		Format: detectFormat("", content),
+	}, nil
+}
+
+// objectSource reads the bundle from a key of an in-cluster secret or
+// configmap
+type objectSource struct {
+	kind      string
+	namespace string
+	name      string
+	key       string
+}
+
+// newObjectSource parses a namespace/name[/key] reference, the key
+// defaulting to ca.crt. The setting holding value is named on errors
+func newObjectSource(kind string, ref string, setting string, value string) (BundleSource, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid %s %q, expected namespace/name[/key]", setting, value)
+	}
+	source := objectSource{kind: kind, namespace: parts[0], name: parts[1], key: mirrorSecretKey}
+	if len(parts) == 3 {
+		source.key = parts[2]
+	}
+	return source, nil
+}
+
+func (s objectSource) Load(ctx context.Context) ([]byte, *BundleProvenance, error) {
+	clientSet, err := getKubernetesClientSet(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var content []byte
+	var ok bool
+	if s.kind == provenanceSourceSecret {
+		secret, err := clientSet.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		content, ok = secret.Data[s.key]
+	} else {
+		configMap, err := clientSet.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		if content, ok = configMap.BinaryData[s.key]; !ok {
+			var data string
+			data, ok = configMap.Data[s.key]
+			content = []byte(data)
+		}
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("key %s not found in %s %s/%s", s.key, s.kind, s.namespace, s.name)
+	}
+	bundle, err := parseBundle("", content)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bundle, &BundleProvenance{
+		Source: s.kind,
+		URI:    fmt.Sprintf("%s://%s/%s/%s", s.kind, s.namespace, s.name, s.key),
+		Format: detectFormat("", content),
+	}, nil
+}
+
+// mergeSource concatenates the certificates of several sources into one
+// bundle, without duplicates, e.g. to add internal roots to a public
+// bundle. Every source must load, so that no root goes silently missing
+type mergeSource struct {
+	sources []BundleSource
+}
+
+func (s mergeSource) Load(ctx context.Context) ([]byte, *BundleProvenance, error) {
+	provenance := &BundleProvenance{Source: provenanceSourceMerge, Format: formatPEM}
+	var bundles [][]byte
+	for _, source := range s.sources {
+		bundle, sourceProvenance, err := source.Load(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		bundles = append(bundles, bundle)
+		provenance.Merged = append(provenance.Merged, sourceProvenance.URI)
+	}
+	merged, err := mergeBundles(bundles...)
+	if err != nil {
+		return nil, nil, err
+	}
+	provenance.URI = strings.Join(provenance.Merged, ",")
+	return merged, provenance, nil
+}
+
+// fallbackSource loads the bundle from the first of several sources that
+// loads, e.g. an url, then its s3 mirror, then the embedded bundle, so
+// that upstream maintenance doesn't stop bundle refreshes
+type fallbackSource struct {
+	locations []string
+	sources   []BundleSource
+}
+
+func (s fallbackSource) Load(ctx context.Context) ([]byte, *BundleProvenance, error) {
+	var failed []string
+	var errs []string
+	for i, source := range s.sources {
+		bundle, provenance, err := source.Load(ctx)
+		if err != nil {
+			failed = append(failed, sourceName(s.locations[i]))
+			errs = append(errs, fmt.Sprintf("%s: %v", sourceName(s.locations[i]), err))
+			continue
+		}
+		if len(failed) > 0 {
+			log.Printf("Loaded ca bundle from fallback source %s, after %s", sourceName(s.locations[i]), strings.Join(errs, "; "))
+			sourceFallbacks.inc(sourceName(s.locations[i]))
+		}
+		if provenance.URI == "" {
+			provenance.URI = s.locations[i]
+		}
+		provenance.FailedOver = failed
+		return bundle, provenance, nil
+	}
+	return nil, nil, fmt.Errorf("no ca bundle source could be loaded: %s", strings.Join(errs, "; "))
+}
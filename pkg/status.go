@@ -0,0 +1,139 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"html/template"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+const maxRecentErrors = 20
+
+var (
+	recentErrorsMutex sync.Mutex
+	recentErrors      []statusError
+
+	statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>kac-ca-injector</title></head>
+<body>
+<h1>kac-ca-injector</h1>
+<h2>Bundle</h2>
+{{- with .Provenance }}
+<table>
+<tr><th>Source</th><td>{{ .Source }} {{ .URI }}</td></tr>
+<tr><th>SHA-256</th><td>{{ .SHA256 }}</td></tr>
+<tr><th>Certificates</th><td>{{ .Certificates }}</td></tr>
+<tr><th>Soonest expiry</th><td>{{ .NotAfter.Format "2006-01-02T15:04:05Z07:00" }}</td></tr>
+<tr><th>Last fetch</th><td>{{ .LoadedAt.Format "2006-01-02T15:04:05Z07:00" }}</td></tr>
+</table>
+{{- else }}
+<p>No bundle loaded yet</p>
+{{- end }}
+<h2>Managed namespaces</h2>
+{{- if .NamespacesError }}
+<p>Unable to list managed configmaps: {{ .NamespacesError }}</p>
+{{- else }}
+<ul>
+{{- range .Namespaces }}
+<li>{{ . }}</li>
+{{- end }}
+</ul>
+{{- end }}
+<h2>Feature gates</h2>
+<table>
+{{- range .Features }}
+<tr><th>{{ .Name }}</th><td>{{ .Maturity }}</td><td>{{ .Enabled }}</td></tr>
+{{- end }}
+</table>
+<h2>Recent errors</h2>
+<ul>
+{{- range .Errors }}
+<li>{{ .Time.Format "2006-01-02T15:04:05Z07:00" }} {{ .Message }}</li>
+{{- else }}
+<li>None</li>
+{{- end }}
+</ul>
+</body>
+</html>
+`))
+)
+
+// statusError is an error reported on the status page
+type statusError struct {
+	Time    time.Time
+	Message string
+}
+
+// statusPage is rendered by the status page template
+type statusPage struct {
+	Provenance      *BundleProvenance
+	Namespaces      []string
+	NamespacesError error
+	Features        []featureState
+	Errors          []statusError
+}
+
+// recordError keeps the most recent errors for the status page
+func recordError(err error) {
+	recentErrorsMutex.Lock()
+	defer recentErrorsMutex.Unlock()
+	recentErrors = append(recentErrors, statusError{Time: time.Now().UTC(), Message: err.Error()})
+	if len(recentErrors) > maxRecentErrors {
+		recentErrors = recentErrors[len(recentErrors)-maxRecentErrors:]
+	}
+}
+
+// writeStatus renders the read-only status page
+func writeStatus(ctx context.Context, w io.Writer) error {
+
+	page := statusPage{Provenance: currentProvenance(), Features: currentFeatureGates()}
+	page.Namespaces, page.NamespacesError = managedNamespaces(ctx)
+
+	recentErrorsMutex.Lock()
+	for i := len(recentErrors) - 1; i >= 0; i-- {
+		page.Errors = append(page.Errors, recentErrors[i])
+	}
+	recentErrorsMutex.Unlock()
+
+	return statusTemplate.Execute(w, page)
+
+}
+
+// managedNamespaces lists the namespaces holding a managed ca bundle
+func managedNamespaces(ctx context.Context) ([]string, error) {
+	clientSet, err := getKubernetesClientSet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	managed, _ := labels.NewRequirement(labelManagedBy, selection.Equals, []string{labelManagedByValue})
+	notRevision, _ := labels.NewRequirement(labelRevisionOf, selection.DoesNotExist, nil)
+	configMaps, err := listBundles(ctx, clientSet, metav1.NamespaceAll, labels.NewSelector().Add(*managed, *notRevision).String())
+	if err != nil {
+		return nil, err
+	}
+	var namespaces []string
+	for _, configMap := range configMaps {
+		if isBundleConfigMap(configMap.Name) && !containsString(namespaces, configMap.Namespace) {
+			namespaces = append(namespaces, configMap.Namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
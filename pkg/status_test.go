@@ -0,0 +1,57 @@
+package kac
+
+import (
+	"context"
+	"errors"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_StatusRoute(t *testing.T) {
+
+	managed := func(namespace string, name string, extraLabels map[string]string) *corev1.ConfigMap {
+		configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{labelManagedBy: labelManagedByValue},
+		}}
+		for key, value := range extraLabels {
+			configMap.Labels[key] = value
+		}
+		return configMap
+	}
+	clientSet := fake.NewSimpleClientset(
+		managed("team-b", os.Getenv(keyConfigMapName), nil),
+		managed("team-a", os.Getenv(keyConfigMapName), nil),
+		managed("team-a", os.Getenv(keyConfigMapName)+"-0123456789", map[string]string{labelRevisionOf: os.Getenv(keyConfigMapName)}),
+	)
+	ctx := WithClientSet(context.Background(), clientSet)
+
+	bundle := fixtures.Certificate("root", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	recordProvenance(&BundleProvenance{Source: provenanceSourceURL, URI: "https://pki.example.com/bundle.pem", Format: formatPEM}, bundle)
+	recordError(errors.New("unable to <fetch> bundle"))
+	defer func() {
+		lastProvenance = nil
+		recentErrors = nil
+	}()
+
+	namespaces, err := managedNamespaces(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"team-a", "team-b"}, namespaces)
+
+	w := fakeRequest(ctx, NewRouter(), http.MethodGet, "/status", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), bundleHash(bundle))
+	assert.Contains(t, w.Body.String(), "2030-01-01T00:00:00Z")
+	assert.Contains(t, w.Body.String(), "<li>team-a</li>")
+	assert.Contains(t, w.Body.String(), "unable to &lt;fetch&gt; bundle")
+
+}
@@ -0,0 +1,149 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	keyMutationSummaryInterval = "CA_BUNDLE_MUTATION_SUMMARY_INTERVAL"
+
+	defaultMutationSummaryPeriod = time.Minute
+
+	labelPodTemplateHash        = "pod-template-hash"
+	labelControllerRevisionHash = "controller-revision-hash"
+
+	// Argo Rollouts label their replicasets and pods with their own
+	// template hash, and Knative labels the pods of every revision with
+	// the revision and the service it belongs to
+	labelRolloutsPodTemplateHash = "rollouts-pod-template-hash"
+	labelKnativeService          = "serving.knative.dev/service"
+	labelKnativeRevision         = "serving.knative.dev/revision"
+)
+
+var (
+	mutationSummariesMutex sync.Mutex
+	// mutationSummaries counts the pods mutated since the last summary but
+	// not logged, by workload revision
+	mutationSummaries = map[workloadRevision]int{}
+
+	workloadMutationsTotal = newMetric(metricTypeCounter, "kac_workload_mutations_total", "Number of pods mutated, by namespace and owner workload", "namespace", "workload")
+)
+
+// workloadRevision identifies the pods created from the same template of a
+// workload, e.g. the replicas of a deployment rollout
+type workloadRevision struct {
+	namespace string
+	workload  string
+	revision  string
+}
+
+// podWorkload returns the workload revision a pod belongs to. Replicasets
+// named after their template hash are reported as their deployment or
+// argo rollout, knative pods as their service (or revision when created
+// without service) and pods without controller share a single Pod
+// workload, so that bare pods don't grow the metric cardinality
+func podWorkload(namespace string, pod *corev1.Pod) workloadRevision {
+	if revision := pod.Labels[labelKnativeRevision]; revision != "" {
+		if service := pod.Labels[labelKnativeService]; service != "" {
+			return workloadRevision{namespace, "Service.serving.knative.dev/" + service, revision}
+		}
+		return workloadRevision{namespace, "Revision.serving.knative.dev/" + revision, revision}
+	}
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return workloadRevision{namespace: namespace, workload: "Pod"}
+	}
+	if revision := pod.Labels[labelRolloutsPodTemplateHash]; owner.Kind == "ReplicaSet" && revision != "" && strings.HasSuffix(owner.Name, "-"+revision) {
+		return workloadRevision{namespace, "Rollout/" + strings.TrimSuffix(owner.Name, "-"+revision), revision}
+	}
+	revision := pod.Labels[labelPodTemplateHash]
+	if revision == "" {
+		revision = pod.Labels[labelControllerRevisionHash]
+	}
+	if owner.Kind == "ReplicaSet" && revision != "" && strings.HasSuffix(owner.Name, "-"+revision) {
+		return workloadRevision{namespace, "Deployment/" + strings.TrimSuffix(owner.Name, "-"+revision), revision}
+	}
+	return workloadRevision{namespace, owner.Kind + "/" + owner.Name, revision}
+}
+
+// recordMutation logs the first mutated pod of each workload revision and
+// counts the others for the next summary, so that a rollout of hundreds
+// of replicas logs a couple of lines instead of one per pod
+func recordMutation(namespace string, pod *corev1.Pod) {
+	key := podWorkload(namespace, pod)
+	workloadMutationsTotal.inc(key.namespace, key.workload)
+	if key.revision == "" {
+		log.Printf("Injected ca bundle into pod %s/%s", namespace, pod.Name+pod.GenerateName)
+		return
+	}
+	mutationSummariesMutex.Lock()
+	defer mutationSummariesMutex.Unlock()
+	if _, ok := mutationSummaries[key]; !ok {
+		log.Printf("Injected ca bundle into pod %s/%s of %s revision %s", namespace, pod.Name+pod.GenerateName, key.workload, key.revision)
+		mutationSummaries[key] = 0
+		return
+	}
+	mutationSummaries[key]++
+}
+
+// flushMutationSummaries logs the pods mutated since the previous summary,
+// one line per workload revision, and forgets the revisions that had no
+// new pods
+func flushMutationSummaries() {
+	mutationSummariesMutex.Lock()
+	defer mutationSummariesMutex.Unlock()
+	keys := make([]workloadRevision, 0, len(mutationSummaries))
+	for key := range mutationSummaries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].namespace+"/"+keys[i].workload+"/"+keys[i].revision < keys[j].namespace+"/"+keys[j].workload+"/"+keys[j].revision
+	})
+	for _, key := range keys {
+		if count := mutationSummaries[key]; count == 0 {
+			delete(mutationSummaries, key)
+		} else {
+			log.Printf("Injected ca bundle into %d more pods of %s/%s revision %s", count, key.namespace, key.workload, key.revision)
+			mutationSummaries[key] = 0
+		}
+	}
+}
+
+// RunMutationSummaries logs the mutation summaries every
+// CA_BUNDLE_MUTATION_SUMMARY_INTERVAL. It returns when ctx is done
+func RunMutationSummaries(ctx context.Context) {
+	interval, _ := time.ParseDuration(os.Getenv(keyMutationSummaryInterval))
+	if interval <= 0 {
+		interval = defaultMutationSummaryPeriod
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushMutationSummaries()
+			return
+		case <-ticker.C:
+			flushMutationSummaries()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package kac
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_MutationSummaries(t *testing.T) {
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		mutationSummaries = map[workloadRevision]int{}
+	}()
+	controller := true
+	replica := func(owner string, kind string, hash string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			GenerateName:    owner + "-",
+			Labels:          map[string]string{labelPodTemplateHash: hash},
+			OwnerReferences: []metav1.OwnerReference{{Kind: kind, Name: owner, Controller: &controller}},
+		}}
+	}
+
+	t.Run("test pod workload", func(t *testing.T) {
+		assert.Equal(t, workloadRevision{"team-a", "Deployment/api", "5d8f7c"}, podWorkload("team-a", replica("api-5d8f7c", "ReplicaSet", "5d8f7c")))
+		assert.Equal(t, workloadRevision{"team-a", "ReplicaSet/batch", "5d8f7c"}, podWorkload("team-a", replica("batch", "ReplicaSet", "5d8f7c")))
+		assert.Equal(t, workloadRevision{"team-a", "Pod", ""}, podWorkload("team-a", &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "debug"}}))
+	})
+
+	t.Run("test argo rollout and knative workloads", func(t *testing.T) {
+		rollout := replica("web-7c9b4d", "ReplicaSet", "")
+		rollout.Labels = map[string]string{labelRolloutsPodTemplateHash: "7c9b4d"}
+		assert.Equal(t, workloadRevision{"team-a", "Rollout/web", "7c9b4d"}, podWorkload("team-a", rollout))
+
+		knative := replica("hello-00002-deployment-6b8c5f", "ReplicaSet", "6b8c5f")
+		knative.Labels[labelKnativeService] = "hello"
+		knative.Labels[labelKnativeRevision] = "hello-00002"
+		assert.Equal(t, workloadRevision{"team-a", "Service.serving.knative.dev/hello", "hello-00002"}, podWorkload("team-a", knative))
+		delete(knative.Labels, labelKnativeService)
+		assert.Equal(t, workloadRevision{"team-a", "Revision.serving.knative.dev/hello-00002", "hello-00002"}, podWorkload("team-a", knative))
+	})
+
+	t.Run("test rollout is summarized", func(t *testing.T) {
+		output.Reset()
+		mutations := workloadMutationsTotal.get("team-a", "Deployment/api")
+		for i := 0; i < 500; i++ {
+			recordMutation("team-a", replica("api-5d8f7c", "ReplicaSet", "5d8f7c"))
+		}
+		flushMutationSummaries()
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		assert.Len(t, lines, 2)
+		assert.Contains(t, lines[0], "Injected ca bundle into pod team-a/api-5d8f7c- of Deployment/api revision 5d8f7c")
+		assert.Contains(t, lines[1], "Injected ca bundle into 499 more pods of team-a/Deployment/api revision 5d8f7c")
+		assert.Equal(t, mutations+500, workloadMutationsTotal.get("team-a", "Deployment/api"))
+	})
+
+	t.Run("test idle revisions are forgotten", func(t *testing.T) {
+		output.Reset()
+		flushMutationSummaries()
+		flushMutationSummaries()
+		assert.Empty(t, output.String())
+		assert.Empty(t, mutationSummaries)
+	})
+
+}
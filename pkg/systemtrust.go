@@ -0,0 +1,161 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	keyInjectionStrategy = "CA_BUNDLE_INJECTION_STRATEGY"
+	keyTrustStoreImage   = "CA_BUNDLE_TRUST_STORE_IMAGE"
+	keyTrustStoreCommand = "CA_BUNDLE_TRUST_STORE_COMMAND"
+
+	strategyAnnotationSuffix = "-strategy"
+	strategySubPath          = "subpath"
+	strategyInitContainer    = "init-container"
+
+	trustStoreContainerName = "ca-injector-trust-store"
+	trustStoreVolumeSuffix  = "-trust-store"
+	trustStoreBundleDir     = "/var/run/ca-injector/bundle"
+	trustStoreOutputDir     = "/var/run/ca-injector/trust-store"
+)
+
+// defaultTrustStoreCommand builds the trust store without root: the
+// system certificates of the init container image are copied into the
+// trust store volume, the bundle is appended to the concatenated files of
+// Debian, Alpine and RHEL based images and split next to them, and the
+// directory is rehashed when openssl is available. Bundles are split
+// since rehashing only picks the first certificate of every file
+const defaultTrustStoreCommand = `set -e
+for dir in /etc/ssl/certs /etc/pki/tls/certs; do
+  if [ -d "$dir" ]; then
+    cp -RL "$dir/." "$TRUST_STORE_DIR"
+    break
+  fi
+done
+cd "$TRUST_STORE_DIR"
+for file in ca-certificates.crt ca-bundle.crt; do
+  if [ -f "$file" ]; then
+    cat "$CA_BUNDLE_FILE" >> "$file"
+  fi
+done
+awk '/BEGIN CERTIFICATE/ { n++ } n { print > ("ca-injector-" n ".pem") }' "$CA_BUNDLE_FILE"
+if command -v openssl >/dev/null 2>&1; then
+  openssl rehash .
+fi
+[ -e "$TRUST_STORE_FILE" ] || cp "$CA_BUNDLE_FILE" "$TRUST_STORE_FILE"
+`
+
+// trustStoreUser runs the init container when the pod doesn't set a user,
+// since images default to root
+const trustStoreUser = int64(65534)
+
+// injectionStrategy returns the strategy named by the pod annotations or
+// else by CA_BUNDLE_INJECTION_STRATEGY. The subpath strategy mounts the
+// bundle file alone, the init-container one replaces the whole directory
+// of the bundle with a system trust store including it
+func injectionStrategy(config Config, annotations map[string]string) (string, error) {
+	strategy, ok := annotations[config.Annotation+strategyAnnotationSuffix]
+	if !ok {
+		strategy = config.InjectionStrategy
+	}
+	switch strategy {
+	case "", strategySubPath:
+		return strategySubPath, nil
+	case strategyInitContainer:
+		if config.TrustStoreImage == "" {
+			return "", fmt.Errorf("the %s injection strategy requires %s", strategyInitContainer, keyTrustStoreImage)
+		}
+		return strategyInitContainer, nil
+	}
+	return "", fmt.Errorf("unknown ca bundle injection strategy %q", strategy)
+}
+
+// trustStoreVolumeName names the emptyDir holding the trust store built
+// from the bundle object named name
+func trustStoreVolumeName(name string) string {
+	return sanitizeVolumeName(name, validation.DNS1123LabelMaxLength-len(trustStoreVolumeSuffix)) + trustStoreVolumeSuffix
+}
+
+// trustStoreContainer builds the trust store of the bundle mounted at
+// mountPath into the trust store volume
+func trustStoreContainer(config Config, spec corev1.PodSpec, name string, mountPath string) corev1.Container {
+	command := config.TrustStoreCommand
+	if command == "" {
+		command = defaultTrustStoreCommand
+	}
+	return corev1.Container{
+		Name:    trustStoreContainerName,
+		Image:   config.TrustStoreImage,
+		Command: []string{"sh", "-c", command},
+		Env: []corev1.EnvVar{
+			{Name: "CA_BUNDLE_FILE", Value: path.Join(trustStoreBundleDir, config.BundleFilename)},
+			{Name: "TRUST_STORE_DIR", Value: trustStoreOutputDir},
+			{Name: "TRUST_STORE_FILE", Value: path.Base(mountPath)},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: name, MountPath: trustStoreBundleDir},
+			{Name: trustStoreVolumeName(name), MountPath: trustStoreOutputDir},
+		},
+		Resources:       qosNeutralResources(spec),
+		SecurityContext: trustStoreSecurityContext(spec),
+	}
+}
+
+// trustStoreSecurityContext restricts the init container as the
+// restricted pod security standard requires. The container only writes to
+// the trust store volume
+func trustStoreSecurityContext(spec corev1.PodSpec) *corev1.SecurityContext {
+	nonRoot, privilegeEscalation, readOnly := true, false, true
+	securityContext := &corev1.SecurityContext{
+		RunAsNonRoot:             &nonRoot,
+		AllowPrivilegeEscalation: &privilegeEscalation,
+		ReadOnlyRootFilesystem:   &readOnly,
+		Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+	if spec.SecurityContext == nil || spec.SecurityContext.RunAsUser == nil || *spec.SecurityContext.RunAsUser == 0 {
+		user := trustStoreUser
+		securityContext.RunAsUser = &user
+	}
+	return securityContext
+}
+
+// qosNeutralResources sizes an added init container so that the pod keeps
+// its qos class and its effective requests. Init containers of guaranteed
+// pods get the resources of the first container, already part of the
+// pod requests, and the others none
+func qosNeutralResources(spec corev1.PodSpec) corev1.ResourceRequirements {
+	for _, container := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+		for _, resource := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			limit, ok := container.Resources.Limits[resource]
+			if !ok {
+				return corev1.ResourceRequirements{}
+			}
+			if request, ok := container.Resources.Requests[resource]; ok && !request.Equal(limit) {
+				return corev1.ResourceRequirements{}
+			}
+		}
+	}
+	if len(spec.Containers) == 0 {
+		return corev1.ResourceRequirements{}
+	}
+	limits := corev1.ResourceList{
+		corev1.ResourceCPU:    spec.Containers[0].Resources.Limits[corev1.ResourceCPU],
+		corev1.ResourceMemory: spec.Containers[0].Resources.Limits[corev1.ResourceMemory],
+	}
+	return corev1.ResourceRequirements{Limits: limits, Requests: limits}
+}
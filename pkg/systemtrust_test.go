@@ -0,0 +1,89 @@
+package kac
+
+import (
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"os"
+	"testing"
+)
+
+func Test_InjectionStrategy(t *testing.T) {
+
+	annotation := os.Getenv(keyCABundleAnnotation) + strategyAnnotationSuffix
+
+	t.Run("test default strategy", func(t *testing.T) {
+		strategy, err := injectionStrategy(testConfig(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, strategySubPath, strategy)
+	})
+
+	t.Run("test init container strategy requires an image", func(t *testing.T) {
+		_, err := injectionStrategy(testConfig(), map[string]string{annotation: strategyInitContainer})
+		assert.EqualError(t, err, "the init-container injection strategy requires CA_BUNDLE_TRUST_STORE_IMAGE")
+	})
+
+	t.Run("test pod annotation overrides the strategy", func(t *testing.T) {
+		_ = os.Setenv(keyInjectionStrategy, strategyInitContainer)
+		_ = os.Setenv(keyTrustStoreImage, "debian:stable-slim")
+		defer func() {
+			_ = os.Unsetenv(keyInjectionStrategy)
+			_ = os.Unsetenv(keyTrustStoreImage)
+		}()
+		strategy, err := injectionStrategy(testConfig(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, strategyInitContainer, strategy)
+		strategy, err = injectionStrategy(testConfig(), map[string]string{annotation: strategySubPath})
+		assert.NoError(t, err)
+		assert.Equal(t, strategySubPath, strategy)
+		_, err = injectionStrategy(testConfig(), map[string]string{annotation: "hostpath"})
+		assert.Error(t, err)
+	})
+
+}
+
+func Test_QOSNeutralResources(t *testing.T) {
+
+	limits := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("256Mi")}
+	guaranteed := corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "app", Resources: corev1.ResourceRequirements{Limits: limits}},
+		{Name: "worker", Resources: corev1.ResourceRequirements{Limits: limits, Requests: limits}},
+	}}
+
+	t.Run("test guaranteed pod", func(t *testing.T) {
+		resources := qosNeutralResources(guaranteed)
+		assert.Equal(t, limits, resources.Limits)
+		assert.Equal(t, limits, resources.Requests)
+	})
+
+	t.Run("test burstable pod", func(t *testing.T) {
+		burstable := guaranteed.DeepCopy()
+		burstable.Containers[1].Resources.Requests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}
+		assert.Empty(t, qosNeutralResources(*burstable))
+	})
+
+	t.Run("test best effort pod", func(t *testing.T) {
+		assert.Empty(t, qosNeutralResources(corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}))
+	})
+
+}
+
+func Test_TrustStoreSecurityContext(t *testing.T) {
+
+	t.Run("test restricted container", func(t *testing.T) {
+		securityContext := trustStoreSecurityContext(corev1.PodSpec{})
+		assert.True(t, *securityContext.RunAsNonRoot)
+		assert.False(t, *securityContext.AllowPrivilegeEscalation)
+		assert.True(t, *securityContext.ReadOnlyRootFilesystem)
+		assert.Equal(t, []corev1.Capability{"ALL"}, securityContext.Capabilities.Drop)
+		assert.Equal(t, corev1.SeccompProfileTypeRuntimeDefault, securityContext.SeccompProfile.Type)
+		assert.Equal(t, trustStoreUser, *securityContext.RunAsUser)
+	})
+
+	t.Run("test pod user is kept", func(t *testing.T) {
+		user := int64(1000)
+		securityContext := trustStoreSecurityContext(corev1.PodSpec{SecurityContext: &corev1.PodSecurityContext{RunAsUser: &user}})
+		assert.Nil(t, securityContext.RunAsUser)
+	})
+
+}
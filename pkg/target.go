@@ -0,0 +1,183 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	keyCABundleTarget = "CA_BUNDLE_TARGET"
+
+	targetConfigMap = "configmap"
+	targetSecret    = "secret"
+)
+
+// bundleTarget returns the kind of object the ca bundle is stored on and
+// mounted from. Secrets are meant for clusters whose policy forbids
+// certificate material on configmaps
+func bundleTarget() string {
+	config, _ := currentConfig()
+	return config.Target
+}
+
+// secretView presents a bundle secret as a configmap, so that the bundle
+// is read the same way whatever the target
+func secretView(secret *corev1.Secret) *corev1.ConfigMap {
+	view := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: secret.ObjectMeta,
+		Data:       map[string]string{},
+	}
+	for key, value := range secret.Data {
+		view.Data[key] = string(value)
+	}
+	return view
+}
+
+// getBundle returns the ca bundle object of the namespace
+func getBundle(ctx context.Context, clientSet kubernetes.Interface, namespace string, name string) (*corev1.ConfigMap, error) {
+	if bundleTarget() == targetConfigMap {
+		return clientSet.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	secret, err := clientSet.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return secretView(secret), nil
+}
+
+// ensureBundle returns the ca bundle object of the namespace, creating it
+// when missing
+func ensureBundle(ctx context.Context, clientSet kubernetes.Interface, namespace string, name string, caBundleFilename string, caBundleURL string) (*corev1.ConfigMap, error) {
+
+	configMap, err := getBundle(ctx, clientSet, namespace, name)
+	if err == nil {
+		return configMap, nil
+	} else if !apierrors.IsNotFound(err) {
+		return nil, err
+	} else if err := checkNamespace(ctx, clientSet, namespace); err != nil {
+		return nil, err
+	}
+
+	body, err := loadCABundle(ctx, caBundleURL)
+	if err != nil {
+		return nil, err
+	} else if body, err = withTeamBundles(ctx, clientSet, namespace, body); err != nil {
+		return nil, err
+	} else if err := checkBundleValidity(body, time.Now()); err != nil {
+		return nil, err
+	}
+	data := map[string]string{caBundleFilename: string(body)}
+	if javaTruststoreEnabled() {
+		truststore, err := javaTruststore(body)
+		if err != nil {
+			return nil, err
+		}
+		data[truststoreFilename] = string(truststore)
+	}
+	if configMap, err = createBundle(ctx, clientSet, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      map[string]string{labelManagedBy: labelManagedByValue},
+			Annotations: timestampAnnotations(body),
+		},
+		Data: data,
+	}); err != nil {
+		return nil, createError(namespace, err)
+	}
+	if err := recordRevision(ctx, clientSet, configMap, caBundleFilename); err != nil {
+		log.Printf("Unable to record ca bundle revision in namespace %s: %v", namespace, err)
+	}
+	return configMap, nil
+
+}
+
+// bundleSecret returns the secret presented by a view
+func bundleSecret(view *corev1.ConfigMap) *corev1.Secret {
+	secret := &corev1.Secret{ObjectMeta: view.ObjectMeta, Type: corev1.SecretTypeOpaque, Data: map[string][]byte{}}
+	for key, value := range view.Data {
+		secret.Data[key] = []byte(value)
+	}
+	return secret
+}
+
+// createBundle creates a ca bundle object, or a revision of one, on the
+// bundle target
+func createBundle(ctx context.Context, clientSet kubernetes.Interface, view *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	if bundleTarget() == targetConfigMap {
+		return clientSet.CoreV1().ConfigMaps(view.Namespace).Create(ctx, view, metav1.CreateOptions{})
+	}
+	secret, err := clientSet.CoreV1().Secrets(view.Namespace).Create(ctx, bundleSecret(view), metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return secretView(secret), nil
+}
+
+// updateBundle stores the data of a ca bundle object
+func updateBundle(ctx context.Context, clientSet kubernetes.Interface, view *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	if view.Kind != "Secret" {
+		return clientSet.CoreV1().ConfigMaps(view.Namespace).Update(ctx, view, metav1.UpdateOptions{})
+	}
+	secret, err := clientSet.CoreV1().Secrets(view.Namespace).Update(ctx, bundleSecret(view), metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return secretView(secret), nil
+}
+
+// deleteBundle deletes a ca bundle object of the bundle target
+func deleteBundle(ctx context.Context, clientSet kubernetes.Interface, namespace string, name string) error {
+	if bundleTarget() == targetConfigMap {
+		return clientSet.CoreV1().ConfigMaps(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	}
+	return clientSet.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+// listBundles lists the ca bundle objects of the namespace, or of every
+// namespace with metav1.NamespaceAll, matching the label selector
+func listBundles(ctx context.Context, clientSet kubernetes.Interface, namespace string, selector string) ([]corev1.ConfigMap, error) {
+	if bundleTarget() == targetConfigMap {
+		configMaps, err := clientSet.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		return configMaps.Items, nil
+	}
+	secrets, err := clientSet.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	views := make([]corev1.ConfigMap, 0, len(secrets.Items))
+	for i := range secrets.Items {
+		views = append(views, *secretView(&secrets.Items[i]))
+	}
+	return views, nil
+}
+
+// bundleVolumeSource mounts the ca bundle object named name
+func bundleVolumeSource(name string) corev1.VolumeSource {
+	if bundleTarget() == targetSecret {
+		return corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: name}}
+	}
+	return corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+		LocalObjectReference: corev1.LocalObjectReference{Name: name},
+	}}
+}
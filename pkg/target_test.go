@@ -0,0 +1,168 @@
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_SecretTarget(t *testing.T) {
+
+	ctx := context.Background()
+	bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bundle)
+	}))
+	defer server.Close()
+
+	_ = os.Setenv(keyCABundleURL, server.URL)
+	_ = os.Setenv(keyCABundleTarget, targetSecret)
+	defer func() {
+		_ = os.Setenv(keyCABundleURL, caBundleURL)
+		_ = os.Unsetenv(keyCABundleTarget)
+	}()
+
+	name, filename, annotation := os.Getenv(keyConfigMapName), os.Getenv(keyCABundleFilename), os.Getenv(keyCABundleAnnotation)
+	clientSet := fake.NewSimpleClientset()
+	mutate := func(annotations map[string]string) *httptest.ResponseRecorder {
+		encodedPod, _ := json.Marshal(corev1.Pod{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "team-a", Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		})
+		ar, _ := admissionReviewFactory(podsGVR, encodedPod)
+		return fakeRequest(WithClientSet(ctx, clientSet), NewRouter(), http.MethodPost, "/mutate", string(ar))
+	}
+
+	t.Run("test route /mutate mounts the bundle secret", func(t *testing.T) {
+		w := mutate(map[string]string{annotation: "true"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, string(admissionResponse(t, w).Patch), `"secret":{"secretName":"`+name+`"}`)
+		secret, err := clientSet.CoreV1().Secrets("team-a").Get(ctx, name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, corev1.SecretTypeOpaque, secret.Type)
+		assert.Equal(t, bundle, secret.Data[filename])
+		_, err = clientSet.CoreV1().ConfigMaps("team-a").Get(ctx, name, metav1.GetOptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("test route /mutate stores extra files on the bundle secret", func(t *testing.T) {
+		templates := t.TempDir()
+		_ = os.WriteFile(filepath.Join(templates, "openssl.cnf"), []byte("CAfile = {{ .BundlePath }}\n"), 0644)
+		_ = os.Setenv(keyCABundleTemplates, templates)
+		defer func() {
+			_ = os.Unsetenv(keyCABundleTemplates)
+		}()
+		w := mutate(map[string]string{annotation: "true", annotation + extraFilesAnnotationSuffix: "openssl.cnf"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		secret, _ := clientSet.CoreV1().Secrets("team-a").Get(ctx, name, metav1.GetOptions{})
+		assert.Equal(t, "CAfile = /etc/ssl/certs/"+filename+"\n", string(secret.Data["openssl.cnf"]))
+		_, err := clientSet.CoreV1().ConfigMaps("team-a").Get(ctx, name, metav1.GetOptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("test route /mutate injects the java truststore", func(t *testing.T) {
+		_ = os.Setenv(keyJavaTruststore, "true")
+		defer func() {
+			_ = os.Unsetenv(keyJavaTruststore)
+		}()
+		w := mutate(map[string]string{annotation: "true"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		patch := string(admissionResponse(t, w).Patch)
+		assert.Contains(t, patch, `"mountPath":"/etc/ssl/certs/truststore.jks","subPath":"truststore.jks"`)
+		assert.Contains(t, patch, javaToolOptionsValue("/etc/ssl/certs/truststore.jks"))
+		secret, err := clientSet.CoreV1().Secrets("team-a").Get(ctx, name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		truststore, _ := javaTruststore(bundle)
+		assert.Equal(t, truststore, secret.Data[truststoreFilename])
+	})
+
+	t.Run("test route /mutate mounts the bundle in the profile format", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleProfiles, `{"windows": {"format": "der", "mountPath": "/etc/ssl/ca.der"}}`)
+		defer func() {
+			_ = os.Unsetenv(keyCABundleProfiles)
+		}()
+		w := mutate(map[string]string{annotation: "true", annotation + profileAnnotationSuffix: "windows"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, string(admissionResponse(t, w).Patch), `"mountPath":"/etc/ssl/ca.der","subPath":"`+derFilename+`"`)
+		secret, _ := clientSet.CoreV1().Secrets("team-a").Get(ctx, name, metav1.GetOptions{})
+		der, _ := derBundle(bundle)
+		assert.Equal(t, der, secret.Data[derFilename])
+	})
+
+	t.Run("test refresh the bundle secret", func(t *testing.T) {
+		bundle = fixtures.Certificate("rotated", time.Now().AddDate(1, 0, 0))
+		refreshed, err := refreshConfigMaps(ctx, clientSet)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"team-a/" + name}, refreshed)
+		secret, _ := clientSet.CoreV1().Secrets("team-a").Get(ctx, name, metav1.GetOptions{})
+		assert.Equal(t, bundle, secret.Data[filename])
+		der, _ := derBundle(bundle)
+		assert.Equal(t, der, secret.Data[derFilename])
+		events, _ := clientSet.CoreV1().Events("team-a").List(ctx, metav1.ListOptions{})
+		assert.Equal(t, "Secret", events.Items[0].InvolvedObject.Kind)
+	})
+
+	t.Run("test revisions and rollback on secrets", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleHistory, "2")
+		defer func() {
+			_ = os.Unsetenv(keyCABundleHistory)
+		}()
+		clientSet := fake.NewSimpleClientset()
+		view := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-b"}}
+		for _, content := range []string{"first", "second", "third"} {
+			view.Data = map[string]string{filename: content}
+			assert.NoError(t, recordRevision(ctx, clientSet, view, filename))
+		}
+		revisions, _ := clientSet.CoreV1().Secrets("team-b").List(ctx, metav1.ListOptions{})
+		assert.Len(t, revisions.Items, 2)
+		configMaps, _ := clientSet.CoreV1().ConfigMaps("team-b").List(ctx, metav1.ListOptions{})
+		assert.Empty(t, configMaps.Items)
+
+		revision := revisions.Items[0]
+		_, _ = createBundle(ctx, clientSet, view)
+		assert.NoError(t, rollbackBundle(ctx, clientSet, "team-b", name, strings.TrimPrefix(revision.Name, name+"-")))
+		rolledBack, _ := clientSet.CoreV1().Secrets("team-b").Get(ctx, name, metav1.GetOptions{})
+		assert.Equal(t, revision.Data[filename], rolledBack.Data[filename])
+	})
+
+	t.Run("test deletion of mounted bundle secret", func(t *testing.T) {
+		secret := &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a", Labels: map[string]string{labelManagedBy: labelManagedByValue}},
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "team-a"},
+			Spec:       corev1.PodSpec{Volumes: []corev1.Volume{{Name: "ca", VolumeSource: bundleVolumeSource(name)}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		encoded, _ := json.Marshal(secret)
+		response, err := bundleDeletionReviewer(ctx, fake.NewSimpleClientset(pod), admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+			Operation: admissionv1.Delete,
+			Resource:  secretsGVR,
+			Namespace: "team-a",
+			OldObject: runtime.RawExtension{Raw: encoded},
+		}})
+		assert.NoError(t, err)
+		assert.False(t, response.Allowed)
+		assert.Contains(t, response.Result.Message, "secret "+name+" is mounted by running pods (app)")
+	})
+
+	t.Run("test mirror doesn't overwrite bundle secrets", func(t *testing.T) {
+		assert.EqualError(t, mirrorBundle(ctx, clientSet, []string{"team-a"}, name, corev1.SecretTypeOpaque), "CA_BUNDLE_MIRROR_SECRET "+name+" is the name of a ca bundle secret")
+	})
+
+}
@@ -0,0 +1,158 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	keyTeamSources = "CA_BUNDLE_TEAM_SOURCES"
+
+	teamCABundlesPath = "/apis/ca-injector.nodis.com.br/v1alpha1/namespaces/%s/teamcabundles"
+)
+
+var teamSourcesRefused = newMetric(metricTypeCounter, "kac_team_sources_refused_total", "Number of TeamCABundle sources refused by the allowlist, by namespace", "namespace")
+
+// teamCABundle is a ca-injector.nodis.com.br TeamCABundle, with which
+// namespace admins add bundles to the cluster bundle of their namespace
+type teamCABundle struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Sources []string `json:"sources"`
+	} `json:"spec"`
+}
+
+type teamCABundleList struct {
+	Items []teamCABundle `json:"items"`
+}
+
+// teamBundleSources lists the sources of the TeamCABundles of the
+// namespace allowed by CA_BUNDLE_TEAM_SOURCES, a list of source prefixes
+// set by cluster admins. Team bundles are disabled without allowlist, and
+// where the TeamCABundle api is not installed
+func teamBundleSources(ctx context.Context, clientSet kubernetes.Interface, namespace string) ([]string, error) {
+	allowed := splitList(os.Getenv(keyTeamSources))
+	if len(allowed) == 0 || !featureEnabled(featureTeamCABundles) {
+		return nil, nil
+	}
+	raw, err := clientSet.Discovery().RESTClient().Get().AbsPath(fmt.Sprintf(teamCABundlesPath, namespace)).DoRaw(ctx)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var list teamCABundleList
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, err
+	}
+	var sources []string
+	for _, bundle := range list.Items {
+		for _, source := range bundle.Spec.Sources {
+			if !allowedTeamSource(source, allowed) {
+				log.Printf("Ignoring source %s of TeamCABundle %s/%s, not allowed by %s", source, namespace, bundle.Metadata.Name, keyTeamSources)
+				teamSourcesRefused.inc(namespace)
+			} else if !containsString(sources, source) {
+				sources = append(sources, source)
+			}
+		}
+	}
+	sort.Strings(sources)
+	return sources, nil
+}
+
+// allowedTeamSource reports whether the source is below one of the
+// allowed locations: schemes and hosts must be equal, and the cleaned
+// source path must be the allowed path or under it on a segment boundary,
+// so that neither longer hosts nor dot segments escape the allowlist
+func allowedTeamSource(source string, allowed []string) bool {
+	sourceURL, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+	sourcePath := cleanURLPath(sourceURL.Path)
+	for _, location := range allowed {
+		allowedURL, err := url.Parse(location)
+		if err != nil || sourceURL.Scheme != allowedURL.Scheme || !strings.EqualFold(sourceURL.Host, allowedURL.Host) {
+			continue
+		}
+		allowedPath := cleanURLPath(allowedURL.Path)
+		if sourcePath == allowedPath || strings.HasPrefix(sourcePath, strings.TrimSuffix(allowedPath, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func cleanURLPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// withTeamBundles merges the bundles of the TeamCABundles of the namespace
+// into the cluster bundle. Team sources are single locations, whose
+// checksum is never the one of the cluster bundle
+func withTeamBundles(ctx context.Context, clientSet kubernetes.Interface, namespace string, bundle []byte) ([]byte, error) {
+	sources, err := teamBundleSources(ctx, clientSet, namespace)
+	if err != nil || len(sources) == 0 {
+		return bundle, err
+	}
+	bundles := [][]byte{bundle}
+	for _, url := range sources {
+		teamBundle, ok := cachedCABundle(ctx, url, time.Now())
+		if !ok {
+			source, err := newSingleSource(url, false)
+			if err != nil {
+				return nil, err
+			}
+			if teamBundle, _, err = source.Load(ctx); err != nil {
+				return nil, fmt.Errorf("unable to load team ca bundle %s of namespace %s: %w", url, namespace, err)
+			}
+			cacheCABundle(url, teamBundle, time.Now())
+		}
+		bundles = append(bundles, teamBundle)
+	}
+	return mergeBundles(bundles...)
+}
+
+// mergeBundles concatenates the certificates of the bundles, without
+// duplicates
+func mergeBundles(bundles ...[]byte) ([]byte, error) {
+	seen := map[[sha256.Size]byte]bool{}
+	var certificates [][]byte
+	for _, bundle := range bundles {
+		parsed, err := parseCertificates(bundle)
+		if err != nil {
+			return nil, err
+		}
+		for _, certificate := range parsed {
+			if fingerprint := sha256.Sum256(certificate.Raw); !seen[fingerprint] {
+				seen[fingerprint] = true
+				certificates = append(certificates, certificate.Raw)
+			}
+		}
+	}
+	return encodeCertificates(certificates), nil
+}
@@ -0,0 +1,110 @@
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_TeamBundles(t *testing.T) {
+
+	cluster := fixtures.Certificate("cluster", time.Now().AddDate(1, 0, 0))
+	payments := fixtures.Certificate("payments", time.Now().AddDate(1, 0, 0))
+	sources := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(payments)
+	}))
+	defer sources.Close()
+	teamCABundle := func(name string, sources ...string) map[string]interface{} {
+		return map[string]interface{}{"metadata": map[string]string{"name": name}, "spec": map[string]interface{}{"sources": sources}}
+	}
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/ca-injector.nodis.com.br/v1alpha1/namespaces/payments/teamcabundles" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": []interface{}{
+			teamCABundle("partners", sources.URL+"/partners.pem", "https://evil.example.com/roots.pem"),
+			teamCABundle("cluster", sources.URL+"/partners.pem", "file:///etc/ssl/certs/ca-certificates.crt"),
+		}})
+	}))
+	defer apiServer.Close()
+	clientSet, err := kubernetes.NewForConfig(&rest.Config{Host: apiServer.URL})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	t.Run("test team bundles disabled without allowlist", func(t *testing.T) {
+		bundle, err := withTeamBundles(ctx, clientSet, "payments", cluster)
+		assert.NoError(t, err)
+		assert.Equal(t, cluster, bundle)
+	})
+
+	_ = os.Setenv(keyTeamSources, sources.URL+"/")
+	defer func() {
+		_ = os.Unsetenv(keyTeamSources)
+	}()
+
+	t.Run("test team bundles behind alpha feature gate", func(t *testing.T) {
+		teamSources, err := teamBundleSources(ctx, clientSet, "payments")
+		assert.NoError(t, err)
+		assert.Empty(t, teamSources)
+	})
+
+	_ = os.Setenv(keyFeatureGates, featureTeamCABundles+"=true")
+	defer func() {
+		_ = os.Unsetenv(keyFeatureGates)
+	}()
+
+	t.Run("test allowed team sources", func(t *testing.T) {
+		refused := teamSourcesRefused.get("payments")
+		teamSources, err := teamBundleSources(ctx, clientSet, "payments")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{sources.URL + "/partners.pem"}, teamSources)
+		assert.Equal(t, refused+2, teamSourcesRefused.get("payments"))
+	})
+
+	t.Run("test merge team bundles", func(t *testing.T) {
+		bundle, err := withTeamBundles(ctx, clientSet, "payments", cluster)
+		assert.NoError(t, err)
+		certificates, err := parseCertificates(bundle)
+		assert.NoError(t, err)
+		assert.Len(t, certificates, 2)
+		assert.Equal(t, "cluster", certificates[0].Subject.CommonName)
+		assert.Equal(t, "payments", certificates[1].Subject.CommonName)
+	})
+
+	t.Run("test namespace without team bundles", func(t *testing.T) {
+		bundle, err := withTeamBundles(ctx, clientSet, "search", cluster)
+		assert.NoError(t, err)
+		assert.Equal(t, cluster, bundle)
+	})
+
+}
+
+func Test_AllowedTeamSource(t *testing.T) {
+
+	allowed := []string{"https://certs.example.com/team/"}
+
+	t.Run("test sources under the allowed location", func(t *testing.T) {
+		assert.True(t, allowedTeamSource("https://certs.example.com/team/roots.pem", allowed))
+		assert.True(t, allowedTeamSource("https://CERTS.example.com/team/sub/../roots.pem", allowed))
+	})
+
+	t.Run("test sources escaping the allowed location", func(t *testing.T) {
+		assert.False(t, allowedTeamSource("https://certs.example.com.evil.io/team/roots.pem", allowed))
+		assert.False(t, allowedTeamSource("https://certs.example.com/team/../other/roots.pem", allowed))
+		assert.False(t, allowedTeamSource("https://certs.example.com/team/%2e%2e/other/roots.pem", allowed))
+		assert.False(t, allowedTeamSource("https://certs.example.com/teams/roots.pem", []string{"https://certs.example.com/team"}))
+		assert.False(t, allowedTeamSource("http://certs.example.com/team/roots.pem", allowed))
+		assert.False(t, allowedTeamSource("https://certs.example.com:8443/team/roots.pem", allowed))
+	})
+
+}
@@ -0,0 +1,168 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+// Package testing runs the injector in-process against a fake clientset
+// and a stub bundle server, with factories for canned admission reviews
+package testing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	kac "github.com/nodis-com-br/kac-ca-injector/pkg"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+)
+
+const (
+	// Annotation is the injection annotation set by the harness
+	Annotation = "example.com/ca-injector"
+	// ConfigMapName is the managed ConfigMap name set by the harness
+	ConfigMapName = "ca-bundle"
+	// Filename is the bundle filename set by the harness
+	Filename = "ca_bundle.pem"
+	// Namespace is the injector namespace set by the harness
+	Namespace = "example"
+)
+
+var (
+	// PodsGVR is the resource of pod admission reviews
+	PodsGVR = metav1.GroupVersionResource{Version: "v1", Resource: "pods"}
+	// ConfigMapsGVR is the resource of configmap admission reviews
+	ConfigMapsGVR = metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+)
+
+// Harness serves the injector router with a shared fake clientset and a
+// stub bundle server configured as the upstream bundle url
+type Harness struct {
+	Router       *gin.Engine
+	ClientSet    *fake.Clientset
+	BundleServer *httptest.Server
+	Bundle       []byte
+
+	ctx      context.Context
+	previous map[string]*string
+}
+
+// NewHarness configures the injector environment and starts the stub bundle
+// server; objects are preloaded into the fake clientset. Close restores the
+// environment
+func NewHarness(objects ...runtime.Object) *Harness {
+	h := &Harness{
+		Router:    kac.NewRouter(),
+		ClientSet: fake.NewSimpleClientset(objects...),
+		Bundle:    fixtures.Certificate("harness-root", time.Now().AddDate(1, 0, 0)),
+		previous:  map[string]*string{},
+	}
+	h.BundleServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		_, _ = w.Write(h.Bundle)
+	}))
+	h.ctx = kac.WithClientSet(context.Background(), h.ClientSet)
+	h.Setenv("CA_BUNDLE_ANNOTATION", Annotation)
+	h.Setenv("CA_BUNDLE_CONFIGMAP", ConfigMapName)
+	h.Setenv("CA_BUNDLE_FILENAME", Filename)
+	h.Setenv("CA_BUNDLE_URL", h.BundleServer.URL)
+	h.Setenv("POD_NAMESPACE", Namespace)
+	return h
+}
+
+// Setenv sets an injector environment variable until the harness is closed
+func (h *Harness) Setenv(key string, value string) {
+	if _, ok := h.previous[key]; !ok {
+		if current, ok := os.LookupEnv(key); ok {
+			h.previous[key] = &current
+		} else {
+			h.previous[key] = nil
+		}
+	}
+	_ = os.Setenv(key, value)
+}
+
+// Close stops the stub bundle server and restores the environment
+func (h *Harness) Close() {
+	h.BundleServer.Close()
+	for key, value := range h.previous {
+		if value == nil {
+			_ = os.Unsetenv(key)
+		} else {
+			_ = os.Setenv(key, *value)
+		}
+	}
+}
+
+// Do sends a request to the router
+func (h *Harness) Do(method string, route string, body []byte) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, route, bytes.NewReader(body)).WithContext(h.ctx)
+	h.Router.ServeHTTP(w, req)
+	return w
+}
+
+// Review posts an encoded admission review to route and decodes the answer
+func (h *Harness) Review(route string, review []byte) (*admissionv1.AdmissionReview, int) {
+	w := h.Do(http.MethodPost, route, review)
+	response := &admissionv1.AdmissionReview{}
+	_ = json.Unmarshal(w.Body.Bytes(), response)
+	return response, w.Code
+}
+
+// Pod returns a pod with the named containers, carrying the injection
+// annotation when annotated is true
+func Pod(namespace string, annotated bool, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: namespace},
+	}
+	if annotated {
+		pod.Annotations = map[string]string{Annotation: "true"}
+	}
+	for _, name := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: name, Image: name})
+	}
+	return pod
+}
+
+// AdmissionReview returns an encoded admission review of obj; the object is
+// sent as the old object of DELETE operations
+func AdmissionReview(operation admissionv1.Operation, gvr metav1.GroupVersionResource, obj runtime.Object) []byte {
+	encoded, _ := json.Marshal(obj)
+	request := &admissionv1.AdmissionRequest{
+		UID:       types.UID("00000000-0000-0000-0000-000000000000"),
+		Operation: operation,
+		Resource:  gvr,
+	}
+	if operation == admissionv1.Delete {
+		request.OldObject = runtime.RawExtension{Raw: encoded}
+	} else {
+		request.Object = runtime.RawExtension{Raw: encoded}
+	}
+	if accessor, ok := obj.(metav1.Object); ok {
+		request.Namespace = accessor.GetNamespace()
+		request.Name = accessor.GetName()
+	}
+	review, _ := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  request,
+	})
+	return review
+}
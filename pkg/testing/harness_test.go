@@ -0,0 +1,44 @@
+package testing_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"testing"
+
+	kactesting "github.com/nodis-com-br/kac-ca-injector/pkg/testing"
+)
+
+func Test_Harness(t *testing.T) {
+
+	h := kactesting.NewHarness()
+	defer h.Close()
+
+	t.Run("test bundle route", func(t *testing.T) {
+		w := h.Do(http.MethodGet, "/bundle", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, h.Bundle, w.Body.Bytes())
+	})
+
+	t.Run("test mutate annotated pod", func(t *testing.T) {
+		review := kactesting.AdmissionReview(admissionv1.Create, kactesting.PodsGVR, kactesting.Pod(kactesting.Namespace, true, "app"))
+		response, code := h.Review("/mutate", review)
+		assert.Equal(t, http.StatusOK, code)
+		assert.True(t, response.Response.Allowed)
+		assert.NotEmpty(t, response.Response.Patch)
+		configMap, err := h.ClientSet.CoreV1().ConfigMaps(kactesting.Namespace).Get(context.Background(), kactesting.ConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, string(h.Bundle), configMap.Data[kactesting.Filename])
+	})
+
+	t.Run("test mutate unannotated pod", func(t *testing.T) {
+		review := kactesting.AdmissionReview(admissionv1.Create, kactesting.PodsGVR, kactesting.Pod(kactesting.Namespace, false, "app"))
+		response, code := h.Review("/mutate", review)
+		assert.Equal(t, http.StatusOK, code)
+		assert.True(t, response.Response.Allowed)
+		assert.Empty(t, response.Response.Patch)
+	})
+
+}
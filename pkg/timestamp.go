@@ -0,0 +1,464 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	keyCABundleTSAURL = "CA_BUNDLE_TSA_URL"
+
+	keyCABundleTSACAFile = "CA_BUNDLE_TSA_CA_FILE"
+
+	timestampAnnotationSuffix     = "-timestamp"
+	timestampTimeAnnotationSuffix = "-timestamp-time"
+
+	timestampRetryInterval = time.Minute
+	// timestampEntries bounds the stamps and failures kept, bundles
+	// merged with team bundles having a revision per namespace
+	timestampEntries = 1024
+)
+
+var (
+	oidSHA256  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidTSTInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidRSASSAPSS     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+
+	// timestampHashes are the digest algorithms accepted from signers
+	timestampHashes = map[string]crypto.Hash{
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+		"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+		"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+	}
+
+	timestampsMutex sync.Mutex
+	// timestamps holds the encoded token and time of the recently stamped
+	// bundles
+	timestamps = newBoundedLRUCache(timestampEntries)
+	// timestampRequests are closed when the request in flight for the
+	// bundle hash completes
+	timestampRequests = map[string]chan struct{}{}
+	// timestampFailures holds the time of the last failed request of the
+	// recent bundle hashes, which are retried after timestampRetryInterval
+	timestampFailures = newBoundedLRUCache(timestampEntries)
+
+	bundleTimestampFailures = newMetric(metricTypeCounter, "kac_bundle_timestamp_failures_total", "Number of bundle revisions left without trusted timestamp")
+)
+
+type tsaMessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+// tsaRequest is the TimeStampReq of RFC 3161
+type tsaRequest struct {
+	Version        int
+	MessageImprint tsaMessageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+// tsaResponse is the TimeStampResp of RFC 3161
+type tsaResponse struct {
+	Status struct {
+		Status int
+	}
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type tsaEncapsulatedContent struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// tsaSignedData is the cms SignedData of a timestamp token, with the
+// TSTInfo it encapsulates
+type tsaSignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      tsaEncapsulatedContent
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []tsaSignerInfo `asn1:"set"`
+}
+
+type tsaSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type tsaAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// tsaInfo is the start of the TSTInfo signed by the authority
+type tsaInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint tsaMessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time   `asn1:"generalized"`
+	Accuracy       tsaAccuracy `asn1:"optional"`
+	Ordering       bool        `asn1:"optional"`
+	Nonce          *big.Int    `asn1:"optional"`
+}
+
+type tsaAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// timestampAnnotations returns the annotations proving the bundle existed
+// at the time certified by the RFC 3161 authority of CA_BUNDLE_TSA_URL.
+// The token is kept base64 encoded for offline verification, e.g. with
+// openssl ts -verify. It never waits for the authority: bundles not
+// stamped yet are stamped in the background and get no annotations, so
+// that neither admissions nor a failing authority hold back the bundle
+func timestampAnnotations(bundle []byte) map[string]string {
+	if os.Getenv(keyCABundleTSAURL) == "" {
+		return nil
+	}
+	hash := bundleHash(bundle)
+	timestampsMutex.Lock()
+	stamp, ok := timestamps.get(hash)
+	_, inFlight := timestampRequests[hash]
+	failed, _ := timestampFailures.get(hash)
+	timestampsMutex.Unlock()
+	if !ok {
+		if failedAt, _ := failed.(time.Time); !inFlight && time.Since(failedAt) >= timestampRetryInterval {
+			go stampBundle(context.Background(), bundle)
+		}
+		return nil
+	}
+	return stampAnnotations(stamp.([2]string))
+}
+
+// setTimestampAnnotations replaces the timestamp annotations of a bundle
+// object with the ones of its new bundle, so that a stale proof never
+// describes another bundle
+func setTimestampAnnotations(annotations map[string]string, bundle []byte) map[string]string {
+	config, _ := currentConfig()
+	annotation := config.Annotation
+	delete(annotations, annotation+timestampAnnotationSuffix)
+	delete(annotations, annotation+timestampTimeAnnotationSuffix)
+	for key, value := range timestampAnnotations(bundle) {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[key] = value
+	}
+	return annotations
+}
+
+func stampAnnotations(stamp [2]string) map[string]string {
+	config, _ := currentConfig()
+	annotation := config.Annotation
+	return map[string]string{
+		annotation + timestampAnnotationSuffix:     stamp[0],
+		annotation + timestampTimeAnnotationSuffix: stamp[1],
+	}
+}
+
+// stampBundle obtains the timestamp of a bundle revision once, waiting
+// for the request already in flight if any. The refresh stamps the new
+// revisions before storing them, so that they carry their proof from
+// their first write
+func stampBundle(ctx context.Context, bundle []byte) bool {
+	tsaURL := os.Getenv(keyCABundleTSAURL)
+	if tsaURL == "" {
+		return false
+	}
+	hash := bundleHash(bundle)
+	timestampsMutex.Lock()
+	if _, ok := timestamps.get(hash); ok {
+		timestampsMutex.Unlock()
+		return true
+	}
+	done, inFlight := timestampRequests[hash]
+	if !inFlight {
+		done = make(chan struct{})
+		timestampRequests[hash] = done
+	}
+	timestampsMutex.Unlock()
+
+	if inFlight {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return false
+		}
+		_, ok := timestamps.get(hash)
+		return ok
+	}
+
+	token, genTime, err := requestTimestamp(ctx, bundleHTTPClient(), tsaURL, bundle)
+	timestampsMutex.Lock()
+	defer timestampsMutex.Unlock()
+	delete(timestampRequests, hash)
+	close(done)
+	if err != nil {
+		log.Printf("Unable to timestamp ca bundle %s: %v", hash, err)
+		bundleTimestampFailures.inc()
+		timestampFailures.add(hash, time.Now())
+		return false
+	}
+	timestampFailures.remove(hash)
+	timestamps.add(hash, [2]string{base64.StdEncoding.EncodeToString(token), genTime.UTC().Format(time.RFC3339)})
+	return true
+}
+
+// pruneTimestamps drops the stamps and failures of the bundles rotated
+// out, keeping the ones of the current bundle hashes
+func pruneTimestamps(current map[string]bool) {
+	keep := func(hash string) bool { return current[hash] }
+	timestamps.retain(keep)
+	timestampFailures.retain(keep)
+}
+
+// requestTimestamp obtains a timestamp token for the sha256 digest of
+// content, checking that the token certifies that digest and is signed by
+// a timestamping authority trusted by timestampRoots
+func requestTimestamp(ctx context.Context, client *http.Client, tsaURL string, content []byte) ([]byte, time.Time, error) {
+
+	digest := sha256.Sum256(content)
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	imprint := tsaMessageImprint{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+		HashedMessage: digest[:],
+	}
+	query, err := asn1.Marshal(tsaRequest{Version: 1, MessageImprint: imprint, Nonce: nonce, CertReq: true})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tsaURL, bytes.NewReader(query))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/timestamp-query")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, upstreamStatusError{url: tsaURL, code: resp.StatusCode}
+	}
+
+	var response tsaResponse
+	if _, err := asn1.Unmarshal(body, &response); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid timestamp response: %w", err)
+	} else if response.Status.Status > 1 {
+		return nil, time.Time{}, fmt.Errorf("timestamp request rejected with status %d", response.Status.Status)
+	}
+	roots, err := timestampRoots()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := verifyTimestampToken(response.TimeStampToken.FullBytes, roots)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid timestamp token: %w", err)
+	} else if !bytes.Equal(info.MessageImprint.HashedMessage, digest[:]) || info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, time.Time{}, fmt.Errorf("timestamp token doesn't match the request")
+	}
+	return response.TimeStampToken.FullBytes, info.GenTime, nil
+
+}
+
+// parseTimestampToken extracts the TSTInfo of a timestamp token, which is
+// a pkcs7 signed data, without verifying it
+func parseTimestampToken(token []byte) (*tsaInfo, error) {
+	info, _, _, err := decodeTimestampToken(token)
+	return info, err
+}
+
+// verifyTimestampToken extracts the TSTInfo of a timestamp token after
+// checking the signature of its signer over the signed attributes, the
+// digest of the TSTInfo within them, and the chain of the signer to roots
+// at the time certified
+func verifyTimestampToken(token []byte, roots *x509.CertPool) (*tsaInfo, error) {
+	info, signedData, content, err := decodeTimestampToken(token)
+	if err != nil {
+		return nil, err
+	} else if len(signedData.SignerInfos) != 1 {
+		return nil, fmt.Errorf("expected a single signer, found %d", len(signedData.SignerInfos))
+	}
+	signer := signedData.SignerInfos[0]
+	certificates, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signer certificates: %w", err)
+	}
+	certificate := timestampSigner(signer.SID, certificates)
+	if certificate == nil {
+		return nil, fmt.Errorf("signer certificate not found in token")
+	}
+	hash, ok := timestampHashes[signer.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", signer.DigestAlgorithm.Algorithm)
+	}
+
+	if len(signer.SignedAttrs.FullBytes) == 0 {
+		return nil, fmt.Errorf("signed attributes missing")
+	}
+	signedAttrs := append([]byte{}, signer.SignedAttrs.FullBytes...)
+	signedAttrs[0] = 0x31
+	var attributes []tsaAttribute
+	if _, err := asn1.UnmarshalWithParams(signedAttrs, &attributes, "set"); err != nil {
+		return nil, fmt.Errorf("invalid signed attributes: %w", err)
+	}
+	var contentType asn1.ObjectIdentifier
+	var messageDigest []byte
+	for _, attribute := range attributes {
+		switch {
+		case attribute.Type.Equal(oidContentType):
+			_, err = asn1.Unmarshal(attribute.Values.Bytes, &contentType)
+		case attribute.Type.Equal(oidMessageDigest):
+			_, err = asn1.Unmarshal(attribute.Values.Bytes, &messageDigest)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid signed attribute %s: %w", attribute.Type, err)
+		}
+	}
+	digest := hash.New()
+	digest.Write(content)
+	if !contentType.Equal(oidTSTInfo) || !bytes.Equal(messageDigest, digest.Sum(nil)) {
+		return nil, fmt.Errorf("signed attributes don't match the token content")
+	}
+	if err := certificate.CheckSignature(timestampSignatureAlgorithm(certificate.PublicKeyAlgorithm, hash, signer.SignatureAlgorithm.Algorithm), signedAttrs, signer.Signature); err != nil {
+		return nil, fmt.Errorf("invalid token signature: %w", err)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certificates {
+		intermediates.AddCert(c)
+	}
+	if _, err := certificate.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   info.GenTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}); err != nil {
+		return nil, fmt.Errorf("untrusted timestamping authority: %w", err)
+	}
+	return info, nil
+}
+
+// decodeTimestampToken decodes the signed data of a timestamp token, and
+// the TSTInfo it signs along with its encoding
+func decodeTimestampToken(token []byte) (*tsaInfo, *tsaSignedData, []byte, error) {
+	var contentInfo pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(token, &contentInfo); err != nil {
+		return nil, nil, nil, err
+	} else if !contentInfo.ContentType.Equal(oidPKCS7SignedData) {
+		return nil, nil, nil, fmt.Errorf("unsupported content type %s", contentInfo.ContentType)
+	}
+	var signedData tsaSignedData
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, nil, nil, err
+	} else if !signedData.ContentInfo.ContentType.Equal(oidTSTInfo) {
+		return nil, nil, nil, fmt.Errorf("unsupported signed content type %s", signedData.ContentInfo.ContentType)
+	}
+	var content []byte
+	if _, err := asn1.Unmarshal(signedData.ContentInfo.Content.Bytes, &content); err != nil {
+		return nil, nil, nil, err
+	}
+	var info tsaInfo
+	if _, err := asn1.Unmarshal(content, &info); err != nil {
+		return nil, nil, nil, err
+	}
+	return &info, &signedData, content, nil
+}
+
+// timestampSigner finds the certificate of the signer identified by sid,
+// either by issuer and serial number or by subject key identifier
+func timestampSigner(sid asn1.RawValue, certificates []*x509.Certificate) *x509.Certificate {
+	var issuerAndSerial struct {
+		Issuer       asn1.RawValue
+		SerialNumber *big.Int
+	}
+	for _, certificate := range certificates {
+		if sid.Class == asn1.ClassContextSpecific && sid.Tag == 0 {
+			if bytes.Equal(certificate.SubjectKeyId, sid.Bytes) {
+				return certificate
+			}
+		} else if _, err := asn1.Unmarshal(sid.FullBytes, &issuerAndSerial); err == nil &&
+			bytes.Equal(certificate.RawIssuer, issuerAndSerial.Issuer.FullBytes) && certificate.SerialNumber.Cmp(issuerAndSerial.SerialNumber) == 0 {
+			return certificate
+		}
+	}
+	return nil
+}
+
+// timestampSignatureAlgorithm returns the x509 algorithm of a signer
+// signature, which cms identifies by its digest and its key algorithm
+func timestampSignatureAlgorithm(key x509.PublicKeyAlgorithm, hash crypto.Hash, signatureAlgorithm asn1.ObjectIdentifier) x509.SignatureAlgorithm {
+	switch key {
+	case x509.RSA:
+		if signatureAlgorithm.Equal(oidRSASSAPSS) {
+			return map[crypto.Hash]x509.SignatureAlgorithm{crypto.SHA256: x509.SHA256WithRSAPSS, crypto.SHA384: x509.SHA384WithRSAPSS, crypto.SHA512: x509.SHA512WithRSAPSS}[hash]
+		}
+		return map[crypto.Hash]x509.SignatureAlgorithm{crypto.SHA256: x509.SHA256WithRSA, crypto.SHA384: x509.SHA384WithRSA, crypto.SHA512: x509.SHA512WithRSA}[hash]
+	case x509.ECDSA:
+		return map[crypto.Hash]x509.SignatureAlgorithm{crypto.SHA256: x509.ECDSAWithSHA256, crypto.SHA384: x509.ECDSAWithSHA384, crypto.SHA512: x509.ECDSAWithSHA512}[hash]
+	case x509.Ed25519:
+		return x509.PureEd25519
+	}
+	return x509.UnknownSignatureAlgorithm
+}
+
+// timestampRoots returns the authorities trusted to sign timestamps, read
+// from CA_BUNDLE_TSA_CA_FILE or else the system roots
+func timestampRoots() (*x509.CertPool, error) {
+	caFile := os.Getenv(keyCABundleTSACAFile)
+	if caFile == "" {
+		return x509.SystemCertPool()
+	}
+	content, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", keyCABundleTSACAFile, err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificates found in %s %s", keyCABundleTSACAFile, caFile)
+	}
+	return roots, nil
+}
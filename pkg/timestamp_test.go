@@ -0,0 +1,208 @@
+package kac
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tsaAuthorityFactory returns a root and a timestamping certificate it
+// issued, with the key of the latter
+func tsaAuthorityFactory() ([]byte, *x509.Certificate, *ecdsa.PrivateKey) {
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	root := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tsa root"},
+		NotBefore:             time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, _ := x509.CreateCertificate(rand.Reader, root, root, &rootKey.PublicKey, rootKey)
+	root, _ = x509.ParseCertificate(rootDER)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "tsa"},
+		NotBefore:    root.NotBefore,
+		NotAfter:     root.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}, root, &key.PublicKey, rootKey)
+	certificate, _ := x509.ParseCertificate(der)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER}), certificate, key
+}
+
+// tsaFactory answers timestamp requests with tokens certifying genTime,
+// signed by the certificate and key. Tamper alters the nonce, forge the
+// content after signing
+func tsaFactory(genTime time.Time, certificate *x509.Certificate, key *ecdsa.PrivateKey, tamper bool, forge bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var req tsaRequest
+		if _, err := asn1.Unmarshal(body, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if tamper {
+			req.Nonce = new(big.Int).Add(req.Nonce, big.NewInt(1))
+		}
+		info, _ := asn1.Marshal(tsaInfo{
+			Version:        1,
+			Policy:         asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1},
+			MessageImprint: req.MessageImprint,
+			SerialNumber:   big.NewInt(1),
+			GenTime:        genTime,
+			Nonce:          req.Nonce,
+		})
+		digest := sha256.Sum256(info)
+		attribute := func(oid asn1.ObjectIdentifier, value interface{}) tsaAttribute {
+			encoded, _ := asn1.Marshal(value)
+			return tsaAttribute{Type: oid, Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: encoded}}
+		}
+		signedAttrs, _ := asn1.MarshalWithParams([]tsaAttribute{
+			attribute(oidContentType, oidTSTInfo),
+			attribute(oidMessageDigest, digest[:]),
+		}, "set")
+		signature, _ := ecdsa.SignASN1(rand.Reader, key, func() []byte { d := sha256.Sum256(signedAttrs); return d[:] }())
+		if forge {
+			info, _ = asn1.Marshal(tsaInfo{Version: 1, Policy: asn1.ObjectIdentifier{1, 2}, MessageImprint: req.MessageImprint, SerialNumber: big.NewInt(2), GenTime: genTime.AddDate(-1, 0, 0), Nonce: req.Nonce})
+		}
+		var attrs asn1.RawValue
+		_, _ = asn1.Unmarshal(signedAttrs, &attrs)
+		sid, _ := asn1.Marshal(struct {
+			Issuer       asn1.RawValue
+			SerialNumber *big.Int
+		}{asn1.RawValue{FullBytes: certificate.RawIssuer}, certificate.SerialNumber})
+		octets, _ := asn1.Marshal(info)
+		signedData, _ := asn1.Marshal(tsaSignedData{
+			Version:          3,
+			DigestAlgorithms: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true},
+			ContentInfo:      tsaEncapsulatedContent{ContentType: oidTSTInfo, Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: octets}},
+			Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificate.Raw},
+			SignerInfos: []tsaSignerInfo{{
+				Version:            1,
+				SID:                asn1.RawValue{FullBytes: sid},
+				DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+				SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs.Bytes},
+				SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+				Signature:          signature,
+			}},
+		})
+		token, _ := asn1.Marshal(struct {
+			ContentType asn1.ObjectIdentifier
+			Content     asn1.RawValue
+		}{oidPKCS7SignedData, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData}})
+		response, _ := asn1.Marshal(struct {
+			Status struct {
+				Status int
+			}
+			TimeStampToken asn1.RawValue
+		}{TimeStampToken: asn1.RawValue{FullBytes: token}})
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		_, _ = w.Write(response)
+	}))
+}
+
+func Test_BundleTimestamp(t *testing.T) {
+
+	ctx := context.Background()
+	genTime := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	annotation := os.Getenv(keyCABundleAnnotation)
+	root, certificate, key := tsaAuthorityFactory()
+	rootFile := filepath.Join(t.TempDir(), "tsa.pem")
+	_ = os.WriteFile(rootFile, root, 0600)
+	_ = os.Setenv(keyCABundleTSACAFile, rootFile)
+	defer func() {
+		_ = os.Unsetenv(keyCABundleTSAURL)
+		_ = os.Unsetenv(keyCABundleTSACAFile)
+		timestamps = newBoundedLRUCache(timestampEntries)
+		timestampFailures = newBoundedLRUCache(timestampEntries)
+	}()
+
+	t.Run("test timestamp new bundle", func(t *testing.T) {
+		tsa := tsaFactory(genTime, certificate, key, false, false)
+		defer tsa.Close()
+		_ = os.Setenv(keyCABundleTSAURL, tsa.URL)
+
+		assert.True(t, stampBundle(ctx, bundle))
+		annotations := timestampAnnotations(bundle)
+		assert.Equal(t, "2022-07-01T12:00:00Z", annotations[annotation+timestampTimeAnnotationSuffix])
+		token, err := base64.StdEncoding.DecodeString(annotations[annotation+timestampAnnotationSuffix])
+		assert.NoError(t, err)
+		info, err := parseTimestampToken(token)
+		assert.NoError(t, err)
+		digest := sha256.Sum256(bundle)
+		assert.Equal(t, digest[:], info.MessageImprint.HashedMessage)
+	})
+
+	t.Run("test stamped bundle is cached", func(t *testing.T) {
+		_ = os.Setenv(keyCABundleTSAURL, "http://127.0.0.1:1")
+		assert.Equal(t, "2022-07-01T12:00:00Z", timestampAnnotations(bundle)[annotation+timestampTimeAnnotationSuffix])
+	})
+
+	t.Run("test unstamped bundle is stamped in the background", func(t *testing.T) {
+		tsa := tsaFactory(genTime, certificate, key, false, false)
+		defer tsa.Close()
+		_ = os.Setenv(keyCABundleTSAURL, tsa.URL)
+		pending := []byte("pending bundle")
+		assert.Nil(t, timestampAnnotations(pending))
+		for i := 0; i < 500 && timestampAnnotations(pending) == nil; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.NotNil(t, timestampAnnotations(pending))
+	})
+
+	t.Run("test invalid tokens", func(t *testing.T) {
+		for name, tsa := range map[string]*httptest.Server{
+			"tampered":  tsaFactory(genTime, certificate, key, true, false),
+			"forged":    tsaFactory(genTime, certificate, key, false, true),
+			"untrusted": tsaFactory(genTime.AddDate(10, 0, 0), certificate, key, false, false),
+		} {
+			_ = os.Setenv(keyCABundleTSAURL, tsa.URL)
+			failures := bundleTimestampFailures.get()
+			other := []byte(name + " bundle")
+			assert.False(t, stampBundle(ctx, other))
+			assert.Nil(t, timestampAnnotations(other))
+			assert.Equal(t, failures+1, bundleTimestampFailures.get())
+			tsa.Close()
+		}
+	})
+
+	t.Run("test stamps of rotated bundles are pruned", func(t *testing.T) {
+		_, ok := timestampFailures.get(bundleHash([]byte("tampered bundle")))
+		assert.True(t, ok)
+		pruneTimestamps(map[string]bool{bundleHash(bundle): true})
+		_, ok = timestamps.get(bundleHash(bundle))
+		assert.True(t, ok)
+		_, ok = timestamps.get(bundleHash([]byte("pending bundle")))
+		assert.False(t, ok)
+		_, ok = timestampFailures.get(bundleHash([]byte("tampered bundle")))
+		assert.False(t, ok)
+	})
+
+	t.Run("test stale timestamp is dropped", func(t *testing.T) {
+		annotations := setTimestampAnnotations(map[string]string{annotation + timestampAnnotationSuffix: "stale"}, []byte("other bundle"))
+		assert.NotContains(t, annotations, annotation+timestampAnnotationSuffix)
+	})
+
+}
@@ -0,0 +1,131 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	// Embedded so that zones resolve on images without zoneinfo
+	_ "time/tzdata"
+)
+
+const (
+	keyTimezone      = "CA_BUNDLE_TIMEZONE"
+	keyRefreshWindow = "CA_BUNDLE_REFRESH_WINDOW"
+
+	textLogTimeLayout = "2006/01/02 15:04:05 "
+)
+
+var (
+	locationMutex sync.RWMutex
+	location      = time.UTC
+)
+
+// loadReportingLocation resolves the IANA time zone of CA_BUNDLE_TIMEZONE,
+// in which times are reported and refresh windows are read. It defaults
+// to UTC
+func loadReportingLocation() (*time.Location, error) {
+	name := lookupSetting(keyTimezone)
+	loaded, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown %s %q: %w", keyTimezone, name, err)
+	}
+	return loaded, nil
+}
+
+// setReportingLocation sets the time zone returned by reportingLocation
+func setReportingLocation(loaded *time.Location) {
+	locationMutex.Lock()
+	defer locationMutex.Unlock()
+	location = loaded
+}
+
+// reportingLocation returns the time zone resolved by ConfigureLogging.
+// It never logs, since it is called by the log writers
+func reportingLocation() *time.Location {
+	locationMutex.RLock()
+	defer locationMutex.RUnlock()
+	return location
+}
+
+// formatTime renders t for logs, events and warnings in the reporting
+// time zone
+func formatTime(t time.Time) string {
+	return t.In(reportingLocation()).Format(time.RFC3339)
+}
+
+// textLogWriter prefixes every line with the time in the reporting time
+// zone, as the standard logger would in the local one
+type textLogWriter struct {
+	out      io.Writer
+	location *time.Location
+	now      func() time.Time
+}
+
+func (w textLogWriter) Write(p []byte) (int, error) {
+	now := time.Now
+	if w.now != nil {
+		now = w.now
+	}
+	if _, err := io.WriteString(w.out, now().In(w.location).Format(textLogTimeLayout)); err != nil {
+		return 0, err
+	}
+	return w.out.Write(p)
+}
+
+// timeWindow is a daily time range of the reporting time zone, which may
+// span midnight
+type timeWindow struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// parseTimeWindow parses HH:MM-HH:MM windows, an empty value allowing
+// any time
+func parseTimeWindow(value string) (*timeWindow, error) {
+	if value == "" {
+		return nil, nil
+	}
+	start, end, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", value)
+	}
+	var window timeWindow
+	for _, bound := range []struct {
+		value  string
+		offset *time.Duration
+	}{{start, &window.start}, {end, &window.end}} {
+		clock, err := time.Parse("15:04", strings.TrimSpace(bound.value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", value)
+		}
+		*bound.offset = time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute
+	}
+	return &window, nil
+}
+
+// contains tells whether t falls within the window, read in the reporting
+// time zone. Nil windows contain any time
+func (w *timeWindow) contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	local := t.In(reportingLocation())
+	offset := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	if w.start <= w.end {
+		return offset >= w.start && offset < w.end
+	}
+	return offset >= w.start || offset < w.end
+}
@@ -0,0 +1,61 @@
+package kac
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_Timezone(t *testing.T) {
+
+	_ = os.Setenv(keyTimezone, "America/Sao_Paulo")
+	defer func() {
+		_ = os.Unsetenv(keyTimezone)
+		setReportingLocation(time.UTC)
+	}()
+	saoPaulo, err := loadReportingLocation()
+	assert.NoError(t, err)
+	setReportingLocation(saoPaulo)
+	instant := time.Date(2022, 7, 1, 4, 30, 0, 0, time.UTC)
+
+	t.Run("test times are reported in the zone", func(t *testing.T) {
+		assert.Equal(t, "2022-07-01T01:30:00-03:00", formatTime(instant))
+	})
+
+	t.Run("test unknown zone is refused", func(t *testing.T) {
+		_ = os.Setenv(keyTimezone, "Mars/Olympus_Mons")
+		defer func() {
+			_ = os.Setenv(keyTimezone, "America/Sao_Paulo")
+		}()
+		assert.EqualError(t, ConfigureLogging(), `unknown CA_BUNDLE_TIMEZONE "Mars/Olympus_Mons": unknown time zone Mars/Olympus_Mons`)
+		assert.Equal(t, "2022-07-01T01:30:00-03:00", formatTime(instant))
+	})
+
+	t.Run("test text logs", func(t *testing.T) {
+		var output bytes.Buffer
+		writer := textLogWriter{out: &output, location: saoPaulo, now: func() time.Time { return instant }}
+		_, err := writer.Write([]byte("message\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, "2022/07/01 01:30:00 message\n", output.String())
+	})
+
+	t.Run("test refresh windows", func(t *testing.T) {
+		window, err := parseTimeWindow("01:00-03:00")
+		assert.NoError(t, err)
+		assert.True(t, window.contains(instant))
+		assert.False(t, window.contains(instant.Add(2*time.Hour)))
+
+		overnight, err := parseTimeWindow("22:00-02:00")
+		assert.NoError(t, err)
+		assert.True(t, overnight.contains(instant))
+		assert.False(t, overnight.contains(instant.Add(-6*time.Hour)))
+
+		var always *timeWindow
+		assert.True(t, always.contains(instant))
+		_, err = parseTimeWindow("1am-3am")
+		assert.Error(t, err)
+	})
+
+}
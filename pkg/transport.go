@@ -0,0 +1,134 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keyCABundleFetchCAFile = "CA_BUNDLE_FETCH_CA_FILE"
+	keyCABundleFetchProxy  = "CA_BUNDLE_FETCH_PROXY"
+	keyCABundleHostAliases = "CA_BUNDLE_HOST_ALIASES"
+)
+
+var (
+	fetchTransportMutex  sync.Mutex
+	fetchTransport       http.RoundTripper
+	fetchTransportConfig [3]string
+)
+
+// bundleTransport returns the transport reaching the bundle source. The
+// proxy is CA_BUNDLE_FETCH_PROXY, or else the one set by HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY. The certificates of CA_BUNDLE_FETCH_CA_FILE
+// are trusted on top of the system roots, for internal bundle servers
+// whose certificate is issued by the very bundle being fetched. Hosts
+// listed on CA_BUNDLE_HOST_ALIASES are dialed on their aliased address
+// instead of being resolved. The transport is shared until the settings
+// change, to reuse connections
+func bundleTransport() http.RoundTripper {
+	config := [3]string{os.Getenv(keyCABundleFetchCAFile), os.Getenv(keyCABundleFetchProxy), os.Getenv(keyCABundleHostAliases)}
+	if config == [3]string{} {
+		return http.DefaultTransport
+	}
+	fetchTransportMutex.Lock()
+	defer fetchTransportMutex.Unlock()
+	if fetchTransport == nil || fetchTransportConfig != config {
+		transport, err := newBundleTransport(config[0], config[1], config[2])
+		if err != nil {
+			return errorTransport{err}
+		}
+		fetchTransport, fetchTransportConfig = transport, config
+	}
+	return fetchTransport
+}
+
+func newBundleTransport(caFile string, proxy string, hostAliases string) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if hostAliases != "" {
+		aliases, err := parseHostAliases(hostAliases)
+		if err != nil {
+			return nil, err
+		}
+		transport.DialContext = aliasedDialer(aliases)
+	}
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid %s %q", keyCABundleFetchProxy, proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if caFile != "" {
+		content, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", keyCABundleFetchCAFile, err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificates found in %s %s", keyCABundleFetchCAFile, caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	return transport, nil
+}
+
+// parseHostAliases parses a comma separated list of host=ip overrides
+func parseHostAliases(value string) (map[string]string, error) {
+	aliases := map[string]string{}
+	for _, item := range splitList(value) {
+		host, ip, ok := strings.Cut(item, "=")
+		host, ip = strings.ToLower(strings.TrimSpace(host)), strings.TrimSpace(ip)
+		if !ok || host == "" || net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid %s entry %q, expected host=ip", keyCABundleHostAliases, item)
+		}
+		aliases[host] = ip
+	}
+	return aliases, nil
+}
+
+// aliasedDialer dials aliased hosts on their address, leaving the request
+// host untouched so that tls verification still checks the host name
+func aliasedDialer(aliases map[string]string) func(context.Context, string, string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			if ip, ok := aliases[strings.ToLower(host)]; ok {
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// errorTransport fails every request with the error that prevented the
+// transport from being configured
+type errorTransport struct {
+	err error
+}
+
+func (t errorTransport) RoundTrip(_ *http.Request) (*http.Response, error) {
+	return nil, t.err
+}
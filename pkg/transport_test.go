@@ -0,0 +1,84 @@
+package kac
+
+import (
+	"context"
+	"encoding/pem"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_BundleTransport(t *testing.T) {
+
+	ctx := context.Background()
+	bundle := fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))
+	_ = os.Setenv(keyCABundleFetchAttempts, "1")
+	defer func() {
+		_ = os.Unsetenv(keyCABundleFetchAttempts)
+		_ = os.Unsetenv(keyCABundleFetchCAFile)
+		_ = os.Unsetenv(keyCABundleFetchProxy)
+		_ = os.Unsetenv(keyCABundleHostAliases)
+	}()
+
+	t.Run("test internal root ca", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(bundle)
+		}))
+		defer server.Close()
+		_, _, err := fetchBundle(ctx, bundleHTTPClient(), server.URL)
+		assert.Error(t, err)
+
+		caFile := filepath.Join(t.TempDir(), "ca.crt")
+		assert.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+		_ = os.Setenv(keyCABundleFetchCAFile, caFile)
+		fetched, _, err := fetchBundle(ctx, bundleHTTPClient(), server.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, bundle, fetched)
+
+		_ = os.Setenv(keyCABundleFetchCAFile, filepath.Join(t.TempDir(), "missing.crt"))
+		_, _, err = fetchBundle(ctx, bundleHTTPClient(), server.URL)
+		assert.Contains(t, err.Error(), "unable to read CA_BUNDLE_FETCH_CA_FILE")
+		_ = os.Unsetenv(keyCABundleFetchCAFile)
+	})
+
+	t.Run("test proxy", func(t *testing.T) {
+		var proxied string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxied = r.URL.String()
+			_, _ = w.Write(bundle)
+		}))
+		defer proxy.Close()
+		_ = os.Setenv(keyCABundleFetchProxy, proxy.URL)
+		fetched, _, err := fetchBundle(ctx, bundleHTTPClient(), "http://bundle.internal/ca.pem")
+		assert.NoError(t, err)
+		assert.Equal(t, bundle, fetched)
+		assert.Equal(t, "http://bundle.internal/ca.pem", proxied)
+		_ = os.Unsetenv(keyCABundleFetchProxy)
+	})
+
+	t.Run("test host aliases", func(t *testing.T) {
+		var host string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host = r.Host
+			_, _ = w.Write(bundle)
+		}))
+		defer server.Close()
+		_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+		_ = os.Setenv(keyCABundleHostAliases, "pki.corp.invalid=127.0.0.1")
+		fetched, _, err := fetchBundle(ctx, bundleHTTPClient(), "http://pki.corp.invalid:"+port+"/ca.pem")
+		assert.NoError(t, err)
+		assert.Equal(t, bundle, fetched)
+		assert.Equal(t, "pki.corp.invalid:"+port, host)
+
+		_ = os.Setenv(keyCABundleHostAliases, "pki.corp.invalid=not-an-ip")
+		_, _, err = fetchBundle(ctx, bundleHTTPClient(), server.URL)
+		assert.Contains(t, err.Error(), `invalid CA_BUNDLE_HOST_ALIASES entry "pki.corp.invalid=not-an-ip"`)
+	})
+
+}
@@ -0,0 +1,193 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	provenanceSourceClusterTrustBundle = "clustertrustbundle"
+	provenanceSourceTrustBundle        = "trustbundle"
+
+	clusterTrustBundlesPath = "/apis/certificates.k8s.io/%s/clustertrustbundles"
+	trustManagerBundlesPath = "/apis/trust.cert-manager.io/v1alpha1/bundles"
+)
+
+// clusterTrustBundleVersions are the served versions of the
+// ClusterTrustBundle api, newest first, as it is still in beta
+var clusterTrustBundleVersions = []string{"v1beta1", "v1alpha1"}
+
+// clusterTrustBundle is the part of a certificates.k8s.io ClusterTrustBundle
+// read by the injector
+type clusterTrustBundle struct {
+	Spec struct {
+		SignerName  string `json:"signerName"`
+		TrustBundle string `json:"trustBundle"`
+	} `json:"spec"`
+}
+
+type clusterTrustBundleList struct {
+	Items []clusterTrustBundle `json:"items"`
+}
+
+// trustManagerBundle is the part of a trust.cert-manager.io Bundle read by
+// the injector, which names the key of the objects it writes the bundle to
+type trustManagerBundle struct {
+	Spec struct {
+		Target struct {
+			ConfigMap *struct {
+				Key string `json:"key"`
+			} `json:"configMap"`
+			Secret *struct {
+				Key string `json:"key"`
+			} `json:"secret"`
+		} `json:"target"`
+	} `json:"spec"`
+}
+
+// trustBundleSource reads the trust anchors published in cluster by the
+// ClusterTrustBundle api or by cert-manager's trust-manager, so that the
+// injector distributes them instead of keeping its own copy
+type trustBundleSource struct {
+	scheme string
+	uri    string
+	name   string
+	// signerName and labelSelector select the ClusterTrustBundles to
+	// concatenate when no name is given
+	signerName    string
+	labelSelector string
+}
+
+// newTrustBundleSource parses clustertrustbundle://name,
+// clustertrustbundle://?signerName=...&labelSelector=... and
+// trustbundle://name references
+func newTrustBundleSource(scheme string, uri string) (BundleSource, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ca bundle source %q: %w", uri, err)
+	}
+	source := trustBundleSource{
+		scheme:        scheme,
+		uri:           uri,
+		name:          parsed.Host,
+		signerName:    parsed.Query().Get("signerName"),
+		labelSelector: parsed.Query().Get("labelSelector"),
+	}
+	if scheme == provenanceSourceTrustBundle && source.name == "" {
+		return nil, fmt.Errorf("invalid ca bundle source %q, expected %s://name", uri, scheme)
+	} else if source.name == "" && source.signerName == "" && source.labelSelector == "" {
+		return nil, fmt.Errorf("invalid ca bundle source %q, expected a name, signerName or labelSelector", uri)
+	}
+	return source, nil
+}
+
+func (s trustBundleSource) Load(ctx context.Context) ([]byte, *BundleProvenance, error) {
+	clientSet, err := getKubernetesClientSet(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.scheme == provenanceSourceTrustBundle {
+		return s.loadTrustManagerBundle(ctx, clientSet)
+	}
+	bundles, err := s.clusterTrustBundles(ctx, clientSet)
+	if err != nil {
+		return nil, nil, err
+	}
+	var pems []string
+	for _, b := range bundles {
+		if s.signerName == "" || b.Spec.SignerName == s.signerName {
+			pems = append(pems, b.Spec.TrustBundle)
+		}
+	}
+	if len(pems) == 0 {
+		return nil, nil, fmt.Errorf("no clustertrustbundle matches %s", s.uri)
+	}
+	bundle, err := parseBundle("", []byte(strings.Join(pems, "\n")))
+	if err != nil {
+		return nil, nil, err
+	}
+	return bundle, &BundleProvenance{Source: s.scheme, URI: s.uri, Format: formatPEM}, nil
+}
+
+// clusterTrustBundles gets the named ClusterTrustBundle, or lists the ones
+// matching the label selector, from the first served api version
+func (s trustBundleSource) clusterTrustBundles(ctx context.Context, clientSet kubernetes.Interface) ([]clusterTrustBundle, error) {
+	var err error
+	for _, version := range clusterTrustBundleVersions {
+		segments := []string{fmt.Sprintf(clusterTrustBundlesPath, version)}
+		if s.name != "" {
+			segments = append(segments, s.name)
+		}
+		request := clientSet.Discovery().RESTClient().Get().AbsPath(segments...)
+		if s.name == "" && s.labelSelector != "" {
+			request = request.Param("labelSelector", s.labelSelector)
+		}
+		var raw []byte
+		if raw, err = request.DoRaw(ctx); apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if s.name != "" {
+			var bundle clusterTrustBundle
+			if err := json.Unmarshal(raw, &bundle); err != nil {
+				return nil, err
+			}
+			return []clusterTrustBundle{bundle}, nil
+		}
+		var list clusterTrustBundleList
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}
+	return nil, err
+}
+
+// loadTrustManagerBundle reads the bundle trust-manager writes to the
+// injector's namespace for the named Bundle
+func (s trustBundleSource) loadTrustManagerBundle(ctx context.Context, clientSet kubernetes.Interface) ([]byte, *BundleProvenance, error) {
+	raw, err := clientSet.Discovery().RESTClient().Get().AbsPath(trustManagerBundlesPath, s.name).DoRaw(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var bundle trustManagerBundle
+	if err := json.Unmarshal(raw, &bundle); err != nil {
+		return nil, nil, err
+	}
+	config, _ := currentConfig()
+	target := objectSource{namespace: config.PodNamespace, name: s.name}
+	if target.namespace == "" {
+		return nil, nil, fmt.Errorf("%s must be set to read trust-manager bundles", keyPodNamespace)
+	} else if t := bundle.Spec.Target; t.ConfigMap != nil {
+		target.kind, target.key = provenanceSourceConfigMap, t.ConfigMap.Key
+	} else if t.Secret != nil {
+		target.kind, target.key = provenanceSourceSecret, t.Secret.Key
+	} else {
+		return nil, nil, fmt.Errorf("trust-manager bundle %s has no target", s.name)
+	}
+	content, provenance, err := target.Load(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	provenance.Source = s.scheme
+	provenance.ResolvedURI = provenance.URI
+	provenance.URI = s.uri
+	return content, provenance, nil
+}
@@ -0,0 +1,102 @@
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_TrustBundleSources(t *testing.T) {
+
+	internal := fixtures.Certificate("internal", time.Now().AddDate(1, 0, 0))
+	partner := fixtures.Certificate("partner", time.Now().AddDate(1, 0, 0))
+	trustBundle := func(signerName string, pem []byte) map[string]interface{} {
+		return map[string]interface{}{"spec": map[string]string{"signerName": signerName, "trustBundle": string(pem)}}
+	}
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body interface{}
+		switch r.URL.Path {
+		case "/apis/certificates.k8s.io/v1alpha1/clustertrustbundles":
+			assert.Equal(t, "team=platform", r.URL.Query().Get("labelSelector"))
+			body = map[string]interface{}{"items": []interface{}{
+				trustBundle("example.com/internal", internal),
+				trustBundle("example.com/partner", partner),
+			}}
+		case "/apis/certificates.k8s.io/v1alpha1/clustertrustbundles/internal-roots":
+			body = trustBundle("example.com/internal", internal)
+		case "/apis/trust.cert-manager.io/v1alpha1/bundles/corp-roots":
+			body = map[string]interface{}{"spec": map[string]interface{}{"target": map[string]interface{}{"configMap": map[string]string{"key": "roots.pem"}}}}
+		case "/api/v1/namespaces/kac/configmaps/corp-roots":
+			body = corev1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+				ObjectMeta: metav1.ObjectMeta{Name: "corp-roots", Namespace: "kac"},
+				Data:       map[string]string{"roots.pem": string(partner)},
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	defer apiServer.Close()
+	clientSet, err := kubernetes.NewForConfig(&rest.Config{Host: apiServer.URL})
+	assert.NoError(t, err)
+	ctx := WithClientSet(context.Background(), clientSet)
+
+	t.Run("test named clustertrustbundle", func(t *testing.T) {
+		source, err := newSingleSource("clustertrustbundle://internal-roots", true)
+		assert.NoError(t, err)
+		bundle, provenance, err := source.Load(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, internal, bundle)
+		assert.Equal(t, provenanceSourceClusterTrustBundle, provenance.Source)
+	})
+
+	t.Run("test clustertrustbundles by signer", func(t *testing.T) {
+		source, err := newSingleSource("clustertrustbundle://?signerName=example.com/partner&labelSelector=team=platform", true)
+		assert.NoError(t, err)
+		bundle, _, err := source.Load(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, partner, bundle)
+	})
+
+	t.Run("test missing clustertrustbundle", func(t *testing.T) {
+		source, _ := newSingleSource("clustertrustbundle://unknown", true)
+		_, _, err := source.Load(ctx)
+		assert.Error(t, err)
+	})
+
+	t.Run("test trust-manager bundle", func(t *testing.T) {
+		podNamespace := os.Getenv(keyPodNamespace)
+		_ = os.Setenv(keyPodNamespace, "kac")
+		defer func() {
+			_ = os.Setenv(keyPodNamespace, podNamespace)
+		}()
+		source, err := newSingleSource("trustbundle://corp-roots", true)
+		assert.NoError(t, err)
+		bundle, provenance, err := source.Load(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, partner, bundle)
+		assert.Equal(t, "trustbundle://corp-roots", provenance.URI)
+		assert.Equal(t, "configmap://kac/corp-roots/roots.pem", provenance.ResolvedURI)
+	})
+
+	t.Run("test invalid references", func(t *testing.T) {
+		_, err := newSingleSource("clustertrustbundle://", true)
+		assert.Error(t, err)
+		_, err = newSingleSource("trustbundle://?signerName=example.com/partner", true)
+		assert.Error(t, err)
+	})
+
+}
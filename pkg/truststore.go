@@ -0,0 +1,101 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"unicode/utf16"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	keyJavaTruststore         = "CA_BUNDLE_JAVA_TRUSTSTORE"
+	keyJavaTruststorePassword = "CA_BUNDLE_JAVA_TRUSTSTORE_PASSWORD"
+
+	truststoreFilename        = "truststore.jks"
+	defaultTruststorePassword = "changeit"
+	javaToolOptions           = "JAVA_TOOL_OPTIONS"
+
+	jksMagic             = 0xfeedfeed
+	jksVersion           = 2
+	jksTrustedCertEntry  = 2
+	jksIntegritySaltText = "Mighty Aphrodite"
+)
+
+// javaTruststoreEnabled tells whether bundle objects also hold the bundle
+// as a Java truststore, for JVM workloads that can't read PEM files
+func javaTruststoreEnabled() bool {
+	config, _ := currentConfig()
+	return config.JavaTruststore
+}
+
+// javaTruststorePassword is the password protecting the integrity of the
+// truststore, which holds no secret
+func javaTruststorePassword() string {
+	if password := os.Getenv(keyJavaTruststorePassword); password != "" {
+		return password
+	}
+	return defaultTruststorePassword
+}
+
+// javaToolOptionsValue points the JVM at the mounted truststore
+func javaToolOptionsValue(truststorePath string) string {
+	return fmt.Sprintf("-Djavax.net.ssl.trustStore=%s -Djavax.net.ssl.trustStorePassword=%s -Djavax.net.ssl.trustStoreType=JKS",
+		truststorePath, javaTruststorePassword())
+}
+
+// javaTruststore encodes the certificates of the bundle as a JKS
+// truststore. Entries are dated by their certificates so that the same
+// bundle always yields the same truststore
+func javaTruststore(bundle []byte) ([]byte, error) {
+	certificates, err := parseCertificates(bundle)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, value := range []uint32{jksMagic, jksVersion, uint32(len(certificates))} {
+		_ = binary.Write(&b, binary.BigEndian, value)
+	}
+	for i, certificate := range certificates {
+		_ = binary.Write(&b, binary.BigEndian, uint32(jksTrustedCertEntry))
+		writeJKSString(&b, fmt.Sprintf("ca-%d", i))
+		_ = binary.Write(&b, binary.BigEndian, certificate.NotBefore.UnixMilli())
+		writeJKSString(&b, "X.509")
+		_ = binary.Write(&b, binary.BigEndian, uint32(len(certificate.Raw)))
+		b.Write(certificate.Raw)
+	}
+	digest := sha1.New()
+	for _, char := range utf16.Encode([]rune(javaTruststorePassword())) {
+		_ = binary.Write(digest, binary.BigEndian, char)
+	}
+	digest.Write([]byte(jksIntegritySaltText))
+	digest.Write(b.Bytes())
+	return digest.Sum(b.Bytes()), nil
+}
+
+// writeJKSString writes the length prefixed strings of Java data streams,
+// aliases being plain ASCII
+func writeJKSString(b *bytes.Buffer, value string) {
+	_ = binary.Write(b, binary.BigEndian, uint16(len(value)))
+	b.WriteString(value)
+}
+
+// missingJavaTruststore tells whether a bundle object lacks the truststore
+// it should hold, e.g. one created before truststores were enabled
+func missingJavaTruststore(configMap *corev1.ConfigMap) bool {
+	_, ok := configMap.Data[truststoreFilename]
+	return javaTruststoreEnabled() && configMap.Kind == "Secret" && !ok
+}
@@ -0,0 +1,74 @@
+package kac
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_JavaTruststore(t *testing.T) {
+
+	first := fixtures.Certificate("first", time.Now().AddDate(1, 0, 0))
+	second := fixtures.Certificate("second", time.Now().AddDate(2, 0, 0))
+	bundle := append(append([]byte{}, first...), second...)
+
+	t.Run("test truststore entries", func(t *testing.T) {
+		truststore, err := javaTruststore(bundle)
+		assert.NoError(t, err)
+		r := bytes.NewReader(truststore[:len(truststore)-sha1.Size])
+		var header [3]uint32
+		assert.NoError(t, binary.Read(r, binary.BigEndian, &header))
+		assert.Equal(t, [3]uint32{jksMagic, jksVersion, 2}, header)
+		for _, alias := range []string{"ca-0", "ca-1"} {
+			var tag uint32
+			var length uint16
+			var date int64
+			_ = binary.Read(r, binary.BigEndian, &tag)
+			_ = binary.Read(r, binary.BigEndian, &length)
+			name := make([]byte, length)
+			_, _ = r.Read(name)
+			_ = binary.Read(r, binary.BigEndian, &date)
+			_ = binary.Read(r, binary.BigEndian, &length)
+			_, _ = r.Seek(int64(length), 1)
+			var size uint32
+			_ = binary.Read(r, binary.BigEndian, &size)
+			der := make([]byte, size)
+			_, _ = r.Read(der)
+			_, err := x509.ParseCertificate(der)
+			assert.NoError(t, err)
+			assert.Equal(t, uint32(jksTrustedCertEntry), tag)
+			assert.Equal(t, alias, string(name))
+		}
+		assert.Zero(t, r.Len())
+	})
+
+	t.Run("test truststore integrity", func(t *testing.T) {
+		truststore, _ := javaTruststore(bundle)
+		again, _ := javaTruststore(bundle)
+		assert.Equal(t, truststore, again)
+		digest := sha1.New()
+		digest.Write([]byte{0, 'c', 0, 'h', 0, 'a', 0, 'n', 0, 'g', 0, 'e', 0, 'i', 0, 't'})
+		digest.Write([]byte(jksIntegritySaltText))
+		digest.Write(truststore[:len(truststore)-sha1.Size])
+		assert.Equal(t, digest.Sum(nil), truststore[len(truststore)-sha1.Size:])
+	})
+
+	t.Run("test truststore password", func(t *testing.T) {
+		_ = os.Setenv(keyJavaTruststorePassword, "secret")
+		defer func() {
+			_ = os.Unsetenv(keyJavaTruststorePassword)
+		}()
+		protected, _ := javaTruststore(bundle)
+		_ = os.Unsetenv(keyJavaTruststorePassword)
+		truststore, _ := javaTruststore(bundle)
+		assert.NotEqual(t, truststore, protected)
+		assert.Contains(t, javaToolOptionsValue("/etc/ssl/certs/truststore.jks"), "-Djavax.net.ssl.trustStorePassword=changeit")
+	})
+
+}
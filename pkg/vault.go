@@ -0,0 +1,140 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	provenanceSourceVault = "vault"
+
+	keyVaultRole      = "CA_BUNDLE_VAULT_ROLE"
+	keyVaultAuthMount = "CA_BUNDLE_VAULT_AUTH_MOUNT"
+	keyVaultNamespace = "CA_BUNDLE_VAULT_NAMESPACE"
+	keyVaultTokenFile = "CA_BUNDLE_VAULT_TOKEN_FILE"
+
+	defaultVaultAuthMount = "kubernetes"
+	defaultVaultTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// vaultSource reads the ca chain of a Vault PKI mount, logging in with the
+// service account token of the injector when CA_BUNDLE_VAULT_ROLE names a
+// role of the Vault kubernetes auth method
+type vaultSource struct {
+	uri     string
+	address string
+	mount   string
+}
+
+// newVaultSource parses vault://host[:port]/mount references, the mount
+// being the path of the PKI secrets engine, e.g. pki or pki/internal
+func newVaultSource(ref string, uri string) (BundleSource, error) {
+	host, mount, _ := strings.Cut(ref, "/")
+	mount = strings.Trim(mount, "/")
+	if host == "" || mount == "" {
+		return nil, fmt.Errorf("invalid ca bundle source %q, expected vault://host/mount", uri)
+	}
+	return vaultSource{uri: uri, address: "https://" + host, mount: mount}, nil
+}
+
+func (s vaultSource) Load(ctx context.Context) ([]byte, *BundleProvenance, error) {
+	client := bundleHTTPClient()
+	header := http.Header{}
+	if namespace := os.Getenv(keyVaultNamespace); namespace != "" {
+		header.Set("X-Vault-Namespace", namespace)
+	}
+	if role := os.Getenv(keyVaultRole); role != "" {
+		token, err := s.login(ctx, client, header, role)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to log in to vault: %w", err)
+		}
+		header.Set("X-Vault-Token", token)
+	}
+	body, _, err := getArtifact(ctx, client, s.address+"/v1/"+s.mount+"/cert/ca_chain", header)
+	if err != nil {
+		return nil, nil, err
+	}
+	var response struct {
+		Data struct {
+			Certificate string `json:"certificate"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, nil, fmt.Errorf("invalid vault response: %w", err)
+	}
+	content := []byte(response.Data.Certificate)
+	bundle, err := parseBundle("", content)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bundle, &BundleProvenance{
+		Source:        provenanceSourceVault,
+		URI:           s.uri,
+		Format:        detectFormat("", content),
+		ContentSHA256: bundleHash(content),
+	}, nil
+}
+
+// login exchanges the service account token for a vault token with the
+// kubernetes auth method
+func (s vaultSource) login(ctx context.Context, client *http.Client, header http.Header, role string) (string, error) {
+	mount := os.Getenv(keyVaultAuthMount)
+	if mount == "" {
+		mount = defaultVaultAuthMount
+	}
+	tokenFile := os.Getenv(keyVaultTokenFile)
+	if tokenFile == "" {
+		tokenFile = defaultVaultTokenFile
+	}
+	jwt, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return "", err
+	}
+	payload, _ := json.Marshal(map[string]string{"role": role, "jwt": strings.TrimSpace(string(jwt))})
+
+	loginURL := s.address + "/v1/auth/" + strings.Trim(mount, "/") + "/login"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, loginURL, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", upstreamStatusError{url: loginURL, code: resp.StatusCode}
+	}
+	var response struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", err
+	} else if response.Auth.ClientToken == "" {
+		return "", fmt.Errorf("no client token in vault login response")
+	}
+	return response.Auth.ClientToken, nil
+}
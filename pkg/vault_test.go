@@ -0,0 +1,88 @@
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/nodis-com-br/kac-ca-injector/pkg/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_VaultSource(t *testing.T) {
+
+	ctx := context.Background()
+	chain := append(fixtures.Certificate("intermediate", time.Now().AddDate(1, 0, 0)), fixtures.Certificate("root", time.Now().AddDate(1, 0, 0))...)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/k8s/login":
+			var login map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&login)
+			if login["role"] != "ca-injector" || login["jwt"] != "projected-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"hvs.example"}}`))
+		case "/v1/pki/internal/cert/ca_chain":
+			if r.Header.Get("X-Vault-Token") != "hvs.example" || r.Header.Get("X-Vault-Namespace") != "platform" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			body, _ := json.Marshal(map[string]interface{}{"data": map[string]string{"certificate": string(chain)}})
+			_, _ = w.Write(body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile, tokenFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "token")
+	_ = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	_ = os.WriteFile(tokenFile, []byte("projected-token\n"), 0600)
+	host := strings.TrimPrefix(server.URL, "https://")
+	for key, value := range map[string]string{
+		keyCABundleFetchCAFile: caFile,
+		keyVaultRole:           "ca-injector",
+		keyVaultAuthMount:      "k8s",
+		keyVaultNamespace:      "platform",
+		keyVaultTokenFile:      tokenFile,
+	} {
+		_ = os.Setenv(key, value)
+		defer func(key string) {
+			_ = os.Unsetenv(key)
+		}(key)
+	}
+
+	t.Run("test vault ca chain", func(t *testing.T) {
+		source, err := newSingleSource("vault://"+host+"/pki/internal", true)
+		assert.NoError(t, err)
+		bundle, provenance, err := source.Load(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, chain, bundle)
+		assert.Equal(t, provenanceSourceVault, provenance.Source)
+	})
+
+	t.Run("test vault login refused", func(t *testing.T) {
+		_ = os.Setenv(keyVaultRole, "other")
+		defer func() {
+			_ = os.Setenv(keyVaultRole, "ca-injector")
+		}()
+		source, _ := newSingleSource("vault://"+host+"/pki/internal", true)
+		_, _, err := source.Load(ctx)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "unable to log in to vault")
+	})
+
+	t.Run("test invalid vault reference", func(t *testing.T) {
+		_, err := newSingleSource("vault://"+host, true)
+		assert.Error(t, err)
+	})
+
+}
@@ -0,0 +1,77 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	keyVolumeName = "CA_BUNDLE_VOLUME_NAME"
+
+	defaultVolumeName = "{{ .Name }}"
+)
+
+// volumeNameData is the data the volume name template is rendered with
+type volumeNameData struct {
+	// Name is the name of the bundle object
+	Name string
+	// Namespace is the namespace of the pod
+	Namespace string
+}
+
+// bundleVolumeName renders the CA_BUNDLE_VOLUME_NAME template, the name
+// of the bundle object by default, into the name of the volume of the
+// bundle object named name, sanitized to a DNS-1123 label
+func bundleVolumeName(config Config, name string, namespace string) (string, error) {
+	text := config.VolumeName
+	if text == "" {
+		text = defaultVolumeName
+	}
+	tmpl, err := template.New(keyVolumeName).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", keyVolumeName, err)
+	}
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, volumeNameData{Name: name, Namespace: namespace}); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", keyVolumeName, err)
+	}
+	volumeName := sanitizeVolumeName(rendered.String(), validation.DNS1123LabelMaxLength)
+	if volumeName == "" {
+		return "", fmt.Errorf("%s renders an empty volume name for %s", keyVolumeName, name)
+	}
+	return volumeName, nil
+}
+
+// sanitizeVolumeName lowercases value and replaces the runs of characters
+// not allowed in DNS-1123 labels by a dash, truncating it to maxLength
+func sanitizeVolumeName(value string, maxLength int) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(value) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	sanitized := b.String()
+	if len(sanitized) > maxLength {
+		sanitized = sanitized[:maxLength]
+	}
+	return strings.TrimRight(sanitized, "-")
+}
@@ -0,0 +1,48 @@
+package kac
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_BundleVolumeName(t *testing.T) {
+
+	defer func() {
+		_ = os.Unsetenv(keyVolumeName)
+	}()
+
+	t.Run("test default volume name", func(t *testing.T) {
+		name, err := bundleVolumeName(testConfig(), "ca-bundle", "team-a")
+		assert.NoError(t, err)
+		assert.Equal(t, "ca-bundle", name)
+		name, err = bundleVolumeName(testConfig(), "Team.CA_Bundle", "team-a")
+		assert.NoError(t, err)
+		assert.Equal(t, "team-ca-bundle", name)
+	})
+
+	t.Run("test volume name template", func(t *testing.T) {
+		_ = os.Setenv(keyVolumeName, "trust-{{ .Namespace }}-{{ .Name }}")
+		name, err := bundleVolumeName(testConfig(), "pki.example.com", "team-a")
+		assert.NoError(t, err)
+		assert.Equal(t, "trust-team-a-pki-example-com", name)
+		name, _ = bundleVolumeName(testConfig(), strings.Repeat("a", 80), "team-a")
+		assert.Len(t, name, 63)
+	})
+
+	t.Run("test invalid volume name template", func(t *testing.T) {
+		for _, value := range []string{"{{ .Name", "{{ .Unknown }}", "..."} {
+			_ = os.Setenv(keyVolumeName, value)
+			_, err := bundleVolumeName(testConfig(), "ca-bundle", "team-a")
+			assert.Error(t, err, value)
+		}
+	})
+
+	t.Run("test trust store volume name", func(t *testing.T) {
+		name := trustStoreVolumeName(strings.Repeat("a", 63))
+		assert.Len(t, name, 63)
+		assert.True(t, strings.HasSuffix(name, trustStoreVolumeSuffix))
+	})
+
+}
@@ -0,0 +1,142 @@
+/*
+ * Kubernetes Admission Controller
+ *
+ * This is a generic definition for a Kubernetes Admission Controller
+ *
+ * API version: 1.0.0
+ * Contact: infra@nodis.com.br
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var (
+	deploymentsGVR  = metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	statefulSetsGVR = metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}
+	daemonSetsGVR   = metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}
+	jobsGVR         = metav1.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+	cronJobsGVR     = metav1.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}
+
+	// workloadTemplatePaths locates the pod template of every workload
+	// resource mutated by workloadMutationReviewer
+	workloadTemplatePaths = map[metav1.GroupVersionResource]string{
+		deploymentsGVR:  "/spec/template",
+		statefulSetsGVR: "/spec/template",
+		daemonSetsGVR:   "/spec/template",
+		jobsGVR:         "/spec/template",
+		cronJobsGVR:     "/spec/jobTemplate/spec/template",
+	}
+)
+
+type templateOperationKey struct{}
+
+// withTemplateOperation returns a context in which a pod template is
+// reviewed, on behalf of the workload admission operation
+func withTemplateOperation(ctx context.Context, operation admissionv1.Operation) context.Context {
+	return context.WithValue(ctx, templateOperationKey{}, operation)
+}
+
+// templateOperation returns the workload admission operation a pod
+// template is reviewed for, and false when reviewing pods
+func templateOperation(ctx context.Context) (admissionv1.Operation, bool) {
+	operation, ok := ctx.Value(templateOperationKey{}).(admissionv1.Operation)
+	return operation, ok
+}
+
+// workloadMutationReviewer injects the ca bundle into the pod template of
+// workload controllers, so that the injection shows on kubectl diff and
+// gitops tooling instead of only on their pods. The template is reviewed
+// as a pod being created, and the resulting patch rebased on it. Pods
+// created from an injected template are left as they are by the pod
+// webhook, which finds them already injected. Templates don't carry the
+// bundle hash, as rotating the bundle would roll out every workload
+func workloadMutationReviewer(ctx context.Context, ar admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+
+	templatePath, ok := workloadTemplatePaths[ar.Request.Resource]
+	if !ok {
+		return nil, fmt.Errorf("expect resource to be a workload, got %s", ar.Request.Resource)
+	}
+	// The pod template of jobs can't change once created
+	if ar.Request.Resource == jobsGVR && ar.Request.Operation == admissionv1.Update {
+		response := allowedResponse
+		return &response, nil
+	}
+	var workload map[string]interface{}
+	if err := json.Unmarshal(ar.Request.Object.Raw, &workload); err != nil {
+		return nil, err
+	}
+	var template interface{} = workload
+	for _, token := range strings.Split(templatePath, "/")[1:] {
+		object, _ := template.(map[string]interface{})
+		template = object[token]
+	}
+	podTemplate, ok := template.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s %s/%s has no pod template", ar.Request.Kind.Kind, ar.Request.Namespace, ar.Request.Name)
+	}
+
+	// The template metadata lacks the namespace, and its name is the one
+	// of the workload for logs
+	metadata, hasMetadata := podTemplate["metadata"].(map[string]interface{})
+	podMetadata := map[string]interface{}{}
+	for key, value := range metadata {
+		podMetadata[key] = value
+	}
+	podMetadata["namespace"] = ar.Request.Namespace
+	if workloadMetadata, ok := workload["metadata"].(map[string]interface{}); ok && workloadMetadata["name"] != nil {
+		podMetadata["generateName"] = fmt.Sprint(workloadMetadata["name"]) + "-"
+	}
+	encodedPod, err := json.Marshal(map[string]interface{}{
+		"apiVersion": podGVK.GroupVersion().String(),
+		"kind":       podGVK.Kind,
+		"metadata":   podMetadata,
+		"spec":       podTemplate["spec"],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Template updates are reviewed like creations, since unlike pods
+	// workloads roll out a new template. The reviewer still knows it
+	// reviews a template, whose updates it leaves alone once injected
+	request := *ar.Request
+	request.Resource = podsGVR
+	request.Kind = metav1.GroupVersionKind{Group: podGVK.Group, Version: podGVK.Version, Kind: podGVK.Kind}
+	request.SubResource = ""
+	request.Operation = admissionv1.Create
+	request.Object = runtime.RawExtension{Raw: encodedPod}
+	request.OldObject = runtime.RawExtension{}
+	response, err := mutationReviewer(withTemplateOperation(ctx, ar.Request.Operation), admissionv1.AdmissionReview{Request: &request})
+	if err != nil || len(response.Patch) == 0 {
+		return response, err
+	}
+
+	var operations []patchOperation
+	if err := json.Unmarshal(response.Patch, &operations); err != nil {
+		return nil, err
+	}
+	var rebased []patchOperation
+	if !hasMetadata {
+		rebased = append(rebased, patchOperation{Op: patchOpAdd, Path: templatePath + "/metadata", Value: json.RawMessage("{}")})
+	}
+	for _, operation := range operations {
+		operation.Path = templatePath + operation.Path
+		rebased = append(rebased, operation)
+	}
+	if response.Patch, err = json.Marshal(rebased); err != nil {
+		return nil, err
+	}
+	return response, nil
+
+}
@@ -0,0 +1,119 @@
+package kac
+
+import (
+	"context"
+	"encoding/json"
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func Test_WorkloadMutation(t *testing.T) {
+
+	ctx := context.WithValue(context.Background(), keyFake, true)
+	router := NewRouter()
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{"app": "web"},
+			Annotations: map[string]string{os.Getenv(keyCABundleAnnotation): "true"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+	}
+
+	mutate := func(t *testing.T, gvr metav1.GroupVersionResource, workload interface{}) ([]byte, string) {
+		encoded, _ := json.Marshal(workload)
+		ar, _ := admissionReviewFactory(gvr, encoded)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate-workload", string(ar))
+		require.Equal(t, http.StatusOK, w.Code)
+		response := admissionResponse(t, w)
+		assert.True(t, response.Allowed)
+		patch, err := jsonpatch.DecodePatch(response.Patch)
+		require.NoError(t, err)
+		patched, err := patch.Apply(encoded)
+		require.NoError(t, err)
+		return patched, string(response.Patch)
+	}
+
+	t.Run("test deployment template", func(t *testing.T) {
+		patched, patch := mutate(t, deploymentsGVR, appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Name: "web"},
+			Spec:       appsv1.DeploymentSpec{Template: template},
+		})
+		assert.Contains(t, patch, `"path":"/spec/template/spec/volumes"`)
+		var deployment appsv1.Deployment
+		assert.NoError(t, json.Unmarshal(patched, &deployment))
+		assert.True(t, hasVolume(deployment.Spec.Template.Spec, "ca-bundle"))
+		assert.True(t, hasVolumeMount(deployment.Spec.Template.Spec.Containers[0], "ca-bundle", "/etc/ssl/certs/ca_bundle.pem"))
+		assert.NotContains(t, deployment.Spec.Template.Annotations, os.Getenv(keyCABundleAnnotation)+hashAnnotationSuffix)
+	})
+
+	t.Run("test injected template update", func(t *testing.T) {
+		_, patch := mutate(t, deploymentsGVR, appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Name: "web"},
+			Spec:       appsv1.DeploymentSpec{Template: template},
+		})
+		injected, _ := jsonpatch.DecodePatch([]byte(patch))
+		encoded, _ := json.Marshal(appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Name: "web"},
+			Spec:       appsv1.DeploymentSpec{Template: template},
+		})
+		updated, _ := injected.Apply(encoded)
+		response, err := workloadMutationReviewer(ctx, admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+			Operation: admissionv1.Update,
+			Resource:  deploymentsGVR,
+			Object:    runtime.RawExtension{Raw: updated},
+		}})
+		require.NoError(t, err)
+		assert.True(t, response.Allowed)
+		assert.Empty(t, response.Patch)
+	})
+
+	t.Run("test cronjob template", func(t *testing.T) {
+		patched, patch := mutate(t, cronJobsGVR, batchv1.CronJob{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "CronJob"},
+			ObjectMeta: metav1.ObjectMeta{Name: "report"},
+			Spec: batchv1.CronJobSpec{
+				Schedule:    "@daily",
+				JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: template}},
+			},
+		})
+		assert.Contains(t, patch, `"path":"/spec/jobTemplate/spec/template/spec/volumes"`)
+		var cronJob batchv1.CronJob
+		assert.NoError(t, json.Unmarshal(patched, &cronJob))
+		assert.True(t, hasVolume(cronJob.Spec.JobTemplate.Spec.Template.Spec, "ca-bundle"))
+	})
+
+	t.Run("test unannotated template", func(t *testing.T) {
+		encoded, _ := json.Marshal(appsv1.StatefulSet{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+			ObjectMeta: metav1.ObjectMeta{Name: "db"},
+			Spec:       appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: template.Spec}},
+		})
+		ar, _ := admissionReviewFactory(statefulSetsGVR, encoded)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate-workload", string(ar))
+		assert.Equal(t, http.StatusOK, w.Code)
+		response := admissionResponse(t, w)
+		assert.True(t, response.Allowed)
+		assert.Empty(t, response.Patch)
+	})
+
+	t.Run("test unsupported resource", func(t *testing.T) {
+		encoded, _ := json.Marshal(pod)
+		ar, _ := admissionReviewFactory(podsGVR, encoded)
+		w := fakeRequest(ctx, router, http.MethodPost, "/mutate-workload", string(ar))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+}